@@ -1,6 +1,7 @@
 package api
 
 import (
+	"wg-gen-plus/api/prometheus"
 	apiv1 "wg-gen-plus/api/v1"
 
 	"github.com/gin-gonic/gin"
@@ -12,4 +13,7 @@ func ApplyRoutes(r *gin.Engine, private bool) {
 	{
 		apiv1.ApplyRoutes(api, private)
 	}
+	if !private {
+		prometheus.ApplyRoutes(r)
+	}
 }
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Users of every role, each test gets them in a new database
+var (
+	adminUser    = &model.User{Sub: "admin-sub", Name: "admin", Email: "admin@example.com", IsAdmin: true}
+	operatorUser = &model.User{Sub: "operator-sub", Name: "operator", Email: "operator@example.com"}
+	selfUser     = &model.User{Sub: "self-sub", Name: "self", Email: "self@example.com", SelfService: true, DeviceQuota: 2}
+)
+
+// testUserHeader user id of a test request, set in the context the way the local auth middleware does
+const testUserHeader = "X-Test-User"
+
+// fakeStatusSource running interface with the peers of the clients of the database
+type fakeStatusSource struct{}
+
+func (fakeStatusSource) Interface(iface string) (*model.InterfaceStatus, error) {
+	return &model.InterfaceStatus{Name: iface, DeviceType: "fake"}, nil
+}
+
+func (fakeStatusSource) Peers(iface string) ([]*model.ClientStatus, error) {
+	clients, err := core.ReadClients(iface)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]*model.ClientStatus, 0, len(clients))
+	for _, client := range clients {
+		peers = append(peers, &model.ClientStatus{PublicKey: client.PublicKey, LastHandshake: time.Now()})
+	}
+	return peers, nil
+}
+
+var testDir string
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	var err error
+	testDir, err = os.MkdirTemp("", "wg-gen-plus-api")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("AUTH_TYPE", "local")
+	for _, key := range []string{"SERVER_RELOAD_CMD", "LIVE_APPLY", "WG_STATS_API", "SMTP_HOST"} {
+		os.Unsetenv(key)
+	}
+	core.WgConfDir = testDir
+	core.SetStatusSource(fakeStatusSource{})
+	// interfaces are registered once per process, every test registers wg0 in its own database again
+	core.SetStore(openStore(filepath.Join(testDir, "setup.db")))
+	if err = core.AddInterface("wg0", ""); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+func openStore(file string) storage.Store {
+	store, err := storage.Open("sqlite", file, nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err = store.Migrate(false); err != nil {
+		panic(err)
+	}
+	return store
+}
+
+func testKey(seed byte) wgtypes.Key {
+	var key wgtypes.Key
+	for i := range key {
+		key[i] = seed
+	}
+	return key
+}
+
+// seedClient client of wg0 owned by owner
+func seedClient(id string, owner *model.User, seed byte) *model.Client {
+	return &model.Client{
+		Id:           id,
+		Interface:    "wg0",
+		Name:         id,
+		Enable:       true,
+		PrivateKey:   testKey(seed).String(),
+		PublicKey:    testKey(seed).PublicKey().String(),
+		PresharedKey: testKey(seed + 100).String(),
+		AllowedIPs:   []string{"0.0.0.0/0"},
+		Address:      []string{fmt.Sprintf("10.0.0.%d/32", seed)},
+		Owner:        owner.Sub,
+		CreatedBy:    owner.Name,
+		Created:      time.Now().UTC(),
+		Updated:      time.Now().UTC(),
+	}
+}
+
+// setupRouter new database with the users of every role, a client owned by each of them and a profile,
+// and the private API routes behind a test authentication
+func setupRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	store := openStore(filepath.Join(t.TempDir(), "wg-gen-plus.db"))
+	t.Cleanup(func() { store.Close() })
+	core.SetStore(store)
+
+	now := time.Now().UTC()
+	err := store.SaveInterface(&model.Interface{Name: "wg0", Created: now})
+	if err == nil {
+		err = store.SaveServer(&model.Server{
+			Interface:  "wg0",
+			Address:    []string{"10.0.0.1/24"},
+			ListenPort: 51820,
+			PrivateKey: testKey(1).String(),
+			PublicKey:  testKey(1).PublicKey().String(),
+			Endpoint:   "vpn.example.com:51820",
+			AllowedIPs: []string{"0.0.0.0/0"},
+			Created:    now,
+			Updated:    now,
+		})
+	}
+	for _, user := range []*model.User{adminUser, operatorUser, selfUser} {
+		if err == nil {
+			err = store.SaveUser(user)
+		}
+	}
+	for i, client := range []*model.Client{
+		seedClient("admin-client", adminUser, 2),
+		seedClient("operator-client", operatorUser, 3),
+		seedClient("self-client", selfUser, 4),
+	} {
+		if err == nil {
+			client.Tags = []string{fmt.Sprintf("tag%d", i)}
+			err = store.SaveClient(client)
+		}
+	}
+	if err == nil {
+		err = store.SaveProfile(&model.Profile{Id: "profile", Interface: "wg0", Name: "profile", AllowedIPs: []string{"10.0.0.0/24"}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if id := c.GetHeader(testUserHeader); id != "" {
+			c.Set("userID", id)
+		}
+	})
+	ApplyRoutes(r, true)
+	return r
+}
+
+// route request of the table, body is sent as JSON
+type route struct {
+	method string
+	path   string
+	body   interface{}
+}
+
+// statuses wanted status of the admin, the operator and the self service user, 403 or the success status of the route
+type statuses [3]int
+
+const (
+	ok        = http.StatusOK
+	forbidden = http.StatusForbidden
+)
+
+// clientBody client to update id with, as its owner would send it
+func clientBody(id string, owner *model.User, seed byte) *model.Client {
+	client := seedClient(id, owner, seed)
+	client.Name = id + "-renamed"
+	return client
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	tests := []struct {
+		group string
+		route route
+		want  statuses
+	}{
+		// clients: everyone lists the clients they may see, operators manage their own clients only,
+		// self service users view their own clients and create theirs through /self
+		{"client", route{"GET", "/client", nil}, statuses{ok, ok, ok}},
+		{"client", route{"POST", "/client", map[string]interface{}{"name": "new", "allowedIPs": []string{"0.0.0.0/0"}, "address": []string{"10.0.0.0/24"}}}, statuses{ok, ok, forbidden}},
+		{"client", route{"GET", "/client/admin-client", nil}, statuses{ok, forbidden, forbidden}},
+		{"client", route{"GET", "/client/operator-client", nil}, statuses{ok, ok, forbidden}},
+		{"client", route{"GET", "/client/self-client", nil}, statuses{ok, forbidden, ok}},
+		{"client", route{"GET", "/client/self-client/config", nil}, statuses{ok, forbidden, ok}},
+		{"client", route{"GET", "/client/operator-client/quota", nil}, statuses{ok, ok, forbidden}},
+		{"client", route{"PATCH", "/client/operator-client", clientBody("operator-client", operatorUser, 3)}, statuses{ok, ok, forbidden}},
+		{"client", route{"PATCH", "/client/self-client", clientBody("self-client", selfUser, 4)}, statuses{ok, forbidden, forbidden}},
+		{"client", route{"POST", "/client/operator-client/rotate", nil}, statuses{ok, ok, forbidden}},
+		{"client", route{"POST", "/client/self-client/rotate", nil}, statuses{ok, forbidden, forbidden}},
+		{"client", route{"DELETE", "/client/operator-client", nil}, statuses{ok, ok, forbidden}},
+		{"client", route{"DELETE", "/client/admin-client", nil}, statuses{ok, forbidden, forbidden}},
+		{"client", route{"POST", "/client/guest", map[string]interface{}{"name": "guest"}}, statuses{ok, forbidden, forbidden}},
+		{"clients", route{"POST", "/clients/bulk", model.BulkOperation{Selector: model.ClientSelector{Tags: []string{"tag1"}}, Action: model.BulkActionDisable}}, statuses{ok, forbidden, forbidden}},
+		{"client by interface", route{"GET", "/interfaces/wg0/client/operator-client", nil}, statuses{ok, ok, forbidden}},
+
+		// server: everyone reads it without its private key, only admins change it
+		{"server", route{"GET", "/server", nil}, statuses{ok, ok, ok}},
+		{"server", route{"GET", "/server/config", nil}, statuses{ok, forbidden, forbidden}},
+		{"server", route{"PATCH", "/server", map[string]interface{}{"address": []string{"10.0.0.1/24"}, "listenPort": 51821, "endpoint": "vpn.example.com:51821", "allowedips": []string{"0.0.0.0/0"}}}, statuses{ok, forbidden, forbidden}},
+
+		{"status", route{"GET", "/status/enabled", nil}, statuses{ok, ok, ok}},
+		{"status", route{"GET", "/status/interface", nil}, statuses{ok, ok, ok}},
+		{"status", route{"GET", "/status/clients", nil}, statuses{ok, ok, ok}},
+		{"status", route{"GET", "/status/clients/operator-client/history", nil}, statuses{ok, ok, forbidden}},
+
+		{"keys", route{"GET", "/keys", nil}, statuses{ok, forbidden, forbidden}},
+		{"keys", route{"POST", "/keys/server/rotate", nil}, statuses{ok, forbidden, forbidden}},
+		{"ipam", route{"GET", "/ipam", nil}, statuses{ok, forbidden, forbidden}},
+		{"ipam", route{"POST", "/ipam/pools", map[string]interface{}{"name": "pool", "network": "10.0.0.128/25"}}, statuses{ok, forbidden, forbidden}},
+
+		{"interfaces", route{"GET", "/interfaces", nil}, statuses{ok, ok, ok}},
+
+		// users: everyone reads and updates their own record, only admins manage the others
+		{"users", route{"GET", "/users", nil}, statuses{ok, ok, ok}},
+		{"users", route{"GET", "/users/me", nil}, statuses{ok, ok, ok}},
+		{"users", route{"GET", "/users/operator-sub", nil}, statuses{ok, ok, forbidden}},
+		{"users", route{"GET", "/users/admin-sub", nil}, statuses{ok, forbidden, forbidden}},
+		{"users", route{"PATCH", "/users/self-sub", map[string]interface{}{"name": "self", "email": "self@example.org"}}, statuses{ok, forbidden, ok}},
+		{"users", route{"POST", "/users", map[string]interface{}{"name": "new", "email": "new@example.com", "password": "secret123"}}, statuses{http.StatusCreated, forbidden, forbidden}},
+		{"users", route{"DELETE", "/users/operator-sub", nil}, statuses{http.StatusNoContent, forbidden, forbidden}},
+
+		{"profiles", route{"GET", "/profiles", nil}, statuses{ok, ok, ok}},
+		{"profiles", route{"GET", "/profiles/profile", nil}, statuses{ok, ok, ok}},
+		{"profiles", route{"POST", "/profiles", map[string]interface{}{"name": "full", "interface": "wg0", "allowedIPs": []string{"0.0.0.0/0"}}}, statuses{ok, forbidden, forbidden}},
+		{"profiles", route{"DELETE", "/profiles/profile", nil}, statuses{ok, forbidden, forbidden}},
+
+		// self service: creation is bound to the device quota of the user, none for the admin and the operator
+		{"self", route{"GET", "/self/quota", nil}, statuses{ok, ok, ok}},
+		{"self", route{"POST", "/self/client", map[string]interface{}{"name": "phone", "profile": "profile"}}, statuses{forbidden, forbidden, ok}},
+
+		{"audit", route{"GET", "/audit", nil}, statuses{ok, forbidden, forbidden}},
+		{"alerts", route{"GET", "/alerts", nil}, statuses{ok, forbidden, forbidden}},
+		{"alertrules", route{"GET", "/alertrules", nil}, statuses{ok, forbidden, forbidden}},
+		{"backup", route{"GET", "/backup", nil}, statuses{ok, forbidden, forbidden}},
+	}
+
+	for _, test := range tests {
+		for i, user := range []*model.User{adminUser, operatorUser, selfUser} {
+			t.Run(fmt.Sprintf("%s %s %s as %s", test.group, test.route.method, test.route.path, user.Name), func(t *testing.T) {
+				r := setupRouter(t)
+				var body bytes.Buffer
+				if test.route.body != nil {
+					if err := json.NewEncoder(&body).Encode(test.route.body); err != nil {
+						t.Fatal(err)
+					}
+				}
+				req := httptest.NewRequest(test.route.method, "/api/v1.0"+test.route.path, &body)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(testUserHeader, user.Sub)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != test.want[i] {
+					t.Errorf("status %d, want %d: %s", w.Code, test.want[i], w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestRouteAuthentication(t *testing.T) {
+	r := setupRouter(t)
+	for _, path := range []string{"/client", "/server", "/status/clients", "/users", "/profiles", "/self/quota", "/keys", "/backup"} {
+		req := httptest.NewRequest("GET", "/api/v1.0"+path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a user: status %d, want %d", path, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestReadServerPrivateKey(t *testing.T) {
+	r := setupRouter(t)
+	for _, user := range []*model.User{adminUser, operatorUser, selfUser} {
+		req := httptest.NewRequest("GET", "/api/v1.0/server", nil)
+		req.Header.Set(testUserHeader, user.Sub)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var server model.Server
+		if err := json.Unmarshal(w.Body.Bytes(), &server); err != nil {
+			t.Fatal(err)
+		}
+		if (server.PrivateKey != "") != user.IsAdmin {
+			t.Errorf("server private key %q for %s, only admins get it", server.PrivateKey, user.Name)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package authz
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"wg-gen-plus/api/iface"
+	"wg-gen-plus/auth"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// contextUserKey gin context key caching the resolved user for the request
+const contextUserKey = "currentUser"
+
+// CurrentUser resolves the authenticated user of the request, the same way for local and OAuth2 auth.
+// Local auth uses the user ID set by the auth middleware, OAuth2 matches the token subject against
+// the users table and registers unknown users as non admin on first sight.
+func CurrentUser(c *gin.Context) (*model.User, error) {
+	if cached, exists := c.Get(contextUserKey); exists {
+		return cached.(*model.User), nil
+	}
+
+	var user *model.User
+	var err error
+
+	if auth.IsLocalAuth() {
+		userID, exists := c.Get("userID")
+		if !exists {
+			return nil, errors.New("user ID not found in context")
+		}
+		userIDStr, ok := userID.(string)
+		if !ok {
+			return nil, errors.New("user ID in context is not a string")
+		}
+		user, err = core.ReadUser(userIDStr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		oauth2Token, exists := c.Get("oauth2Token")
+		if !exists {
+			return nil, errors.New("oauth2Token not found in context")
+		}
+		oauth2Client, exists := c.Get("oauth2Client")
+		if !exists || oauth2Client == nil {
+			return nil, errors.New("oauth2Client not found in context")
+		}
+		userInfo, err := oauth2Client.(auth.Auth).UserInfo(oauth2Token.(*oauth2.Token))
+		if err != nil {
+			return nil, err
+		}
+
+		user, err = core.ReadUser(userInfo.Sub)
+		if errors.Is(err, sql.ErrNoRows) {
+			// first login of this OAuth2 user, register it so roles can be managed
+			user, err = registerOauth2User(c, userInfo)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// never hand the password hash to handlers
+	user.Password = ""
+
+	c.Set(contextUserKey, user)
+	return user, nil
+}
+
+// registerOauth2User register the OAuth2 user of userInfo as non admin, unless its email is listed in
+// OAUTH2_ADMIN_EMAILS. A user who takes the name of another one is registered with its email, or its subject,
+// appended to the name, so both can log in and an admin can rename them.
+func registerOauth2User(c *gin.Context, userInfo *model.User) (*model.User, error) {
+	user := &model.User{
+		Sub:     userInfo.Sub,
+		Name:    userInfo.Name,
+		Email:   userInfo.Email,
+		IsAdmin: isOauth2Admin(userInfo.Email),
+	}
+	created, err := core.CreateUser(model.Actor{Sub: user.Sub, Name: user.Name, SourceIP: c.ClientIP()}, user)
+	if !errors.Is(err, core.ErrUserNameTaken) {
+		return created, err
+	}
+
+	suffix := userInfo.Email
+	if suffix == "" {
+		suffix = userInfo.Sub
+	}
+	user.Name = fmt.Sprintf("%s (%s)", userInfo.Name, suffix)
+	log.WithFields(log.Fields{
+		"sub":  userInfo.Sub,
+		"name": userInfo.Name,
+	}).Warn("OAuth2 user name is taken by another user, registering it as " + user.Name)
+	created, err = core.CreateUser(model.Actor{Sub: user.Sub, Name: user.Name, SourceIP: c.ClientIP()}, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register OAuth2 user %s (%s): %w", userInfo.Name, userInfo.Sub, err)
+	}
+	return created, nil
+}
+
+// Actor current user and source IP of the request, for the audit log
+func Actor(c *gin.Context) model.Actor {
+	actor := model.Actor{SourceIP: c.ClientIP()}
+	if user, err := CurrentUser(c); err == nil {
+		actor.Sub = user.Sub
+		actor.Name = user.Name
+	}
+	return actor
+}
+
+// RequireUser middleware rejecting requests without a resolvable user
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := CurrentUser(c)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to resolve current user")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin middleware rejecting requests from non admin users with 403
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := CurrentUser(c)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to resolve current user")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !user.IsAdmin {
+			log.WithFields(log.Fields{
+				"user":   user.Name,
+				"method": c.Request.Method,
+				"path":   c.Request.URL.Path,
+			}).Warn("admin access denied")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// CanManageClient admins manage every client, operators only the clients they own,
+// self service users can not change clients at all
+func CanManageClient(user *model.User, client *model.Client) bool {
+	if user.IsAdmin {
+		return true
+	}
+	if user.SelfService {
+		return false
+	}
+	return client.IsOwnedBy(user)
+}
+
+// CanViewClient admins view every client, other users only the clients they own
+func CanViewClient(user *model.User, client *model.Client) bool {
+	return user.IsAdmin || client.IsOwnedBy(user)
+}
+
+// RequireNotSelfService middleware rejecting requests from self service users with 403
+func RequireNotSelfService() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := CurrentUser(c)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to resolve current user")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if user.SelfService && !user.IsAdmin {
+			log.WithFields(log.Fields{
+				"user":   user.Name,
+				"method": c.Request.Method,
+				"path":   c.Request.URL.Path,
+			}).Warn("self service access denied")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Client middleware for the :id client routes, abort with 404 for clients of other interfaces
+// and with 403 if allowed rejects the current user for the client
+func Client(allowed func(user *model.User, client *model.Client) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := CurrentUser(c)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to resolve current user")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		client, err := core.ReadClient(c.Param("id"))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+				"id":  c.Param("id"),
+			}).Error("failed to read client")
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		// clients are only reachable through the routes of their own interface
+		if client.Interface != iface.Name(c) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if !allowed(user, client) {
+			log.WithFields(log.Fields{
+				"user":   user.Name,
+				"client": client.Id,
+			}).Warn("client access denied")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// isOauth2Admin check if email is listed in OAUTH2_ADMIN_EMAILS
+func isOauth2Admin(email string) bool {
+	if email == "" {
+		return false
+	}
+	for _, admin := range strings.Split(os.Getenv("OAUTH2_ADMIN_EMAILS"), ",") {
+		if strings.EqualFold(strings.TrimSpace(admin), email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package iface
+
+import (
+	"net/http"
+	"wg-gen-plus/core"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// contextInterfaceKey gin context key holding the WireGuard interface of the request
+const contextInterfaceKey = "interface"
+
+// Resolve middleware selecting the WireGuard interface of the request from the :iface route parameter,
+// routes without the parameter work on the default interface
+func Resolve() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("iface")
+		if name == "" {
+			name = core.DefaultInterface
+		} else if !core.IsInterface(name) {
+			log.WithFields(log.Fields{
+				"interface": name,
+			}).Error("unknown interface")
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Set(contextInterfaceKey, name)
+		c.Next()
+	}
+}
+
+// Name WireGuard interface selected by Resolve for the request
+func Name(c *gin.Context) string {
+	if name, exists := c.Get(contextInterfaceKey); exists {
+		return name.(string)
+	}
+	return core.DefaultInterface
+}
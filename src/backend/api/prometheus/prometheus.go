@@ -0,0 +1,40 @@
+package prometheus
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"wg-gen-plus/core"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ApplyRoutes applies the /metrics route to gin engine, outside of the API authentication
+func ApplyRoutes(r *gin.Engine) {
+	r.GET("/metrics", requireToken(), readMetrics)
+}
+
+// requireToken middleware rejecting requests without the METRICS_TOKEN bearer token, when it is set
+func requireToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv("METRICS_TOKEN")
+		if token == "" {
+			c.Next()
+			return
+		}
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+func readMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	core.WriteMetrics(c.Writer)
+}
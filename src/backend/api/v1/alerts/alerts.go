@@ -0,0 +1,206 @@
+package alerts
+
+import (
+	"net/http"
+	"strconv"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/alerts")
+	g.Use(authz.RequireAdmin())
+	{
+		g.GET("", readAlerts)
+		g.POST("/:id/acknowledge", acknowledgeAlert)
+	}
+	rules := r.Group("/alertrules")
+	rules.Use(authz.RequireAdmin())
+	{
+		rules.GET("", readAlertRules)
+		rules.GET("/:id", readAlertRule)
+		rules.POST("", createAlertRule)
+		rules.PATCH("/:id", updateAlertRule)
+		rules.DELETE("/:id", deleteAlertRule)
+	}
+}
+
+// readAlerts one page of the alerts, most recently fired first, filtered by state, ruleId and interface
+func readAlerts(c *gin.Context) {
+	filter := model.AlertFilter{
+		RuleId:    c.Query("ruleId"),
+		State:     c.Query("state"),
+		Interface: c.Query("interface"),
+	}
+	if filter.State != "" && filter.State != model.AlertStateFiring && filter.State != model.AlertStateResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be firing or resolved"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pageSize must be between 1 and " + strconv.Itoa(maxPageSize)})
+		return
+	}
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	alerts, total, err := core.ReadAlerts(filter)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read alerts")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+		"alerts":   alerts,
+	})
+}
+
+func acknowledgeAlert(c *gin.Context) {
+	id := c.Param("id")
+
+	alert, err := core.AcknowledgeAlert(authz.Actor(c), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to acknowledge alert")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+func readAlertRules(c *gin.Context) {
+	rules, err := core.ReadAlertRules()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to list alert rules")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func readAlertRule(c *gin.Context) {
+	rule, err := core.ReadAlertRule(c.Param("id"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  c.Param("id"),
+		}).Error("failed to read alert rule")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func createAlertRule(c *gin.Context) {
+	var data model.AlertRule
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	data.CreatedBy = user.Name
+	data.UpdatedBy = user.Name
+
+	rule, err := core.CreateAlertRule(authz.Actor(c), &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create alert rule")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func updateAlertRule(c *gin.Context) {
+	var data model.AlertRule
+	id := c.Param("id")
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	data.UpdatedBy = user.Name
+
+	rule, err := core.UpdateAlertRule(authz.Actor(c), id, &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to update alert rule")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func deleteAlertRule(c *gin.Context) {
+	id := c.Param("id")
+
+	err := core.DeleteAlertRule(authz.Actor(c), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to remove alert rule")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/audit")
+	g.Use(authz.RequireAdmin())
+	{
+		g.GET("", readAudit)
+		g.GET("/csv", exportAudit)
+	}
+}
+
+// readAudit one page of the audit log, most recent first
+func readAudit(c *gin.Context) {
+	filter, err := parseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pageSize must be between 1 and " + strconv.Itoa(maxPageSize)})
+		return
+	}
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	entries, total, err := core.ReadAudit(filter)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read audit log")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+		"entries":  entries,
+	})
+}
+
+// exportAudit every audit entry matching the filter as CSV
+func exportAudit(c *gin.Context) {
+	filter, err := parseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, _, err := core.ReadAudit(filter)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read audit log")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=audit.csv")
+	c.Header("Content-Type", "text/csv")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"time", "actor", "sourceIP", "action", "objectType", "objectId", "objectName", "diff"})
+	for _, e := range entries {
+		w.Write([]string{
+			e.Time.Format(time.RFC3339Nano), e.Actor, e.SourceIP, e.Action,
+			e.ObjectType, e.ObjectId, e.ObjectName, string(e.Diff),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to write audit csv")
+	}
+}
+
+// parseFilter audit filter from the actor, action, objectType, objectId, from and to (RFC3339) query parameters
+func parseFilter(c *gin.Context) (model.AuditFilter, error) {
+	filter := model.AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		ObjectType: c.Query("objectType"),
+		ObjectId:   c.Query("objectId"),
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, errors.New("from must be a RFC3339 time")
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, errors.New("to must be a RFC3339 time")
+		}
+	}
+	return filter, nil
+}
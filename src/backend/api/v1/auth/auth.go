@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 	"wg-gen-plus/auth"
+	"wg-gen-plus/metrics"
 	"wg-gen-plus/model"
 	"wg-gen-plus/util"
 
@@ -109,6 +110,7 @@ func oauth2Exchange(c *gin.Context) {
 	oauth2Client := c.MustGet("oauth2Client").(auth.Auth)
 
 	oauth2Token, err := oauth2Client.Exchange(loginVals.Code)
+	metrics.Login("oauth2", err == nil)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -197,6 +199,7 @@ func handleLocalLogin(c *gin.Context) {
 	}
 
 	user, err := localAuth.Authenticate(username, password)
+	metrics.Login("local", err == nil)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
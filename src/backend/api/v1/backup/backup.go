@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/backup")
+	g.Use(authz.RequireAdmin())
+	{
+		g.GET("", readBackup)             // Download a backup
+		g.POST("", createBackup)          // Download a backup encrypted with a passphrase
+		g.POST("/restore", restoreBackup) // Restore a backup
+	}
+}
+
+// readBackup backup in plaintext, keys included
+func readBackup(c *gin.Context) {
+	writeBackup(c, "")
+}
+
+// createBackup backup encrypted with the passphrase of the request, in the body so it is not logged with the URL
+func createBackup(c *gin.Context) {
+	var data model.BackupRequest
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	writeBackup(c, data.Passphrase)
+}
+
+func writeBackup(c *gin.Context, passphrase string) {
+	backup, err := core.CreateBackup()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create backup")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	data, err := core.EncodeBackup(backup, passphrase)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to encode backup")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=wg-gen-plus-%s.json", backup.Created.Format("20060102-150405")))
+	c.Data(http.StatusOK, "application/json", data)
+}
+
+func restoreBackup(c *gin.Context) {
+	var data model.RestoreRequest
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	backup, err := core.DecodeBackup(data.Backup, data.Passphrase)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := core.RestoreBackup(authz.Actor(c), backup, data.Mode)
+	if errors.Is(err, core.ErrBackupInvalid) {
+		c.JSON(http.StatusBadRequest, result)
+		return
+	}
+	if errors.Is(err, core.ErrRestoreMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to restore backup")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -1,29 +1,42 @@
 package client
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
-	"wg-gen-plus/auth"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/api/iface"
 	"wg-gen-plus/core"
 	"wg-gen-plus/model"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"github.com/skip2/go-qrcode"
-	"golang.org/x/oauth2"
 )
 
 // ApplyRoutes applies router to gin Router
 func ApplyRoutes(r *gin.RouterGroup) {
 	g := r.Group("/client")
+	g.Use(authz.RequireUser())
 	{
-		g.POST("", createClient)
-		g.GET("/:id", readClient)
-		g.PATCH("/:id", updateClient)
-		g.DELETE("/:id", deleteClient)
+		g.POST("", authz.RequireNotSelfService(), createClient)
+		g.POST("/guest", authz.RequireAdmin(), createGuestClient)
+		g.POST("/import", authz.RequireAdmin(), importClients)
 		g.GET("", readClients)
-		g.GET("/:id/config", configClient)
-		g.GET("/:id/email", emailClient)
+		g.GET("/:id", authz.Client(authz.CanViewClient), readClient)
+		g.PATCH("/:id", authz.Client(authz.CanManageClient), updateClient)
+		g.DELETE("/:id", authz.Client(authz.CanManageClient), deleteClient)
+		g.GET("/:id/config", authz.Client(authz.CanViewClient), configClient)
+		g.GET("/:id/email", authz.Client(authz.CanViewClient), emailClient)
+		g.GET("/:id/quota", authz.Client(authz.CanViewClient), readClientQuota)
+		g.POST("/:id/quota/reset", authz.Client(authz.CanManageClient), resetClientQuota)
+		g.POST("/:id/rotate", authz.Client(authz.CanManageClient), rotateClientKeys)
+	}
+	bulk := r.Group("/clients")
+	bulk.Use(authz.RequireAdmin())
+	{
+		bulk.POST("/bulk", bulkClients)
 	}
 }
 
@@ -38,60 +51,58 @@ func createClient(c *gin.Context) {
 		return
 	}
 
-	// Get creator info based on authentication type
-	var createdBy string
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
-	if auth.IsLocalAuth() {
-		// Local auth: Get user from context
-		userID, exists := c.Get("userID")
-		if !exists {
-			log.Error("User ID not found in context")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+	data.Owner = user.Sub
+	data.CreatedBy = user.Name
 
-		userIDStr, ok := userID.(string)
-		if !ok {
-			log.Error("User ID is not a string")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+	client, err := core.CreateClient(authz.Actor(c), iface.Name(c), &data)
+	if errors.Is(err, core.ErrAddressUnavailable) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create client")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
-		// Get the user from database
-		user, err := core.ReadUser(userIDStr)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-				"id":  userIDStr,
-			}).Error("failed to get user")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+	c.JSON(http.StatusOK, client)
+}
 
-		createdBy = user.Name
-	} else {
-		// OAuth: Get user from token
-		oauth2Token := c.MustGet("oauth2Token").(*oauth2.Token)
-		oauth2Client := c.MustGet("oauth2Client").(auth.Auth)
-		user, err := oauth2Client.UserInfo(oauth2Token)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"oauth2Token": oauth2Token,
-				"err":         err,
-			}).Error("failed to get user with oauth token")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		createdBy = user.Name
-	}
+// createGuestClient client valid for a limited time, deleted once expired
+func createGuestClient(c *gin.Context) {
+	var data model.Guest
 
-	data.CreatedBy = createdBy
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+	if data.Duration == "" {
+		data.Duration = "24h"
+	}
 
-	client, err := core.CreateClient(&data)
+	client, err := core.CreateGuestClient(authz.Actor(c), iface.Name(c), &data)
+	if errors.Is(err, core.ErrInvalidGuestDuration) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
-		}).Error("failed to create client")
+		}).Error("failed to create guest client")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -99,6 +110,36 @@ func createClient(c *gin.Context) {
 	c.JSON(http.StatusOK, client)
 }
 
+// importClients clients of a CSV body, or of a JSON array, only validated with dryRun=true
+func importClients(c *gin.Context) {
+	var rows []*model.ImportRow
+	var err error
+	if c.ContentType() == "text/csv" {
+		rows, err = core.ParseImportCSV(c.Request.Body)
+	} else {
+		rows, err = core.ParseImportJSON(c.Request.Body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := core.ImportClients(authz.Actor(c), iface.Name(c), rows, c.Query("dryRun") == "true")
+	if errors.Is(err, core.ErrImportInvalid) {
+		c.JSON(http.StatusBadRequest, result)
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to import clients")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 func readClient(c *gin.Context) {
 	id := c.Param("id")
 
@@ -126,57 +167,37 @@ func updateClient(c *gin.Context) {
 		return
 	}
 
-	// Get updater info based on authentication type
-	var updatedBy string
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Debugf("User %s is updating client %s", user.Name, id)
 
-	if auth.IsLocalAuth() {
-		// Local auth: Get user from context
-		userID, exists := c.Get("userID")
-		if !exists {
-			log.Error("User ID not found in context")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+	data.UpdatedBy = user.Name
 
-		userIDStr, ok := userID.(string)
-		if !ok {
-			log.Error("User ID is not a string")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		// Get the user from database
-		user, err := core.ReadUser(userIDStr)
+	// only admins change the address of a client
+	if !user.IsAdmin {
+		current, err := core.ReadClient(id)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"err": err,
-				"id":  userIDStr,
-			}).Error("failed to get user")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		updatedBy = user.Name
-		log.Debugf("User %s is updating client %s", updatedBy, id)
-	} else {
-		// OAuth: Get user from token
-		oauth2Token := c.MustGet("oauth2Token").(*oauth2.Token)
-		oauth2Client := c.MustGet("oauth2Client").(auth.Auth)
-		user, err := oauth2Client.UserInfo(oauth2Token)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"oauth2Token": oauth2Token,
-				"err":         err,
-			}).Error("failed to get user with oauth token")
+				"id":  id,
+			}).Error("failed to read client")
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-		updatedBy = user.Name
+		data.Address = current.Address
 	}
 
-	data.UpdatedBy = updatedBy
-
-	client, err := core.UpdateClient(id, &data)
+	client, err := core.UpdateClient(authz.Actor(c), id, &data)
+	if errors.Is(err, core.ErrAddressUnavailable) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -191,7 +212,7 @@ func updateClient(c *gin.Context) {
 func deleteClient(c *gin.Context) {
 	id := c.Param("id")
 
-	err := core.DeleteClient(id)
+	err := core.DeleteClient(authz.Actor(c), id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -204,7 +225,16 @@ func deleteClient(c *gin.Context) {
 }
 
 func readClients(c *gin.Context) {
-	clients, err := core.ReadClients()
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	clients, err := core.ReadClients(iface.Name(c))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -213,7 +243,15 @@ func readClients(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, clients)
+	// non admin users only see the clients they own
+	visible := make([]*model.Client, 0, len(clients))
+	for _, client := range clients {
+		if authz.CanViewClient(user, client) {
+			visible = append(visible, client)
+		}
+	}
+
+	c.JSON(http.StatusOK, visible)
 }
 
 func configClient(c *gin.Context) {
@@ -229,10 +267,23 @@ func configClient(c *gin.Context) {
 	formatQr := c.DefaultQuery("qrcode", "false")
 	if formatQr == "false" {
 		// return config as txt file
-		c.Header("Content-Disposition", "attachment; filename=wg0.conf")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", iface.Name(c)))
 		c.Data(http.StatusOK, "application/config", configData)
 		return
 	}
+	// the config of a client with its own key needs its private key first, a scanned one is of no use
+	client, err := core.ReadClient(c.Param("id"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read client")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if client.HasOwnKey() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client has its own key, its config has no private key to scan"})
+		return
+	}
 	// return config as png qrcode
 	png, err := qrcode.Encode(string(configData), qrcode.Medium, 250)
 	if err != nil {
@@ -259,3 +310,99 @@ func emailClient(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func readClientQuota(c *gin.Context) {
+	id := c.Param("id")
+
+	usage, err := core.ReadClientQuota(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read client quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+func resetClientQuota(c *gin.Context) {
+	id := c.Param("id")
+
+	usage, err := core.ResetClientQuota(authz.Actor(c), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to reset client quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// rotateClientKeys new keypair and preshared key for the client, optionally emailing the new config.
+// The old keys stop working at once.
+func rotateClientKeys(c *gin.Context) {
+	id := c.Param("id")
+	var data model.KeyRotation
+
+	// the body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&data); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to bind")
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	client, err := core.RotateClientKeys(authz.Actor(c), id, data.Email)
+	if errors.Is(err, core.ErrOwnKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to rotate client keys")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// the old keys stopped working, the client needs its new config
+	c.Header(model.KeyRotationHeader, model.KeyRotationImmediate)
+	c.JSON(http.StatusOK, client)
+}
+
+// bulkClients apply an action to the clients matching a selector
+func bulkClients(c *gin.Context) {
+	var data model.BulkOperation
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+	if errs := data.IsValid(); len(errs) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errs[0].Error()})
+		return
+	}
+
+	result, err := core.BulkClients(authz.Actor(c), iface.Name(c), &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to apply bulk client operation")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if data.Action == model.BulkActionRotate {
+		c.Header(model.KeyRotationHeader, model.KeyRotationImmediate)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,32 @@
+package interfaces
+
+import (
+	"net/http"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/core"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/interfaces")
+	g.Use(authz.RequireUser())
+	{
+		g.GET("", readInterfaces)
+	}
+}
+
+func readInterfaces(c *gin.Context) {
+	interfaces, err := core.ReadInterfaces()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to list interfaces")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces)
+}
@@ -0,0 +1,185 @@
+package ipam
+
+import (
+	"net/http"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/api/iface"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/ipam")
+	g.Use(authz.RequireAdmin())
+	{
+		g.GET("", readUsage)                             // Get utilisation of every pool
+		g.GET("/pools", readPools)                       // Get all address pools
+		g.GET("/pools/:id", readPool)                    // Get specific address pool
+		g.POST("/pools", createPool)                     // Create new address pool
+		g.PATCH("/pools/:id", updatePool)                // Update existing address pool
+		g.DELETE("/pools/:id", deletePool)               // Delete address pool
+		g.GET("/reservations", readReservations)         // Get all address reservations
+		g.POST("/reservations", createReservation)       // Create new address reservation
+		g.DELETE("/reservations/:id", deleteReservation) // Delete address reservation
+	}
+}
+
+func readUsage(c *gin.Context) {
+	usages, err := core.ReadIPUsage(iface.Name(c))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read address usage")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, usages)
+}
+
+func readPools(c *gin.Context) {
+	pools, err := core.ReadIPPools(iface.Name(c))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to list address pools")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, pools)
+}
+
+func readPool(c *gin.Context) {
+	pool, err := core.ReadIPPool(iface.Name(c), c.Param("id"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  c.Param("id"),
+		}).Error("failed to read address pool")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, pool)
+}
+
+func createPool(c *gin.Context) {
+	var data model.IPPool
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	pool, err := core.CreateIPPool(authz.Actor(c), iface.Name(c), &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create address pool")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, pool)
+}
+
+func updatePool(c *gin.Context) {
+	var data model.IPPool
+	id := c.Param("id")
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	pool, err := core.UpdateIPPool(authz.Actor(c), iface.Name(c), id, &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to update address pool")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, pool)
+}
+
+func deletePool(c *gin.Context) {
+	id := c.Param("id")
+
+	err := core.DeleteIPPool(authz.Actor(c), iface.Name(c), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to remove address pool")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func readReservations(c *gin.Context) {
+	reservations, err := core.ReadIPReservations(iface.Name(c))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to list address reservations")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, reservations)
+}
+
+func createReservation(c *gin.Context) {
+	var data model.IPReservation
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	reservation, err := core.CreateIPReservation(authz.Actor(c), iface.Name(c), &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create address reservation")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reservation)
+}
+
+func deleteReservation(c *gin.Context) {
+	id := c.Param("id")
+
+	err := core.DeleteIPReservation(authz.Actor(c), iface.Name(c), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to remove address reservation")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
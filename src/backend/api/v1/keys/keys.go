@@ -0,0 +1,94 @@
+package keys
+
+import (
+	"net/http"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/api/iface"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/keys")
+	g.Use(authz.RequireAdmin())
+	{
+		g.GET("", readKeyReport)
+		g.POST("/clients/rotate", rotateTagKeys)
+		g.POST("/server/rotate", rotateServerKeys)
+	}
+}
+
+// readKeyReport key ages of the server and clients, flagged against the max key age
+func readKeyReport(c *gin.Context) {
+	report, err := core.ReadKeyReport(iface.Name(c))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read key report")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// rotateTagKeys new keys for the clients with a tag
+func rotateTagKeys(c *gin.Context) {
+	var data model.KeyRotation
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+	if data.Tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag is required"})
+		return
+	}
+
+	clients, err := core.RotateTagKeys(authz.Actor(c), iface.Name(c), data.Tag, data.Email)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to rotate client keys")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header(model.KeyRotationHeader, model.KeyRotationImmediate)
+	c.JSON(http.StatusOK, clients)
+}
+
+// rotateServerKeys new keypair for the server, every client needs its new config
+func rotateServerKeys(c *gin.Context) {
+	var data model.KeyRotation
+
+	// the body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&data); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to bind")
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	server, err := core.RotateServerKeys(authz.Actor(c), iface.Name(c), data.Email)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to rotate server keys")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header(model.KeyRotationHeader, model.KeyRotationImmediate)
+	c.JSON(http.StatusOK, server)
+}
@@ -0,0 +1,135 @@
+package profiles
+
+import (
+	"net/http"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/profiles")
+	g.Use(authz.RequireUser())
+	{
+		g.GET("", readProfiles)                               // Get all profiles, everyone can pick from them
+		g.GET("/:id", readProfile)                            // Get specific profile
+		g.POST("", authz.RequireAdmin(), createProfile)       // Create new profile
+		g.PATCH("/:id", authz.RequireAdmin(), updateProfile)  // Update existing profile
+		g.DELETE("/:id", authz.RequireAdmin(), deleteProfile) // Delete profile
+	}
+}
+
+func readProfiles(c *gin.Context) {
+	profiles, err := core.ReadProfiles()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to list profiles")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+func readProfile(c *gin.Context) {
+	profile, err := core.ReadProfile(c.Param("id"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  c.Param("id"),
+		}).Error("failed to read profile")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func createProfile(c *gin.Context) {
+	var data model.Profile
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	data.CreatedBy = user.Name
+
+	profile, err := core.CreateProfile(authz.Actor(c), &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create profile")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func updateProfile(c *gin.Context) {
+	var data model.Profile
+	id := c.Param("id")
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	data.UpdatedBy = user.Name
+
+	profile, err := core.UpdateProfile(authz.Actor(c), id, &data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to update profile")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func deleteProfile(c *gin.Context) {
+	id := c.Param("id")
+
+	err := core.DeleteProfile(authz.Actor(c), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  id,
+		}).Error("failed to remove profile")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
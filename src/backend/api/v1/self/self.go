@@ -0,0 +1,93 @@
+package self
+
+import (
+	"errors"
+	"net/http"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/core"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyRoutes applies router to gin Router
+func ApplyRoutes(r *gin.RouterGroup) {
+	g := r.Group("/self")
+	g.Use(authz.RequireUser())
+	{
+		g.GET("/quota", readQuota)
+		g.POST("/client", createClient)
+	}
+}
+
+func readQuota(c *gin.Context) {
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	owned, err := core.ReadOwnedClients(user)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to list owned clients")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deviceQuota": user.DeviceQuota,
+		"used":        len(owned),
+	})
+}
+
+func createClient(c *gin.Context) {
+	var data struct {
+		Name    string `json:"name"`
+		Profile string `json:"profile"`
+		// PublicKey of a device bringing its own key, empty to have a keypair generated
+		PublicKey string `json:"publicKey"`
+	}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to bind")
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if data.Profile == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "profile is required"})
+		return
+	}
+
+	client, err := core.CreateSelfServiceClient(authz.Actor(c), user, data.Profile, data.Name, data.PublicKey)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"user": user.Name,
+		}).Error("failed to create self service client")
+		if errors.Is(err, core.ErrDeviceQuotaExceeded) || user.DeviceQuota <= 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, client)
+}
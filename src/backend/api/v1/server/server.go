@@ -1,39 +1,53 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
-	"wg-gen-plus/auth"
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/api/iface"
 	"wg-gen-plus/core"
 	"wg-gen-plus/model"
 	"wg-gen-plus/version"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/oauth2"
 )
 
 // ApplyRoutes applies router to gin Router
 func ApplyRoutes(r *gin.RouterGroup) {
 	g := r.Group("/server")
 	{
-		g.GET("", readServer)
-		g.PATCH("", updateServer)
-		g.GET("/config", configServer)
+		g.GET("", authz.RequireUser(), readServer)
+		g.PATCH("", authz.RequireAdmin(), updateServer)
+		g.GET("/config", authz.RequireAdmin(), configServer)
 		g.GET("/version", versionStr)
 	}
 }
 
+// readServer server of the interface, without its private key for non admin users
 func readServer(c *gin.Context) {
-	client, err := core.ReadServer()
+	server, err := core.ReadServer(iface.Name(c))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
-		}).Error("failed to read client")
+		}).Error("failed to read server")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.JSON(http.StatusOK, client)
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !user.IsAdmin {
+		server.PrivateKey = ""
+	}
+
+	c.JSON(http.StatusOK, server)
 }
 
 func updateServer(c *gin.Context) {
@@ -47,47 +61,17 @@ func updateServer(c *gin.Context) {
 		return
 	}
 
-	// Support both OAuth2 and local authentication
-	if auth.IsLocalAuth() {
-		userID, exists := c.Get("userID")
-		if !exists {
-			log.Error("userID not found in context for local auth")
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-		userIDStr, ok := userID.(string)
-		if !ok {
-			log.Error("userID in context is not a string")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		data.UpdatedBy = userIDStr
-	} else {
-		oauth2Token, exists := c.Get("oauth2Token")
-		if !exists {
-			log.Error("oauth2Token not found in context for OAuth2 auth")
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-		oauth2Client, exists := c.Get("oauth2Client")
-		if !exists {
-			log.Error("oauth2Client not found in context for OAuth2 auth")
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-		user, err := oauth2Client.(auth.Auth).UserInfo(oauth2Token.(*oauth2.Token))
-		if err != nil {
-			log.WithFields(log.Fields{
-				"oauth2Token": oauth2Token,
-				"err":         err,
-			}).Error("failed to get user with oauth token")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		data.UpdatedBy = user.Name
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
+	data.UpdatedBy = user.Name
 
-	server, err := core.UpdateServer(&data)
+	server, err := core.UpdateServer(authz.Actor(c), iface.Name(c), &data)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -100,7 +84,7 @@ func updateServer(c *gin.Context) {
 }
 
 func configServer(c *gin.Context) {
-	configData, err := core.ReadWgConfigFile()
+	configData, err := core.ReadWgConfigFile(iface.Name(c))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -110,7 +94,7 @@ func configServer(c *gin.Context) {
 	}
 
 	// return config as txt file
-	c.Header("Content-Disposition", "attachment; filename=wg0.conf")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", iface.Name(c)))
 	c.Data(http.StatusOK, "application/config", configData)
 }
 
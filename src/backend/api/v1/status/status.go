@@ -1,31 +1,91 @@
 package status
 
 import (
+	"errors"
+	"io"
 	"net/http"
-	"os"
+	"time"
 
+	"wg-gen-plus/api/authz"
+	"wg-gen-plus/api/iface"
 	"wg-gen-plus/core"
+	"wg-gen-plus/model"
+	"wg-gen-plus/util"
 
 	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 )
 
+// streamTokenLifetime time a stream token can be used to open a stream
+const streamTokenLifetime = time.Minute
+
+// streamHeartbeat interval of comments keeping idle streams open through proxies, the auth token is checked as well
+const streamHeartbeat = 30 * time.Second
+
 // ApplyRoutes applies router to gin Router
 func ApplyRoutes(r *gin.RouterGroup) {
 	g := r.Group("/status")
+	g.Use(authz.RequireUser())
 	{
 		g.GET("/enabled", readEnabled)
 		g.GET("/interface", readInterfaceStatus)
 		g.GET("/clients", readClientStatus)
+		g.GET("/clients/:id/history", authz.Client(authz.CanViewClient), readClientHistory)
+		g.GET("/stream", streamStatus)
+		g.POST("/stream/token", createStreamToken)
+	}
+}
+
+// visiblePeers public keys of the peers of the interface the current user may see, nil for admins who see them all
+func visiblePeers(c *gin.Context) (map[string]bool, error) {
+	user, err := authz.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsAdmin {
+		return nil, nil
+	}
+	clients, err := core.ReadClients(iface.Name(c))
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool)
+	for _, client := range clients {
+		if authz.CanViewClient(user, client) {
+			visible[client.PublicKey] = true
+		}
+	}
+	return visible, nil
+}
+
+// filterEvent event with the peers in visible only, the event itself is shared with other streams and left as is
+func filterEvent(event *model.StatusEvent, visible map[string]bool) *model.StatusEvent {
+	if visible == nil {
+		return event
+	}
+	filtered := *event
+	filtered.Peers = make([]*model.PeerDelta, 0, len(event.Peers))
+	for _, peer := range event.Peers {
+		if visible[peer.Status.PublicKey] {
+			filtered.Peers = append(filtered.Peers, peer)
+		}
 	}
+	filtered.Removed = nil
+	for _, publicKey := range event.Removed {
+		if visible[publicKey] {
+			filtered.Removed = append(filtered.Removed, publicKey)
+		}
+	}
+	return &filtered
 }
 
 func readEnabled(c *gin.Context) {
-	c.JSON(http.StatusOK, os.Getenv("WG_STATS_API") != "")
+	c.JSON(http.StatusOK, core.StatusEnabled(iface.Name(c)))
 }
 
 func readInterfaceStatus(c *gin.Context) {
-	status, err := core.ReadInterfaceStatus()
+	status, err := core.ReadInterfaceStatus(iface.Name(c))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -37,8 +97,9 @@ func readInterfaceStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
+// readClientStatus peers of the interface, non admin users only get the peers of the clients they may view
 func readClientStatus(c *gin.Context) {
-	status, err := core.ReadClientStatus()
+	status, err := core.ReadClientStatus(iface.Name(c))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -47,5 +108,148 @@ func readClientStatus(c *gin.Context) {
 		return
 	}
 
+	visible, err := visiblePeers(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read visible peers")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if visible != nil {
+		filtered := make([]*model.ClientStatus, 0, len(status))
+		for _, peer := range status {
+			if visible[peer.PublicKey] {
+				filtered = append(filtered, peer)
+			}
+		}
+		status = filtered
+	}
+
 	c.JSON(http.StatusOK, status)
 }
+
+// readClientHistory traffic of a client, from and to are RFC3339 times defaulting to the last 24 hours,
+// step a duration like 5m or 24h defaulting to 1h
+func readClientHistory(c *gin.Context) {
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC3339 time"})
+			return
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC3339 time"})
+			return
+		}
+		from = t
+	}
+	step := time.Hour
+	if value := c.Query("step"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "step must be a positive duration like 5m or 24h"})
+			return
+		}
+		step = d
+	}
+
+	points, err := core.ReadClientHistory(c.Param("id"), from, to, step)
+	if errors.Is(err, core.ErrInvalidHistory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"id":  c.Param("id"),
+		}).Error("failed to read client history")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clientId": c.Param("id"),
+		"from":     from,
+		"to":       to,
+		"step":     int(step.Seconds()),
+		"points":   points,
+	})
+}
+
+// createStreamToken one time token opening a status stream as the current user, for EventSource which can not
+// send the auth header. It is passed as the token query parameter and valid for a minute.
+func createStreamToken(c *gin.Context) {
+	streamToken, err := util.GenerateRandomString(32)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to generate stream token")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	cacheDb := c.MustGet("cache").(*cache.Cache)
+	cacheDb.Set(util.StreamTokenCachePrefix+streamToken, c.Request.Header.Get(util.AuthTokenHeaderName), streamTokenLifetime)
+	c.JSON(http.StatusOK, gin.H{"token": streamToken})
+}
+
+// streamStatus Server-Sent Events with the peers of the interface, a snapshot first and then the changes.
+// Non admin users only get the peers of the clients they may view, checked again on every heartbeat.
+// The stream ends when the auth token expires or the user logs out.
+func streamStatus(c *gin.Context) {
+	visible, err := visiblePeers(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read visible peers")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe, err := core.SubscribeStatus(iface.Name(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer unsubscribe()
+
+	cacheDb := c.MustGet("cache").(*cache.Cache)
+	authToken := c.Request.Header.Get(util.AuthTokenHeaderName)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	// keep nginx from buffering the events
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// dropped for falling behind, EventSource reconnects and gets a new snapshot
+				return false
+			}
+			c.SSEvent(event.Type, filterEvent(event, visible))
+			return true
+		case <-heartbeat.C:
+			if _, found := cacheDb.Get(authToken); !found {
+				return false
+			}
+			if visible != nil {
+				// clients created or deleted since, a failed read keeps the previous peers
+				if refreshed, err := visiblePeers(c); err == nil {
+					visible = refreshed
+				}
+			}
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -2,31 +2,68 @@ package users
 
 import (
 	"net/http"
-	"wg-gen-plus/auth"
+	"wg-gen-plus/api/authz"
 	"wg-gen-plus/core"
 	"wg-gen-plus/model"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/oauth2"
 )
 
 // ApplyRoutes applies router to gin Router
 func ApplyRoutes(r *gin.RouterGroup) {
 	g := r.Group("/users")
+	g.Use(authz.RequireUser())
 	{
-		g.GET("", readUsers)         // Get all users
-		g.GET("/me", getCurrentUser) // Get current authenticated user
-		g.GET("/:id", readUser)      // Get specific user
-		g.POST("", createUser)       // Create new user
-		g.PATCH("/:id", updateUser)  // Update existing user
-		g.DELETE("/:id", deleteUser) // Delete user
+		g.GET("", readUsers)                               // Get all users, non admins only get themselves
+		g.GET("/me", getCurrentUser)                       // Get current authenticated user
+		g.GET("/:id", requireSelfOrAdmin, readUser)        // Get specific user
+		g.POST("", authz.RequireAdmin(), createUser)       // Create new user
+		g.PATCH("/:id", requireSelfOrAdmin, updateUser)    // Update existing user
+		g.DELETE("/:id", authz.RequireAdmin(), deleteUser) // Delete user
 	}
 }
 
-// readUsers returns all users
+// requireSelfOrAdmin abort with 403 unless the current user is an admin or the user addressed by the id param
+func requireSelfOrAdmin(c *gin.Context) {
+	currentUser, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if !currentUser.IsAdmin && currentUser.Sub != c.Param("id") {
+		log.WithFields(log.Fields{
+			"user": currentUser.Name,
+			"id":   c.Param("id"),
+		}).Warn("user access denied")
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.Next()
+}
+
+// readUsers returns all users to admins, non admin users only get their own record
 func readUsers(c *gin.Context) {
+	currentUser, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !currentUser.IsAdmin {
+		c.JSON(http.StatusOK, []*model.User{currentUser})
+		return
+	}
+
 	users, err := core.ReadUsers()
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -36,6 +73,11 @@ func readUsers(c *gin.Context) {
 		return
 	}
 
+	// Don't return the password hashes
+	for _, user := range users {
+		user.Password = ""
+	}
+
 	c.JSON(http.StatusOK, users)
 }
 
@@ -57,6 +99,9 @@ func readUser(c *gin.Context) {
 		return
 	}
 
+	// Don't return the password hash
+	user.Password = ""
+
 	c.JSON(http.StatusOK, user)
 }
 
@@ -94,23 +139,7 @@ func createUser(c *gin.Context) {
 		return
 	}
 
-	// Get current user from auth
-	if !auth.IsLocalAuth() {
-		oauth2Token := c.MustGet("oauth2Token").(*oauth2.Token)
-		oauth2Client := c.MustGet("oauth2Client").(auth.Auth)
-		currentUser, err := oauth2Client.UserInfo(oauth2Token)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-			}).Error("failed to get current user")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		// Use currentUser to avoid compiler error
-		log.Debugf("User operation performed by: %s", currentUser.Name)
-	}
-
-	createdUser, err := core.CreateUser(&newUser)
+	createdUser, err := core.CreateUser(authz.Actor(c), &newUser)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -173,23 +202,26 @@ func updateUser(c *gin.Context) {
 		userData.Password = existingUser.Password
 	}
 
-	// Get current user from auth
-	if !auth.IsLocalAuth() {
-		oauth2Token := c.MustGet("oauth2Token").(*oauth2.Token)
-		oauth2Client := c.MustGet("oauth2Client").(auth.Auth)
-		currentUser, err := oauth2Client.UserInfo(oauth2Token)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-			}).Error("failed to get current user")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		// Use currentUser to avoid compiler error
-		log.Debugf("User %s is updating user %s", currentUser.Name, id)
+	currentUser, err := authz.CurrentUser(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to resolve current user")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Debugf("User %s is updating user %s", currentUser.Name, id)
+
+	// Only admins can change roles, quotas and identities, client ownership by email relies on the email
+	if !currentUser.IsAdmin {
+		userData.Name = existingUser.Name
+		userData.Email = existingUser.Email
+		userData.IsAdmin = existingUser.IsAdmin
+		userData.SelfService = existingUser.SelfService
+		userData.DeviceQuota = existingUser.DeviceQuota
 	}
 
-	updatedUser, err := core.UpdateUser(id, &userData)
+	updatedUser, err := core.UpdateUser(authz.Actor(c), id, &userData)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -213,7 +245,7 @@ func deleteUser(c *gin.Context) {
 		return
 	}
 
-	err := core.DeleteUser(id)
+	err := core.DeleteUser(authz.Actor(c), id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -228,78 +260,15 @@ func deleteUser(c *gin.Context) {
 
 // getCurrentUser returns the current authenticated user
 func getCurrentUser(c *gin.Context) {
-	// In local auth mode, we need to get user from session
-	if auth.IsLocalAuth() {
-		// Get user ID from context
-		userID, exists := c.Get("userID")
-		if !exists {
-			log.Warn("No user ID in context, user may not be authenticated")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-			return
-		}
-
-		userIDStr, ok := userID.(string)
-		if !ok {
-			log.Error("User ID in context is not a string")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		// Get user from storage
-		user, err := core.ReadUser(userIDStr)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-				"id":  userIDStr,
-			}).Error("Failed to read current user")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		// Don't return password hash
-		user.Password = ""
-
-		// Return user info
-		c.JSON(http.StatusOK, user)
-		return
-	}
-
-	// In OAuth mode, get user info from provider
-	oauth2Token := c.MustGet("oauth2Token").(*oauth2.Token)
-	oauth2Client := c.MustGet("oauth2Client").(auth.Auth)
-	userInfo, err := oauth2Client.UserInfo(oauth2Token)
+	user, err := authz.CurrentUser(c)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
-		}).Error("Failed to get user info from OAuth provider")
+		}).Error("Failed to read current user")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	// Try to find user in our database by sub/ID
-	user, err := core.ReadUser(userInfo.Sub)
-	if err != nil {
-		// User might not exist in our database yet
-		// For OAuth users, we could create them on the fly
-		newUser := &model.User{
-			Sub:     userInfo.Sub,
-			Name:    userInfo.Name,
-			Email:   userInfo.Email,
-			IsAdmin: false, // Default to non-admin for new users
-		}
-
-		// Create the user if they don't exist
-		user, err = core.CreateUser(newUser)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-				"sub": userInfo.Sub,
-			}).Error("Failed to create user from OAuth info")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Return user info
 	c.JSON(http.StatusOK, user)
 }
@@ -1,8 +1,17 @@
 package apiv1
 
 import (
+	"wg-gen-plus/api/iface"
+	"wg-gen-plus/api/v1/alerts"
+	"wg-gen-plus/api/v1/audit"
 	"wg-gen-plus/api/v1/auth"
+	"wg-gen-plus/api/v1/backup"
 	"wg-gen-plus/api/v1/client"
+	"wg-gen-plus/api/v1/interfaces"
+	"wg-gen-plus/api/v1/ipam"
+	"wg-gen-plus/api/v1/keys"
+	"wg-gen-plus/api/v1/profiles"
+	"wg-gen-plus/api/v1/self"
 	"wg-gen-plus/api/v1/server"
 	"wg-gen-plus/api/v1/status"
 	"wg-gen-plus/api/v1/users"
@@ -15,10 +24,24 @@ func ApplyRoutes(r *gin.RouterGroup, private bool) {
 	v1 := r.Group("/v1.0")
 	{
 		if private {
-			client.ApplyRoutes(v1)
-			server.ApplyRoutes(v1)
-			status.ApplyRoutes(v1)
+			// interface routes without interface name work on the default interface
+			for _, g := range []*gin.RouterGroup{
+				v1.Group("", iface.Resolve()),
+				v1.Group("/interfaces/:iface", iface.Resolve()),
+			} {
+				client.ApplyRoutes(g)
+				server.ApplyRoutes(g)
+				status.ApplyRoutes(g)
+				keys.ApplyRoutes(g)
+				ipam.ApplyRoutes(g)
+			}
+			interfaces.ApplyRoutes(v1)
 			users.ApplyRoutes(v1)
+			profiles.ApplyRoutes(v1)
+			self.ApplyRoutes(v1)
+			audit.ApplyRoutes(v1)
+			alerts.ApplyRoutes(v1)
+			backup.ApplyRoutes(v1)
 		} else {
 			auth.ApplyRoutes(v1)
 		}
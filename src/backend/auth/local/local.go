@@ -3,8 +3,8 @@ package local
 import (
 	"errors"
 	"strings"
+	"wg-gen-plus/core"
 	"wg-gen-plus/model"
-	"wg-gen-plus/storage"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -20,7 +20,7 @@ func (l *Local) Setup() error {
 // Authenticate checks username and password against database
 func (l *Local) Authenticate(username, password string) (*model.User, error) {
 	// Get all users from database
-	users, err := storage.LoadAllUsers()
+	users, err := core.ReadUsers()
 	if err != nil {
 		return nil, errors.New("failed to load users from database")
 	}
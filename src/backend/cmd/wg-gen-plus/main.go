@@ -14,14 +14,18 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"wg-gen-plus/api"
 	"wg-gen-plus/auth"
 	"wg-gen-plus/core"
+	"wg-gen-plus/model"
 	"wg-gen-plus/storage"
 	"wg-gen-plus/util"
 	"wg-gen-plus/version"
@@ -34,10 +38,13 @@ import (
 	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
+	"golang.zx2c4.com/wireguard/wgctrl"
 )
 
 const help = `Wg Gen Plus is a comprehensive web based configuration generator for WireGuard
 Usage: wg-gen-plus [options]
+       wg-gen-plus [options] backup <file>   write a backup of the database to file, - for stdout, and exit
+       wg-gen-plus [options] restore <file>  restore the backup in file, - for stdin, and exit
 
 Helpers:
   --version       display the version number of Wg Gen Plus
@@ -46,11 +53,21 @@ Helpers:
 Options:
   --config=<file>            file name of optional Wg Gen Plus configuration file
   --db-dir=<path>            directory for the database file (default: /var/lib/wg-gen-plus)
-  --wg-interface=<name>      name of the WireGuard interface (default: wg0)
+  --wg-interface=<names>     comma separated names of the WireGuard interfaces, the first is the default (default: wg0)
   --wg-conf-path=<name>      path to the WireGuard configuration directory (default: /etc/wireguard/)
   --port=<port>              port to run the web server on (default: 8080)
   --server=<address>         address to bind the web server to (default: 0.0.0.0)
   --use-defaults=true|false  use all default values for server (default: false - enable for testing only)
+  --migrate                  apply pending database schema migrations and exit
+  --migrate-dry-run          list pending database schema migrations, test them in a rolled back transaction and exit
+  --import=<file>            import clients from a .csv or .json file into the default interface and exit
+  --import-dry-run           only validate the clients of --import and show their addresses
+  --import-interface=<name>  interface to import the clients of --import into
+  --rotate-encryption-key=<file>  re-encrypt the stored keys with the master key in file and exit
+  --decrypt-keys             store the keys in plaintext again and exit
+  --backup-passphrase-file=<file>  encrypt the backup, or decrypt the restored one, with the passphrase in file
+                             (default: the BACKUP_PASSPHRASE environment variable, no encryption when unset)
+  --restore-mode=merge|replace  keep the objects which are not in the restored backup, or delete them (default: merge)
 `
 
 // Default configuration values
@@ -72,8 +89,6 @@ const (
 	DefaultAuthType = "local"
 )
 
-var WgConfigFile string
-
 var (
 	cacheDb = cache.New(60*time.Minute, 10*time.Minute)
 )
@@ -114,6 +129,15 @@ func main() {
 		serverflag      string
 		ginmodeflag     bool
 		useDefaults     bool
+		migrate         bool
+		migrateDryRun   bool
+		importFile      string
+		importDryRun    bool
+		importInterface string
+		rotateKeyFile   string
+		decryptKeys     bool
+		passphraseFile  string
+		restoreMode     string
 		err             error
 	)
 
@@ -127,8 +151,27 @@ func main() {
 	flag.StringVar(&serverflag, "server", "", "Address to bind the web server to")
 	flag.BoolVar(&ginmodeflag, "debug", false, "Set Gin mode to debug")
 	flag.BoolVar(&useDefaults, "use-defaults", false, "Use all default values for server configuration (For testing only)")
+	flag.BoolVar(&migrate, "migrate", false, "Apply pending database schema migrations and exit")
+	flag.BoolVar(&migrateDryRun, "migrate-dry-run", false, "List and test pending database schema migrations without applying them, then exit")
+	flag.StringVar(&importFile, "import", "", "Import clients from a CSV or JSON file, then exit")
+	flag.BoolVar(&importDryRun, "import-dry-run", false, "Validate the clients of --import without importing them")
+	flag.StringVar(&importInterface, "import-interface", "", "Interface to import the clients of --import into")
+	flag.StringVar(&rotateKeyFile, "rotate-encryption-key", "", "Re-encrypt the stored keys with the master key in this file, then exit")
+	flag.BoolVar(&decryptKeys, "decrypt-keys", false, "Store the keys in plaintext again, then exit")
+	flag.StringVar(&passphraseFile, "backup-passphrase-file", "", "Encrypt or decrypt the backup with the passphrase in this file")
+	flag.StringVar(&restoreMode, "restore-mode", model.RestoreModeMerge, "Restore mode, merge or replace")
 	flag.Parse()
 
+	// backup and restore commands run instead of the server
+	command := flag.Arg(0)
+	switch {
+	case flag.NArg() == 0:
+	case (command == "backup" || command == "restore") && flag.NArg() == 2:
+	default:
+		fmt.Print(help)
+		os.Exit(2)
+	}
+
 	if !useDefaults {
 
 		// If configPathflag is set, use it, otherwise use the environment variable
@@ -204,7 +247,16 @@ func main() {
 	}
 
 	// Set file path variables from environment
-	wgInterface = os.Getenv("WG_INTERFACE_NAME")
+	wgInterfaces := []string{}
+	for _, name := range strings.Split(os.Getenv("WG_INTERFACE_NAME"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wgInterfaces = append(wgInterfaces, name)
+		}
+	}
+	if len(wgInterfaces) > 0 {
+		// the default interface names the config file
+		wgInterface = wgInterfaces[0]
+	}
 	dbDir := os.Getenv("DB_FILE_DIR")
 	wgConfPath := os.Getenv("WG_CONF_DIR")
 	configFile = os.Getenv("WG_GEN_CONF_FILE")
@@ -236,16 +288,26 @@ func main() {
 		}
 	}
 
-	// Set other filename paths
-	WgConfigFile = filepath.Join(wgConfPath, fmt.Sprintf("%s.conf", wgInterface))
-	core.WgConfigFile = WgConfigFile
-	dbFile := filepath.Join(dbDir, fmt.Sprintf("wg-gen-plus-%s.db", wgInterface))
+	// Set other filename paths, one database for all interfaces
+	core.WgConfDir = wgConfPath
+	dbType := os.Getenv("DB_TYPE")
+	if dbType == "" {
+		dbType = "sqlite"
+	}
+	dbDsn := os.Getenv("DB_DSN")
+	dbFile := ""
+	if dbType == "sqlite" && dbDsn == "" {
+		dbFile = filepath.Join(dbDir, "wg-gen-plus.db")
+		dbDsn = dbFile
+	}
 
-	// Log file paths to console
+	// Log file paths to console, the DSN may hold a password
 	log.WithFields(log.Fields{
-		"configFile":   configFile,
-		"dbFile":       dbFile,
-		"WgConfigFile": WgConfigFile,
+		"configFile": configFile,
+		"dbType":     dbType,
+		"dbFile":     dbFile,
+		"WgConfDir":  wgConfPath,
+		"interfaces": wgInterfaces,
 	}).Info("Using the following file paths")
 
 	if os.Getenv("WG_STATS_API") != "" {
@@ -257,12 +319,18 @@ func main() {
 	if configFile == "" {
 		missing = append(missing, "configFile")
 	}
-	if dbDir == "" {
+	if dbDir == "" && dbFile != "" {
 		missing = append(missing, "dbDir")
 	}
+	if dbDsn == "" {
+		missing = append(missing, "dbDsn")
+	}
 	if wgConfPath == "" {
 		missing = append(missing, "wgConfPath")
 	}
+	if len(wgInterfaces) == 0 {
+		missing = append(missing, "wgInterface")
+	}
 	if len(missing) > 0 {
 		log.WithFields(log.Fields{
 			"missing": missing,
@@ -277,19 +345,91 @@ func main() {
 	}
 
 	// Check if the DB directory exists
-	if !util.DirectoryExists(dbDir) {
+	if dbDir != "" && !util.DirectoryExists(dbDir) {
 		log.WithFields(log.Fields{
 			"dir": dbDir,
 		}).Fatalf("Database directory does not exist: %s. Please create it or set DB_FILE_DIR to a valid directory.", dbDir)
 	}
 
+	// the private and preshared keys are encrypted at rest once a master key is set
+	secrets, err := encryptionCipher()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("failed to load encryption key")
+	}
+
 	// Initialize database
-	err = storage.InitStorage(dbFile)
+	store, err := storage.Open(dbType, dbDsn, secrets)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"db_type": dbType,
+			"db_file": dbFile,
+		}).Fatal("failed to initialize storage")
+	}
+	defer store.Close()
+	core.SetStore(store)
+
+	// Bring the database schema up to date, or only report what would be done
+	if migrate || migrateDryRun {
+		runMigrations(store, migrateDryRun)
+		os.Exit(0)
+	}
+	applied, err := store.Migrate(false)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err":     err,
+			"db_type": dbType,
 			"db_file": dbFile,
-		}).Fatal("failed to initialize SQLite storage")
+		}).Fatal("failed to migrate database schema")
+	}
+	for _, m := range applied {
+		log.WithFields(log.Fields{
+			"version":     m.Version,
+			"description": m.Description,
+		}).Info("applied database schema migration")
+	}
+
+	// re-encrypt the keys with a new master key, or decrypt them, instead of running the server
+	if rotateKeyFile != "" || decryptKeys {
+		os.Exit(runReencrypt(store, rotateKeyFile))
+	}
+	// encrypt the keys stored in plaintext, which also checks the stored keys can be read with the master key
+	encrypted, err := store.ReencryptSecrets(secrets)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("failed to read the stored keys, check the encryption key")
+	}
+	if encrypted > 0 {
+		log.WithFields(log.Fields{
+			"keys":      encrypted,
+			"masterKey": secrets.KeyID(),
+		}).Info("encrypted the stored keys")
+	}
+
+	// Register interfaces, importing the per interface databases of previous releases on first start
+	for _, name := range wgInterfaces {
+		legacyDbFile := ""
+		if dbDir != "" {
+			legacyDbFile = filepath.Join(dbDir, fmt.Sprintf("wg-gen-plus-%s.db", name))
+		}
+		err = core.AddInterface(name, legacyDbFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": name,
+			}).Fatal("failed to add interface")
+		}
+	}
+
+	// after the imports, so imported users are not shadowed by a new admin
+	err = storage.CreateDefaultAdminIfNeeded(store)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("failed to create default admin user")
 	}
 
 	if os.Getenv("GIN_MODE") == "debug" {
@@ -304,12 +444,95 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
-	// dump wg config file
-	err = core.UpdateServerConfigWg()
+	// the kernel interfaces give the peer status, and are configured directly with live apply
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		for _, name := range wgInterfaces {
+			if core.LiveApply(name) {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Fatal("failed to open WireGuard control client for live apply")
+			}
+		}
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warning("failed to open WireGuard control client, status needs WG_STATS_API")
+	} else {
+		defer wgClient.Close()
+		core.SetDevice(wgClient)
+	}
+
+	// import clients with a single config update instead of running the server
+	if importFile != "" {
+		os.Exit(runImport(importFile, importInterface, importDryRun))
+	}
+	if command != "" {
+		passphrase, err := backupPassphrase(passphraseFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": passphraseFile,
+			}).Fatal("failed to read backup passphrase")
+		}
+		if command == "backup" {
+			os.Exit(runBackup(flag.Arg(1), passphrase))
+		}
+		os.Exit(runRestore(flag.Arg(1), passphrase, restoreMode))
+	}
+
+	// dump wg config files
+	for _, name := range wgInterfaces {
+		err = core.UpdateServerConfigWg(name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": name,
+			}).Fatal("failed to dump wg config file")
+		}
+	}
+
+	// keep the traffic history of the clients, TRAFFIC_SAMPLE_INTERVAL=0 turns it off
+	sampleInterval := durationEnv("TRAFFIC_SAMPLE_INTERVAL", time.Minute)
+	if sampleInterval > 0 {
+		err = core.StartTrafficSampler(sampleInterval,
+			durationEnv("TRAFFIC_RAW_RETENTION", 48*time.Hour),
+			durationEnv("TRAFFIC_RETENTION", 400*24*time.Hour))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("failed to start traffic sampler")
+		}
+	}
+
+	// check the alert rules, ALERT_INTERVAL=0 turns alerting off
+	alertInterval := durationEnv("ALERT_INTERVAL", time.Minute)
+	if alertInterval > 0 {
+		err = core.StartAlerting(alertInterval)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("failed to start alerting")
+		}
+	}
+
+	// enable clients within their validity window, disable or delete them once expired
+	err = core.StartClientScheduler(durationEnv("CLIENT_SCHEDULE_INTERVAL", time.Minute),
+		time.Duration(intEnv("EXPIRY_REMINDER_DAYS", 3))*24*time.Hour)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
-		}).Fatal("failed to dump wg config file")
+		}).Fatal("failed to start client scheduler")
+	}
+
+	// push status changes to dashboards, STATUS_STREAM_INTERVAL=0 turns the stream off
+	streamInterval := durationEnv("STATUS_STREAM_INTERVAL", 5*time.Second)
+	if streamInterval > 0 {
+		err = core.StartStatusStream(streamInterval)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("failed to start status stream")
+		}
 	}
 
 	// creates a gin router with default middleware: logger and recovery (crash-free) middleware
@@ -319,6 +542,7 @@ func main() {
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AddAllowHeaders("Authorization", util.AuthTokenHeaderName)
+	config.AddExposeHeaders(model.KeyRotationHeader)
 	app.Use(cors.New(config))
 
 	// protection middleware
@@ -371,6 +595,16 @@ func main() {
 		cacheDb := c.MustGet("cache").(*cache.Cache)
 		token := c.Request.Header.Get(util.AuthTokenHeaderName)
 
+		// EventSource can not set headers, it sends a one time stream token standing for the auth token instead
+		if token == "" && strings.HasSuffix(c.Request.URL.Path, "/status/stream") {
+			key := util.StreamTokenCachePrefix + c.Query(util.StreamTokenQueryName)
+			if authToken, found := cacheDb.Get(key); found {
+				cacheDb.Delete(key)
+				token = authToken.(string)
+				c.Request.Header.Set(util.AuthTokenHeaderName, token)
+			}
+		}
+
 		// For local auth, the token maps to a user ID
 		if auth.IsLocalAuth() {
 			// If no token, reject the request
@@ -452,6 +686,295 @@ func main() {
 	}
 }
 
+// runMigrations apply pending schema migrations, or test them in a rolled back transaction for a dry run
+func runMigrations(store storage.Store, dryRun bool) {
+	version, err := store.SchemaVersion()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("failed to read database schema version")
+	}
+	fmt.Printf("Current schema version: %d\n", version)
+
+	migrations, err := store.Migrate(dryRun)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("failed to migrate database schema")
+	}
+	if len(migrations) == 0 {
+		fmt.Println("Database schema is up to date")
+		return
+	}
+
+	action := "Applied"
+	if dryRun {
+		action = "Pending (dry run, nothing applied)"
+	}
+	fmt.Printf("%s:\n", action)
+	for _, m := range migrations {
+		fmt.Printf("  %d  %s\n", m.Version, m.Description)
+	}
+}
+
+// runImport import the clients of file into interface iface, the default one when empty, and return the exit code
+func runImport(file, iface string, dryRun bool) int {
+	if iface == "" {
+		iface = core.DefaultInterface
+	}
+	if !core.IsInterface(iface) {
+		log.WithField("interface", iface).Error("unknown interface to import into")
+		return 1
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": file,
+		}).Error("failed to open import file")
+		return 1
+	}
+	defer f.Close()
+	var rows []*model.ImportRow
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		rows, err = core.ParseImportCSV(f)
+	} else {
+		rows, err = core.ParseImportJSON(f)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": file,
+		}).Error("failed to read import file")
+		return 1
+	}
+
+	result, err := core.ImportClients(model.Actor{Name: "cli"}, iface, rows, dryRun)
+	if err != nil && !errors.Is(err, core.ErrImportInvalid) {
+		log.WithFields(log.Fields{
+			"err":       err,
+			"interface": iface,
+		}).Error("failed to import clients")
+		return 1
+	}
+	for _, row := range result.Rows {
+		if len(row.Errors) > 0 {
+			fmt.Printf("  %4d  %-40s  %s\n", row.Row, row.Name, strings.Join(row.Errors, "; "))
+		} else {
+			fmt.Printf("  %4d  %-40s  %s\n", row.Row, row.Name, strings.Join(row.Client.Address, ", "))
+		}
+	}
+	switch {
+	case err != nil:
+		fmt.Printf("%d of %d rows are invalid, nothing imported\n", result.Total-result.Valid, result.Total)
+		return 1
+	case dryRun:
+		fmt.Printf("%d rows are valid (dry run, nothing imported)\n", result.Valid)
+	default:
+		fmt.Printf("Imported %d clients into %s\n", result.Imported, iface)
+	}
+	return 0
+}
+
+// backupPassphrase passphrase of backups, read from file or set in BACKUP_PASSPHRASE, empty when none is set
+func backupPassphrase(file string) (string, error) {
+	if file == "" {
+		return os.Getenv("BACKUP_PASSPHRASE"), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// runBackup write a backup of the database to file, stdout for -, encrypted with passphrase unless it is empty,
+// and return the exit code
+func runBackup(file, passphrase string) int {
+	backup, err := core.CreateBackup()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to create backup")
+		return 1
+	}
+	data, err := core.EncodeBackup(backup, passphrase)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to encode backup")
+		return 1
+	}
+
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		// the backup holds the private keys, only the owner may read it
+		err = os.WriteFile(file, data, 0600)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": file,
+		}).Error("failed to write backup")
+		return 1
+	}
+	if file != "-" {
+		fmt.Printf("Backed up %d servers, %d clients and %d users to %s\n", len(backup.Servers), len(backup.Clients), len(backup.Users), file)
+	}
+	return 0
+}
+
+// runRestore restore the backup in file, stdin for -, in mode and return the exit code
+func runRestore(file, passphrase, mode string) int {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": file,
+		}).Error("failed to read backup")
+		return 1
+	}
+
+	backup, err := core.DecodeBackup(data, passphrase)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": file,
+		}).Error("failed to read backup")
+		return 1
+	}
+	result, err := core.RestoreBackup(model.Actor{Name: "cli"}, backup, mode)
+	if errors.Is(err, core.ErrBackupInvalid) {
+		for _, e := range result.Errors {
+			fmt.Printf("  %s\n", e)
+		}
+		fmt.Printf("%d errors, nothing restored\n", len(result.Errors))
+		return 1
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": file,
+		}).Error("failed to restore backup")
+		return 1
+	}
+	fmt.Printf("Restored %d servers, %d clients and %d users (%s), deleted %d objects\n",
+		result.Servers, result.Clients, result.Users, result.Mode, result.Deleted)
+	return 0
+}
+
+// encryptionCipher cipher of the master key read from ENCRYPTION_KEY_FILE, set in ENCRYPTION_KEY or printed by
+// ENCRYPTION_KEY_COMMAND, nil when none is set
+func encryptionCipher() (*storage.Cipher, error) {
+	var value string
+	switch {
+	case os.Getenv("ENCRYPTION_KEY_FILE") != "":
+		data, err := os.ReadFile(os.Getenv("ENCRYPTION_KEY_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		value = string(data)
+	case os.Getenv("ENCRYPTION_KEY") != "":
+		value = os.Getenv("ENCRYPTION_KEY")
+	case os.Getenv("ENCRYPTION_KEY_COMMAND") != "":
+		output, err := exec.Command("bash", "-c", os.Getenv("ENCRYPTION_KEY_COMMAND")).Output()
+		if err != nil {
+			return nil, fmt.Errorf("encryption key command failed: %w", err)
+		}
+		value = string(output)
+	default:
+		return nil, nil
+	}
+	key, err := storage.ParseMasterKey(value)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewCipher(key)
+}
+
+// runReencrypt re-encrypt the stored keys with the master key in newKeyFile, or decrypt them when empty,
+// and return the exit code
+func runReencrypt(store storage.Store, newKeyFile string) int {
+	var next *storage.Cipher
+	if newKeyFile != "" {
+		data, err := os.ReadFile(newKeyFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": newKeyFile,
+			}).Error("failed to read new encryption key")
+			return 1
+		}
+		key, err := storage.ParseMasterKey(string(data))
+		if err == nil {
+			next, err = storage.NewCipher(key)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": newKeyFile,
+			}).Error("invalid new encryption key")
+			return 1
+		}
+	}
+
+	count, err := store.ReencryptSecrets(next)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to re-encrypt the stored keys, nothing changed")
+		return 1
+	}
+	if next == nil {
+		fmt.Printf("Decrypted %d keys, remove the encryption key from the configuration before the next start\n", count)
+	} else {
+		fmt.Printf("Re-encrypted %d keys with master key %s, make it the encryption key before the next start\n", count, next.KeyID())
+	}
+	return 0
+}
+
+// durationEnv duration set in environment variable key, like 30s or 48h, def when unset or invalid
+func durationEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":   err,
+			"value": value,
+		}).Warningf("invalid %s, using %s", key, def)
+		return def
+	}
+	return d
+}
+
+// intEnv integer set in environment variable key, def when unset or invalid
+func intEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":   err,
+			"value": value,
+		}).Warningf("invalid %s, using %d", key, def)
+		return def
+	}
+	return n
+}
+
 func setDefaultsIfRequested() {
 	os.Setenv("WG_CONF_DIR", DefaultWgConfPath)
 	os.Setenv("WG_INTERFACE_NAME", DefaultWgInterface)
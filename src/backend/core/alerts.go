@@ -0,0 +1,474 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/gomail.v2"
+)
+
+// CreateAlertRule creates a new alert rule, an empty interface checks every interface
+func CreateAlertRule(actor model.Actor, rule *model.AlertRule) (*model.AlertRule, error) {
+	err := checkAlertRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to generate UUID")
+		return nil, errors.New("failed to generate alert rule ID")
+	}
+	rule.Id = u.String()
+	rule.Created = time.Now().UTC()
+	rule.Updated = rule.Created
+
+	err = store.SaveAlertRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := store.LoadAlertRule(rule.Id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionCreate, "alertRule", created.Id, created.Name, nil, created)
+	return created, nil
+}
+
+// ReadAlertRule alert rule by id
+func ReadAlertRule(id string) (*model.AlertRule, error) {
+	return store.LoadAlertRule(id)
+}
+
+// ReadAlertRules all alert rules
+func ReadAlertRules() ([]*model.AlertRule, error) {
+	return store.LoadAllAlertRules()
+}
+
+// UpdateAlertRule updates an existing alert rule, the firing alerts of a disabled rule are resolved without notification
+func UpdateAlertRule(actor model.Actor, id string, rule *model.AlertRule) (*model.AlertRule, error) {
+	current, err := store.LoadAlertRule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Id != id {
+		return nil, errors.New("records Id mismatch")
+	}
+
+	err = checkAlertRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	rule.CreatedBy = current.CreatedBy
+	rule.Created = current.Created
+	rule.Updated = time.Now().UTC()
+
+	var updated *model.AlertRule
+	err = store.WithTx(func(tx storage.Store) error {
+		err := tx.SaveAlertRule(rule)
+		if err != nil {
+			return err
+		}
+		updated, err = tx.LoadAlertRule(id)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionUpdate, "alertRule", updated.Id, updated.Name, current, updated)
+		if updated.Enable {
+			return nil
+		}
+		return resolveRuleAlerts(tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteAlertRule removes an alert rule, its alerts are kept as history and resolved without notification
+func DeleteAlertRule(actor model.Actor, id string) error {
+	rule, err := store.LoadAlertRule(id)
+	if err != nil {
+		return err
+	}
+	return store.WithTx(func(tx storage.Store) error {
+		err := tx.DeleteAlertRule(id)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionDelete, "alertRule", rule.Id, rule.Name, rule, nil)
+		return resolveRuleAlerts(tx, id)
+	})
+}
+
+// checkAlertRule validate rule and resolve its interface
+func checkAlertRule(rule *model.AlertRule) error {
+	errs := rule.IsValid()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("alert rule validation error")
+		}
+		return errors.New("failed to validate alert rule")
+	}
+	if rule.Interface != "" && !IsInterface(rule.Interface) {
+		return ErrUnknownInterface
+	}
+	return nil
+}
+
+// resolveRuleAlerts resolve the firing alerts of rule id in s
+func resolveRuleAlerts(s storage.Store, id string) error {
+	alerts, _, err := s.LoadAlerts(model.AlertFilter{RuleId: id, State: model.AlertStateFiring})
+	if err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		alert.State = model.AlertStateResolved
+		alert.Resolved = time.Now().UTC()
+		err = s.SaveAlert(alert)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAlerts alerts matching filter, most recently fired first, and the number of matching alerts
+func ReadAlerts(filter model.AlertFilter) ([]*model.Alert, int, error) {
+	return store.LoadAlerts(filter)
+}
+
+// AcknowledgeAlert mark alert id as seen by actor, acknowledging does not resolve it
+func AcknowledgeAlert(actor model.Actor, id string) (*model.Alert, error) {
+	current, err := store.LoadAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Acknowledged {
+		return current, nil
+	}
+
+	alert := *current
+	alert.Acknowledged = true
+	alert.AcknowledgedBy = actor.Name
+	alert.AcknowledgedAt = time.Now().UTC()
+	err = store.SaveAlert(&alert)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionUpdate, "alert", alert.Id, alert.RuleName, current, &alert)
+	return &alert, nil
+}
+
+// alertCondition client or peer currently meeting a rule
+type alertCondition struct {
+	name    string
+	message string
+}
+
+// StartAlerting evaluate the enabled alert rules each interval in the background
+func StartAlerting(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("alert interval must be positive")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			evaluateAlerts(now.UTC())
+		}
+	}()
+	return nil
+}
+
+// evaluateAlerts fire an alert for each client or peer newly meeting a rule, resolve the alerts of the ones no longer
+// meeting it. An alert is only notified when it fires and when it resolves.
+func evaluateAlerts(now time.Time) {
+	rules, err := store.LoadAllAlertRules()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read alert rules")
+		return
+	}
+	// peers are read once per interface for all rules
+	peers := make(map[string][]*model.ClientStatus)
+
+	for _, rule := range rules {
+		if !rule.Enable {
+			continue
+		}
+		interfaces := configuredInterfaces
+		if rule.Interface != "" {
+			interfaces = []string{rule.Interface}
+		}
+		for _, iface := range interfaces {
+			if _, ok := peers[iface]; !ok {
+				ifacePeers, err := readPeers(iface)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err":       err,
+						"interface": iface,
+					}).Debug("failed to read peers for alerts")
+				}
+				peers[iface] = ifacePeers
+			}
+			// without status the rules can not tell, leave the alerts as they are, key ages need no status
+			if peers[iface] == nil && rule.Type != model.AlertRuleKeyOverdue {
+				continue
+			}
+			conditions, err := alertConditions(rule, iface, peers[iface], now)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err":       err,
+					"rule":      rule.Name,
+					"interface": iface,
+				}).Error("failed to evaluate alert rule")
+				continue
+			}
+			updateAlerts(rule, iface, conditions, now)
+		}
+	}
+}
+
+// readPeers peers of interface iface, nil without status source
+func readPeers(iface string) ([]*model.ClientStatus, error) {
+	source := statusSourceOf(iface)
+	if source == nil {
+		return nil, nil
+	}
+	return source.Peers(iface)
+}
+
+// alertConditions clients or peers of interface iface meeting rule, by alert subject
+func alertConditions(rule *model.AlertRule, iface string, peers []*model.ClientStatus, now time.Time) (map[string]alertCondition, error) {
+	conditions := make(map[string]alertCondition)
+	clients, err := store.LoadAllClients(iface)
+	if err != nil {
+		return nil, err
+	}
+	byPublicKey := make(map[string]*model.ClientStatus, len(peers))
+	for _, peer := range peers {
+		byPublicKey[peer.PublicKey] = peer
+	}
+
+	switch rule.Type {
+	case model.AlertRuleStaleHandshake:
+		for _, client := range clients {
+			peer, ok := byPublicKey[client.PublicKey]
+			if !ok || !client.Enable || !alertRuleMatches(rule, client) {
+				continue
+			}
+			if peer.LastHandshake.IsZero() {
+				conditions[client.Id] = alertCondition{client.Name, fmt.Sprintf("%s has never completed a handshake", client.Name)}
+				continue
+			}
+			age := now.Sub(peer.LastHandshake)
+			if age > time.Duration(rule.Minutes)*time.Minute {
+				conditions[client.Id] = alertCondition{client.Name,
+					fmt.Sprintf("%s has had no handshake for %d minutes", client.Name, int(age.Minutes()))}
+			}
+		}
+	case model.AlertRuleTrafficExceeded:
+		midnight := now.Truncate(24 * time.Hour)
+		for _, client := range clients {
+			if !alertRuleMatches(rule, client) {
+				continue
+			}
+			received, transmitted, err := clientTraffic(client.Id, midnight)
+			if err != nil {
+				return nil, err
+			}
+			if total := received + transmitted; total > rule.Bytes {
+				conditions[client.Id] = alertCondition{client.Name,
+					fmt.Sprintf("%s transferred %d bytes today, more than %d", client.Name, total, rule.Bytes)}
+			}
+		}
+	case model.AlertRuleQuotaExceeded:
+		for _, client := range clients {
+			if client.QuotaBytes == 0 || !alertRuleMatches(rule, client) {
+				continue
+			}
+			usage, err := quotaUsage(client, now)
+			if err != nil {
+				return nil, err
+			}
+			if usage.Exceeded {
+				conditions[client.Id] = alertCondition{client.Name,
+					fmt.Sprintf("%s transferred %d bytes this %s, more than its quota of %d",
+						client.Name, usage.UsedBytes, usage.Period, usage.QuotaBytes)}
+			}
+		}
+	case model.AlertRuleKeyOverdue:
+		maxAgeDays, graceDays := KeyPolicy(iface)
+		for _, client := range clients {
+			if !alertRuleMatches(rule, client) {
+				continue
+			}
+			if age := keyAge(client.KeysRotated, now, maxAgeDays, graceDays); age.Overdue {
+				conditions[client.Id] = alertCondition{client.Name,
+					fmt.Sprintf("%s keys are %d days old, older than the max key age of %d days",
+						client.Name, age.AgeDays, maxAgeDays)}
+			}
+		}
+	case model.AlertRuleUnknownPeer:
+		known := make(map[string]bool, len(clients))
+		for _, client := range clients {
+			known[client.PublicKey] = true
+		}
+		for _, peer := range peers {
+			if !known[peer.PublicKey] {
+				conditions[peer.PublicKey] = alertCondition{peer.PublicKey,
+					fmt.Sprintf("unknown peer %s is configured on %s", peer.PublicKey, iface)}
+			}
+		}
+	}
+	return conditions, nil
+}
+
+// alertRuleMatches check if client is selected by the site to site and tag filters of rule
+func alertRuleMatches(rule *model.AlertRule, client *model.Client) bool {
+	if rule.Site2SiteOnly && !client.Site2Site {
+		return false
+	}
+	if len(rule.Tags) == 0 {
+		return true
+	}
+	for _, tag := range rule.Tags {
+		for _, clientTag := range client.Tags {
+			if strings.EqualFold(tag, clientTag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updateAlerts fire the new conditions of rule on interface iface and resolve the firing alerts without condition
+func updateAlerts(rule *model.AlertRule, iface string, conditions map[string]alertCondition, now time.Time) {
+	firing, _, err := store.LoadAlerts(model.AlertFilter{RuleId: rule.Id, State: model.AlertStateFiring, Interface: iface})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"rule": rule.Name,
+		}).Error("failed to read firing alerts")
+		return
+	}
+
+	for _, alert := range firing {
+		if _, ok := conditions[alert.Subject]; ok {
+			// already firing, notified once
+			delete(conditions, alert.Subject)
+			continue
+		}
+		alert.State = model.AlertStateResolved
+		alert.Resolved = now
+		err = store.SaveAlert(alert)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"rule": rule.Name,
+			}).Error("failed to resolve alert")
+			continue
+		}
+		notifyAlert(rule, alert)
+	}
+
+	for subject, condition := range conditions {
+		u, err := uuid.NewV4()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to generate UUID")
+			continue
+		}
+		alert := &model.Alert{
+			Id:          u.String(),
+			RuleId:      rule.Id,
+			RuleName:    rule.Name,
+			Interface:   iface,
+			Subject:     subject,
+			SubjectName: condition.name,
+			Message:     condition.message,
+			State:       model.AlertStateFiring,
+			Fired:       now,
+		}
+		err = store.SaveAlert(alert)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"rule": rule.Name,
+			}).Error("failed to save alert")
+			continue
+		}
+		notifyAlert(rule, alert)
+	}
+}
+
+// notifyAlert send alert to the emails and webhooks of rule, failures are logged
+func notifyAlert(rule *model.AlertRule, alert *model.Alert) {
+	log.WithFields(log.Fields{
+		"rule":      rule.Name,
+		"interface": alert.Interface,
+		"subject":   alert.SubjectName,
+		"state":     alert.State,
+	}).Warn(alert.Message)
+
+	if len(rule.Emails) > 0 {
+		m := gomail.NewMessage()
+		m.SetHeader("To", rule.Emails...)
+		m.SetHeader("Subject", fmt.Sprintf("[%s] %s: %s", strings.ToUpper(alert.State), rule.Name, alert.SubjectName))
+		m.SetBody("text/plain", fmt.Sprintf("%s\n\nInterface: %s\nFired: %s\n", alert.Message, alert.Interface,
+			alert.Fired.Format(time.RFC3339)))
+		err := sendMail(m)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"rule": rule.Name,
+			}).Error("failed to email alert")
+		}
+	}
+
+	if len(rule.Webhooks) == 0 {
+		return
+	}
+	// only the rule identity, its webhook URLs may hold tokens
+	payload, _ := json.Marshal(map[string]interface{}{
+		"rule":  map[string]string{"id": rule.Id, "name": rule.Name, "type": rule.Type},
+		"alert": alert,
+	})
+	webhookClient := http.Client{
+		Timeout: time.Second * 5,
+	}
+	for _, webhook := range rule.Webhooks {
+		res, err := webhookClient.Post(webhook, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode >= 300 {
+				err = fmt.Errorf("webhook returned %s", res.Status)
+			}
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"rule":    rule.Name,
+				"webhook": webhook,
+			}).Error("failed to call alert webhook")
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+// fakeWebhook server recording the alert states it is called with, read with the returned function
+func fakeWebhook(t *testing.T) (string, func() string) {
+	t.Helper()
+	var mu sync.Mutex
+	states := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Rule  map[string]string `json:"rule"`
+			Alert model.Alert       `json:"alert"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		states = append(states, payload.Alert.SubjectName+" "+payload.Alert.State)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(states, ",")
+	}
+}
+
+// firingAlerts subject names of the firing alerts, sorted
+func firingAlerts(t *testing.T) string {
+	t.Helper()
+	alerts, _, err := ReadAlerts(model.AlertFilter{State: model.AlertStateFiring})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		names = append(names, alert.RuleName+": "+alert.SubjectName)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	setupCore(t)
+	webhook, notified := fakeWebhook(t)
+	now := time.Now().UTC()
+
+	branch := newTestClient("branch")
+	branch.Tags = []string{"site"}
+	branch, err := CreateClient(testActor, "wg0", branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := CreateClient(testActor, "wg0", newTestClient("laptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := testKey(7).PublicKey().String()
+	source := &fakeStatusSource{peers: []*model.ClientStatus{
+		{PublicKey: branch.PublicKey, LastHandshake: now.Add(-20 * time.Minute)},
+		// not selected by the tags of the rule
+		{PublicKey: laptop.PublicKey},
+		{PublicKey: unknown},
+	}}
+	SetStatusSource(source)
+
+	if _, err = CreateAlertRule(testActor, &model.AlertRule{Name: "stale", Type: model.AlertRuleStaleHandshake, Enable: true,
+		Tags: []string{"SITE"}, Minutes: 10, Webhooks: []string{webhook}}); err != nil {
+		t.Fatal(err)
+	}
+	unknownRule, err := CreateAlertRule(testActor, &model.AlertRule{Name: "unknown", Type: model.AlertRuleUnknownPeer, Enable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evaluateAlerts(now)
+	if alerts := firingAlerts(t); alerts != "stale: branch,unknown: "+unknown {
+		t.Errorf("firing alerts %s, want the stale site and the unknown peer", alerts)
+	}
+	// an alert is notified when it fires, not each time it is evaluated
+	evaluateAlerts(now.Add(time.Minute))
+	if states := notified(); states != "branch firing" {
+		t.Errorf("webhook called with %s, want the alert of branch firing once", states)
+	}
+	_, total, err := ReadAlerts(model.AlertFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("%d alerts, want the 2 firing ones only", total)
+	}
+
+	// without status the alerts are left as they are
+	source.err = errors.New("interface wg0 is not up")
+	evaluateAlerts(now.Add(2 * time.Minute))
+	if alerts := firingAlerts(t); alerts != "stale: branch,unknown: "+unknown {
+		t.Errorf("firing alerts %s without status, want them unchanged", alerts)
+	}
+
+	source.err = nil
+	source.peers[0].LastHandshake = now.Add(3 * time.Minute)
+	evaluateAlerts(now.Add(3 * time.Minute))
+	if alerts := firingAlerts(t); alerts != "unknown: "+unknown {
+		t.Errorf("firing alerts %s after a handshake, want the alert of branch resolved", alerts)
+	}
+	if states := notified(); states != "branch firing,branch resolved" {
+		t.Errorf("webhook called with %s, want the alert of branch firing then resolved", states)
+	}
+
+	// disabling a rule resolves its alerts
+	unknownRule.Enable = false
+	if _, err = UpdateAlertRule(testActor, unknownRule.Id, unknownRule); err != nil {
+		t.Fatal(err)
+	}
+	evaluateAlerts(now.Add(4 * time.Minute))
+	if alerts := firingAlerts(t); alerts != "" {
+		t.Errorf("firing alerts %s after the rule was disabled, want none", alerts)
+	}
+}
+
+func TestAlertRuleQuotaExceeded(t *testing.T) {
+	setupCore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	client := newQuotaClient(t, "contractor", model.QuotaActionAlert)
+	rule := &model.AlertRule{Name: "quota", Type: model.AlertRuleQuotaExceeded, Enable: true}
+
+	conditions, err := alertConditions(rule, "wg0", nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conditions) != 0 {
+		t.Errorf("conditions %v within the quota, want none", conditions)
+	}
+	saveTrafficSample(t, client.Id, now.Add(-time.Second), time.Minute, 600)
+	if conditions, err = alertConditions(rule, "wg0", nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if condition, ok := conditions[client.Id]; !ok || !strings.Contains(condition.message, "1800 bytes this month") {
+		t.Errorf("conditions %v over the quota, want the client transferring 1800 bytes", conditions)
+	}
+}
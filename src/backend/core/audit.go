@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// redactedFields JSON fields only recorded as changed, never with their value
+var redactedFields = map[string]bool{
+	"privateKey":   true,
+	"presharedKey": true,
+	"password":     true,
+}
+
+// ReadAudit audit entries matching filter, most recent first, and the number of matching entries
+func ReadAudit(filter model.AuditFilter) ([]*model.AuditEntry, int, error) {
+	return store.LoadAuditEntries(filter)
+}
+
+// audit records a change in the audit log of s, before is nil for creations and after is nil for deletions.
+// The change is already stored, a failure is logged but does not fail the change.
+func audit(s storage.Store, actor model.Actor, action, objectType, objectId, objectName string, before, after interface{}) {
+	diff, err := auditDiff(before, after)
+	if err == nil {
+		var u uuid.UUID
+		u, err = uuid.NewV4()
+		if err == nil {
+			err = s.SaveAuditEntry(&model.AuditEntry{
+				Id:         u.String(),
+				Time:       time.Now().UTC(),
+				Actor:      actor.Name,
+				SourceIP:   actor.SourceIP,
+				Action:     action,
+				ObjectType: objectType,
+				ObjectId:   objectId,
+				ObjectName: objectName,
+				Diff:       diff,
+			})
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"actor":  actor.Name,
+			"action": action,
+			"object": objectType,
+			"id":     objectId,
+		}).Error("failed to write audit log")
+	}
+}
+
+// auditDiff changed JSON fields between before and after as {"field": {"old": ..., "new": ...}}
+func auditDiff(before, after interface{}) (json.RawMessage, error) {
+	oldFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]map[string]interface{}{}
+	for _, fields := range []map[string]interface{}{oldFields, newFields} {
+		for name := range fields {
+			if _, done := diff[name]; done {
+				continue
+			}
+			oldValue, hasOld := oldFields[name]
+			newValue, hasNew := newFields[name]
+			if hasOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+				continue
+			}
+			change := map[string]interface{}{}
+			if hasOld {
+				change["old"] = redact(name, oldValue)
+			}
+			if hasNew {
+				change["new"] = redact(name, newValue)
+			}
+			diff[name] = change
+		}
+	}
+	return json.Marshal(diff)
+}
+
+// jsonFields object as its JSON fields, none for nil
+func jsonFields(object interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if object == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+func redact(name string, value interface{}) interface{} {
+	if redactedFields[name] && value != "" {
+		return "[redacted]"
+	}
+	return value
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+)
+
+func TestAuditDiff(t *testing.T) {
+	type object struct {
+		Name         string `json:"name"`
+		PrivateKey   string `json:"privateKey"`
+		PresharedKey string `json:"presharedKey"`
+		Password     string `json:"password"`
+	}
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          string
+	}{
+		{"creation", nil, object{Name: "laptop", PrivateKey: "private", PresharedKey: "preshared", Password: "secret"},
+			`{"name":{"new":"laptop"},"password":{"new":"[redacted]"},"presharedKey":{"new":"[redacted]"},"privateKey":{"new":"[redacted]"}}`},
+		{"deletion", object{Name: "laptop", PrivateKey: "private"}, nil,
+			`{"name":{"old":"laptop"},"password":{"old":""},"presharedKey":{"old":""},"privateKey":{"old":"[redacted]"}}`},
+		{"keys changed", object{Name: "laptop", PrivateKey: "old", PresharedKey: "old"}, object{Name: "laptop", PrivateKey: "new", PresharedKey: "new"},
+			`{"presharedKey":{"new":"[redacted]","old":"[redacted]"},"privateKey":{"new":"[redacted]","old":"[redacted]"}}`},
+		// removing a secret is told apart from changing it
+		{"key removed", object{PrivateKey: "private"}, object{},
+			`{"privateKey":{"new":"","old":"[redacted]"}}`},
+		{"unchanged", object{Name: "laptop", PrivateKey: "private"}, object{Name: "laptop", PrivateKey: "private"}, `{}`},
+	}
+	for _, test := range tests {
+		diff, err := auditDiff(test.before, test.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(diff) != test.want {
+			t.Errorf("%s: diff %s, want %s", test.name, diff, test.want)
+		}
+	}
+}
+
+func TestAuditRedactsKeys(t *testing.T) {
+	server := setupCore(t)
+	fakeReload(t, "broken")
+	secrets := []string{server.PrivateKey}
+
+	client, err := CreateClient(testActor, "wg0", newTestClient("laptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets = append(secrets, client.PrivateKey, client.PresharedKey)
+	rotated, err := RotateClientKeys(testActor, client.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets = append(secrets, rotated.PrivateKey, rotated.PresharedKey)
+	rotatedServer, err := RotateServerKeys(testActor, "wg0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets = append(secrets, rotatedServer.PrivateKey)
+	if err = DeleteClient(testActor, client.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, total, err := ReadAudit(model.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 {
+		t.Errorf("%d audit entries, want the creation, both rotations and the deletion", total)
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range secrets {
+			if secret != "" && strings.Contains(string(data), secret) {
+				t.Errorf("%s of %s %s records a key: %s", entry.Action, entry.ObjectType, entry.ObjectName, data)
+			}
+		}
+		diff := map[string]map[string]interface{}{}
+		if err = json.Unmarshal(entry.Diff, &diff); err != nil {
+			t.Fatal(err)
+		}
+		if change, ok := diff["privateKey"]; !ok || !strings.Contains(fmt.Sprint(change), "[redacted]") {
+			t.Errorf("%s of %s %s: private key change %v, want it recorded as redacted", entry.Action, entry.ObjectType, entry.ObjectName, change)
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+	"wg-gen-plus/version"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+// backupEncryption encryption of an EncryptedBackup
+const backupEncryption = "scrypt-aes-256-gcm"
+
+// scrypt cost of new encrypted backups, the recommended interactive parameters
+const (
+	backupScryptN = 1 << 15
+	backupScryptR = 8
+	backupScryptP = 1
+	// backupScryptMaxN highest cost accepted on restore, so a crafted backup can not exhaust the memory
+	backupScryptMaxN = 1 << 20
+)
+
+var (
+	// ErrBackupFormat the data is not a backup of a supported version
+	ErrBackupFormat = errors.New("not a supported wg-gen-plus backup")
+	// ErrBackupEncrypted the backup is encrypted and no passphrase was given
+	ErrBackupEncrypted = errors.New("backup is encrypted, a passphrase is required")
+	// ErrBackupPassphrase the backup can not be decrypted with the passphrase
+	ErrBackupPassphrase = errors.New("wrong passphrase or corrupted backup")
+	// ErrBackupInvalid some objects of a backup are invalid or conflict, nothing was restored
+	ErrBackupInvalid = errors.New("backup is invalid, nothing restored")
+	// ErrRestoreMode the restore mode is neither merge nor replace
+	ErrRestoreMode = errors.New("restore mode must be merge or replace")
+)
+
+// CreateBackup servers, clients, users and configuration of the managed interfaces, read in one transaction so
+// the backup is a consistent snapshot even while the database is in use
+func CreateBackup() (*model.Backup, error) {
+	backup := &model.Backup{
+		Version:        model.BackupVersion,
+		AppVersion:     version.Version,
+		Created:        time.Now().UTC(),
+		Servers:        []*model.Server{},
+		Clients:        []*model.Client{},
+		Profiles:       []*model.Profile{},
+		IPPools:        []*model.IPPool{},
+		IPReservations: []*model.IPReservation{},
+		AlertRules:     []*model.AlertRule{},
+	}
+	err := store.WithTx(func(tx storage.Store) error {
+		for _, iface := range configuredInterfaces {
+			server, err := tx.LoadServer(iface)
+			if errors.Is(err, sql.ErrNoRows) {
+				// the server is created on first use
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			backup.Servers = append(backup.Servers, server)
+		}
+
+		current, err := loadRestorable(tx)
+		if err != nil {
+			return err
+		}
+		backup.Clients = current.Clients
+		backup.Users = current.Users
+		backup.Profiles = current.Profiles
+		backup.IPPools = current.IPPools
+		backup.IPReservations = current.IPReservations
+		backup.AlertRules = current.AlertRules
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// loadRestorable objects of s a restore may change: the clients, profiles, pools, reservations and alert rules
+// of the managed interfaces, and the users
+func loadRestorable(s storage.Store) (*model.Backup, error) {
+	current := &model.Backup{
+		Clients:        []*model.Client{},
+		Profiles:       []*model.Profile{},
+		IPPools:        []*model.IPPool{},
+		IPReservations: []*model.IPReservation{},
+		AlertRules:     []*model.AlertRule{},
+	}
+	var err error
+	for _, iface := range configuredInterfaces {
+		clients, err := s.LoadAllClients(iface)
+		if err != nil {
+			return nil, err
+		}
+		current.Clients = append(current.Clients, clients...)
+		pools, err := s.LoadIPPools(iface)
+		if err != nil {
+			return nil, err
+		}
+		current.IPPools = append(current.IPPools, pools...)
+		reservations, err := s.LoadIPReservations(iface)
+		if err != nil {
+			return nil, err
+		}
+		current.IPReservations = append(current.IPReservations, reservations...)
+	}
+
+	if current.Users, err = s.LoadAllUsers(); err != nil {
+		return nil, err
+	}
+	profiles, err := s.LoadAllProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles {
+		if IsInterface(profile.Interface) {
+			current.Profiles = append(current.Profiles, profile)
+		}
+	}
+	rules, err := s.LoadAllAlertRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Interface == "" || IsInterface(rule.Interface) {
+			current.AlertRules = append(current.AlertRules, rule)
+		}
+	}
+	return current, nil
+}
+
+// EncodeBackup backup as JSON, encrypted with passphrase unless it is empty
+func EncodeBackup(backup *model.Backup, passphrase string) ([]byte, error) {
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return data, nil
+	}
+
+	encrypted := &model.EncryptedBackup{
+		Version:    model.BackupVersion,
+		Encryption: backupEncryption,
+		ScryptN:    backupScryptN,
+		ScryptR:    backupScryptR,
+		ScryptP:    backupScryptP,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(encrypted.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := backupAEAD(encrypted, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	encrypted.Data = aead.Seal(nonce, nonce, data, nil)
+	return json.MarshalIndent(encrypted, "", "  ")
+}
+
+// DecodeBackup backup of data written by EncodeBackup, passphrase is only needed for an encrypted backup
+func DecodeBackup(data []byte, passphrase string) (*model.Backup, error) {
+	var encrypted model.EncryptedBackup
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	if encrypted.Encryption != "" {
+		if encrypted.Encryption != backupEncryption {
+			return nil, fmt.Errorf("%w: encryption %s is unknown", ErrBackupFormat, encrypted.Encryption)
+		}
+		if passphrase == "" {
+			return nil, ErrBackupEncrypted
+		}
+		aead, err := backupAEAD(&encrypted, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if len(encrypted.Data) < aead.NonceSize() {
+			return nil, ErrBackupPassphrase
+		}
+		nonce, sealed := encrypted.Data[:aead.NonceSize()], encrypted.Data[aead.NonceSize():]
+		if data, err = aead.Open(nil, nonce, sealed, nil); err != nil {
+			return nil, ErrBackupPassphrase
+		}
+	}
+
+	var backup model.Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	if backup.Version < 1 || backup.Version > model.BackupVersion {
+		return nil, fmt.Errorf("%w: version %d, this release reads versions 1 to %d", ErrBackupFormat, backup.Version, model.BackupVersion)
+	}
+	return &backup, nil
+}
+
+// backupAEAD cipher of the key derived from passphrase with the scrypt parameters of encrypted
+func backupAEAD(encrypted *model.EncryptedBackup, passphrase string) (cipher.AEAD, error) {
+	if encrypted.ScryptN > backupScryptMaxN || encrypted.ScryptR < 1 || encrypted.ScryptP < 1 ||
+		encrypted.ScryptR*encrypted.ScryptP >= 1<<30 || len(encrypted.Salt) == 0 {
+		return nil, fmt.Errorf("%w: scrypt parameters are invalid", ErrBackupFormat)
+	}
+	key, err := scrypt.Key([]byte(passphrase), encrypted.Salt, encrypted.ScryptN, encrypted.ScryptR, encrypted.ScryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// RestoreBackup restore backup in mode merge, the default, or replace, and regenerate the config of every
+// managed interface. Objects are validated first, then restored in one transaction, so a backup with an
+// invalid or conflicting object restores nothing and returns the errors in the result with ErrBackupInvalid.
+func RestoreBackup(actor model.Actor, backup *model.Backup, mode string) (*model.RestoreResult, error) {
+	switch mode {
+	case "":
+		mode = model.RestoreModeMerge
+	case model.RestoreModeMerge, model.RestoreModeReplace:
+	default:
+		return nil, ErrRestoreMode
+	}
+	result := &model.RestoreResult{
+		Mode:           mode,
+		Servers:        len(backup.Servers),
+		Clients:        len(backup.Clients),
+		Users:          len(backup.Users),
+		Profiles:       len(backup.Profiles),
+		IPPools:        len(backup.IPPools),
+		IPReservations: len(backup.IPReservations),
+		AlertRules:     len(backup.AlertRules),
+		Errors:         checkBackup(backup),
+	}
+	if len(result.Errors) > 0 {
+		return result, ErrBackupInvalid
+	}
+
+	err := store.WithTx(func(tx storage.Store) error {
+		current, err := loadRestorable(tx)
+		if err != nil {
+			return err
+		}
+		replace := mode == model.RestoreModeReplace
+		clients := restoreSet(current.Clients, backup.Clients, func(c *model.Client) string { return c.Id }, replace)
+		users := restoreSet(current.Users, backup.Users, func(u *model.User) string { return u.Sub }, replace)
+		profiles := restoreSet(current.Profiles, backup.Profiles, func(p *model.Profile) string { return p.Id }, replace)
+		pools := restoreSet(current.IPPools, backup.IPPools, func(p *model.IPPool) string { return p.Id }, replace)
+		reservations := restoreSet(current.IPReservations, backup.IPReservations, func(r *model.IPReservation) string { return r.Id }, replace)
+		rules := restoreSet(current.AlertRules, backup.AlertRules, func(r *model.AlertRule) string { return r.Id }, replace)
+
+		servers := make(map[string]*model.Server)
+		for _, iface := range configuredInterfaces {
+			server, err := tx.LoadServer(iface)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			servers[iface] = server
+		}
+		for _, server := range backup.Servers {
+			servers[server.Interface] = server
+		}
+
+		// conflicts between the objects of the backup and those which are kept
+		result.Errors = append(result.Errors, checkRestoredUsers(users.final)...)
+		result.Errors = append(result.Errors, checkRestoredClients(clients.final, servers)...)
+		result.Errors = append(result.Errors, uniqueNames("profile", profiles.final, func(p *model.Profile) (string, string) {
+			return "", p.Name
+		})...)
+		result.Errors = append(result.Errors, uniqueNames("address pool", pools.final, func(p *model.IPPool) (string, string) {
+			return p.Interface, p.Name
+		})...)
+		if len(result.Errors) > 0 {
+			return ErrBackupInvalid
+		}
+
+		// deletions first, a restored object may take the unique name of a deleted one
+		for _, c := range clients.deleted {
+			if err := tx.DeleteClient(c.Id); err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionDelete, "client", c.Id, c.Name, c, nil)
+		}
+		for _, u := range users.deleted {
+			if err := tx.DeleteUser(u.Sub); err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionDelete, "user", u.Sub, u.Name, u, nil)
+		}
+		for _, p := range profiles.deleted {
+			if err := tx.DeleteProfile(p.Id); err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionDelete, "profile", p.Id, p.Name, p, nil)
+		}
+		for _, p := range pools.deleted {
+			if err := tx.DeleteIPPool(p.Id); err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionDelete, "ipPool", p.Id, p.Name, p, nil)
+		}
+		for _, r := range reservations.deleted {
+			if err := tx.DeleteIPReservation(r.Id); err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionDelete, "ipReservation", r.Id, r.Address, r, nil)
+		}
+		for _, r := range rules.deleted {
+			if err := tx.DeleteAlertRule(r.Id); err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionDelete, "alertRule", r.Id, r.Name, r, nil)
+			if err := resolveRuleAlerts(tx, r.Id); err != nil {
+				return err
+			}
+		}
+		result.Deleted = len(clients.deleted) + len(users.deleted) + len(profiles.deleted) + len(pools.deleted) +
+			len(reservations.deleted) + len(rules.deleted)
+
+		for _, server := range backup.Servers {
+			before, err := tx.LoadServer(server.Interface)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if err := tx.SaveServer(server); err != nil {
+				return fmt.Errorf("server %s: %w", server.Interface, err)
+			}
+			if before == nil {
+				audit(tx, actor, model.AuditActionCreate, "server", server.Interface, server.Interface, nil, server)
+			} else if !sameJSON(before, server) {
+				audit(tx, actor, model.AuditActionUpdate, "server", server.Interface, server.Interface, before, server)
+			}
+		}
+		for _, c := range backup.Clients {
+			if err := tx.SaveClient(c); err != nil {
+				return fmt.Errorf("client %s: %w", c.Name, err)
+			}
+			auditRestored(tx, actor, "client", c.Id, c.Name, clients.previous[c.Id], c)
+		}
+		for _, u := range backup.Users {
+			if err := tx.SaveUser(u); err != nil {
+				return fmt.Errorf("user %s: %w", u.Name, err)
+			}
+			auditRestored(tx, actor, "user", u.Sub, u.Name, users.previous[u.Sub], u)
+		}
+		for _, p := range backup.Profiles {
+			if err := tx.SaveProfile(p); err != nil {
+				return fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+			auditRestored(tx, actor, "profile", p.Id, p.Name, profiles.previous[p.Id], p)
+		}
+		for _, p := range backup.IPPools {
+			if err := tx.SaveIPPool(p); err != nil {
+				return fmt.Errorf("address pool %s: %w", p.Name, err)
+			}
+			auditRestored(tx, actor, "ipPool", p.Id, p.Name, pools.previous[p.Id], p)
+		}
+		for _, r := range backup.IPReservations {
+			if err := tx.SaveIPReservation(r); err != nil {
+				return fmt.Errorf("address reservation %s: %w", r.Address, err)
+			}
+			auditRestored(tx, actor, "ipReservation", r.Id, r.Address, reservations.previous[r.Id], r)
+		}
+		for _, r := range backup.AlertRules {
+			if err := tx.SaveAlertRule(r); err != nil {
+				return fmt.Errorf("alert rule %s: %w", r.Name, err)
+			}
+			auditRestored(tx, actor, "alertRule", r.Id, r.Name, rules.previous[r.Id], r)
+		}
+
+		// data modified, dump new configs, a failed reload rolls the whole restore back
+		for _, iface := range configuredInterfaces {
+			if servers[iface] == nil {
+				continue
+			}
+			if err := writeServerConfig(tx, iface); err != nil {
+				return fmt.Errorf("interface %s: %w", iface, err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrBackupInvalid) {
+		return result, err
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"mode": mode,
+		}).Error("failed to restore backup")
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkBackup errors of the objects of backup on their own: invalid, of an interface which is not managed,
+// or with the id of another object of the backup
+func checkBackup(backup *model.Backup) []string {
+	errs := make([]string, 0)
+	check := func(kind, name, iface string, invalid []error) {
+		for _, err := range invalid {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", kind, name, err))
+		}
+		if iface != "" && !IsInterface(iface) {
+			errs = append(errs, fmt.Sprintf("%s %s: interface %s is not managed", kind, name, iface))
+		}
+	}
+	ids := make(map[string]bool)
+	checkId := func(kind, name, id string) {
+		if id == "" {
+			errs = append(errs, fmt.Sprintf("%s %s: id is required", kind, name))
+		} else if ids[kind+"/"+id] {
+			errs = append(errs, fmt.Sprintf("%s %s: id %s is used twice", kind, name, id))
+		}
+		ids[kind+"/"+id] = true
+	}
+
+	for _, s := range backup.Servers {
+		check("server", s.Interface, s.Interface, s.IsValid())
+		if s.Interface == "" {
+			errs = append(errs, "server: interface is required")
+		}
+		checkId("server", s.Interface, s.Interface)
+	}
+	for _, c := range backup.Clients {
+		check("client", c.Name, c.Interface, c.IsValid())
+		if c.Interface == "" {
+			errs = append(errs, fmt.Sprintf("client %s: interface is required", c.Name))
+		}
+		checkId("client", c.Name, c.Id)
+		for _, address := range c.Address {
+			// a backup holds assigned addresses, never pools to allocate from
+			if _, err := netip.ParsePrefix(address); err != nil {
+				errs = append(errs, fmt.Sprintf("client %s: address %s is not a CIDR", c.Name, address))
+			}
+		}
+	}
+	for _, u := range backup.Users {
+		check("user", u.Name, "", u.IsValid())
+		checkId("user", u.Name, u.Sub)
+	}
+	for _, p := range backup.Profiles {
+		check("profile", p.Name, p.Interface, p.IsValid())
+		checkId("profile", p.Name, p.Id)
+	}
+	for _, p := range backup.IPPools {
+		check("address pool", p.Name, p.Interface, p.IsValid())
+		checkId("address pool", p.Name, p.Id)
+	}
+	for _, r := range backup.IPReservations {
+		check("address reservation", r.Address, r.Interface, r.IsValid())
+		checkId("address reservation", r.Address, r.Id)
+	}
+	for _, r := range backup.AlertRules {
+		check("alert rule", r.Name, r.Interface, r.IsValid())
+		checkId("alert rule", r.Name, r.Id)
+	}
+	return errs
+}
+
+// checkRestoredUsers user names are unique and an admin is left to manage the users
+func checkRestoredUsers(users []*model.User) []string {
+	errs := uniqueNames("user", users, func(u *model.User) (string, string) {
+		return "", u.Name
+	})
+	if countAdmins(users) == 0 {
+		errs = append(errs, "no admin user would be left")
+	}
+	return errs
+}
+
+// checkRestoredClients addresses and public keys of the clients of an interface are unique, and differ from those
+// of its server
+func checkRestoredClients(clients []*model.Client, servers map[string]*model.Server) []string {
+	errs := make([]string, 0)
+	owners := make(map[string]string)
+	for iface, server := range servers {
+		if server == nil {
+			continue
+		}
+		for _, address := range server.Address {
+			if prefix, err := netip.ParsePrefix(address); err == nil {
+				owners[iface+"/"+prefix.Addr().Unmap().String()] = "server"
+			}
+		}
+		owners[iface+"/"+server.PublicKey] = "server"
+	}
+	for _, c := range clients {
+		for _, address := range c.Address {
+			prefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				continue
+			}
+			key := c.Interface + "/" + prefix.Addr().Unmap().String()
+			if owner, ok := owners[key]; ok {
+				errs = append(errs, fmt.Sprintf("client %s: address %s is used by %s", c.Name, address, owner))
+				continue
+			}
+			owners[key] = "client " + c.Name
+		}
+		if c.PublicKey == "" {
+			continue
+		}
+		key := c.Interface + "/" + c.PublicKey
+		if owner, ok := owners[key]; ok {
+			errs = append(errs, fmt.Sprintf("client %s: public key is used by %s", c.Name, owner))
+			continue
+		}
+		owners[key] = "client " + c.Name
+	}
+	return errs
+}
+
+// uniqueNames errors of the objects with the name of another one of the same scope, ignoring case
+func uniqueNames[T any](kind string, objects []*T, name func(*T) (scope string, name string)) []string {
+	errs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, o := range objects {
+		scope, n := name(o)
+		key := scope + "/" + strings.ToLower(n)
+		if seen[key] {
+			errs = append(errs, fmt.Sprintf("%s %s: another %s has this name", kind, n, kind))
+		}
+		seen[key] = true
+	}
+	return errs
+}
+
+// restored objects of one type once a backup is restored
+type restored[T any] struct {
+	// final objects of the backup and the current ones which are kept
+	final []*T
+	// previous current objects by id, to audit the changes
+	previous map[string]*T
+	// deleted current objects which are not in the backup, only when replacing
+	deleted []*T
+}
+
+// restoreSet objects once backup is restored over current, current objects not in backup are deleted on replace
+func restoreSet[T any](current, backup []*T, id func(*T) string, replace bool) restored[T] {
+	r := restored[T]{
+		final:    append([]*T{}, backup...),
+		previous: make(map[string]*T),
+		deleted:  []*T{},
+	}
+	inBackup := make(map[string]bool)
+	for _, o := range backup {
+		inBackup[id(o)] = true
+	}
+	for _, o := range current {
+		switch {
+		case inBackup[id(o)]:
+			r.previous[id(o)] = o
+		case replace:
+			r.deleted = append(r.deleted, o)
+		default:
+			r.final = append(r.final, o)
+		}
+	}
+	return r
+}
+
+// auditRestored records the creation of a restored object, or its update when it changed
+func auditRestored[T any](s storage.Store, actor model.Actor, objectType, id, name string, before, after *T) {
+	if before == nil {
+		audit(s, actor, model.AuditActionCreate, objectType, id, name, nil, after)
+	} else if !sameJSON(before, after) {
+		audit(s, actor, model.AuditActionUpdate, objectType, id, name, before, after)
+	}
+}
+
+// sameJSON check if a and b have the same JSON
+func sameJSON(a, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJSON, bJSON)
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+)
+
+// setupBackup core with an admin and the clients laptop and phone, and the backup of it
+func setupBackup(t *testing.T) *model.Backup {
+	t.Helper()
+	setupCore(t)
+	admin := &model.User{Sub: "admin", Name: "admin", IsAdmin: true}
+	if err := store.SaveUser(admin); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"laptop", "phone"} {
+		if _, err := CreateClient(testActor, "wg0", newTestClient(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backup, err := CreateBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backup
+}
+
+// clientNames names of the clients of wg0
+func clientNames(t *testing.T) string {
+	t.Helper()
+	clients, err := ReadClients("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(clients))
+	for _, client := range clients {
+		names = append(names, client.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// readConfig config of wg0 as written
+func readConfig(t *testing.T) string {
+	t.Helper()
+	config, err := os.ReadFile(WgConfigFilePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(config)
+}
+
+func TestEncodeBackup(t *testing.T) {
+	backup := setupBackup(t)
+	plain, err := EncodeBackup(backup, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncodeBackup(backup, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte(backup.Servers[0].PrivateKey)) {
+		t.Error("encrypted backup holds the server private key in plaintext")
+	}
+
+	for name, data := range map[string][]byte{"plain": plain, "encrypted": encrypted} {
+		decoded, err := DecodeBackup(data, "correct horse")
+		if err != nil {
+			t.Fatalf("%s backup: %v", name, err)
+		}
+		if !sameJSON(decoded, backup) {
+			t.Errorf("%s backup decoded as %+v, want %+v", name, decoded, backup)
+		}
+	}
+
+	if _, err = DecodeBackup(encrypted, ""); !errors.Is(err, ErrBackupEncrypted) {
+		t.Errorf("encrypted backup decoded without a passphrase: %v, want ErrBackupEncrypted", err)
+	}
+	if _, err = DecodeBackup(encrypted, "wrong horse"); !errors.Is(err, ErrBackupPassphrase) {
+		t.Errorf("encrypted backup decoded with a wrong passphrase: %v, want ErrBackupPassphrase", err)
+	}
+	if _, err = DecodeBackup([]byte(`{"version": 2}`), ""); !errors.Is(err, ErrBackupFormat) {
+		t.Errorf("backup of a later version decoded: %v, want ErrBackupFormat", err)
+	}
+}
+
+func TestRestoreBackupMerge(t *testing.T) {
+	backup := setupBackup(t)
+	reloads := fakeReload(t, "broken")
+
+	// changes made after the backup
+	renamed := *backup.Clients[0]
+	renamed.Name = "renamed"
+	if _, err := UpdateClient(testActor, renamed.Id, &renamed); err != nil {
+		t.Fatal(err)
+	}
+	tablet, err := CreateClient(testActor, "wg0", newTestClient("tablet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server config of the backup differs
+	backup.Servers[0].ListenPort = 51999
+	runs := reloads()
+
+	result, err := RestoreBackup(testActor, backup, model.RestoreModeMerge)
+	if err != nil {
+		t.Fatal(err, result.Errors)
+	}
+	if result.Deleted != 0 {
+		t.Errorf("%d objects deleted by a merge", result.Deleted)
+	}
+	if names := clientNames(t); names != "laptop,phone,tablet" {
+		t.Errorf("clients %s after the merge, want the backup ones restored and tablet kept", names)
+	}
+	config := readConfig(t)
+	if !strings.Contains(config, "ListenPort = 51999") || !strings.Contains(config, tablet.PublicKey) {
+		t.Errorf("config not regenerated with the restored server and the kept client:\n%s", config)
+	}
+	if reloads() != runs+1 {
+		t.Errorf("%d reloads by the restore, want 1", reloads()-runs)
+	}
+}
+
+func TestRestoreBackupReplace(t *testing.T) {
+	backup := setupBackup(t)
+	fakeReload(t, "broken")
+
+	tablet, err := CreateClient(testActor, "wg0", newTestClient("tablet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SaveUser(&model.User{Sub: "operator", Name: "operator"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := RestoreBackup(testActor, backup, model.RestoreModeReplace)
+	if err != nil {
+		t.Fatal(err, result.Errors)
+	}
+	if result.Deleted != 2 {
+		t.Errorf("%d objects deleted, want tablet and the operator", result.Deleted)
+	}
+	if names := clientNames(t); names != "laptop,phone" {
+		t.Errorf("clients %s after the replace, want those of the backup only", names)
+	}
+	users, err := ReadUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Sub != "admin" {
+		t.Errorf("users %+v after the replace, want the admin only", users)
+	}
+	if config := readConfig(t); strings.Contains(config, tablet.PublicKey) {
+		t.Errorf("config still holds the deleted client:\n%s", config)
+	}
+}
+
+func TestRestoreBackupInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		change func(backup *model.Backup)
+		want   string
+	}{
+		{"no admin", model.RestoreModeReplace, func(backup *model.Backup) {
+			backup.Users[0].IsAdmin = false
+		}, "no admin user would be left"},
+		{"no users", model.RestoreModeReplace, func(backup *model.Backup) {
+			backup.Users = nil
+		}, "no admin user would be left"},
+		{"admin demoted by a merge", model.RestoreModeMerge, func(backup *model.Backup) {
+			backup.Users[0].IsAdmin = false
+		}, "no admin user would be left"},
+		{"duplicate addresses", model.RestoreModeReplace, func(backup *model.Backup) {
+			backup.Clients[1].Address = backup.Clients[0].Address
+		}, "is used by client"},
+		{"address of the server", model.RestoreModeReplace, func(backup *model.Backup) {
+			backup.Clients[1].Address = []string{"10.0.0.1/32"}
+		}, "is used by server"},
+		{"duplicate ids", model.RestoreModeMerge, func(backup *model.Backup) {
+			backup.Clients[1].Id = backup.Clients[0].Id
+		}, "is used twice"},
+		{"unmanaged interface", model.RestoreModeMerge, func(backup *model.Backup) {
+			backup.Clients[1].Interface = "wg9"
+		}, "interface wg9 is not managed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backup := setupBackup(t)
+			reloads := fakeReload(t, "broken")
+			before := readConfig(t)
+			// the change is only in the backup, those made since are kept
+			if _, err := CreateClient(testActor, "wg0", newTestClient("tablet")); err != nil {
+				t.Fatal(err)
+			}
+			test.change(backup)
+			config, runs := readConfig(t), reloads()
+
+			result, err := RestoreBackup(testActor, backup, test.mode)
+			if !errors.Is(err, ErrBackupInvalid) {
+				t.Fatalf("restore error %v, want ErrBackupInvalid", err)
+			}
+			if !strings.Contains(strings.Join(result.Errors, "\n"), test.want) {
+				t.Errorf("restore errors %v, want %q", result.Errors, test.want)
+			}
+			if names := clientNames(t); names != "laptop,phone,tablet" {
+				t.Errorf("clients %s after an invalid restore, want them unchanged", names)
+			}
+			users, err := ReadUsers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 1 || !users[0].IsAdmin {
+				t.Errorf("users %+v after an invalid restore, want them unchanged", users)
+			}
+			if readConfig(t) != config || config == before || reloads() != runs {
+				t.Error("config written by an invalid restore")
+			}
+		})
+	}
+}
+
+func TestRestoreBackupReloadFailure(t *testing.T) {
+	backup := setupBackup(t)
+	reloads := fakeReload(t, "broken")
+	backup.Clients[0].Name = "broken"
+	before := readConfig(t)
+
+	if _, err := RestoreBackup(testActor, backup, model.RestoreModeReplace); err == nil {
+		t.Fatal("backup restored although the reload failed")
+	}
+	// the failed reload, then the reload of the restored config
+	if reloads() != 2 {
+		t.Errorf("%d reloads, want 2", reloads())
+	}
+	if names := clientNames(t); names != "laptop,phone" {
+		t.Errorf("clients %s after a failed restore, want them unchanged", names)
+	}
+	if readConfig(t) != before {
+		t.Error("previous config not restored after a failed restore")
+	}
+}
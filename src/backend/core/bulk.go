@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BulkClients apply op to the clients of interface iface matching its selector. Changes are made in one
+// transaction with one config update, a failure changes nothing. Emails are sent one by one, a failed email
+// is reported for its client.
+func BulkClients(actor model.Actor, iface string, op *model.BulkOperation) (*model.BulkResult, error) {
+	clients, err := store.LoadAllClients(iface)
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]*model.Client, 0)
+	for _, client := range clients {
+		if op.Selector.Matches(client) {
+			selected = append(selected, client)
+		}
+	}
+
+	result := &model.BulkResult{
+		Action:   op.Action,
+		Selected: len(selected),
+		Clients:  make([]*model.BulkClientResult, 0, len(selected)),
+	}
+	byId := make(map[string]*model.BulkClientResult, len(selected))
+	for _, client := range selected {
+		clientResult := &model.BulkClientResult{Id: client.Id, Name: client.Name}
+		result.Clients = append(result.Clients, clientResult)
+		byId[client.Id] = clientResult
+	}
+	if len(selected) == 0 {
+		return result, nil
+	}
+
+	switch op.Action {
+	case model.BulkActionEmail:
+		for _, client := range selected {
+			err := errors.New("client has no email")
+			if client.Email != "" {
+				err = EmailClient(client.Id)
+			}
+			if err != nil {
+				byId[client.Id].Error = err.Error()
+				continue
+			}
+			byId[client.Id].Changed = true
+		}
+	case model.BulkActionRotate:
+		rotated, err := rotateClientKeys(actor, iface, selected, op.Email)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range rotated {
+			byId[client.Id].Changed = true
+		}
+		for _, client := range selected {
+			if client.HasOwnKey() {
+				byId[client.Id].Error = ErrOwnKey.Error()
+			}
+		}
+	default:
+		err = bulkUpdateClients(actor, iface, op, selected, byId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, clientResult := range result.Clients {
+		if clientResult.Changed {
+			result.Changed++
+		}
+		if clientResult.Error != "" {
+			result.Failed++
+		}
+	}
+	log.WithFields(log.Fields{
+		"interface": iface,
+		"action":    op.Action,
+		"selected":  result.Selected,
+		"changed":   result.Changed,
+		"actor":     actor.Name,
+	}).Info("bulk client operation applied")
+	return result, nil
+}
+
+// bulkUpdateClients enable, disable, delete or re-tag the selected clients in one transaction
+func bulkUpdateClients(actor model.Actor, iface string, op *model.BulkOperation, selected []*model.Client, byId map[string]*model.BulkClientResult) error {
+	now := time.Now().UTC()
+	return store.WithTx(func(tx storage.Store) error {
+		changed := false
+		for _, current := range selected {
+			if op.Action == model.BulkActionDelete {
+				err := tx.DeleteClient(current.Id)
+				if err != nil {
+					return err
+				}
+				audit(tx, actor, model.AuditActionDelete, "client", current.Id, current.Name, current, nil)
+				byId[current.Id].Changed = true
+				changed = true
+				continue
+			}
+
+			client := *current
+			switch op.Action {
+			case model.BulkActionEnable, model.BulkActionDisable:
+				// same rules as an update by hand, quota and schedule still apply
+				client.Enable = op.Action == model.BulkActionEnable
+				client.QuotaDisabled = current.QuotaDisabled && !client.Enable
+				scheduleClient(&client, now)
+			case model.BulkActionAddTag:
+				if !hasTag(&client, op.Tag) {
+					client.Tags = append(append([]string{}, current.Tags...), strings.TrimSpace(op.Tag))
+				}
+			case model.BulkActionRemoveTag:
+				client.Tags = make([]string, 0, len(current.Tags))
+				for _, tag := range current.Tags {
+					if !strings.EqualFold(tag, strings.TrimSpace(op.Tag)) {
+						client.Tags = append(client.Tags, tag)
+					}
+				}
+			}
+			if client.Enable == current.Enable && client.QuotaDisabled == current.QuotaDisabled &&
+				client.ScheduleDisabled == current.ScheduleDisabled && len(client.Tags) == len(current.Tags) {
+				continue
+			}
+
+			client.UpdatedBy = actor.Name
+			client.Updated = now
+			err := tx.SaveClient(&client)
+			if err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionUpdate, "client", client.Id, client.Name, current, &client)
+			byId[client.Id].Changed = true
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, iface)
+	})
+}
+
+// hasTag check if client has tag, ignoring case
+func hasTag(client *model.Client, tag string) bool {
+	return model.ClientSelector{Tags: []string{strings.TrimSpace(tag)}}.Matches(client)
+}
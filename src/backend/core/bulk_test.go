@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+)
+
+// failingClient store failing to save or delete client id within transactions
+type failingClient struct {
+	storage.Store
+	id string
+}
+
+var errClientWrite = errors.New("client write failed")
+
+func (f *failingClient) WithTx(fn func(tx storage.Store) error) error {
+	return f.Store.WithTx(func(tx storage.Store) error {
+		return fn(&failingClient{Store: tx, id: f.id})
+	})
+}
+
+func (f *failingClient) SaveClient(client *model.Client) error {
+	if client.Id == f.id {
+		return errClientWrite
+	}
+	return f.Store.SaveClient(client)
+}
+
+func (f *failingClient) DeleteClient(id string) error {
+	if id == f.id {
+		return errClientWrite
+	}
+	return f.Store.DeleteClient(id)
+}
+
+// setupBulk clients laptop-1 to laptop-3 tagged team, and desktop without tags
+func setupBulk(t *testing.T) []*model.Client {
+	t.Helper()
+	setupCore(t)
+	clients := make([]*model.Client, 0)
+	for _, name := range []string{"laptop-1", "laptop-2", "laptop-3", "desktop"} {
+		client := newTestClient(name)
+		if strings.HasPrefix(name, "laptop") {
+			client.Tags = []string{"team"}
+		}
+		created, err := CreateClient(testActor, "wg0", client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, created)
+	}
+	return clients
+}
+
+// clientStates name, enable and tags of every client of wg0
+func clientStates(t *testing.T) string {
+	t.Helper()
+	clients, err := ReadClients("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make([]string, 0, len(clients))
+	for _, client := range clients {
+		state := client.Name
+		if !client.Enable {
+			state += " disabled"
+		}
+		if len(client.Tags) > 0 {
+			state += " " + strings.Join(client.Tags, ";")
+		}
+		states = append(states, state)
+	}
+	return strings.Join(states, ",")
+}
+
+func TestBulkClients(t *testing.T) {
+	clients := setupBulk(t)
+	reloads := fakeReload(t, "broken")
+	team := model.ClientSelector{Tags: []string{"TEAM"}}
+
+	steps := []struct {
+		op          model.BulkOperation
+		wantChanged int
+		want        string
+	}{
+		{model.BulkOperation{Selector: team, Action: model.BulkActionDisable}, 3,
+			"laptop-1 disabled team,laptop-2 disabled team,laptop-3 disabled team,desktop"},
+		{model.BulkOperation{Selector: model.ClientSelector{Name: "laptop-*", Ids: []string{clients[0].Id, clients[3].Id}}, Action: model.BulkActionEnable}, 1,
+			"laptop-1 team,laptop-2 disabled team,laptop-3 disabled team,desktop"},
+		{model.BulkOperation{Selector: model.ClientSelector{Name: "*"}, Action: model.BulkActionAddTag, Tag: "office"}, 4,
+			"laptop-1 team;office,laptop-2 disabled team;office,laptop-3 disabled team;office,desktop office"},
+		{model.BulkOperation{Selector: team, Action: model.BulkActionRemoveTag, Tag: "Team"}, 3,
+			"laptop-1 office,laptop-2 disabled office,laptop-3 disabled office,desktop office"},
+		{model.BulkOperation{Selector: model.ClientSelector{Ids: []string{clients[1].Id, clients[2].Id}}, Action: model.BulkActionDelete}, 2,
+			"laptop-1 office,desktop office"},
+		// nothing left to do
+		{model.BulkOperation{Selector: model.ClientSelector{Name: "laptop-*"}, Action: model.BulkActionEnable}, 0,
+			"laptop-1 office,desktop office"},
+	}
+	for _, step := range steps {
+		runs := reloads()
+		result, err := BulkClients(testActor, "wg0", &step.op)
+		if err != nil {
+			t.Fatalf("%s: %v", step.op.Action, err)
+		}
+		if result.Changed != step.wantChanged || result.Failed != 0 {
+			t.Errorf("%s: %d changed %d failed, want %d changed", step.op.Action, result.Changed, result.Failed, step.wantChanged)
+		}
+		if states := clientStates(t); states != step.want {
+			t.Errorf("%s: clients %s, want %s", step.op.Action, states, step.want)
+		}
+		// one config update for all the changes
+		if wantRuns := min(step.wantChanged, 1); reloads() != runs+wantRuns {
+			t.Errorf("%s: %d reloads, want %d", step.op.Action, reloads()-runs, wantRuns)
+		}
+	}
+	config := readConfig(t)
+	if strings.Contains(config, clients[1].PublicKey) || !strings.Contains(config, clients[0].PublicKey) {
+		t.Errorf("config does not hold the remaining enabled clients only:\n%s", config)
+	}
+}
+
+func TestBulkClientsFailure(t *testing.T) {
+	for _, action := range []string{model.BulkActionDisable, model.BulkActionAddTag, model.BulkActionDelete} {
+		t.Run(action, func(t *testing.T) {
+			clients := setupBulk(t)
+			reloads := fakeReload(t, "broken")
+			states, config := clientStates(t), readConfig(t)
+			_, audited, err := store.LoadAuditEntries(model.AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the second of the selected clients fails
+			SetStore(&failingClient{Store: store, id: clients[1].Id})
+
+			op := &model.BulkOperation{Selector: model.ClientSelector{Tags: []string{"team"}}, Action: action, Tag: "office"}
+			if _, err = BulkClients(testActor, "wg0", op); !errors.Is(err, errClientWrite) {
+				t.Fatalf("bulk error %v, want the failed write", err)
+			}
+			if got := clientStates(t); got != states {
+				t.Errorf("clients %s after a failed bulk %s, want %s", got, action, states)
+			}
+			if readConfig(t) != config || reloads() != 0 {
+				t.Error("config written by a failed bulk operation")
+			}
+			_, total, err := store.LoadAuditEntries(model.AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != audited {
+				t.Errorf("%d audit entries of a failed bulk operation", total-audited)
+			}
+		})
+	}
+}
@@ -4,22 +4,26 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"time"
 	"wg-gen-plus/model"
 	"wg-gen-plus/storage"
 	"wg-gen-plus/template"
-	"wg-gen-plus/util"
 
 	"github.com/gofrs/uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/skip2/go-qrcode"
-	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"gopkg.in/gomail.v2"
 )
 
-// CreateClient client with all necessary data
-func CreateClient(client *model.Client) (*model.Client, error) {
+// CreateClient client of interface iface with all necessary data
+func CreateClient(actor model.Actor, iface string, client *model.Client) (*model.Client, error) {
+	return createClient(actor, iface, client, nil)
+}
+
+// createClient see CreateClient, check runs first in the transaction creating the client and its error aborts
+// the creation, for checks which concurrent creations must not pass alike
+func createClient(actor model.Actor, iface string, client *model.Client, check func(tx storage.Store) error) (*model.Client, error) {
 	// check if client is valid
 	errs := client.IsValid()
 	if len(errs) != 0 {
@@ -31,70 +35,85 @@ func CreateClient(client *model.Client) (*model.Client, error) {
 		return nil, errors.New("failed to validate client")
 	}
 
-	// Generate UUID
-	u, err := uuid.NewV4()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"err": err,
-		}).Error("failed to generate UUID")
-		return nil, errors.New("failed to generate client ID")
-	}
-	client.Id = u.String()
-
-	key, err := wgtypes.GeneratePrivateKey()
-	if err != nil {
-		return nil, err
-	}
-	client.PrivateKey = key.String()
-	client.PublicKey = key.PublicKey().String()
-
-	presharedKey, err := wgtypes.GenerateKey()
+	err := initClient(iface, client)
 	if err != nil {
 		return nil, err
 	}
-	client.PresharedKey = presharedKey.String()
 
-	reserverIps, err := GetAllReservedIps()
-	if err != nil {
-		return nil, err
-	}
+	err = store.WithTx(func(tx storage.Store) error {
+		if check != nil {
+			if err := check(tx); err != nil {
+				return err
+			}
+		}
+		// allocate against the addresses seen by the transaction
+		allocator, err := interfaceAllocator(tx, iface, "")
+		if err != nil {
+			return err
+		}
+		client.Address, err = allocator.assignAddresses(client.Address, nil)
+		if err != nil {
+			return err
+		}
 
-	ips := make([]string, 0)
-	for _, network := range client.Address {
-		ip, err := util.GetAvailableIp(network, reserverIps)
+		err = tx.SaveClient(client)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if util.IsIPv6(ip) {
-			ip = ip + "/128"
-		} else {
-			ip = ip + "/32"
+
+		// Reload from DB to ensure all fields are set
+		client, err = tx.LoadClient(client.Id)
+		if err != nil {
+			return err
 		}
-		ips = append(ips, ip)
-	}
-	client.Address = ips
-	client.Created = time.Now().UTC()
-	client.Updated = client.Created
+		audit(tx, actor, model.AuditActionCreate, "client", client.Id, client.Name, nil, client)
 
-	// Replace file storage with SQLite
-	err = storage.SaveClient(client)
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, iface)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return client, nil
+}
 
-	// Reload from DB to ensure all fields are set
-	client, err = storage.LoadClient(client.Id)
+// initClient give a new client of interface iface its id, keys and initial state
+func initClient(iface string, client *model.Client) error {
+	// Generate UUID
+	u, err := uuid.NewV4()
 	if err != nil {
-		return nil, err
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to generate UUID")
+		return errors.New("failed to generate client ID")
 	}
+	client.Id = u.String()
+	client.Interface = iface
+	client.Created = time.Now().UTC()
 
-	// data modified, dump new config
-	return client, UpdateServerConfigWg()
+	// a client with a public key brings its own keypair, its private key never reaches the server
+	if client.PublicKey != "" {
+		err = ownClientKey(client, client.PublicKey, client.Created)
+	} else {
+		err = newClientKeys(client, client.Created)
+	}
+	if err != nil {
+		return err
+	}
+
+	// quota and schedule state is managed by wg-gen-plus
+	client.QuotaDisabled = false
+	client.QuotaResetAt = time.Time{}
+	client.ScheduleDisabled = false
+	client.ExpiryReminded = false
+	scheduleClient(client, client.Created)
+	client.Updated = client.Created
+	return nil
 }
 
 // ReadClient client by id
 func ReadClient(id string) (*model.Client, error) {
-	client, err := storage.LoadClient(id)
+	client, err := store.LoadClient(id)
 	if err != nil {
 		return nil, err
 	}
@@ -102,8 +121,8 @@ func ReadClient(id string) (*model.Client, error) {
 }
 
 // UpdateClient preserve keys
-func UpdateClient(Id string, client *model.Client) (*model.Client, error) {
-	current, err := storage.LoadClient(Id)
+func UpdateClient(actor model.Actor, Id string, client *model.Client) (*model.Client, error) {
+	current, err := store.LoadClient(Id)
 	if err != nil {
 		return nil, err
 	}
@@ -123,41 +142,87 @@ func UpdateClient(Id string, client *model.Client) (*model.Client, error) {
 		return nil, errors.New("failed to validate client")
 	}
 
-	// keep keys
+	// clients never move between interfaces, their address belongs to the server network
+	client.Interface = current.Interface
+	// keep keys, a client with its own key rotates it by submitting its new public key
 	client.PrivateKey = current.PrivateKey
-	client.PublicKey = current.PublicKey
+	client.KeysRotated = current.KeysRotated
+	if current.HasOwnKey() && client.PublicKey != "" && client.PublicKey != current.PublicKey {
+		err = ownClientKey(client, client.PublicKey, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		client.PublicKey = current.PublicKey
+	}
+	// keep ownership, it drives client access for non admin users
+	client.Owner = current.Owner
+	client.CreatedBy = current.CreatedBy
+	// quota state is managed by wg-gen-plus, a client enabled by hand is checked against its quota again
+	client.QuotaDisabled = current.QuotaDisabled && !client.Enable
+	client.QuotaResetAt = current.QuotaResetAt
+	// a client is disabled until its validity window starts, a new expiry gets a new reminder
+	client.ScheduleDisabled = current.ScheduleDisabled
+	client.ExpiryReminded = current.ExpiryReminded && client.ExpiresAt.Equal(current.ExpiresAt)
+	client.Created = current.Created
 	client.Updated = time.Now().UTC()
+	scheduleClient(client, client.Updated)
+
+	err = store.WithTx(func(tx storage.Store) error {
+		// new addresses are assigned like those of a new client, the keys stay the same
+		if !slices.Equal(client.Address, current.Address) {
+			allocator, err := interfaceAllocator(tx, client.Interface, client.Id)
+			if err != nil {
+				return err
+			}
+			client.Address, err = allocator.assignAddresses(client.Address, current.Address)
+			if err != nil {
+				return err
+			}
+		}
 
-	err = storage.SaveClient(client)
-	if err != nil {
-		return nil, err
-	}
+		err := tx.SaveClient(client)
+		if err != nil {
+			return err
+		}
+
+		client, err = tx.LoadClient(Id)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionUpdate, "client", client.Id, client.Name, current, client)
 
-	client, err = storage.LoadClient(Id)
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, client.Interface)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// data modified, dump new config
-	return client, UpdateServerConfigWg()
+	return client, nil
 }
 
 // DeleteClient from storage
-func DeleteClient(id string) error {
-	// Implement a DeleteClient function in storage
-	err := storage.DeleteClient(id)
+func DeleteClient(actor model.Actor, id string) error {
+	client, err := store.LoadClient(id)
 	if err != nil {
 		return err
 	}
 
-	// data modified, dump new config
-	return UpdateServerConfigWg()
+	return store.WithTx(func(tx storage.Store) error {
+		err := tx.DeleteClient(id)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionDelete, "client", client.Id, client.Name, client, nil)
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, client.Interface)
+	})
 }
 
-// ReadClients all clients
-func ReadClients() ([]*model.Client, error) {
-	// Implement a LoadAllClients function in storage
-	return storage.LoadAllClients()
+// ReadClients all clients of interface iface, of every interface if iface is empty
+func ReadClients(iface string) ([]*model.Client, error) {
+	return store.LoadAllClients(iface)
 }
 
 // ReadClientConfig in wg format
@@ -167,12 +232,17 @@ func ReadClientConfig(id string) ([]byte, error) {
 		return nil, err
 	}
 
-	server, err := ReadServer()
+	server, err := ReadServer(client.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := ReadClients(client.Interface)
 	if err != nil {
 		return nil, err
 	}
 
-	configDataWg, err := template.DumpClientWg(client, server)
+	configDataWg, err := template.DumpClientWg(client, server, clients)
 	if err != nil {
 		return nil, err
 	}
@@ -205,53 +275,45 @@ func EmailClient(id string) error {
 	}
 	defer os.Remove(tmpfileCfg.Name()) // clean up
 
-	// conf as png image
-	png, err := qrcode.Encode(string(configData), qrcode.Medium, 280)
-	if err != nil {
-		return err
-	}
-	tmpfilePng, err := os.CreateTemp("", "qrcode-*.png")
-	if err != nil {
-		return err
-	}
-	if _, err := tmpfilePng.Write(png); err != nil {
-		return err
-	}
-	if err := tmpfilePng.Close(); err != nil {
-		return err
+	// conf as png image, not for a client with its own key whose config needs its private key first
+	qrcodePng := ""
+	if !client.HasOwnKey() {
+		png, err := qrcode.Encode(string(configData), qrcode.Medium, 280)
+		if err != nil {
+			return err
+		}
+		tmpfilePng, err := os.CreateTemp("", "qrcode-*.png")
+		if err != nil {
+			return err
+		}
+		if _, err := tmpfilePng.Write(png); err != nil {
+			return err
+		}
+		if err := tmpfilePng.Close(); err != nil {
+			return err
+		}
+		defer os.Remove(tmpfilePng.Name()) // clean up
+		qrcodePng = tmpfilePng.Name()
 	}
-	defer os.Remove(tmpfilePng.Name()) // clean up
 
 	// get email body
-	emailBody, err := template.DumpEmail(client, filepath.Base(tmpfilePng.Name()))
-	if err != nil {
-		return err
+	qrcodePngName := ""
+	if qrcodePng != "" {
+		qrcodePngName = filepath.Base(qrcodePng)
 	}
-
-	// port to int
-	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	emailBody, err := template.DumpEmail(client, qrcodePngName)
 	if err != nil {
 		return err
 	}
 
-	d := gomail.NewDialer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
-	s, err := d.Dial()
-	if err != nil {
-		return err
-	}
 	m := gomail.NewMessage()
-
-	m.SetHeader("From", os.Getenv("SMTP_FROM"))
 	m.SetAddressHeader("To", client.Email, client.Name)
 	m.SetHeader("Subject", "WireGuard VPN Configuration")
 	m.SetBody("text/html", string(emailBody))
 	m.Attach(tmpfileCfg.Name())
-	m.Embed(tmpfilePng.Name())
-
-	err = gomail.Send(s, m)
-	if err != nil {
-		return err
+	if qrcodePng != "" {
+		m.Embed(qrcodePng)
 	}
 
-	return nil
+	return sendMail(m)
 }
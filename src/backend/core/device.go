@@ -0,0 +1,188 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/util"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Device kernel WireGuard interfaces, live apply configures them and the status is read from them,
+// satisfied by *wgctrl.Client
+type Device interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+var device Device
+
+// SetDevice set the WireGuard device live apply and the status go through, nil turns them off
+func SetDevice(d Device) {
+	device = d
+}
+
+// LiveApply check if changes of interface iface are applied to the running interface peer by peer,
+// set with LIVE_APPLY=true, or LIVE_APPLY_<INTERFACE> for a single interface
+func LiveApply(iface string) bool {
+	return util.InterfaceEnv("LIVE_APPLY", iface) == "true"
+}
+
+// reloadServer apply a freshly written config of interface iface, peer by peer with live apply,
+// with the reload command otherwise
+func reloadServer(iface string, server *model.Server, clients []*model.Client) error {
+	if device != nil && LiveApply(iface) {
+		return applyDevice(iface, server, clients)
+	}
+	return util.ReloadServerConfig(iface)
+}
+
+// applyDevice bring the peers, listen port and private key of the running interface iface in line with server and clients.
+// Only the peers that differ are added, updated or removed, sessions of the others are untouched.
+// An interface that is not up is skipped, it reads the config file when it comes up.
+func applyDevice(iface string, server *model.Server, clients []*model.Client) error {
+	current, err := device.Device(iface)
+	if errors.Is(err, os.ErrNotExist) {
+		log.WithField("interface", iface).Warning("interface is not up, live apply skipped")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	wanted, err := devicePeers(server, clients)
+	if err != nil {
+		return err
+	}
+
+	cfg := wgtypes.Config{Peers: make([]wgtypes.PeerConfig, 0)}
+	if server.ListenPort != 0 && server.ListenPort != current.ListenPort {
+		cfg.ListenPort = &server.ListenPort
+	}
+	// a rotated server key
+	privateKey, err := wgtypes.ParseKey(server.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("server private key: %w", err)
+	}
+	if privateKey != current.PrivateKey {
+		cfg.PrivateKey = &privateKey
+	}
+	for _, peer := range current.Peers {
+		want, ok := wanted[peer.PublicKey]
+		if !ok {
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+			continue
+		}
+		delete(wanted, peer.PublicKey)
+		if !peerMatches(peer, want) {
+			cfg.Peers = append(cfg.Peers, want)
+		}
+	}
+	for _, want := range wanted {
+		cfg.Peers = append(cfg.Peers, want)
+	}
+	if cfg.ListenPort == nil && cfg.PrivateKey == nil && len(cfg.Peers) == 0 {
+		return nil
+	}
+
+	err = device.ConfigureDevice(iface, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure interface %s: %w", iface, err)
+	}
+	log.WithFields(log.Fields{
+		"interface": iface,
+		"peers":     len(cfg.Peers),
+	}).Info("live applied changed peers")
+	return nil
+}
+
+// devicePeers peers of the enabled clients by public key, the same values the config file template writes
+func devicePeers(server *model.Server, clients []*model.Client) (map[wgtypes.Key]wgtypes.PeerConfig, error) {
+	peers := make(map[wgtypes.Key]wgtypes.PeerConfig)
+	for _, client := range clients {
+		if !client.Enable {
+			continue
+		}
+		publicKey, err := wgtypes.ParseKey(client.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("client %s public key: %w", client.Name, err)
+		}
+		peer := wgtypes.PeerConfig{
+			PublicKey:         publicKey,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        make([]net.IPNet, 0),
+		}
+		presharedKey, err := wgtypes.ParseKey(client.PresharedKey)
+		if err != nil {
+			return nil, fmt.Errorf("client %s preshared key: %w", client.Name, err)
+		}
+		peer.PresharedKey = &presharedKey
+		for _, cidr := range append(append([]string{}, client.Address...), client.LANIPs...) {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("client %s allowed IP: %w", client.Name, err)
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, *ipNet)
+		}
+		keepalive := time.Duration(0)
+		if client.HasSite2SiteEndpoint() {
+			peer.Endpoint, err = net.ResolveUDPAddr("udp", client.Site2SiteEndpointAddress())
+			if err != nil {
+				return nil, fmt.Errorf("client %s endpoint: %w", client.Name, err)
+			}
+			keepalive = time.Duration(client.PersistentKeepalive(server.PersistentKeepalive)) * time.Second
+		}
+		peer.PersistentKeepaliveInterval = &keepalive
+		peers[publicKey] = peer
+	}
+	return peers, nil
+}
+
+// peerMatches check if the running peer already has the wanted settings,
+// the endpoint of a roaming peer is learned by the interface and not compared
+func peerMatches(peer wgtypes.Peer, want wgtypes.PeerConfig) bool {
+	if peer.PresharedKey != *want.PresharedKey {
+		return false
+	}
+	if peer.PersistentKeepaliveInterval != *want.PersistentKeepaliveInterval {
+		return false
+	}
+	if want.Endpoint != nil && (peer.Endpoint == nil || peer.Endpoint.String() != want.Endpoint.String()) {
+		return false
+	}
+	return ipNetsKey(peer.AllowedIPs) == ipNetsKey(want.AllowedIPs)
+}
+
+// ipNetsKey order independent representation of a list of networks
+func ipNetsKey(ipNets []net.IPNet) string {
+	keys := make([]string, 0, len(ipNets))
+	for _, ipNet := range ipNets {
+		keys = append(keys, ipNet.String())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// reloadCommitted apply the committed state of interface iface again after a failed change,
+// the store outside the failed transaction still holds it
+func reloadCommitted(iface string) error {
+	if device == nil || !LiveApply(iface) {
+		return util.ReloadServerConfig(iface)
+	}
+	server, err := store.LoadServer(iface)
+	if err != nil {
+		return err
+	}
+	clients, err := store.LoadAllClients(iface)
+	if err != nil {
+		return err
+	}
+	return applyDevice(iface, server, clients)
+}
@@ -0,0 +1,314 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"wg-gen-plus/model"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeDevice running interfaces in memory, recording the configurations applied to them
+type fakeDevice struct {
+	devices map[string]*wgtypes.Device
+	configs []wgtypes.Config
+	// failNext error of the next configuration, applied anyway like a kernel failing halfway
+	failNext error
+}
+
+func newFakeDevice(devices ...*wgtypes.Device) *fakeDevice {
+	d := &fakeDevice{devices: make(map[string]*wgtypes.Device)}
+	for _, device := range devices {
+		d.devices[device.Name] = device
+	}
+	return d
+}
+
+func (d *fakeDevice) Device(name string) (*wgtypes.Device, error) {
+	device, ok := d.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("device %s: %w", name, os.ErrNotExist)
+	}
+	copied := *device
+	copied.Peers = append([]wgtypes.Peer{}, device.Peers...)
+	return &copied, nil
+}
+
+func (d *fakeDevice) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	device, ok := d.devices[name]
+	if !ok {
+		return fmt.Errorf("device %s: %w", name, os.ErrNotExist)
+	}
+	d.configs = append(d.configs, cfg)
+	if cfg.ListenPort != nil {
+		device.ListenPort = *cfg.ListenPort
+	}
+	if cfg.PrivateKey != nil {
+		device.PrivateKey = *cfg.PrivateKey
+		device.PublicKey = cfg.PrivateKey.PublicKey()
+	}
+	for _, peerCfg := range cfg.Peers {
+		peers := make([]wgtypes.Peer, 0, len(device.Peers))
+		for _, peer := range device.Peers {
+			if peer.PublicKey != peerCfg.PublicKey {
+				peers = append(peers, peer)
+			}
+		}
+		if !peerCfg.Remove {
+			peers = append(peers, peerOf(peerCfg))
+		}
+		device.Peers = peers
+	}
+	err := d.failNext
+	d.failNext = nil
+	return err
+}
+
+// peerOf running peer configured with cfg
+func peerOf(cfg wgtypes.PeerConfig) wgtypes.Peer {
+	peer := wgtypes.Peer{
+		PublicKey:  cfg.PublicKey,
+		Endpoint:   cfg.Endpoint,
+		AllowedIPs: cfg.AllowedIPs,
+	}
+	if cfg.PresharedKey != nil {
+		peer.PresharedKey = *cfg.PresharedKey
+	}
+	if cfg.PersistentKeepaliveInterval != nil {
+		peer.PersistentKeepaliveInterval = *cfg.PersistentKeepaliveInterval
+	}
+	return peer
+}
+
+// runningDevice interface wg0 running server with the peers of clients
+func runningDevice(t *testing.T, server *model.Server, clients ...*model.Client) *wgtypes.Device {
+	t.Helper()
+	privateKey, err := wgtypes.ParseKey(server.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted, err := devicePeers(server, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &wgtypes.Device{
+		Name:       "wg0",
+		Type:       wgtypes.LinuxKernel,
+		PrivateKey: privateKey,
+		PublicKey:  privateKey.PublicKey(),
+		ListenPort: server.ListenPort,
+		Peers:      make([]wgtypes.Peer, 0),
+	}
+	for _, peer := range wanted {
+		device.Peers = append(device.Peers, peerOf(peer))
+	}
+	return device
+}
+
+// testPeerClient enabled client with fixed keys and address
+func testPeerClient(name string, seed byte, address string) *model.Client {
+	return &model.Client{
+		Id:           name,
+		Interface:    "wg0",
+		Name:         name,
+		Enable:       true,
+		PrivateKey:   testKey(seed).String(),
+		PublicKey:    testKey(seed).PublicKey().String(),
+		PresharedKey: testKey(seed + 100).String(),
+		AllowedIPs:   []string{"0.0.0.0/0"},
+		Address:      []string{address},
+	}
+}
+
+// peerKeys public keys of the peers of device, sorted
+func peerKeys(device *wgtypes.Device) []string {
+	keys := make([]string, 0, len(device.Peers))
+	for _, peer := range device.Peers {
+		keys = append(keys, peer.PublicKey.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestApplyDeviceChangedPeersOnly(t *testing.T) {
+	server := setupCore(t)
+	kept := testPeerClient("kept", 2, "10.0.0.2/32")
+	removed := testPeerClient("removed", 3, "10.0.0.3/32")
+	changed := testPeerClient("changed", 4, "10.0.0.4/32")
+	added := testPeerClient("added", 5, "10.0.0.5/32")
+	disabled := testPeerClient("disabled", 6, "10.0.0.6/32")
+
+	running := runningDevice(t, server, kept, removed, changed, disabled)
+	running.ListenPort = 51000
+	fake := newFakeDevice(running)
+	SetDevice(fake)
+
+	changed.Address = []string{"10.0.0.14/32"}
+	disabled.Enable = false
+	err := applyDevice("wg0", server, []*model.Client{kept, changed, added, disabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.configs) != 1 {
+		t.Fatalf("%d configurations applied, want 1", len(fake.configs))
+	}
+	cfg := fake.configs[0]
+	if cfg.ListenPort == nil || *cfg.ListenPort != server.ListenPort {
+		t.Errorf("listen port not applied: %v", cfg.ListenPort)
+	}
+	if cfg.PrivateKey != nil {
+		t.Error("unchanged private key applied")
+	}
+	got := map[string]string{}
+	for _, peer := range cfg.Peers {
+		action := "set"
+		if peer.Remove {
+			action = "remove"
+		}
+		got[peer.PublicKey.String()] = action
+	}
+	want := map[string]string{
+		removed.PublicKey:  "remove",
+		disabled.PublicKey: "remove",
+		changed.PublicKey:  "set",
+		added.PublicKey:    "set",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("peers applied %v, want %v, the kept peer untouched", got, want)
+	}
+
+	// the interface is in line now, nothing more to apply
+	err = applyDevice("wg0", server, []*model.Client{kept, changed, added, disabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.configs) != 1 {
+		t.Errorf("%d configurations applied to an interface in line, want none", len(fake.configs)-1)
+	}
+}
+
+func TestApplyDeviceRotatedServerKey(t *testing.T) {
+	server := setupCore(t)
+	fake := newFakeDevice(runningDevice(t, server))
+	SetDevice(fake)
+
+	server.PrivateKey = testKey(9).String()
+	err := applyDevice("wg0", server, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.configs) != 1 || fake.configs[0].PrivateKey == nil || *fake.configs[0].PrivateKey != testKey(9) {
+		t.Errorf("rotated private key not applied: %+v", fake.configs)
+	}
+}
+
+func TestApplyDeviceNotUp(t *testing.T) {
+	server := setupCore(t)
+	fake := newFakeDevice()
+	SetDevice(fake)
+
+	err := applyDevice("wg0", server, []*model.Client{testPeerClient("laptop", 2, "10.0.0.2/32")})
+	if err != nil {
+		t.Errorf("live apply to an interface that is not up: %v", err)
+	}
+	if len(fake.configs) != 0 {
+		t.Errorf("%d configurations applied to an interface that is not up", len(fake.configs))
+	}
+}
+
+func TestLiveApply(t *testing.T) {
+	server := setupCore(t)
+	fake := newFakeDevice(runningDevice(t, server))
+	SetDevice(fake)
+	t.Setenv("LIVE_APPLY", "true")
+	// live apply replaces the reload command
+	t.Setenv("SERVER_RELOAD_CMD", "false")
+
+	client, err := CreateClient(testActor, "wg0", newTestClient("laptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, _ := fake.Device("wg0")
+	if len(running.Peers) != 1 || running.Peers[0].PublicKey.String() != client.PublicKey {
+		t.Fatalf("new client not applied: %+v", running.Peers)
+	}
+	if ipNetsKey(running.Peers[0].AllowedIPs) != client.Address[0] {
+		t.Errorf("peer allowed IPs %s, want %s", ipNetsKey(running.Peers[0].AllowedIPs), client.Address[0])
+	}
+
+	err = DeleteClient(testActor, client.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, _ = fake.Device("wg0")
+	if len(running.Peers) != 0 {
+		t.Errorf("deleted client still a peer: %+v", running.Peers)
+	}
+}
+
+func TestLiveApplyFailure(t *testing.T) {
+	server := setupCore(t)
+	fake := newFakeDevice(runningDevice(t, server))
+	SetDevice(fake)
+	t.Setenv("LIVE_APPLY", "true")
+
+	kept, err := CreateClient(testActor, "wg0", newTestClient("laptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.ReadFile(WgConfigFilePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("netlink failure")
+	fake.failNext = failure
+	_, err = CreateClient(testActor, "wg0", newTestClient("phone"))
+	if !errors.Is(err, failure) {
+		t.Fatalf("creation error %v, want the live apply failure", err)
+	}
+
+	// the committed state is applied again, the peer of the failed change is gone
+	running, _ := fake.Device("wg0")
+	if keys := peerKeys(running); len(keys) != 1 || keys[0] != kept.PublicKey {
+		t.Errorf("peers %v after the failed change, want only %s", keys, kept.PublicKey)
+	}
+	clients, err := ReadClients("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].Id != kept.Id {
+		t.Errorf("client creation not rolled back: %+v", clients)
+	}
+	config, err := os.ReadFile(WgConfigFilePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(config, previous) {
+		t.Errorf("previous config not restored, got:\n%s\nwant:\n%s", config, previous)
+	}
+}
+
+func TestPeerMatchesIgnoresRoamingEndpoint(t *testing.T) {
+	server := setupCore(t)
+	client := testPeerClient("laptop", 2, "10.0.0.2/32")
+	wanted, err := devicePeers(server, []*model.Client{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := wanted[testKey(2).PublicKey()]
+	peer := peerOf(want)
+	peer.Endpoint = &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	if !peerMatches(peer, want) {
+		t.Error("peer with a learned endpoint does not match")
+	}
+	peer.PresharedKey = testKey(50)
+	if peerMatches(peer, want) {
+		t.Error("peer with another preshared key matches")
+	}
+}
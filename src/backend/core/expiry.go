@@ -0,0 +1,274 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/gomail.v2"
+)
+
+// maxGuestDuration longest validity of a guest client
+const maxGuestDuration = 30 * 24 * time.Hour
+
+// ErrInvalidGuestDuration guest duration is not a positive duration of at most 30 days
+var ErrInvalidGuestDuration = errors.New("duration must be like 24h or 7d, at most 30d")
+
+// StartClientScheduler apply the validity window of every client each interval in the background, and email
+// a reminder to clients expiring within reminder, 0 sends no reminders
+func StartClientScheduler(interval, reminder time.Duration) error {
+	if interval <= 0 {
+		return errors.New("client schedule interval must be positive")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			scheduleClients(now.UTC(), reminder)
+		}
+	}()
+	return nil
+}
+
+// scheduleClient enable or disable client for its validity window at now, true when it changed.
+// Clients are disabled until they are valid, and disabled for good once expired.
+func scheduleClient(client *model.Client, now time.Time) bool {
+	switch {
+	case client.IsExpired(now):
+		if client.Enable || client.ScheduleDisabled {
+			client.Enable = false
+			client.ScheduleDisabled = false
+			return true
+		}
+	case client.IsPending(now):
+		if client.Enable {
+			client.Enable = false
+			client.ScheduleDisabled = true
+			return true
+		}
+	case client.ScheduleDisabled:
+		client.Enable = true
+		client.ScheduleDisabled = false
+		return true
+	}
+	return false
+}
+
+// scheduleClients apply the validity windows of the clients of every interface, delete the expired clients with
+// the delete action and send the expiry reminders, with one config update per interface
+func scheduleClients(now time.Time, reminder time.Duration) {
+	for _, iface := range configuredInterfaces {
+		clients, err := store.LoadAllClients(iface)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Error("failed to read clients for schedule")
+			continue
+		}
+
+		changes := make([]clientChange, 0)
+		deleted := make([]*model.Client, 0)
+		reminders := make([]*model.Client, 0)
+		reload := false
+		for _, client := range clients {
+			if client.IsExpired(now) && client.ExpiryAction == model.ExpiryActionDelete {
+				deleted = append(deleted, client)
+				continue
+			}
+
+			updated := *client
+			changed := scheduleClient(&updated, now)
+			reload = reload || changed
+			if expiryReminderDue(&updated, now, reminder) {
+				// sent once the flag is saved, a failed change is reminded again on the next run
+				updated.ExpiryReminded = true
+				changed = true
+				reminders = append(reminders, &updated)
+			}
+			if changed {
+				updated.UpdatedBy = model.SystemActor.Name
+				updated.Updated = now
+				changes = append(changes, clientChange{client, &updated})
+			}
+		}
+		if len(changes) == 0 && len(deleted) == 0 {
+			continue
+		}
+
+		err = store.WithTx(func(tx storage.Store) error {
+			for _, change := range changes {
+				err := tx.SaveClient(change.after)
+				if err != nil {
+					return err
+				}
+				audit(tx, model.SystemActor, model.AuditActionUpdate, "client", change.after.Id, change.after.Name,
+					change.before, change.after)
+			}
+			for _, client := range deleted {
+				err := tx.DeleteClient(client.Id)
+				if err != nil {
+					return err
+				}
+				audit(tx, model.SystemActor, model.AuditActionDelete, "client", client.Id, client.Name, client, nil)
+			}
+			if !reload && len(deleted) == 0 {
+				return nil
+			}
+
+			// data modified, dump new config, a failed reload rolls the change back
+			return writeServerConfig(tx, iface)
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Error("failed to apply client schedule")
+			continue
+		}
+		for _, change := range changes {
+			if change.before.Enable != change.after.Enable {
+				log.WithFields(log.Fields{
+					"client":    change.after.Id,
+					"name":      change.after.Name,
+					"interface": iface,
+					"enable":    change.after.Enable,
+				}).Info("client schedule applied")
+			}
+		}
+		for _, client := range deleted {
+			log.WithFields(log.Fields{
+				"client":    client.Id,
+				"name":      client.Name,
+				"interface": iface,
+			}).Info("expired client deleted")
+		}
+		for _, client := range reminders {
+			remindExpiry(client)
+		}
+	}
+}
+
+// expiryReminderDue check if client expires within reminder and was not reminded yet
+func expiryReminderDue(client *model.Client, now time.Time, reminder time.Duration) bool {
+	return reminder > 0 && !client.ExpiryReminded && client.Email != "" && client.Enable &&
+		!client.ExpiresAt.IsZero() && !client.IsExpired(now) && !now.Add(reminder).Before(client.ExpiresAt)
+}
+
+// remindExpiry email client that it expires soon. Its reminder flag is already saved, it is cleared again
+// when the email fails so the reminder is retried on the next run.
+func remindExpiry(client *model.Client) {
+	action := "disabled"
+	if client.ExpiryAction == model.ExpiryActionDelete {
+		action = "deleted"
+	}
+	m := gomail.NewMessage()
+	m.SetAddressHeader("To", client.Email, client.Name)
+	m.SetHeader("Subject", "WireGuard VPN access expires soon")
+	m.SetBody("text/plain", fmt.Sprintf("Your WireGuard VPN access %s expires on %s, it will be %s then.\n",
+		client.Name, client.ExpiresAt.Format(time.RFC1123), action))
+	err := sendMail(m)
+	if err == nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"err":    err,
+		"client": client.Id,
+	}).Error("failed to email expiry reminder")
+
+	// the flag does not change the config, no reload needed
+	current, err := store.LoadClient(client.Id)
+	if err == nil {
+		current.ExpiryReminded = false
+		err = store.SaveClient(current)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"client": client.Id,
+		}).Error("failed to clear expiry reminder flag")
+	}
+}
+
+// parseGuestDuration duration like 24h, or in days like 7d
+func parseGuestDuration(value string) (time.Duration, error) {
+	var d time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, ErrInvalidGuestDuration
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, ErrInvalidGuestDuration
+		}
+	}
+	if d <= 0 || d > maxGuestDuration {
+		return 0, ErrInvalidGuestDuration
+	}
+	return d, nil
+}
+
+// CreateGuestClient client of interface iface valid for the guest duration from now on, deleted once expired
+func CreateGuestClient(actor model.Actor, iface string, guest *model.Guest) (*model.Client, error) {
+	duration, err := parseGuestDuration(guest.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := ReadServer(iface)
+	if err != nil {
+		return nil, err
+	}
+	client := &model.Client{
+		Name:         guest.Name,
+		Email:        guest.Email,
+		Enable:       true,
+		AllowedIPs:   append([]string{}, server.AllowedIPs...),
+		Address:      append([]string{}, server.Address...),
+		Tags:         []string{"guest"},
+		LANIPs:       []string{},
+		ExpiresAt:    time.Now().UTC().Add(duration).Truncate(time.Second),
+		ExpiryAction: model.ExpiryActionDelete,
+		Owner:        actor.Sub,
+		CreatedBy:    actor.Name,
+	}
+	if guest.Profile != "" {
+		profile, err := ReadProfile(guest.Profile)
+		if err != nil {
+			return nil, err
+		}
+		profileInterface, err := resolveInterface(profile.Interface)
+		if err != nil {
+			return nil, err
+		}
+		if profileInterface != iface {
+			return nil, fmt.Errorf("profile %s belongs to interface %s", profile.Name, profileInterface)
+		}
+		client.AllowedIPs = append([]string{}, profile.AllowedIPs...)
+		client.UseRemoteDNS = profile.UseRemoteDNS
+	}
+
+	client, err = CreateClient(actor, iface, client)
+	if err != nil {
+		return nil, err
+	}
+	if guest.SendEmail && client.Email != "" {
+		err = EmailClient(client.Id)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":    err,
+				"client": client.Id,
+			}).Error("failed to email guest client")
+		}
+	}
+	return client, nil
+}
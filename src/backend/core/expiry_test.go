@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+func TestScheduleClients(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	create := func(name string, notBefore, expiresAt time.Time, action string) *model.Client {
+		t.Helper()
+		client := newTestClient(name)
+		client.NotBefore, client.ExpiresAt, client.ExpiryAction = notBefore, expiresAt, action
+		created, err := CreateClient(testActor, "wg0", client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+	clients := map[string]*model.Client{
+		"window":    create("window", now.Add(time.Hour), now.Add(2*time.Hour), ""),
+		"expiring":  create("expiring", time.Time{}, now.Add(30*time.Minute), model.ExpiryActionDisable),
+		"guest":     create("guest", time.Time{}, now.Add(30*time.Minute), model.ExpiryActionDelete),
+		"permanent": create("permanent", time.Time{}, time.Time{}, ""),
+	}
+
+	// want state of the clients at each time, enabled or disabled, or deleted when missing
+	steps := []struct {
+		name string
+		at   time.Time
+		want map[string]bool
+	}{
+		{"before the window", now, map[string]bool{"window": false, "expiring": true, "guest": true, "permanent": true}},
+		{"expired", now.Add(time.Hour), map[string]bool{"window": true, "expiring": false, "permanent": true}},
+		{"after the window", now.Add(2 * time.Hour), map[string]bool{"window": false, "expiring": false, "permanent": true}},
+	}
+	for _, step := range steps {
+		runs := reloads()
+		scheduleClients(step.at, 0)
+		if step.at != now && reloads() != runs+1 {
+			t.Errorf("%s: %d reloads, want 1", step.name, reloads()-runs)
+		}
+
+		config := readConfig(t)
+		for name, client := range clients {
+			enabled, kept := step.want[name]
+			current, err := store.LoadClient(client.Id)
+			if !kept {
+				if err == nil {
+					t.Errorf("%s: client %s kept, want it deleted", step.name, name)
+				}
+				if strings.Contains(config, client.PublicKey) {
+					t.Errorf("%s: config holds the deleted client %s", step.name, name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: client %s: %v", step.name, name, err)
+			}
+			if current.Enable != enabled {
+				t.Errorf("%s: client %s enabled %t, want %t", step.name, name, current.Enable, enabled)
+			}
+			if strings.Contains(config, client.PublicKey) != enabled {
+				t.Errorf("%s: config holds client %s %t, want %t", step.name, name, !enabled, enabled)
+			}
+		}
+	}
+
+	// an expired client stays disabled, enabling it needs a new expiry date
+	expired, err := store.LoadClient(clients["expiring"].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.Enable = true
+	expired.ExpiresAt = now.Add(-time.Minute)
+	updated, err := UpdateClient(testActor, expired.Id, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Enable {
+		t.Error("expired client enabled by an update")
+	}
+	updated.Enable = true
+	updated.ExpiresAt = time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	if updated, err = UpdateClient(testActor, updated.Id, updated); err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Enable {
+		t.Error("client extended by an update still disabled")
+	}
+}
+
+func TestParseGuestDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"24h", 24 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"30d", maxGuestDuration},
+		{"31d", 0},
+		{"721h", 0},
+		{"0d", 0},
+		{"-1h", 0},
+		{"d", 0},
+		{"week", 0},
+	}
+	for _, test := range tests {
+		got, err := parseGuestDuration(test.value)
+		if test.want == 0 {
+			if !errors.Is(err, ErrInvalidGuestDuration) {
+				t.Errorf("%s: %v %v, want ErrInvalidGuestDuration", test.value, got, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: %v %v, want %v", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestCreateGuestClient(t *testing.T) {
+	setupCore(t)
+	before := time.Now().UTC().Truncate(time.Second)
+	client, err := CreateGuestClient(testActor, "wg0", &model.Guest{Name: "visitor", Duration: "2d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.ExpiryAction != model.ExpiryActionDelete || !client.Enable {
+		t.Errorf("guest client enabled %t with expiry action %s, want enabled until deleted", client.Enable, client.ExpiryAction)
+	}
+	if client.ExpiresAt.Before(before.Add(48*time.Hour)) || client.ExpiresAt.After(time.Now().UTC().Add(48*time.Hour)) {
+		t.Errorf("guest client expires %s, want in 2 days", client.ExpiresAt)
+	}
+
+	scheduleClients(client.ExpiresAt, 0)
+	if _, err = store.LoadClient(client.Id); err == nil {
+		t.Error("expired guest client not deleted")
+	}
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxImportRows most rows of one import
+const maxImportRows = 10000
+
+// ErrImportInvalid some rows of an import are invalid, nothing was imported
+var ErrImportInvalid = errors.New("import has invalid rows, nothing imported")
+
+// ParseImportCSV rows of CSV with a header line naming the columns name, email, tags, allowedIPs and address.
+// Lists are separated by spaces or semicolons, or commas within quotes.
+func ParseImportCSV(r io.Reader) ([]*model.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("CSV header has no name column")
+	}
+	for name := range columns {
+		switch name {
+		case "name", "email", "tags", "allowedips", "address":
+		default:
+			return nil, fmt.Errorf("CSV column %s is unknown", name)
+		}
+	}
+
+	rows := make([]*model.ImportRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("more than %d rows", maxImportRows)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, &model.ImportRow{
+			Name:       field("name"),
+			Email:      field("email"),
+			Tags:       splitImportList(field("tags")),
+			AllowedIPs: splitImportList(field("allowedips")),
+			Address:    splitImportList(field("address")),
+		})
+	}
+	return rows, nil
+}
+
+// splitImportList values of a CSV list field
+func splitImportList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == ';' || r == ','
+	})
+}
+
+// ParseImportJSON rows of a JSON array of rows
+func ParseImportJSON(r io.Reader) ([]*model.ImportRow, error) {
+	rows := make([]*model.ImportRow, 0)
+	err := json.NewDecoder(r).Decode(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON rows: %w", err)
+	}
+	if len(rows) > maxImportRows {
+		return nil, fmt.Errorf("more than %d rows", maxImportRows)
+	}
+	return rows, nil
+}
+
+// errImportDryRun rolls back the transaction of a dry run
+var errImportDryRun = errors.New("import dry run")
+
+// ImportClients create a client of interface iface for every row, addresses are allocated in row order.
+// Rows are all imported in one transaction with one config update, or none when a row is invalid.
+// Addresses are allocated within the transaction, so clients created meanwhile never get the same ones.
+// A dry run validates the rows and allocates the addresses in a transaction which is rolled back.
+func ImportClients(actor model.Actor, iface string, rows []*model.ImportRow, dryRun bool) (*model.ImportResult, error) {
+	result := &model.ImportResult{
+		DryRun: dryRun,
+		Total:  len(rows),
+		Rows:   make([]*model.ImportRowResult, 0, len(rows)),
+	}
+	clients := make([]*model.Client, 0, len(rows))
+	err := store.WithTx(func(tx storage.Store) error {
+		allocator, err := interfaceAllocator(tx, iface, "")
+		if err != nil {
+			return err
+		}
+
+		for i, row := range rows {
+			rowResult := &model.ImportRowResult{Row: i + 1, Name: row.Name, Errors: []string{}}
+			result.Rows = append(result.Rows, rowResult)
+
+			client := importClient(row, allocator.server, actor)
+			for _, err := range client.IsValid() {
+				rowResult.Errors = append(rowResult.Errors, err.Error())
+			}
+			if len(rowResult.Errors) > 0 {
+				continue
+			}
+			err := initClient(iface, client)
+			if err != nil {
+				return err
+			}
+
+			addresses := make([]string, 0, len(client.Address))
+			for _, address := range client.Address {
+				// the allocator marks the address as used, later rows do not get it
+				ip, err := allocator.assign(address)
+				if err != nil {
+					rowResult.Errors = append(rowResult.Errors, err.Error())
+					continue
+				}
+				addresses = append(addresses, ip)
+			}
+			if len(rowResult.Errors) > 0 {
+				continue
+			}
+			client.Address = addresses
+			rowResult.Client = client
+			clients = append(clients, client)
+		}
+		result.Valid = len(clients)
+		if result.Valid < result.Total {
+			return ErrImportInvalid
+		}
+		if dryRun {
+			return errImportDryRun
+		}
+		if len(clients) == 0 {
+			return nil
+		}
+
+		for _, client := range clients {
+			err := tx.SaveClient(client)
+			if err != nil {
+				return fmt.Errorf("client %s: %w", client.Name, err)
+			}
+			audit(tx, actor, model.AuditActionCreate, "client", client.Id, client.Name, nil, client)
+		}
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, iface)
+	})
+	switch {
+	case errors.Is(err, errImportDryRun):
+		return result, nil
+	case errors.Is(err, ErrImportInvalid):
+		return result, err
+	case err != nil:
+		return nil, err
+	}
+	result.Imported = len(clients)
+	if result.Imported > 0 {
+		log.WithFields(log.Fields{
+			"interface": iface,
+			"clients":   result.Imported,
+			"actor":     actor.Name,
+		}).Info("clients imported")
+	}
+	return result, nil
+}
+
+// importClient enabled client of row with the server allowed IPs and networks unless the row has its own
+func importClient(row *model.ImportRow, server *model.Server, actor model.Actor) *model.Client {
+	client := &model.Client{
+		Name:       row.Name,
+		Email:      row.Email,
+		Enable:     true,
+		Tags:       row.Tags,
+		AllowedIPs: row.AllowedIPs,
+		Address:    append([]string{}, row.Address...),
+		LANIPs:     []string{},
+		Owner:      actor.Sub,
+		CreatedBy:  actor.Name,
+	}
+	if client.Tags == nil {
+		client.Tags = []string{}
+	}
+	if len(client.AllowedIPs) == 0 {
+		client.AllowedIPs = append([]string{}, server.AllowedIPs...)
+	}
+	if len(client.Address) == 0 {
+		client.Address = append([]string{}, server.Address...)
+	}
+	return client
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	rows, err := ParseImportCSV(strings.NewReader("Name, email, Tags, allowedIPs\n" +
+		"laptop, alice@example.com, staff;remote, 10.0.0.0/8 192.168.0.0/16\n" +
+		"phone,,\"a,b\",\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.ImportRow{
+		{Name: "laptop", Email: "alice@example.com", Tags: []string{"staff", "remote"}, AllowedIPs: []string{"10.0.0.0/8", "192.168.0.0/16"}, Address: []string{}},
+		{Name: "phone", Tags: []string{"a", "b"}, AllowedIPs: []string{}, Address: []string{}},
+	}
+	if !sameJSON(rows, want) {
+		t.Errorf("rows %+v, want %+v", rows, want)
+	}
+
+	for _, header := range []string{"email,tags", "name,owner"} {
+		if _, err = ParseImportCSV(strings.NewReader(header + "\n")); err == nil {
+			t.Errorf("header %s parsed", header)
+		}
+	}
+}
+
+// importRows rows of clients desk-a, desk-b with a static address and desk-c
+func importRows() []*model.ImportRow {
+	return []*model.ImportRow{
+		{Name: "desk-a"},
+		{Name: "desk-b", Address: []string{"10.0.0.50/32"}},
+		{Name: "desk-c", Tags: []string{"imported"}},
+	}
+}
+
+// importAddresses first address of every row of result
+func importAddresses(result *model.ImportResult) string {
+	addresses := make([]string, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		if row.Client != nil {
+			addresses = append(addresses, row.Client.Address[0])
+		}
+	}
+	return strings.Join(addresses, ",")
+}
+
+func TestImportClients(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+	if _, err := CreateClient(testActor, "wg0", newTestClient("existing")); err != nil {
+		t.Fatal(err)
+	}
+	config, runs := readConfig(t), reloads()
+
+	dryRun, err := ImportClients(testActor, "wg0", importRows(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dryRun.DryRun || dryRun.Total != 3 || dryRun.Valid != 3 || dryRun.Imported != 0 {
+		t.Errorf("dry run result %+v, want 3 valid rows and none imported", dryRun)
+	}
+	if addresses := importAddresses(dryRun); addresses != "10.0.0.3/32,10.0.0.50/32,10.0.0.4/32" {
+		t.Errorf("dry run allocated %s", addresses)
+	}
+	if names := clientNames(t); names != "existing" {
+		t.Errorf("clients %s after a dry run, want none imported", names)
+	}
+	if readConfig(t) != config || reloads() != runs {
+		t.Error("config written by a dry run")
+	}
+	_, total, err := store.LoadAuditEntries(model.AuditFilter{ObjectType: "client", Action: model.AuditActionCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Errorf("%d client creations in the audit log after a dry run, want 1", total)
+	}
+
+	result, err := ImportClients(testActor, "wg0", importRows(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DryRun || result.Valid != 3 || result.Imported != 3 {
+		t.Errorf("import result %+v, want 3 rows imported", result)
+	}
+	if addresses := importAddresses(result); addresses != importAddresses(dryRun) {
+		t.Errorf("import allocated %s, want the addresses of the dry run %s", addresses, importAddresses(dryRun))
+	}
+	if names := clientNames(t); names != "existing,desk-a,desk-b,desk-c" {
+		t.Errorf("clients %s after the import, want the rows imported", names)
+	}
+	if reloads() != runs+1 {
+		t.Errorf("%d reloads by the import, want 1", reloads()-runs)
+	}
+	config = readConfig(t)
+	for _, row := range result.Rows {
+		if !strings.Contains(config, row.Client.PublicKey) {
+			t.Errorf("config does not hold the imported client %s", row.Name)
+		}
+	}
+}
+
+func TestImportClientsInvalidRow(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(rows []*model.ImportRow)
+		want   string
+	}{
+		{"invalid email", func(rows []*model.ImportRow) {
+			rows[1].Email = "not an email"
+		}, "email"},
+		{"address of an earlier row", func(rows []*model.ImportRow) {
+			rows[1].Address = []string{"10.0.0.2/32"}
+		}, "already in use"},
+		{"address outside of the server networks", func(rows []*model.ImportRow) {
+			rows[1].Address = []string{"10.9.0.1/32"}
+		}, "outside of the server networks"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupCore(t)
+			reloads := fakeReload(t, "broken")
+			rows := importRows()
+			test.change(rows)
+
+			for _, dryRun := range []bool{true, false} {
+				result, err := ImportClients(testActor, "wg0", rows, dryRun)
+				if !errors.Is(err, ErrImportInvalid) {
+					t.Fatalf("dry run %t: import error %v, want ErrImportInvalid", dryRun, err)
+				}
+				if result.Valid != 2 || result.Imported != 0 {
+					t.Errorf("dry run %t: result %+v, want 2 valid rows and none imported", dryRun, result)
+				}
+				errs := make([]string, 0)
+				for _, row := range result.Rows {
+					errs = append(errs, fmt.Sprint(row.Row, row.Errors))
+				}
+				if got := strings.Join(errs, " "); !strings.HasPrefix(got, "1 [] 2 [") || !strings.Contains(got, test.want) ||
+					!strings.HasSuffix(got, "3 []") {
+					t.Errorf("dry run %t: row errors %s, want %q on row 2 only", dryRun, got, test.want)
+				}
+			}
+			if names := clientNames(t); names != "" {
+				t.Errorf("clients %s imported, want none", names)
+			}
+			if reloads() != 0 {
+				t.Error("config written by an invalid import")
+			}
+		})
+	}
+}
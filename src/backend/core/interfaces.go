@@ -0,0 +1,137 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+	"wg-gen-plus/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// WgConfDir directory the <interface>.conf WireGuard config files are written to
+	WgConfDir string
+	// DefaultInterface first configured interface, used by the API routes without interface
+	DefaultInterface string
+	// configuredInterfaces managed WireGuard interfaces, in configuration order
+	configuredInterfaces []string
+)
+
+// ErrUnknownInterface returned for interfaces wg-gen-plus is not configured to manage
+var ErrUnknownInterface = errors.New("unknown interface")
+
+// WgConfigFilePath path of the WireGuard config file of interface iface
+func WgConfigFilePath(iface string) string {
+	return filepath.Join(WgConfDir, fmt.Sprintf("%s.conf", iface))
+}
+
+// AddInterface registers a managed WireGuard interface, the first one added is the default.
+// The first time an interface is seen its pre multi interface database legacyDbFile is imported, if it exists.
+func AddInterface(iface string, legacyDbFile string) error {
+	i := &model.Interface{
+		Name:    iface,
+		Created: time.Now().UTC(),
+	}
+	errs := i.IsValid()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("interface validation error")
+		}
+		return errors.New("failed to validate interface")
+	}
+	if IsInterface(iface) {
+		return fmt.Errorf("interface %s is configured twice", iface)
+	}
+
+	known, err := store.LoadAllInterfaces()
+	if err != nil {
+		return err
+	}
+	registered := false
+	for _, k := range known {
+		if k.Name == iface {
+			registered = true
+			break
+		}
+	}
+
+	if !registered {
+		if legacyDbFile != "" && util.FileExists(legacyDbFile) {
+			log.WithFields(log.Fields{
+				"interface": iface,
+				"dbFile":    legacyDbFile,
+			}).Info("importing legacy database")
+			err = storage.ImportLegacyDatabase(store, legacyDbFile, iface)
+			if err != nil {
+				return fmt.Errorf("failed to import legacy database %s: %w", legacyDbFile, err)
+			}
+		}
+		err = store.SaveInterface(i)
+		if err != nil {
+			return err
+		}
+	}
+
+	if DefaultInterface == "" {
+		DefaultInterface = iface
+	}
+	configuredInterfaces = append(configuredInterfaces, iface)
+	return nil
+}
+
+// IsInterface check if iface is a managed interface
+func IsInterface(iface string) bool {
+	for _, configured := range configuredInterfaces {
+		if configured == iface {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceIndex position of iface in the configuration, 0 for unknown interfaces
+func interfaceIndex(iface string) int {
+	for i, configured := range configuredInterfaces {
+		if configured == iface {
+			return i
+		}
+	}
+	return 0
+}
+
+// ReadInterfaces all managed interfaces, in configuration order
+func ReadInterfaces() ([]*model.Interface, error) {
+	known, err := store.LoadAllInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]*model.Interface, 0, len(configuredInterfaces))
+	for _, iface := range configuredInterfaces {
+		for _, k := range known {
+			if k.Name == iface {
+				k.Default = iface == DefaultInterface
+				interfaces = append(interfaces, k)
+				break
+			}
+		}
+	}
+	return interfaces, nil
+}
+
+// resolveInterface empty interface means the default one, anything else must be managed
+func resolveInterface(iface string) (string, error) {
+	if iface == "" {
+		return DefaultInterface, nil
+	}
+	if !IsInterface(iface) {
+		return "", ErrUnknownInterface
+	}
+	return iface, nil
+}
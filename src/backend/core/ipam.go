@@ -0,0 +1,488 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+	"time"
+	"wg-gen-plus/ipam"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// addressAllocator allocator of the addresses of an interface, the pools of a server network are its address pools,
+// or the whole network when it has none
+type addressAllocator struct {
+	*ipam.Allocator
+	server *model.Server
+	// defaults pools of the server networks without address pools
+	defaults map[*ipam.Pool]bool
+}
+
+// ErrAddressUnavailable a client address can not be assigned, it is taken, outside of the server networks or
+// its pool is exhausted
+var ErrAddressUnavailable = errors.New("address unavailable")
+
+// interfaceAllocator allocator of interface iface from s, addresses of the server and clients are used, those of
+// reservations and excluded ranges are reserved. The addresses of client skip are free, to update its addresses.
+func interfaceAllocator(s storage.Store, iface, skip string) (*addressAllocator, error) {
+	server, err := s.LoadServer(iface)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := s.LoadAllClients(iface)
+	if err != nil {
+		return nil, err
+	}
+	ipPools, err := s.LoadIPPools(iface)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := s.LoadIPReservations(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make([]ipam.Range, 0, len(clients)*2+len(server.Address))
+	addresses := append([]string{}, server.Address...)
+	for _, client := range clients {
+		if client.Id != skip {
+			addresses = append(addresses, client.Address...)
+		}
+	}
+	for _, cidr := range addresses {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"cidr": cidr,
+			}).Error("failed to get IP from CIDR")
+			continue
+		}
+		addr := prefix.Addr().Unmap()
+		used = append(used, ipam.Range{First: addr, Last: addr})
+	}
+
+	reserved := make([]ipam.Range, 0, len(reservations))
+	for _, reservation := range reservations {
+		r, err := ipam.ParseRange(reservation.Address)
+		if err == nil {
+			reserved = append(reserved, r)
+		}
+	}
+	pools := make([]*ipam.Pool, 0, len(ipPools)+len(server.Address))
+	for _, ipPool := range ipPools {
+		prefix, err := ipam.ParsePrefix(ipPool.Network)
+		if err != nil {
+			continue
+		}
+		pool, err := ipam.NewPool(ipPool.Name, prefix, ipPool.Strategy)
+		if err != nil {
+			continue
+		}
+		pools = append(pools, pool)
+		for _, excluded := range ipPool.Excluded {
+			r, err := ipam.ParseRange(excluded)
+			if err == nil {
+				reserved = append(reserved, r)
+			}
+		}
+	}
+
+	defaults := make(map[*ipam.Pool]bool)
+	for _, cidr := range server.Address {
+		network, err := ipam.ParsePrefix(cidr)
+		if err != nil || len(poolsIn(pools, network)) > 0 {
+			continue
+		}
+		pool, err := ipam.NewPool(network.String(), network, ipam.StrategySequential)
+		if err != nil {
+			continue
+		}
+		pools = append(pools, pool)
+		defaults[pool] = true
+	}
+
+	return &addressAllocator{
+		Allocator: ipam.NewAllocator(pools, used, reserved),
+		server:    server,
+		defaults:  defaults,
+	}, nil
+}
+
+// poolsIn pools within network
+func poolsIn(pools []*ipam.Pool, network netip.Prefix) []*ipam.Pool {
+	within := make([]*ipam.Pool, 0)
+	for _, pool := range pools {
+		if pool.Prefix.Bits() >= network.Bits() && network.Contains(pool.Prefix.Addr()) {
+			within = append(within, pool)
+		}
+	}
+	return within
+}
+
+// assignAddresses host addresses for addresses, kept addresses of an updated client stay as they are
+func (a *addressAllocator) assignAddresses(addresses, kept []string) ([]string, error) {
+	keep := make(map[string]bool, len(kept))
+	for _, address := range kept {
+		keep[address] = true
+	}
+	// kept addresses first, new ones must not take them
+	for _, address := range addresses {
+		if prefix, err := netip.ParsePrefix(address); err == nil && keep[address] {
+			a.Use(prefix.Addr().Unmap())
+		}
+	}
+	assigned := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if keep[address] {
+			assigned = append(assigned, address)
+			continue
+		}
+		ip, err := a.assign(address)
+		if err != nil {
+			return nil, err
+		}
+		assigned = append(assigned, ip)
+	}
+	return assigned, nil
+}
+
+// assign host address for address: a static host address, written with or without /32 or /128, a free one of
+// a server network, or a free one of the address pool named address
+func (a *addressAllocator) assign(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if addr, err := netip.ParseAddr(address); err == nil {
+		return a.assignStatic(addr.Unmap())
+	}
+	if prefix, err := netip.ParsePrefix(address); err == nil {
+		if prefix.IsSingleIP() {
+			return a.assignStatic(prefix.Addr().Unmap())
+		}
+		return a.allocate(prefix.Masked())
+	}
+	pool := a.Pool(address)
+	if pool == nil {
+		return "", fmt.Errorf("%w: %s is neither an address, a network nor an address pool", ErrAddressUnavailable, address)
+	}
+	addr, err := a.Allocate(pool)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrAddressUnavailable, err)
+	}
+	return hostCIDR(addr), nil
+}
+
+// assignStatic addr when it is a usable address of a server network and free, like those allocated from its pools
+func (a *addressAllocator) assignStatic(addr netip.Addr) (string, error) {
+	inServer, usable := false, false
+	for _, cidr := range a.server.Address {
+		network, err := ipam.ParsePrefix(cidr)
+		if err != nil || !network.Contains(addr) {
+			continue
+		}
+		inServer = true
+		usable = usable || ipam.UsableRange(network).Contains(addr)
+	}
+	if !inServer {
+		return "", fmt.Errorf("%w: %s is outside of the server networks", ErrAddressUnavailable, addr)
+	}
+	if !usable {
+		return "", fmt.Errorf("%w: %s is the network or broadcast address of a server network", ErrAddressUnavailable, addr)
+	}
+	if a.IsReserved(addr) {
+		return "", fmt.Errorf("%w: %s is reserved", ErrAddressUnavailable, addr)
+	}
+	if !a.IsFree(addr) {
+		return "", fmt.Errorf("%w: %s is already in use", ErrAddressUnavailable, addr)
+	}
+	a.Use(addr)
+	return hostCIDR(addr), nil
+}
+
+// allocate host address from network, from its pools in order of name, network is within a server network
+func (a *addressAllocator) allocate(network netip.Prefix) (string, error) {
+	if !a.inServer(network) {
+		return "", fmt.Errorf("%w: %s is outside of the server networks", ErrAddressUnavailable, network)
+	}
+	for _, pool := range poolsIn(a.Pools(), network) {
+		addr, err := a.Allocate(pool)
+		if errors.Is(err, ipam.ErrPoolExhausted) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return hostCIDR(addr), nil
+	}
+	return "", fmt.Errorf("%w: no more available address in %s", ErrAddressUnavailable, network)
+}
+
+// inServer check if network is within a server network
+func (a *addressAllocator) inServer(network netip.Prefix) bool {
+	for _, cidr := range a.server.Address {
+		serverNetwork, err := ipam.ParsePrefix(cidr)
+		if err == nil && network.Bits() >= serverNetwork.Bits() && serverNetwork.Contains(network.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostCIDR addr as a /32 or /128
+func hostCIDR(addr netip.Addr) string {
+	return netip.PrefixFrom(addr, addr.BitLen()).String()
+}
+
+// ReadIPUsage used and free addresses of every pool of interface iface
+func ReadIPUsage(iface string) ([]*model.IPPoolUsage, error) {
+	allocator, err := interfaceAllocator(store, iface, "")
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]*model.IPPoolUsage, 0, len(allocator.Pools()))
+	for _, pool := range allocator.Pools() {
+		usage := allocator.Usage(pool)
+		family := "ipv6"
+		if pool.Prefix.Addr().Is4() {
+			family = "ipv4"
+		}
+		utilisation := 0.0
+		if usage.Size.Sign() > 0 {
+			taken := new(big.Float).SetInt(new(big.Int).Sub(usage.Size, usage.Free))
+			utilisation, _ = new(big.Float).Quo(taken, new(big.Float).SetInt(usage.Size)).Float64()
+			utilisation *= 100
+		}
+		usages = append(usages, &model.IPPoolUsage{
+			Name:        pool.Name,
+			Network:     pool.Prefix.String(),
+			Family:      family,
+			Strategy:    pool.Strategy,
+			Default:     allocator.defaults[pool],
+			Size:        usage.Size,
+			Used:        usage.Used,
+			Reserved:    usage.Reserved,
+			Free:        usage.Free,
+			Utilisation: utilisation,
+		})
+	}
+	return usages, nil
+}
+
+// ReadIPPools address pools of interface iface
+func ReadIPPools(iface string) ([]*model.IPPool, error) {
+	return store.LoadIPPools(iface)
+}
+
+// ReadIPPool address pool id of interface iface
+func ReadIPPool(iface, id string) (*model.IPPool, error) {
+	pool, err := store.LoadIPPool(id)
+	if err != nil {
+		return nil, err
+	}
+	if pool.Interface != iface {
+		return nil, errors.New("address pool not found")
+	}
+	return pool, nil
+}
+
+// CreateIPPool address pool of interface iface
+func CreateIPPool(actor model.Actor, iface string, pool *model.IPPool) (*model.IPPool, error) {
+	u, err := uuid.NewV4()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to generate UUID")
+		return nil, errors.New("failed to generate address pool ID")
+	}
+	pool.Id = u.String()
+	pool.Interface = iface
+	err = checkIPPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	pool.CreatedBy = actor.Name
+	pool.UpdatedBy = actor.Name
+	pool.Created = time.Now().UTC()
+	pool.Updated = pool.Created
+
+	err = store.SaveIPPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	created, err := store.LoadIPPool(pool.Id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionCreate, "ipPool", created.Id, created.Name, nil, created)
+	return created, nil
+}
+
+// UpdateIPPool address pool id of interface iface, addresses already allocated from it are kept
+func UpdateIPPool(actor model.Actor, iface, id string, pool *model.IPPool) (*model.IPPool, error) {
+	current, err := ReadIPPool(iface, id)
+	if err != nil {
+		return nil, err
+	}
+	if pool.Id != id {
+		return nil, errors.New("records Id mismatch")
+	}
+	pool.Interface = iface
+	err = checkIPPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	pool.CreatedBy = current.CreatedBy
+	pool.UpdatedBy = actor.Name
+	pool.Created = current.Created
+	pool.Updated = time.Now().UTC()
+
+	err = store.SaveIPPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := store.LoadIPPool(id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionUpdate, "ipPool", updated.Id, updated.Name, current, updated)
+	return updated, nil
+}
+
+// DeleteIPPool address pool id of interface iface, its clients keep their addresses
+func DeleteIPPool(actor model.Actor, iface, id string) error {
+	pool, err := ReadIPPool(iface, id)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteIPPool(id)
+	if err != nil {
+		return err
+	}
+	audit(store, actor, model.AuditActionDelete, "ipPool", pool.Id, pool.Name, pool, nil)
+	return nil
+}
+
+// checkIPPool validate pool and normalize its network. Pools lie within a server network, do not overlap and
+// have a unique name per interface, excluded ranges lie within their pool.
+func checkIPPool(pool *model.IPPool) error {
+	errs := pool.IsValid()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("address pool validation error")
+		}
+		return errs[0]
+	}
+	if pool.Strategy == "" {
+		pool.Strategy = ipam.StrategySequential
+	}
+	if pool.Excluded == nil {
+		pool.Excluded = []string{}
+	}
+	network, _ := ipam.ParsePrefix(pool.Network)
+	pool.Network = network.String()
+
+	server, err := store.LoadServer(pool.Interface)
+	if err != nil {
+		return err
+	}
+	inServer := false
+	for _, cidr := range server.Address {
+		serverNetwork, err := ipam.ParsePrefix(cidr)
+		if err == nil && len(poolsIn([]*ipam.Pool{{Prefix: network}}, serverNetwork)) > 0 {
+			inServer = true
+		}
+	}
+	if !inServer {
+		return fmt.Errorf("network %s is outside of the server networks", pool.Network)
+	}
+	for _, excluded := range pool.Excluded {
+		r, _ := ipam.ParseRange(excluded)
+		if !network.Contains(r.First) || !network.Contains(r.Last) {
+			return fmt.Errorf("excluded range %s is outside of the pool network", excluded)
+		}
+	}
+
+	pools, err := store.LoadIPPools(pool.Interface)
+	if err != nil {
+		return err
+	}
+	for _, existing := range pools {
+		if existing.Id == pool.Id {
+			continue
+		}
+		if strings.EqualFold(existing.Name, pool.Name) {
+			return errors.New("an address pool with this name already exists")
+		}
+		other, err := ipam.ParsePrefix(existing.Network)
+		if err == nil && other.Overlaps(network) {
+			return fmt.Errorf("network %s overlaps address pool %s", pool.Network, existing.Name)
+		}
+	}
+	return nil
+}
+
+// ReadIPReservations address reservations of interface iface
+func ReadIPReservations(iface string) ([]*model.IPReservation, error) {
+	return store.LoadIPReservations(iface)
+}
+
+// CreateIPReservation address reservation of interface iface
+func CreateIPReservation(actor model.Actor, iface string, reservation *model.IPReservation) (*model.IPReservation, error) {
+	errs := reservation.IsValid()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("address reservation validation error")
+		}
+		return nil, errs[0]
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to generate UUID")
+		return nil, errors.New("failed to generate address reservation ID")
+	}
+	reservation.Id = u.String()
+	reservation.Interface = iface
+	reservation.Address = strings.TrimSpace(reservation.Address)
+	reservation.CreatedBy = actor.Name
+	reservation.Created = time.Now().UTC()
+
+	err = store.SaveIPReservation(reservation)
+	if err != nil {
+		return nil, err
+	}
+	created, err := store.LoadIPReservation(reservation.Id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionCreate, "ipReservation", created.Id, created.Address, nil, created)
+	return created, nil
+}
+
+// DeleteIPReservation address reservation id of interface iface
+func DeleteIPReservation(actor model.Actor, iface, id string) error {
+	reservation, err := store.LoadIPReservation(id)
+	if err != nil {
+		return err
+	}
+	if reservation.Interface != iface {
+		return errors.New("address reservation not found")
+	}
+	err = store.DeleteIPReservation(id)
+	if err != nil {
+		return err
+	}
+	audit(store, actor, model.AuditActionDelete, "ipReservation", reservation.Id, reservation.Address, reservation, nil)
+	return nil
+}
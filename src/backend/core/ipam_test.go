@@ -0,0 +1,97 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+)
+
+func TestAssignStaticAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		// want assigned address, or the error
+		want string
+	}{
+		{"free", "10.0.0.50/32", "10.0.0.50/32"},
+		{"without a prefix length", "10.0.0.50", "10.0.0.50/32"},
+		{"IPv6", "fd00::50/128", "fd00::50/128"},
+		{"used by the server", "10.0.0.1/32", "already in use"},
+		{"used by a client", "10.0.0.40/32", "already in use"},
+		{"reserved", "10.0.0.25/32", "is reserved"},
+		{"excluded from a pool", "fd00::5/128", "is reserved"},
+		{"outside of the server networks", "10.1.0.5/32", "outside of the server networks"},
+		{"network address", "10.0.0.0/32", "network or broadcast address"},
+		{"broadcast address", "10.0.0.255", "network or broadcast address"},
+		{"IPv6 subnet router anycast address", "fd00::/128", "network or broadcast address"},
+		{"last IPv6 address", "fd00::ffff:ffff:ffff:ffff/128", "network or broadcast address"},
+		// point to point networks have no network nor broadcast address
+		{"point to point network", "192.168.9.1/32", "192.168.9.1/32"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := setupCore(t)
+			server.Address = []string{"10.0.0.1/24", "fd00::1/64", "192.168.9.0/31"}
+			if err := store.SaveServer(server); err != nil {
+				t.Fatal(err)
+			}
+			used := newTestClient("used")
+			used.Address = []string{"10.0.0.40/32"}
+			if _, err := CreateClient(testActor, "wg0", used); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CreateIPReservation(testActor, "wg0", &model.IPReservation{Address: "10.0.0.20-10.0.0.29"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CreateIPPool(testActor, "wg0", &model.IPPool{Name: "v6", Network: "fd00::/64", Excluded: []string{"fd00::1-fd00::9"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			client := newTestClient("static")
+			client.Address = []string{test.address}
+			created, err := CreateClient(testActor, "wg0", client)
+			if strings.Contains(test.want, "/") {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if created.Address[0] != test.want {
+					t.Errorf("assigned %s, want %s", created.Address[0], test.want)
+				}
+				return
+			}
+			if !errors.Is(err, ErrAddressUnavailable) || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("assignment of %s: %v, want ErrAddressUnavailable %s", test.address, err, test.want)
+			}
+
+			// the same checks apply to the new addresses of a client
+			other, err := CreateClient(testActor, "wg0", newTestClient("other"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			other.Address = []string{test.address}
+			if _, err = UpdateClient(testActor, other.Id, other); !errors.Is(err, ErrAddressUnavailable) {
+				t.Errorf("update to %s: %v, want ErrAddressUnavailable", test.address, err)
+			}
+		})
+	}
+}
+
+func TestAllocateSkipsNetworkAndBroadcast(t *testing.T) {
+	server := setupCore(t)
+	server.Address = []string{"10.0.0.1/30"}
+	if err := store.SaveServer(server); err != nil {
+		t.Fatal(err)
+	}
+	client, err := CreateClient(testActor, "wg0", &model.Client{Name: "only", Enable: true, AllowedIPs: []string{"0.0.0.0/0"}, Address: []string{"10.0.0.0/30"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Address[0] != "10.0.0.2/32" {
+		t.Errorf("allocated %s, want 10.0.0.2/32", client.Address[0])
+	}
+	_, err = CreateClient(testActor, "wg0", &model.Client{Name: "none left", Enable: true, AllowedIPs: []string{"0.0.0.0/0"}, Address: []string{"10.0.0.0/30"}})
+	if !errors.Is(err, ErrAddressUnavailable) {
+		t.Errorf("allocation from a full network: %v, want ErrAddressUnavailable", err)
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"os"
+	"strconv"
+
+	"gopkg.in/gomail.v2"
+)
+
+// sendMail send m from SMTP_FROM through the SMTP server of the SMTP_* settings
+func sendMail(m *gomail.Message) error {
+	// port to int
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		return err
+	}
+
+	d := gomail.NewDialer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	s, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	m.SetHeader("From", os.Getenv("SMTP_FROM"))
+	return gomail.Send(s, m)
+}
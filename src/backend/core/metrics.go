@@ -0,0 +1,84 @@
+package core
+
+import (
+	"io"
+	"strings"
+	"time"
+	"wg-gen-plus/metrics"
+	"wg-gen-plus/model"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WriteMetrics write the peer, client and application metrics of every interface in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	clientCounts := make([]metrics.Sample, 0)
+	statusUp := make([]metrics.Sample, 0)
+	received := make([]metrics.Sample, 0)
+	transmitted := make([]metrics.Sample, 0)
+	handshakeAge := make([]metrics.Sample, 0)
+	connected := make([]metrics.Sample, 0)
+
+	for _, iface := range configuredInterfaces {
+		clients, err := store.LoadAllClients(iface)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Error("failed to read clients for metrics")
+			continue
+		}
+		enabled := 0
+		byPublicKey := make(map[string]*model.Client, len(clients))
+		for _, client := range clients {
+			if client.Enable {
+				enabled++
+			}
+			byPublicKey[client.PublicKey] = client
+		}
+		clientCounts = append(clientCounts,
+			metrics.Sample{Labels: metrics.Labels("interface", iface, "state", "enabled"), Value: float64(enabled)},
+			metrics.Sample{Labels: metrics.Labels("interface", iface, "state", "disabled"), Value: float64(len(clients) - enabled)})
+
+		if !StatusEnabled(iface) {
+			continue
+		}
+		peers, err := ReadClientStatus(iface)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Debug("failed to read peers for metrics")
+			statusUp = append(statusUp, metrics.Sample{Labels: metrics.Labels("interface", iface), Value: 0})
+			continue
+		}
+		statusUp = append(statusUp, metrics.Sample{Labels: metrics.Labels("interface", iface), Value: 1})
+
+		for _, peer := range peers {
+			clientId, clientName, tags := "", "", ""
+			if client, ok := byPublicKey[peer.PublicKey]; ok {
+				clientId, clientName, tags = client.Id, client.Name, strings.Join(client.Tags, ",")
+			}
+			labels := metrics.Labels("interface", iface, "public_key", peer.PublicKey,
+				"client_id", clientId, "client_name", clientName, "tags", tags)
+			received = append(received, metrics.Sample{Labels: labels, Value: float64(peer.ReceivedBytes)})
+			transmitted = append(transmitted, metrics.Sample{Labels: labels, Value: float64(peer.TransmittedBytes)})
+			if !peer.LastHandshake.IsZero() {
+				handshakeAge = append(handshakeAge, metrics.Sample{Labels: labels, Value: time.Since(peer.LastHandshake).Seconds()})
+			}
+			value := 0.0
+			if peer.Connected {
+				value = 1
+			}
+			connected = append(connected, metrics.Sample{Labels: labels, Value: value})
+		}
+	}
+
+	metrics.Write(w, "wg_gen_plus_clients", "Clients by state", "gauge", clientCounts)
+	metrics.Write(w, "wg_gen_plus_status_up", "Whether the peer status of the interface could be read", "gauge", statusUp)
+	metrics.Write(w, "wg_gen_plus_peer_received_bytes", "Bytes received from the peer since the interface came up", "counter", received)
+	metrics.Write(w, "wg_gen_plus_peer_transmitted_bytes", "Bytes sent to the peer since the interface came up", "counter", transmitted)
+	metrics.Write(w, "wg_gen_plus_peer_last_handshake_age_seconds", "Seconds since the last handshake of the peer", "gauge", handshakeAge)
+	metrics.Write(w, "wg_gen_plus_peer_connected", "Whether the peer is considered connected", "gauge", connected)
+	metrics.WriteCounters(w)
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	setupCore(t)
+	laptop := newTestClient("laptop")
+	laptop.Tags = []string{"staff", "remote"}
+	laptop, err := CreateClient(testActor, "wg0", laptop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone := newTestClient("phone")
+	phone.Enable = false
+	if _, err = CreateClient(testActor, "wg0", phone); err != nil {
+		t.Fatal(err)
+	}
+	unknown := testKey(7).PublicKey().String()
+	source := &fakeStatusSource{peers: []*model.ClientStatus{
+		{PublicKey: laptop.PublicKey, ReceivedBytes: 1500, TransmittedBytes: 2500, LastHandshake: time.Now().Add(-30 * time.Second)},
+		// a peer of the interface which is not a client
+		{PublicKey: unknown},
+	}}
+	SetStatusSource(source)
+
+	var out bytes.Buffer
+	WriteMetrics(&out)
+	laptopLabels := `{interface="wg0",public_key="` + laptop.PublicKey + `",client_id="` + laptop.Id + `",client_name="laptop",tags="staff,remote"}`
+	unknownLabels := `{interface="wg0",public_key="` + unknown + `",client_id="",client_name="",tags=""}`
+	for _, line := range []string{
+		"# TYPE wg_gen_plus_clients gauge",
+		`wg_gen_plus_clients{interface="wg0",state="enabled"} 1`,
+		`wg_gen_plus_clients{interface="wg0",state="disabled"} 1`,
+		`wg_gen_plus_status_up{interface="wg0"} 1`,
+		"wg_gen_plus_peer_received_bytes" + laptopLabels + " 1500",
+		"wg_gen_plus_peer_transmitted_bytes" + laptopLabels + " 2500",
+		"wg_gen_plus_peer_connected" + laptopLabels + " 1",
+		"wg_gen_plus_peer_connected" + unknownLabels + " 0",
+		"# TYPE wg_gen_plus_config_generations_total counter",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("metrics do not hold %s:\n%s", line, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "wg_gen_plus_peer_last_handshake_age_seconds"+laptopLabels+" 3") ||
+		strings.Contains(out.String(), "wg_gen_plus_peer_last_handshake_age_seconds"+unknownLabels) {
+		t.Errorf("metrics do not hold the handshake age of the laptop only:\n%s", out.String())
+	}
+
+	// peers are unknown while the status cannot be read
+	source.err = errors.New("interface wg0 is not up")
+	out.Reset()
+	WriteMetrics(&out)
+	if !strings.Contains(out.String(), `wg_gen_plus_status_up{interface="wg0"} 0`+"\n") ||
+		strings.Contains(out.String(), "wg_gen_plus_peer_connected{") {
+		t.Errorf("metrics of an interface which is down:\n%s", out.String())
+	}
+}
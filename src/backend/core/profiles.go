@@ -0,0 +1,143 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateProfile creates a new client profile
+func CreateProfile(actor model.Actor, profile *model.Profile) (*model.Profile, error) {
+	errs := profile.IsValid()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("profile validation error")
+		}
+		return nil, errors.New("failed to validate profile")
+	}
+
+	if err := checkProfileName(profile); err != nil {
+		return nil, err
+	}
+
+	iface, err := resolveInterface(profile.Interface)
+	if err != nil {
+		return nil, err
+	}
+	profile.Interface = iface
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to generate UUID")
+		return nil, errors.New("failed to generate profile ID")
+	}
+	profile.Id = u.String()
+	profile.Created = time.Now().UTC()
+	profile.Updated = profile.Created
+
+	err = store.SaveProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := store.LoadProfile(profile.Id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionCreate, "profile", created.Id, created.Name, nil, created)
+	return created, nil
+}
+
+// ReadProfile client profile by id
+func ReadProfile(id string) (*model.Profile, error) {
+	return store.LoadProfile(id)
+}
+
+// ReadProfiles all client profiles
+func ReadProfiles() ([]*model.Profile, error) {
+	return store.LoadAllProfiles()
+}
+
+// UpdateProfile updates an existing client profile, clients already created from it are left untouched
+func UpdateProfile(actor model.Actor, id string, profile *model.Profile) (*model.Profile, error) {
+	current, err := store.LoadProfile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.Id != id {
+		return nil, errors.New("records Id mismatch")
+	}
+
+	errs := profile.IsValid()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("profile validation error")
+		}
+		return nil, errors.New("failed to validate profile")
+	}
+
+	if err := checkProfileName(profile); err != nil {
+		return nil, err
+	}
+
+	iface, err := resolveInterface(profile.Interface)
+	if err != nil {
+		return nil, err
+	}
+	profile.Interface = iface
+
+	profile.CreatedBy = current.CreatedBy
+	profile.Created = current.Created
+	profile.Updated = time.Now().UTC()
+
+	err = store.SaveProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := store.LoadProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionUpdate, "profile", updated.Id, updated.Name, current, updated)
+	return updated, nil
+}
+
+// DeleteProfile removes a client profile
+func DeleteProfile(actor model.Actor, id string) error {
+	profile, err := store.LoadProfile(id)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteProfile(id)
+	if err != nil {
+		return err
+	}
+	audit(store, actor, model.AuditActionDelete, "profile", profile.Id, profile.Name, profile, nil)
+	return nil
+}
+
+// checkProfileName profile names must be unique, users pick them by name
+func checkProfileName(profile *model.Profile) error {
+	profiles, err := store.LoadAllProfiles()
+	if err != nil {
+		return err
+	}
+	for _, existing := range profiles {
+		if strings.EqualFold(existing.Name, profile.Name) && existing.Id != profile.Id {
+			return errors.New("a profile with this name already exists")
+		}
+	}
+	return nil
+}
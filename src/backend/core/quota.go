@@ -0,0 +1,171 @@
+package core
+
+import (
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clientChange client before and after a change made by wg-gen-plus
+type clientChange struct {
+	before *model.Client
+	after  *model.Client
+}
+
+// ReadClientQuota traffic of client id counted against its quota in the current period
+func ReadClientQuota(id string) (*model.QuotaUsage, error) {
+	client, err := store.LoadClient(id)
+	if err != nil {
+		return nil, err
+	}
+	return quotaUsage(client, time.Now().UTC())
+}
+
+// quotaUsage traffic of client counted against its quota in the period containing now
+func quotaUsage(client *model.Client, now time.Time) (*model.QuotaUsage, error) {
+	start, end := model.QuotaPeriodBounds(client.QuotaPeriod, now)
+	since := start
+	if client.QuotaResetAt.After(since) {
+		since = client.QuotaResetAt
+	}
+	received, transmitted, err := clientTraffic(client.Id, since)
+	if err != nil {
+		return nil, err
+	}
+	return &model.QuotaUsage{
+		ClientId:         client.Id,
+		QuotaBytes:       client.QuotaBytes,
+		Period:           client.QuotaPeriod,
+		Action:           client.QuotaAction,
+		PeriodStart:      start,
+		PeriodEnd:        end,
+		Since:            since,
+		ReceivedBytes:    received,
+		TransmittedBytes: transmitted,
+		UsedBytes:        received + transmitted,
+		Exceeded:         client.QuotaBytes > 0 && received+transmitted > client.QuotaBytes,
+		Disabled:         client.QuotaDisabled,
+	}, nil
+}
+
+// ResetClientQuota count the quota of client id from now on, a client disabled by its quota is enabled again
+func ResetClientQuota(actor model.Actor, id string) (*model.QuotaUsage, error) {
+	current, err := store.LoadClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	client := *current
+	// stored with second precision
+	client.QuotaResetAt = time.Now().UTC().Truncate(time.Second)
+	if client.QuotaDisabled {
+		client.Enable = true
+		client.QuotaDisabled = false
+		scheduleClient(&client, client.QuotaResetAt)
+	}
+	client.UpdatedBy = actor.Name
+	client.Updated = client.QuotaResetAt
+
+	err = store.WithTx(func(tx storage.Store) error {
+		err := tx.SaveClient(&client)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionUpdate, "client", client.Id, client.Name, current, &client)
+		if client.Enable == current.Enable {
+			return nil
+		}
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, client.Interface)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quotaUsage(&client, client.QuotaResetAt)
+}
+
+// enforceQuotas disable the clients over a quota with the disable action, and enable the clients it disabled once
+// their quota is available again, with one config update per interface
+func enforceQuotas(now time.Time) {
+	for _, iface := range configuredInterfaces {
+		clients, err := store.LoadAllClients(iface)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Error("failed to read clients for quotas")
+			continue
+		}
+
+		changes := make([]clientChange, 0)
+		for _, client := range clients {
+			if client.QuotaBytes == 0 && !client.QuotaDisabled {
+				continue
+			}
+			block := false
+			if client.QuotaBytes > 0 && client.QuotaAction == model.QuotaActionDisable {
+				usage, err := quotaUsage(client, now)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"err":    err,
+						"client": client.Id,
+					}).Error("failed to read quota usage")
+					continue
+				}
+				block = usage.Exceeded
+			}
+
+			updated := *client
+			switch {
+			case block && client.Enable:
+				updated.Enable = false
+				updated.QuotaDisabled = true
+			case !block && client.QuotaDisabled:
+				// next period, reset, or quota changed or removed, within the validity window of the client
+				updated.Enable = true
+				updated.QuotaDisabled = false
+				scheduleClient(&updated, now)
+			default:
+				continue
+			}
+			updated.UpdatedBy = model.SystemActor.Name
+			updated.Updated = now
+			changes = append(changes, clientChange{client, &updated})
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		err = store.WithTx(func(tx storage.Store) error {
+			for _, change := range changes {
+				err := tx.SaveClient(change.after)
+				if err != nil {
+					return err
+				}
+				audit(tx, model.SystemActor, model.AuditActionUpdate, "client", change.after.Id, change.after.Name,
+					change.before, change.after)
+			}
+
+			// data modified, dump new config, a failed reload rolls the change back
+			return writeServerConfig(tx, iface)
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Error("failed to apply client quotas")
+			continue
+		}
+		for _, change := range changes {
+			log.WithFields(log.Fields{
+				"client":    change.after.Id,
+				"name":      change.after.Name,
+				"interface": iface,
+				"enable":    change.after.Enable,
+			}).Info("client quota enforced")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+// newQuotaClient client of wg0 with a monthly quota of 1000 bytes and action
+func newQuotaClient(t *testing.T, name, action string) *model.Client {
+	t.Helper()
+	client := newTestClient(name)
+	client.QuotaBytes = 1000
+	client.QuotaPeriod = model.QuotaPeriodMonth
+	client.QuotaAction = action
+	created, err := CreateClient(testActor, "wg0", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func TestEnforceQuotas(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+	now := time.Now().UTC().Truncate(time.Second)
+	_, end := model.QuotaPeriodBounds(model.QuotaPeriodMonth, now)
+
+	contractor := newQuotaClient(t, "contractor", model.QuotaActionDisable)
+	alerted := newQuotaClient(t, "alerted", model.QuotaActionAlert)
+	within := newQuotaClient(t, "within", model.QuotaActionDisable)
+	saveTrafficSample(t, contractor.Id, now.Add(-time.Second), time.Minute, 400)
+	saveTrafficSample(t, alerted.Id, now.Add(-time.Second), time.Minute, 400)
+	saveTrafficSample(t, within.Id, now.Add(-time.Second), time.Minute, 300)
+
+	// want state of the clients after each step
+	check := func(step string, want map[*model.Client]bool) {
+		t.Helper()
+		config := readConfig(t)
+		for client, enabled := range want {
+			current, err := store.LoadClient(client.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.Enable != enabled || current.QuotaDisabled == enabled {
+				t.Errorf("%s: client %s enabled %t disabled by its quota %t, want enabled %t", step, current.Name,
+					current.Enable, current.QuotaDisabled, enabled)
+			}
+			if strings.Contains(config, current.PublicKey) != enabled {
+				t.Errorf("%s: config holds client %s %t, want %t", step, current.Name, !enabled, enabled)
+			}
+		}
+	}
+
+	runs := reloads()
+	enforceQuotas(now)
+	check("quota exceeded", map[*model.Client]bool{contractor: false, alerted: true, within: true})
+	if reloads() != runs+1 {
+		t.Errorf("%d reloads to disable the client, want 1", reloads()-runs)
+	}
+	runs = reloads()
+	enforceQuotas(now)
+	if reloads() != runs {
+		t.Error("config updated without a change")
+	}
+
+	enforceQuotas(end)
+	check("next period", map[*model.Client]bool{contractor: true, alerted: true, within: true})
+	enforceQuotas(now)
+	check("quota exceeded again", map[*model.Client]bool{contractor: false})
+
+	usage, err := ResetClientQuota(testActor, contractor.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.UsedBytes != 0 || usage.Exceeded || usage.Disabled {
+		t.Errorf("usage after a reset %+v, want none counted", usage)
+	}
+	check("reset", map[*model.Client]bool{contractor: true})
+	enforceQuotas(time.Now().UTC())
+	check("traffic before the reset", map[*model.Client]bool{contractor: true})
+}
+
+func TestResetClientQuotaKeepsSchedule(t *testing.T) {
+	setupCore(t)
+	fakeReload(t, "broken")
+	now := time.Now().UTC().Truncate(time.Second)
+	client := newQuotaClient(t, "contractor", model.QuotaActionDisable)
+	saveTrafficSample(t, client.Id, now.Add(-time.Second), time.Minute, 2000)
+	enforceQuotas(now)
+
+	// the validity window of the client closed while it was disabled by its quota
+	expired, err := store.LoadClient(client.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.ExpiresAt = now.Add(-time.Minute)
+	if err = store.SaveClient(expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ResetClientQuota(testActor, client.Id); err != nil {
+		t.Fatal(err)
+	}
+	current, err := store.LoadClient(client.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Enable || current.QuotaDisabled {
+		t.Errorf("expired client enabled %t disabled by its quota %t after a reset, want it disabled by its expiry",
+			current.Enable, current.QuotaDisabled)
+	}
+}
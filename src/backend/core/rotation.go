@@ -0,0 +1,293 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+	"wg-gen-plus/util"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultKeyRotationGrace days before the max key age from which keys are due for rotation
+const defaultKeyRotationGrace = 7
+
+// KeyPolicy max key age and grace period of interface iface in days, set with KEY_MAX_AGE_DAYS and
+// KEY_ROTATION_GRACE_DAYS, a max key age of 0 flags no keys
+func KeyPolicy(iface string) (maxAgeDays, graceDays int) {
+	return keyPolicyDays("KEY_MAX_AGE_DAYS", iface, 0), keyPolicyDays("KEY_ROTATION_GRACE_DAYS", iface, defaultKeyRotationGrace)
+}
+
+// keyPolicyDays days set in environment variable key for interface iface, def when unset or invalid
+func keyPolicyDays(key, iface string, def int) int {
+	value := util.InterfaceEnv(key, iface)
+	if value == "" {
+		return def
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		log.WithFields(log.Fields{
+			"err":       err,
+			"interface": iface,
+			"value":     value,
+		}).Warningf("invalid %s, using the default", key)
+		return def
+	}
+	return days
+}
+
+// keyAge age of keys rotated at rotated against the key policy at now
+func keyAge(rotated, now time.Time, maxAgeDays, graceDays int) *model.KeyAge {
+	age := &model.KeyAge{
+		KeysRotated: rotated,
+		AgeDays:     int(now.Sub(rotated).Hours() / 24),
+	}
+	if maxAgeDays > 0 {
+		maxAge := time.Duration(maxAgeDays) * 24 * time.Hour
+		grace := time.Duration(graceDays) * 24 * time.Hour
+		age.Overdue = now.Sub(rotated) > maxAge
+		age.Due = !age.Overdue && now.Sub(rotated) > maxAge-grace
+	}
+	return age
+}
+
+// ReadKeyReport key ages of the server and clients of interface iface, oldest clients first
+func ReadKeyReport(iface string) (*model.KeyReport, error) {
+	server, err := ReadServer(iface)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := store.LoadAllClients(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	maxAgeDays, graceDays := KeyPolicy(iface)
+	report := &model.KeyReport{
+		Interface:  iface,
+		MaxAgeDays: maxAgeDays,
+		GraceDays:  graceDays,
+		Server:     keyAge(server.KeysRotated, now, maxAgeDays, graceDays),
+		Clients:    make([]*model.KeyAge, 0, len(clients)),
+	}
+	report.Server.Id = iface
+	report.Server.Name = iface
+	report.Server.Enable = true
+	for _, client := range clients {
+		age := keyAge(client.KeysRotated, now, maxAgeDays, graceDays)
+		age.Id = client.Id
+		age.Name = client.Name
+		age.Email = client.Email
+		age.Tags = client.Tags
+		age.Enable = client.Enable
+		report.Clients = append(report.Clients, age)
+		if age.Overdue {
+			report.Overdue++
+		}
+		if age.Due {
+			report.Due++
+		}
+	}
+	sort.SliceStable(report.Clients, func(i, j int) bool {
+		return report.Clients[i].KeysRotated.Before(report.Clients[j].KeysRotated)
+	})
+	return report, nil
+}
+
+// newClientKeys give client a new keypair and preshared key rotated at now
+func newClientKeys(client *model.Client, now time.Time) error {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	presharedKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		return err
+	}
+	client.PrivateKey = key.String()
+	client.PublicKey = key.PublicKey().String()
+	client.PresharedKey = presharedKey.String()
+	client.KeysRotated = now
+	return nil
+}
+
+// ownClientKey keys of a client bringing its own keypair, publicKey with a new preshared key rotated at now.
+// The server keeps no private key for it.
+func ownClientKey(client *model.Client, publicKey string, now time.Time) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("publicKey %s is invalid", publicKey)
+	}
+	presharedKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		return err
+	}
+	client.PrivateKey = ""
+	client.PublicKey = key.String()
+	client.PresharedKey = presharedKey.String()
+	client.KeysRotated = now
+	return nil
+}
+
+// ErrOwnKey the client brought its own keypair, only its owner can rotate it by submitting a new public key
+var ErrOwnKey = errors.New("client has its own key, submit its new public key instead")
+
+// RotateClientKeys new keypair and preshared key for client id. There is no overlap: the old keys stop working
+// as soon as the config is reloaded, the client is cut off until it gets its new config.
+func RotateClientKeys(actor model.Actor, id string, email bool) (*model.Client, error) {
+	client, err := store.LoadClient(id)
+	if err != nil {
+		return nil, err
+	}
+	if client.HasOwnKey() {
+		return nil, ErrOwnKey
+	}
+	rotated, err := rotateClientKeys(actor, client.Interface, []*model.Client{client}, email)
+	if err != nil {
+		return nil, err
+	}
+	return rotated[0], nil
+}
+
+// RotateTagKeys new keypair and preshared key for every client of interface iface with tag, with one config update
+func RotateTagKeys(actor model.Actor, iface, tag string, email bool) ([]*model.Client, error) {
+	clients, err := store.LoadAllClients(iface)
+	if err != nil {
+		return nil, err
+	}
+	selector := model.ClientSelector{Tags: []string{tag}}
+	tagged := make([]*model.Client, 0)
+	for _, client := range clients {
+		if selector.Matches(client) {
+			tagged = append(tagged, client)
+		}
+	}
+	if len(tagged) == 0 {
+		return tagged, nil
+	}
+	return rotateClientKeys(actor, iface, tagged, email)
+}
+
+// rotateClientKeys new keys for clients of interface iface in one transaction, and email the new config
+// to the clients with an email. Clients with their own key are skipped.
+func rotateClientKeys(actor model.Actor, iface string, clients []*model.Client, email bool) ([]*model.Client, error) {
+	now := time.Now().UTC()
+	rotated := make([]*model.Client, 0, len(clients))
+	err := store.WithTx(func(tx storage.Store) error {
+		for _, current := range clients {
+			if current.HasOwnKey() {
+				continue
+			}
+			client := *current
+			err := newClientKeys(&client, now)
+			if err != nil {
+				return err
+			}
+			client.UpdatedBy = actor.Name
+			client.Updated = now
+			err = tx.SaveClient(&client)
+			if err != nil {
+				return err
+			}
+			saved, err := tx.LoadClient(client.Id)
+			if err != nil {
+				return err
+			}
+			audit(tx, actor, model.AuditActionRotate, "client", saved.Id, saved.Name, current, saved)
+			rotated = append(rotated, saved)
+		}
+		if len(rotated) == 0 {
+			return nil
+		}
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, iface)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, client := range rotated {
+		log.WithFields(log.Fields{
+			"client":    client.Id,
+			"name":      client.Name,
+			"interface": iface,
+		}).Info("client keys rotated")
+		if email && client.Email != "" {
+			emailRotatedClient(client)
+		}
+	}
+	return rotated, nil
+}
+
+// RotateServerKeys new keypair for the server of interface iface. Every client config changes with the server
+// public key, with email the new config is sent to the enabled clients with an email.
+func RotateServerKeys(actor model.Actor, iface string, email bool) (*model.Server, error) {
+	current, err := store.LoadServer(iface)
+	if err != nil {
+		return nil, err
+	}
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	server := *current
+	server.PrivateKey = key.String()
+	server.PublicKey = key.PublicKey().String()
+	server.KeysRotated = time.Now().UTC()
+	server.UpdatedBy = actor.Name
+	server.Updated = server.KeysRotated
+
+	saved := &server
+	err = store.WithTx(func(tx storage.Store) error {
+		err := tx.SaveServer(saved)
+		if err != nil {
+			return err
+		}
+		saved, err = tx.LoadServer(iface)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionRotate, "server", iface, iface, current, saved)
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, iface)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"interface": iface,
+	}).Info("server keys rotated")
+
+	if email {
+		clients, err := store.LoadAllClients(iface)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			if client.Enable && client.Email != "" {
+				emailRotatedClient(client)
+			}
+		}
+	}
+	return saved, nil
+}
+
+// emailRotatedClient send the new config to client, a failure is logged since the keys are already rotated
+func emailRotatedClient(client *model.Client) {
+	err := EmailClient(client.Id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"client": client.Id,
+		}).Error("failed to email rotated client config")
+	}
+}
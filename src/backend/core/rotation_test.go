@@ -0,0 +1,116 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+func TestKeyAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name         string
+		age          time.Duration
+		maxAge       int
+		wantDays     int
+		due, overdue bool
+	}{
+		{"new", time.Hour, 90, 0, false, false},
+		{"before the grace period", 83 * day, 90, 83, false, false},
+		{"within the grace period", 84 * day, 90, 84, true, false},
+		{"at the max age", 90 * day, 90, 90, true, false},
+		{"past the max age", 90*day + time.Second, 90, 90, false, true},
+		{"no max age", 400 * day, 0, 400, false, false},
+	}
+	for _, test := range tests {
+		got := keyAge(now.Add(-test.age), now, test.maxAge, 7)
+		if got.AgeDays != test.wantDays || got.Due != test.due || got.Overdue != test.overdue {
+			t.Errorf("%s: %d days due %t overdue %t, want %d days due %t overdue %t", test.name, got.AgeDays, got.Due,
+				got.Overdue, test.wantDays, test.due, test.overdue)
+		}
+	}
+}
+
+func TestRotateClientKeys(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+	clients := make([]*model.Client, 0)
+	for _, name := range []string{"laptop", "phone", "own key"} {
+		client := newTestClient(name)
+		client.Tags = []string{"team"}
+		if name == "own key" {
+			client.PublicKey = testKey(9).PublicKey().String()
+		}
+		created, err := CreateClient(testActor, "wg0", client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, created)
+	}
+	if _, err := RotateClientKeys(testActor, clients[2].Id, false); !errors.Is(err, ErrOwnKey) {
+		t.Errorf("rotation of a client with its own key: %v, want ErrOwnKey", err)
+	}
+
+	runs := reloads()
+	rotated, err := RotateTagKeys(testActor, "wg0", "TEAM", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("%d clients rotated, want those without their own key", len(rotated))
+	}
+	if reloads() != runs+1 {
+		t.Errorf("%d reloads by the rotation, want 1", reloads()-runs)
+	}
+	config := readConfig(t)
+	for i, client := range rotated {
+		previous := clients[i]
+		if client.Id != previous.Id || client.PrivateKey == previous.PrivateKey || client.PresharedKey == previous.PresharedKey {
+			t.Errorf("client %s keys not rotated", previous.Name)
+		}
+		if client.KeysRotated.Before(previous.KeysRotated) {
+			t.Errorf("client %s rotated %s, want from %s on", previous.Name, client.KeysRotated, previous.KeysRotated)
+		}
+		if strings.Contains(config, previous.PublicKey) || !strings.Contains(config, client.PublicKey) {
+			t.Errorf("config does not hold the new public key of client %s only", previous.Name)
+		}
+	}
+	if !strings.Contains(config, clients[2].PublicKey) {
+		t.Error("config does not hold the client with its own key")
+	}
+}
+
+func TestRotateServerKeys(t *testing.T) {
+	previous := setupCore(t)
+	fakeReload(t, "broken")
+	if _, err := CreateClient(testActor, "wg0", newTestClient("laptop")); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := RotateServerKeys(testActor, "wg0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.PrivateKey == previous.PrivateKey || server.PublicKey == previous.PublicKey {
+		t.Error("server keys not rotated")
+	}
+	config := readConfig(t)
+	if strings.Contains(config, previous.PrivateKey) || !strings.Contains(config, server.PrivateKey) {
+		t.Errorf("config does not hold the new server private key only:\n%s", config)
+	}
+
+	t.Setenv("KEY_MAX_AGE_DAYS", "30")
+	report, err := ReadKeyReport("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.MaxAgeDays != 30 || report.GraceDays != defaultKeyRotationGrace || !report.Server.KeysRotated.Equal(server.KeysRotated) {
+		t.Errorf("key report %+v, server %+v, want the policy and the rotated server keys", report, report.Server)
+	}
+	if len(report.Clients) != 1 || report.Due != 0 || report.Overdue != 0 {
+		t.Errorf("key report clients %d due %d overdue %d, want one new client", len(report.Clients), report.Due, report.Overdue)
+	}
+}
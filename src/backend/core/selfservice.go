@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+)
+
+// ErrDeviceQuotaExceeded returned when a self service user already owns as many clients as allowed
+var ErrDeviceQuotaExceeded = errors.New("device quota exceeded")
+
+// ReadOwnedClients clients owned by user, created by it or issued to its email
+func ReadOwnedClients(user *model.User) ([]*model.Client, error) {
+	return ownedClients(store, user)
+}
+
+// ownedClients clients of s owned by user
+func ownedClients(s storage.Store, user *model.User) ([]*model.Client, error) {
+	clients, err := s.LoadAllClients("")
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make([]*model.Client, 0)
+	for _, client := range clients {
+		if client.IsOwnedBy(user) {
+			owned = append(owned, client)
+		}
+	}
+	return owned, nil
+}
+
+// CreateSelfServiceClient create a client for user from an admin defined profile, within the user device quota.
+// With publicKey the client brings its own key.
+func CreateSelfServiceClient(actor model.Actor, user *model.User, profileId string, name string, publicKey string) (*model.Client, error) {
+	if user.DeviceQuota <= 0 {
+		return nil, errors.New("self service client creation is disabled for this user")
+	}
+
+	profile, err := ReadProfile(profileId)
+	if err != nil {
+		return nil, err
+	}
+
+	iface, err := resolveInterface(profile.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := ReadServer(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	// settings come from the profile only, self service users never choose their own routes
+	client := &model.Client{
+		Name:         name,
+		Email:        user.Email,
+		Enable:       true,
+		UseRemoteDNS: profile.UseRemoteDNS,
+		AllowedIPs:   append([]string{}, profile.AllowedIPs...),
+		Address:      append([]string{}, server.Address...),
+		Tags:         []string{},
+		LANIPs:       []string{},
+		PublicKey:    publicKey,
+		Owner:        user.Sub,
+		CreatedBy:    user.Name,
+	}
+
+	// clients are counted in the transaction creating the client, with the user locked, so concurrent requests
+	// can not both pass the quota
+	return createClient(actor, iface, client, func(tx storage.Store) error {
+		if err := tx.LockUser(user.Sub); err != nil {
+			return err
+		}
+		owned, err := ownedClients(tx, user)
+		if err != nil {
+			return err
+		}
+		if len(owned) >= user.DeviceQuota {
+			return ErrDeviceQuotaExceeded
+		}
+		return nil
+	})
+}
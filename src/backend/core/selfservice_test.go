@@ -0,0 +1,112 @@
+package core
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+)
+
+// pausedCount store pausing the first request counting all clients until released, so another request runs
+// between its count and the creation of its client
+type pausedCount struct {
+	storage.Store
+	paused  chan struct{}
+	release chan struct{}
+	counted *atomic.Bool
+}
+
+func (p *pausedCount) WithTx(fn func(tx storage.Store) error) error {
+	return p.Store.WithTx(func(tx storage.Store) error {
+		return fn(&pausedCount{Store: tx, paused: p.paused, release: p.release, counted: p.counted})
+	})
+}
+
+func (p *pausedCount) LoadAllClients(iface string) ([]*model.Client, error) {
+	clients, err := p.Store.LoadAllClients(iface)
+	if iface == "" && p.counted.CompareAndSwap(false, true) {
+		close(p.paused)
+		<-p.release
+	}
+	return clients, err
+}
+
+// setupSelfService self service user with a quota of 2 clients, and the profile it creates them from
+func setupSelfService(t *testing.T) (*model.User, *model.Profile) {
+	t.Helper()
+	setupCore(t)
+	user := &model.User{Sub: "alice", Name: "alice", Email: "alice@example.com", SelfService: true, DeviceQuota: 2}
+	if err := store.SaveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := CreateProfile(testActor, &model.Profile{Interface: "wg0", Name: "laptops", AllowedIPs: []string{"0.0.0.0/0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, profile
+}
+
+func TestSelfServiceDeviceQuota(t *testing.T) {
+	user, profile := setupSelfService(t)
+	actor := model.Actor{Sub: user.Sub, Name: user.Name}
+
+	if _, err := CreateSelfServiceClient(actor, user, profile.Id, "laptop", ""); err != nil {
+		t.Fatal(err)
+	}
+	// a client issued to the email of the user counts as well
+	issued := newTestClient("issued")
+	issued.Email = "Alice@example.com"
+	if _, err := CreateClient(testActor, "wg0", issued); err != nil {
+		t.Fatal(err)
+	}
+	config := readConfig(t)
+
+	_, err := CreateSelfServiceClient(actor, user, profile.Id, "phone", "")
+	if !errors.Is(err, ErrDeviceQuotaExceeded) {
+		t.Fatalf("creation beyond the quota: %v, want ErrDeviceQuotaExceeded", err)
+	}
+	if names := clientNames(t); names != "laptop,issued" {
+		t.Errorf("clients %s, want the creation beyond the quota rolled back", names)
+	}
+	if readConfig(t) != config {
+		t.Error("config written by a creation beyond the quota")
+	}
+
+	user.DeviceQuota = 0
+	if _, err = CreateSelfServiceClient(actor, user, profile.Id, "phone", ""); err == nil || errors.Is(err, ErrDeviceQuotaExceeded) {
+		t.Errorf("creation with self service disabled: %v", err)
+	}
+}
+
+func TestSelfServiceDeviceQuotaConcurrent(t *testing.T) {
+	user, profile := setupSelfService(t)
+	actor := model.Actor{Sub: user.Sub, Name: user.Name}
+	if _, err := CreateSelfServiceClient(actor, user, profile.Id, "laptop", ""); err != nil {
+		t.Fatal(err)
+	}
+	// one client is left in the quota, a second request runs between the count of the first one and its creation
+	paused := &pausedCount{Store: store, paused: make(chan struct{}), release: make(chan struct{}), counted: &atomic.Bool{}}
+	SetStore(paused)
+
+	first := make(chan error)
+	go func() {
+		_, err := CreateSelfServiceClient(actor, user, profile.Id, "phone", "")
+		first <- err
+	}()
+	<-paused.paused
+	_, errSecond := CreateSelfServiceClient(actor, user, profile.Id, "tablet", "")
+	close(paused.release)
+	errFirst := <-first
+
+	owned, err := ReadOwnedClients(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != user.DeviceQuota {
+		t.Errorf("%d clients owned after concurrent requests, want %d", len(owned), user.DeviceQuota)
+	}
+	if (errFirst == nil) == (errSecond == nil) {
+		t.Errorf("concurrent requests failed with %v and %v, want one of them to fail", errFirst, errSecond)
+	}
+}
@@ -2,9 +2,11 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"time"
+	"wg-gen-plus/metrics"
 	"wg-gen-plus/model"
 	"wg-gen-plus/storage"
 	"wg-gen-plus/template"
@@ -14,17 +16,15 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-var WgConfigFile string
-
-// ReadServer object, create default one
-func ReadServer() (*model.Server, error) {
-	server, err := storage.LoadServer()
+// ReadServer object of interface iface, create default one
+func ReadServer(iface string) (*model.Server, error) {
+	server, err := store.LoadServer(iface)
 	if err == nil && server != nil {
 		return server, nil
 	}
 
 	// If not found, create default
-	server = &model.Server{}
+	server = &model.Server{Interface: iface}
 	key, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
 		return nil, err
@@ -61,29 +61,32 @@ func ReadServer() (*model.Server, error) {
 	server.PrivateKey = key.String()
 	server.PublicKey = key.PublicKey().String()
 	server.Endpoint = "wireguard.example.com:123"
-	server.ListenPort = 51820
+	// one listen port per interface, in configuration order
+	server.ListenPort = 51820 + interfaceIndex(iface)
 	server.Address = []string{ipv6ServerAddress, ipv4ServerAddress}
 	server.Dns = []string{ipv6.String(), ipv4.String()}
 	server.AllowedIPs = []string{ipv6Subnet, ipv4Subnet}
 	server.PersistentKeepalive = 25
 	server.Mtu = 0
 	server.Created = time.Now().UTC()
+	server.KeysRotated = server.Created
 	server.Updated = server.Created
 
-	err = storage.SaveServer(server)
+	err = store.SaveServer(server)
 	if err != nil {
 		return nil, err
 	}
-	err = UpdateServerConfigWg()
+	audit(store, model.SystemActor, model.AuditActionCreate, "server", iface, iface, nil, server)
+	err = UpdateServerConfigWg(iface)
 	if err != nil {
 		return nil, err
 	}
 	return server, nil
 }
 
-// UpdateServer keep private values from existing one
-func UpdateServer(server *model.Server) (*model.Server, error) {
-	current, err := storage.LoadServer()
+// UpdateServer of interface iface, keep private values from existing one
+func UpdateServer(actor model.Actor, iface string, server *model.Server) (*model.Server, error) {
+	current, err := store.LoadServer(iface)
 	if err != nil {
 		return nil, err
 	}
@@ -96,97 +99,137 @@ func UpdateServer(server *model.Server) (*model.Server, error) {
 		}
 		return nil, errors.New("failed to validate server")
 	}
+	server.Interface = current.Interface
 	server.PrivateKey = current.PrivateKey
 	server.PublicKey = current.PublicKey
+	server.KeysRotated = current.KeysRotated
 	server.Updated = time.Now().UTC()
 
-	err = storage.SaveServer(server)
-	if err != nil {
-		return nil, err
-	}
-	server, err = storage.LoadServer()
+	err = store.WithTx(func(tx storage.Store) error {
+		err := tx.SaveServer(server)
+		if err != nil {
+			return err
+		}
+		server, err = tx.LoadServer(iface)
+		if err != nil {
+			return err
+		}
+		audit(tx, actor, model.AuditActionUpdate, "server", iface, iface, current, server)
+
+		// data modified, dump new config, a failed reload rolls the change back
+		return writeServerConfig(tx, iface)
+	})
 	if err != nil {
 		return nil, err
 	}
-	return server, UpdateServerConfigWg()
+	return server, nil
 }
 
-// UpdateServerConfigWg in wg format
-func UpdateServerConfigWg() error {
-	// Check if WgConfigFile is empty
-	if WgConfigFile == "" {
-		return errors.New("WireGuard config file path is empty")
-	}
-
-	clients, err := ReadClients()
+// UpdateServerConfigWg of interface iface in wg format
+func UpdateServerConfigWg(iface string) error {
+	// make sure the interface has a server
+	_, err := ReadServer(iface)
 	if err != nil {
 		return err
 	}
+	return writeServerConfig(store, iface)
+}
 
-	server, err := ReadServer()
+// writeServerConfig render the config of interface iface from s, check it, replace the config file atomically
+// and run the reload command, or live apply the changed peers. When the reload fails the previous file is
+// restored and reloaded, and an error is returned so a transaction of s rolls back as well. When the transaction
+// of s rolls back later on, or fails to commit, the previous file is restored and reloaded then.
+func writeServerConfig(s storage.Store, iface string) error {
+	// Check if the WireGuard config directory is set
+	if WgConfDir == "" {
+		return errors.New("WireGuard config directory is empty")
+	}
+	start := time.Now()
+	defer func() {
+		metrics.ConfigGenerated(iface, time.Since(start))
+	}()
+
+	clients, err := s.LoadAllClients(iface)
 	if err != nil {
 		return err
 	}
 
-	// Get environment variables for hook scripts
-	preUpHook := os.Getenv("SERVER_PREUP_HOOK")
-	postUpHook := os.Getenv("SERVER_POSTUP_HOOK")
-	preDownHook := os.Getenv("SERVER_PREDOWN_HOOK")
-	postDownHook := os.Getenv("SERVER_POSTDOWN_HOOK")
-
-	// Use the global WgConfigFile variable
-	_, err = template.DumpServerWg(clients, server, preUpHook, postUpHook, preDownHook, postDownHook, WgConfigFile)
+	server, err := s.LoadServer(iface)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	// Get environment variables for hook scripts, <NAME>_<INTERFACE> overrides <NAME>
+	preUpHook := util.InterfaceEnv("SERVER_PREUP_HOOK", iface)
+	postUpHook := util.InterfaceEnv("SERVER_POSTUP_HOOK", iface)
+	preDownHook := util.InterfaceEnv("SERVER_PREDOWN_HOOK", iface)
+	postDownHook := util.InterfaceEnv("SERVER_POSTDOWN_HOOK", iface)
 
-// GetAllReservedIps the list of all reserved IPs, client and server
-func GetAllReservedIps() ([]string, error) {
-	clients, err := ReadClients()
+	configDataWg, err := template.DumpServerWg(clients, server, preUpHook, postUpHook, preDownHook, postDownHook)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	server, err := ReadServer()
+	err = template.ValidateWg(configDataWg)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("rendered config of %s is invalid: %w", iface, err)
 	}
 
-	reserverIps := make([]string, 0)
+	path := WgConfigFilePath(iface)
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	hadPrevious := err == nil
 
-	for _, client := range clients {
-		for _, cidr := range client.Address {
-			ip, err := util.GetIpFromCidr(cidr)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"err":  err,
-					"cidr": cidr,
-				}).Error("failed to get IP from CIDR")
-			} else {
-				reserverIps = append(reserverIps, ip)
-			}
-		}
+	// the file holds the server private key, only root may read it
+	err = util.WriteFile(path, configDataWg, 0600)
+	if err != nil {
+		return err
 	}
 
-	for _, cidr := range server.Address {
-		ip, err := util.GetIpFromCidr(cidr)
-		if err != nil {
+	err = reloadServer(iface, server, clients)
+	if err == nil {
+		// the running interface must not keep data which is not committed
+		s.OnRollback(func() {
 			log.WithFields(log.Fields{
-				"err":  err,
-				"cidr": cidr,
-			}).Error("failed to get IP from CIDR")
-		} else {
-			reserverIps = append(reserverIps, ip)
+				"interface": iface,
+			}).Warning("changes rolled back, restoring previous config")
+			restoreServerConfig(iface, previous, hadPrevious)
+		})
+		return nil
+	}
+
+	metrics.ReloadFailed(iface)
+	log.WithFields(log.Fields{
+		"err":       err,
+		"interface": iface,
+	}).Error("reload failed, restoring previous config")
+	restoreServerConfig(iface, previous, hadPrevious)
+	return err
+}
+
+// restoreServerConfig write back the previous config file of interface iface and apply the committed state again,
+// or remove the file when there was none
+func restoreServerConfig(iface string, previous []byte, hadPrevious bool) {
+	path := WgConfigFilePath(iface)
+	var err error
+	if hadPrevious {
+		err = util.WriteFile(path, previous, 0600)
+		if err == nil {
+			err = reloadCommitted(iface)
 		}
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":       err,
+			"interface": iface,
+		}).Error("failed to restore previous config")
 	}
-
-	return reserverIps, nil
 }
 
-// ReadWgConfigFile return content of wireguard config file
-func ReadWgConfigFile() ([]byte, error) {
-	return util.ReadFile(WgConfigFile)
+// ReadWgConfigFile return content of wireguard config file of interface iface
+func ReadWgConfigFile(iface string) ([]byte, error) {
+	return util.ReadFile(WgConfigFilePath(iface))
 }
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+)
+
+// fakeReload set a reload command which records every run and fails while the config of wg0 holds failOn,
+// the number of runs is read with the returned function
+func fakeReload(t *testing.T, failOn string) func() int {
+	t.Helper()
+	runs := filepath.Join(t.TempDir(), "reloads")
+	t.Setenv("SERVER_RELOAD_CMD", "echo %i >> "+runs+" && ! grep -q '"+failOn+"' "+WgConfigFilePath("wg0"))
+	return func() int {
+		data, err := os.ReadFile(runs)
+		if errors.Is(err, os.ErrNotExist) {
+			return 0
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "wg0\n")
+	}
+}
+
+func TestWriteServerConfigReload(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+
+	client, err := CreateClient(testActor, "wg0", newTestClient("laptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloads() != 1 {
+		t.Errorf("%d reloads, want 1", reloads())
+	}
+	config, err := os.ReadFile(WgConfigFilePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(config, []byte(client.PublicKey)) {
+		t.Errorf("config does not hold the new client:\n%s", config)
+	}
+}
+
+func TestWriteServerConfigReloadFailure(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+
+	if _, err := CreateClient(testActor, "wg0", newTestClient("laptop")); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.ReadFile(WgConfigFilePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CreateClient(testActor, "wg0", newTestClient("broken"))
+	if err == nil {
+		t.Fatal("client created although the reload failed")
+	}
+	// the failed reload, then the reload of the restored config
+	if reloads() != 3 {
+		t.Errorf("%d reloads, want 3", reloads())
+	}
+
+	config, err := os.ReadFile(WgConfigFilePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(config, previous) {
+		t.Errorf("previous config not restored, got:\n%s\nwant:\n%s", config, previous)
+	}
+	clients, err := ReadClients("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].Name != "laptop" {
+		t.Errorf("client creation not rolled back: %+v", clients)
+	}
+	_, total, err := store.LoadAuditEntries(model.AuditFilter{ObjectType: "client", Action: model.AuditActionCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Errorf("%d client creations in the audit log, want the one of laptop only", total)
+	}
+}
+
+func TestWriteServerConfigReloadFailureWithoutPrevious(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+
+	_, err := CreateClient(testActor, "wg0", newTestClient("broken"))
+	if err == nil {
+		t.Fatal("client created although the reload failed")
+	}
+	// there was no config to reload
+	if reloads() != 1 {
+		t.Errorf("%d reloads, want 1", reloads())
+	}
+	if _, err = os.Stat(WgConfigFilePath("wg0")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("config written by the failed change not removed: %v", err)
+	}
+	clients, err := ReadClients("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 0 {
+		t.Errorf("client creation not rolled back: %+v", clients)
+	}
+}
+
+// failedCommit store whose transactions fail to commit after fn succeeded
+type failedCommit struct {
+	storage.Store
+}
+
+var errCommit = errors.New("commit failed")
+
+func (f *failedCommit) WithTx(fn func(tx storage.Store) error) error {
+	return f.Store.WithTx(func(tx storage.Store) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errCommit
+	})
+}
+
+func TestWriteServerConfigCommitFailure(t *testing.T) {
+	setupCore(t)
+	reloads := fakeReload(t, "broken")
+
+	if _, err := CreateClient(testActor, "wg0", newTestClient("laptop")); err != nil {
+		t.Fatal(err)
+	}
+	previous := readConfig(t)
+	SetStore(&failedCommit{Store: store})
+
+	if _, err := CreateClient(testActor, "wg0", newTestClient("phone")); !errors.Is(err, errCommit) {
+		t.Fatalf("creation error %v, want the failed commit", err)
+	}
+	// the reload of the new config, then the reload of the restored one
+	if reloads() != 3 {
+		t.Errorf("%d reloads, want 3", reloads())
+	}
+	if config := readConfig(t); config != previous {
+		t.Errorf("previous config not restored, got:\n%s\nwant:\n%s", config, previous)
+	}
+	if names := clientNames(t); names != "laptop" {
+		t.Errorf("clients %s, want the creation rolled back", names)
+	}
+}
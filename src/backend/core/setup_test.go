@@ -0,0 +1,89 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// testKey fixed key, seed tells keys apart
+func testKey(seed byte) wgtypes.Key {
+	var key wgtypes.Key
+	for i := range key {
+		key[i] = seed
+	}
+	return key
+}
+
+// setupCore point core at a new, migrated SQLite store and a config directory of its own, managing interface wg0
+// with a server and no clients. The globals of core are restored with the test.
+func setupCore(t *testing.T) *model.Server {
+	t.Helper()
+	dir := t.TempDir()
+	// a transaction waiting on another one fails after 100ms instead of 5s
+	s, err := storage.Open("sqlite", filepath.Join(dir, "wg-gen-plus.db")+"?_busy_timeout=100", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	previousStore, previousDir, previousInterfaces := store, WgConfDir, configuredInterfaces
+	previousDevice, previousStatusSource := device, statusSource
+	t.Cleanup(func() {
+		store, WgConfDir, configuredInterfaces = previousStore, previousDir, previousInterfaces
+		device, statusSource = previousDevice, previousStatusSource
+		s.Close()
+	})
+	SetStore(s)
+	WgConfDir = filepath.Join(dir, "conf")
+	if err = os.Mkdir(WgConfDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	configuredInterfaces = []string{"wg0"}
+	SetDevice(nil)
+	SetStatusSource(nil)
+	// the environment of the developer running the tests does not apply
+	for _, key := range []string{"SERVER_RELOAD_CMD", "LIVE_APPLY", "WG_STATS_API", "WG_STATS_API_TOKEN", "WG_STATS_API_USER", "WG_STATS_API_PASS", "CONNECTED_THRESHOLD"} {
+		for _, name := range []string{key, key + "_WG0"} {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+
+	server := &model.Server{
+		Interface:           "wg0",
+		Address:             []string{"10.0.0.1/24"},
+		ListenPort:          51820,
+		PrivateKey:          testKey(1).String(),
+		PublicKey:           testKey(1).PublicKey().String(),
+		Endpoint:            "vpn.example.com:51820",
+		PersistentKeepalive: 25,
+		AllowedIPs:          []string{"0.0.0.0/0"},
+		Created:             time.Now().UTC(),
+		Updated:             time.Now().UTC(),
+	}
+	if err = store.SaveServer(server); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// newTestClient client of wg0 to create, its address is allocated from the server network
+func newTestClient(name string) *model.Client {
+	return &model.Client{
+		Name:       name,
+		Enable:     true,
+		AllowedIPs: []string{"0.0.0.0/0"},
+		Address:    []string{"10.0.0.0/24"},
+	}
+}
+
+// testActor actor of the changes made by the tests
+var testActor = model.Actor{Sub: "test", Name: "test"}
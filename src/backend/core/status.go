@@ -1,91 +1,136 @@
 package core
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"sort"
 	"time"
 
 	"wg-gen-plus/model"
-)
+	"wg-gen-plus/util"
 
-// apiError implements a top-level JSON-RPC error.
-type apiError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
 
-	Data interface{} `json:"data,omitempty"`
+// StatusSource reads the state of running WireGuard interfaces, peers are returned without client details
+type StatusSource interface {
+	Interface(iface string) (*model.InterfaceStatus, error)
+	Peers(iface string) ([]*model.ClientStatus, error)
 }
 
-type apiRequest struct {
-	Version string          `json:"jsonrpc"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params,omitempty"`
-}
+// defaultConnectedThreshold handshakes happen at least every 2 minutes on an active tunnel
+const defaultConnectedThreshold = 3 * time.Minute
+
+// ErrStatusUnavailable no status source for the interface
+var ErrStatusUnavailable = errors.New("Status API integration not configured")
 
-type apiResponse struct {
-	Version string          `json:"jsonrpc"`
-	Result  interface{}     `json:"result,omitempty"`
-	Error   *apiError       `json:"error,omitempty"`
-	ID      json.RawMessage `json:"id"`
+var statusSource StatusSource
+
+// SetStatusSource read the status of every interface from s instead of wg-api or the kernel, nil restores them
+func SetStatusSource(s StatusSource) {
+	statusSource = s
 }
 
-func fetchWireGuardAPI(reqData apiRequest) (*apiResponse, error) {
-	apiUrl := os.Getenv("WG_STATS_API")
-	if apiUrl == "" {
-		return nil, errors.New("Status API integration not configured")
+// statusSourceOf source of interface iface: the one set, wg-api when WG_STATS_API is set,
+// the kernel device otherwise, nil when there is none
+func statusSourceOf(iface string) StatusSource {
+	if statusSource != nil {
+		return statusSource
 	}
-
-	apiClient := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
+	if util.InterfaceEnv("WG_STATS_API", iface) != "" {
+		return wgAPIStatus{}
 	}
-	jsonData, _ := json.Marshal(reqData)
-	req, err := http.NewRequest(http.MethodPost, apiUrl, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
+	if device != nil {
+		return deviceStatus{}
 	}
+	return nil
+}
 
-	req.Header.Set("User-Agent", "wg-gen-plus")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Cache-Control", "no-cache")
+// StatusEnabled check if the status of interface iface can be read
+func StatusEnabled(iface string) bool {
+	return statusSourceOf(iface) != nil
+}
 
-	if os.Getenv("WG_STATS_API_TOKEN") != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Token %s", os.Getenv("WG_STATS_API_TOKEN")))
-	} else if os.Getenv("WG_STATS_API_USER") != "" {
-		req.SetBasicAuth(os.Getenv("WG_STATS_API_USER"), os.Getenv("WG_STATS_API_PASS"))
-	}
+// deviceStatus status source reading the kernel interface through the WireGuard device
+type deviceStatus struct{}
 
-	res, getErr := apiClient.Do(req)
-	if getErr != nil {
-		return nil, getErr
+// readDevice running interface iface
+func readDevice(iface string) (*wgtypes.Device, error) {
+	d, err := device.Device(iface)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("interface %s is not up", iface)
 	}
+	return d, err
+}
 
-	if res.Body != nil {
-		defer res.Body.Close()
+// Interface status of interface iface
+func (deviceStatus) Interface(iface string) (*model.InterfaceStatus, error) {
+	d, err := readDevice(iface)
+	if err != nil {
+		return nil, err
 	}
+	return &model.InterfaceStatus{
+		Name:          d.Name,
+		DeviceType:    d.Type.String(),
+		ListenPort:    d.ListenPort,
+		NumberOfPeers: len(d.Peers),
+		PublicKey:     d.PublicKey.String(),
+	}, nil
+}
 
-	body, readErr := io.ReadAll(res.Body)
-	if readErr != nil {
-		return nil, readErr
+// Peers peers of interface iface
+func (deviceStatus) Peers(iface string) ([]*model.ClientStatus, error) {
+	d, err := readDevice(iface)
+	if err != nil {
+		return nil, err
 	}
-
-	response := apiResponse{}
-	jsonErr := json.Unmarshal(body, &response)
-	if jsonErr != nil {
-		return nil, jsonErr
+	peers := make([]*model.ClientStatus, 0, len(d.Peers))
+	for _, peer := range d.Peers {
+		allowedIPs := make([]string, 0, len(peer.AllowedIPs))
+		for _, allowedIP := range peer.AllowedIPs {
+			allowedIPs = append(allowedIPs, allowedIP.String())
+		}
+		endpoint := ""
+		if peer.Endpoint != nil {
+			endpoint = peer.Endpoint.String()
+		}
+		peers = append(peers, &model.ClientStatus{
+			PublicKey:        peer.PublicKey.String(),
+			HasPresharedKey:  peer.PresharedKey != wgtypes.Key{},
+			ProtocolVersion:  peer.ProtocolVersion,
+			AllowedIPs:       allowedIPs,
+			Endpoint:         endpoint,
+			LastHandshake:    peer.LastHandshakeTime,
+			ReceivedBytes:    int(peer.ReceiveBytes),
+			TransmittedBytes: int(peer.TransmitBytes),
+		})
 	}
+	return peers, nil
+}
 
-	return &response, nil
+// ConnectedThreshold handshake age up to which a peer of interface iface counts as connected,
+// set with CONNECTED_THRESHOLD, 3 minutes by default
+func ConnectedThreshold(iface string) time.Duration {
+	value := util.InterfaceEnv("CONNECTED_THRESHOLD", iface)
+	if value == "" {
+		return defaultConnectedThreshold
+	}
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold <= 0 {
+		log.WithFields(log.Fields{
+			"err":       err,
+			"interface": iface,
+			"value":     value,
+		}).Warning("invalid CONNECTED_THRESHOLD, using the default")
+		return defaultConnectedThreshold
+	}
+	return threshold
 }
 
-// ReadInterfaceStatus object, create default one
-func ReadInterfaceStatus() (*model.InterfaceStatus, error) {
+// ReadInterfaceStatus object of interface iface, create default one
+func ReadInterfaceStatus(iface string) (*model.InterfaceStatus, error) {
 	interfaceStatus := &model.InterfaceStatus{
 		Name:          "unknown",
 		DeviceType:    "unknown",
@@ -94,73 +139,43 @@ func ReadInterfaceStatus() (*model.InterfaceStatus, error) {
 		PublicKey:     "",
 	}
 
-	data, err := fetchWireGuardAPI(apiRequest{
-		Version: "2.0",
-		Method:  "GetDeviceInfo",
-		Params:  nil,
-	})
+	source := statusSourceOf(iface)
+	if source == nil {
+		return interfaceStatus, ErrStatusUnavailable
+	}
+	status, err := source.Interface(iface)
 	if err != nil {
 		return interfaceStatus, err
 	}
 
-	resultData := data.Result.(map[string]interface{})
-	device := resultData["device"].(map[string]interface{})
-	interfaceStatus.Name = device["name"].(string)
-	interfaceStatus.DeviceType = device["type"].(string)
-	interfaceStatus.PublicKey = device["public_key"].(string)
-	interfaceStatus.ListenPort = int(device["listen_port"].(float64))
-	interfaceStatus.NumberOfPeers = int(device["num_peers"].(float64))
-
-	return interfaceStatus, nil
+	return status, nil
 }
 
-// ReadClientStatus object, create default one, last recent active client is listed first
-func ReadClientStatus() ([]*model.ClientStatus, error) {
+// ReadClientStatus object of interface iface, create default one, last recent active client is listed first
+func ReadClientStatus(iface string) ([]*model.ClientStatus, error) {
 	var clientStatus []*model.ClientStatus
 
-	data, err := fetchWireGuardAPI(apiRequest{
-		Version: "2.0",
-		Method:  "ListPeers",
-		Params:  []byte("{}"),
-	})
+	source := statusSourceOf(iface)
+	if source == nil {
+		return clientStatus, ErrStatusUnavailable
+	}
+	peers, err := source.Peers(iface)
 	if err != nil {
 		return clientStatus, err
 	}
 
-	resultData := data.Result.(map[string]interface{})
-	peers := resultData["peers"].([]interface{})
-
-	clients, err := ReadClients()
+	threshold := ConnectedThreshold(iface)
+	clients, err := ReadClients(iface)
 	withClientDetails := true
 	if err != nil {
 		withClientDetails = false
 	}
 
-	for _, tmpPeer := range peers {
-		peer := tmpPeer.(map[string]interface{})
-		peerHandshake, _ := time.Parse(time.RFC3339Nano, peer["last_handshake"].(string))
-		peerIPs := peer["allowed_ips"].([]interface{})
-		peerAddresses := make([]string, len(peerIPs))
-		for i, peerIP := range peerIPs {
-			peerAddresses[i] = peerIP.(string)
-		}
-		peerHandshakeRelative := time.Since(peerHandshake)
-		peerActive := peerHandshakeRelative.Minutes() < 3 // TODO: we need a better detection... ping for example?
-
-		newClientStatus := &model.ClientStatus{
-			PublicKey:             peer["public_key"].(string),
-			HasPresharedKey:       peer["has_preshared_key"].(bool),
-			ProtocolVersion:       int(peer["protocol_version"].(float64)),
-			Name:                  "UNKNOWN",
-			Email:                 "UNKNOWN",
-			Connected:             peerActive,
-			AllowedIPs:            peerAddresses,
-			Endpoint:              peer["endpoint"].(string),
-			LastHandshake:         peerHandshake,
-			LastHandshakeRelative: peerHandshakeRelative,
-			ReceivedBytes:         int(peer["receive_bytes"].(float64)),
-			TransmittedBytes:      int(peer["transmit_bytes"].(float64)),
-		}
+	for _, newClientStatus := range peers {
+		newClientStatus.LastHandshakeRelative = time.Since(newClientStatus.LastHandshake)
+		newClientStatus.Connected = newClientStatus.LastHandshakeRelative < threshold
+		newClientStatus.Name = "UNKNOWN"
+		newClientStatus.Email = "UNKNOWN"
 
 		if withClientDetails {
 			for _, client := range clients {
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeStatusSource status of every interface from memory
+type fakeStatusSource struct {
+	status *model.InterfaceStatus
+	peers  []*model.ClientStatus
+	err    error
+}
+
+func (s *fakeStatusSource) Interface(iface string) (*model.InterfaceStatus, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	status := *s.status
+	return &status, nil
+}
+
+func (s *fakeStatusSource) Peers(iface string) ([]*model.ClientStatus, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	// the caller fills in the client details
+	peers := make([]*model.ClientStatus, 0, len(s.peers))
+	for _, peer := range s.peers {
+		copied := *peer
+		peers = append(peers, &copied)
+	}
+	return peers, nil
+}
+
+func TestStatusSourceOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   bool
+		statsAPI map[string]string
+		device   bool
+		// want type of the source of wg0 and wg1
+		want [2]string
+	}{
+		{"none", false, nil, false, [2]string{"<nil>", "<nil>"}},
+		{"device", false, nil, true, [2]string{"core.deviceStatus", "core.deviceStatus"}},
+		{"wg-api", false, map[string]string{"WG_STATS_API": "http://wg-api"}, true, [2]string{"core.wgAPIStatus", "core.wgAPIStatus"}},
+		{"wg-api of one interface", false, map[string]string{"WG_STATS_API_WG0": "http://wg-api"}, false, [2]string{"core.wgAPIStatus", "<nil>"}},
+		{"wg-api of one interface with device", false, map[string]string{"WG_STATS_API_WG0": "http://wg-api"}, true, [2]string{"core.wgAPIStatus", "core.deviceStatus"}},
+		{"set source", true, map[string]string{"WG_STATS_API": "http://wg-api"}, true, [2]string{"*core.fakeStatusSource", "*core.fakeStatusSource"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupCore(t)
+			for key, value := range test.statsAPI {
+				t.Setenv(key, value)
+			}
+			if test.device {
+				SetDevice(newFakeDevice())
+			}
+			if test.source {
+				SetStatusSource(&fakeStatusSource{})
+			}
+			for i, iface := range []string{"wg0", "wg1"} {
+				got := fmt.Sprintf("%T", statusSourceOf(iface))
+				if got != test.want[i] {
+					t.Errorf("status source of %s is %s, want %s", iface, got, test.want[i])
+				}
+				if StatusEnabled(iface) != (test.want[i] != "<nil>") {
+					t.Errorf("status of %s enabled %v with source %s", iface, StatusEnabled(iface), got)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusUnavailable(t *testing.T) {
+	setupCore(t)
+
+	status, err := ReadInterfaceStatus("wg0")
+	if !errors.Is(err, ErrStatusUnavailable) || status.Name != "unknown" {
+		t.Errorf("interface status without source: %+v %v, want the unknown status and ErrStatusUnavailable", status, err)
+	}
+	_, err = ReadClientStatus("wg0")
+	if !errors.Is(err, ErrStatusUnavailable) {
+		t.Errorf("client status without source: %v, want ErrStatusUnavailable", err)
+	}
+}
+
+func TestReadClientStatus(t *testing.T) {
+	setupCore(t)
+	for _, client := range []*model.Client{
+		testPeerClient("recent", 2, "10.0.0.2/32"),
+		testPeerClient("idle", 3, "10.0.0.3/32"),
+	} {
+		client.Email = client.Name + "@example.com"
+		if err := store.SaveClient(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	SetStatusSource(&fakeStatusSource{
+		status: &model.InterfaceStatus{Name: "wg0", DeviceType: "fake", ListenPort: 51820, NumberOfPeers: 3},
+		peers: []*model.ClientStatus{
+			{PublicKey: testKey(3).PublicKey().String(), LastHandshake: now.Add(-time.Hour)},
+			{PublicKey: testKey(2).PublicKey().String(), LastHandshake: now.Add(-time.Minute)},
+			{PublicKey: testKey(9).PublicKey().String(), LastHandshake: now.Add(-10 * time.Second)},
+		},
+	})
+
+	status, err := ReadInterfaceStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.DeviceType != "fake" || status.NumberOfPeers != 3 {
+		t.Errorf("interface status not read from the set source: %+v", status)
+	}
+
+	peers, err := ReadClientStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name      string
+		email     string
+		connected bool
+	}{
+		{"UNKNOWN", "UNKNOWN", true},
+		{"recent", "recent@example.com", true},
+		{"idle", "idle@example.com", false},
+	}
+	if len(peers) != len(want) {
+		t.Fatalf("%d peers, want %d", len(peers), len(want))
+	}
+	for i, w := range want {
+		if peers[i].Name != w.name || peers[i].Email != w.email || peers[i].Connected != w.connected {
+			t.Errorf("peer %d: %s %s connected %v, want %s %s connected %v, most recent handshake first",
+				i, peers[i].Name, peers[i].Email, peers[i].Connected, w.name, w.email, w.connected)
+		}
+	}
+
+	// a shorter threshold tells the same handshakes apart
+	t.Setenv("CONNECTED_THRESHOLD", "30s")
+	peers, err = ReadClientStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !peers[0].Connected || peers[1].Connected {
+		t.Errorf("connected %v %v with a threshold of 30s, want true false", peers[0].Connected, peers[1].Connected)
+	}
+
+	failure := errors.New("source failure")
+	SetStatusSource(&fakeStatusSource{err: failure})
+	if _, err = ReadClientStatus("wg0"); !errors.Is(err, failure) {
+		t.Errorf("client status error %v, want the source failure", err)
+	}
+}
+
+func TestDeviceStatus(t *testing.T) {
+	server := setupCore(t)
+	client := testPeerClient("laptop", 2, "10.0.0.2/32")
+	running := runningDevice(t, server, client)
+	running.Peers[0].Endpoint = &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	running.Peers[0].ReceiveBytes = 1000
+	running.Peers[0].TransmitBytes = 2000
+	SetDevice(newFakeDevice(running))
+
+	status, err := ReadInterfaceStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Name != "wg0" || status.ListenPort != server.ListenPort || status.NumberOfPeers != 1 || status.PublicKey != server.PublicKey {
+		t.Errorf("interface status not read from the device: %+v", status)
+	}
+	peers, err := statusSourceOf("wg0").Peers("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 {
+		t.Fatalf("%d peers, want 1", len(peers))
+	}
+	peer := peers[0]
+	if peer.PublicKey != client.PublicKey || !peer.HasPresharedKey || peer.Endpoint != "203.0.113.7:40000" ||
+		peer.ReceivedBytes != 1000 || peer.TransmittedBytes != 2000 || strings.Join(peer.AllowedIPs, ",") != "10.0.0.2/32" {
+		t.Errorf("peer status not read from the device: %+v", peer)
+	}
+
+	// an interface that is not up has no status
+	SetDevice(newFakeDevice(&wgtypes.Device{Name: "wg1"}))
+	if _, err = ReadInterfaceStatus("wg0"); err == nil || !strings.Contains(err.Error(), "is not up") {
+		t.Errorf("status of an interface that is not up: %v", err)
+	}
+}
+
+func TestWgAPIStatus(t *testing.T) {
+	setupCore(t)
+	handshake := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req apiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var result interface{}
+		switch req.Method {
+		case "GetDeviceInfo":
+			result = apiDevice{Device: &apiDeviceInfo{Name: "wg0", Type: "Linux kernel", ListenPort: 51820, NumPeers: 1}}
+		case "ListPeers":
+			result = apiPeers{Peers: []apiPeer{{PublicKey: testKey(2).PublicKey().String(), LastHandshake: handshake, ReceiveBytes: 10}}}
+		}
+		data, _ := json.Marshal(result)
+		json.NewEncoder(w).Encode(apiResponse{Version: "2.0", Result: data})
+	}))
+	defer api.Close()
+	t.Setenv("WG_STATS_API", api.URL)
+	t.Setenv("WG_STATS_API_TOKEN", "secret")
+	// wg-api takes precedence over the device
+	SetDevice(newFakeDevice())
+
+	status, err := ReadInterfaceStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.DeviceType != "Linux kernel" || status.NumberOfPeers != 1 {
+		t.Errorf("interface status not read from wg-api: %+v", status)
+	}
+	peers, err := ReadClientStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || !peers[0].LastHandshake.Equal(handshake) || !peers[0].Connected || peers[0].ReceivedBytes != 10 {
+		t.Errorf("peers not read from wg-api: %+v", peers)
+	}
+}
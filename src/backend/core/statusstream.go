@@ -0,0 +1,235 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"wg-gen-plus/model"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// statusSubscriberBuffer events a subscriber may fall behind before it is dropped
+const statusSubscriberBuffer = 16
+
+// ErrStatusStreamDisabled the status stream was not started
+var ErrStatusStreamDisabled = errors.New("status streaming is disabled")
+
+// statusSubscriber receiver of the status events of one interface
+type statusSubscriber struct {
+	iface  string
+	events chan *model.StatusEvent
+	// snapshot the next event must hold every peer, err the last error sent
+	snapshot bool
+	err      string
+}
+
+// statusSample peers of an interface in status order and by public key
+type statusSample struct {
+	time   time.Time
+	peers  []*model.PeerDelta
+	byPeer map[string]*model.PeerDelta
+}
+
+// statusStream one sampler reading the status of the interfaces with subscribers, shared by all of them
+type statusStream struct {
+	mu          sync.Mutex
+	subscribers map[*statusSubscriber]struct{}
+	last        map[string]*statusSample
+}
+
+var stream *statusStream
+
+// StartStatusStream read the status of the interfaces with subscribers each interval in the background
+// and send the changes to the subscribers
+func StartStatusStream(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("status stream interval must be positive")
+	}
+	s := &statusStream{
+		subscribers: make(map[*statusSubscriber]struct{}),
+		last:        make(map[string]*statusSample),
+	}
+	stream = s
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.sample(now.UTC())
+		}
+	}()
+	return nil
+}
+
+// SubscribeStatus events of interface iface, starting with a snapshot. The channel is closed when the subscriber
+// falls too far behind, unsubscribe must be called once done.
+func SubscribeStatus(iface string) (events <-chan *model.StatusEvent, unsubscribe func(), err error) {
+	s := stream
+	if s == nil {
+		return nil, nil, ErrStatusStreamDisabled
+	}
+	if !StatusEnabled(iface) {
+		return nil, nil, ErrStatusUnavailable
+	}
+
+	sub := &statusSubscriber{
+		iface:    iface,
+		events:   make(chan *model.StatusEvent, statusSubscriberBuffer),
+		snapshot: true,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[sub] = struct{}{}
+	// the interface is already sampled for others, no need to wait for the next sample
+	if last, ok := s.last[iface]; ok {
+		s.send(sub, last.event(model.StatusEventSnapshot, iface))
+	}
+	return sub.events, func() { s.unsubscribe(sub) }, nil
+}
+
+func (s *statusStream) unsubscribe(sub *statusSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// send event to sub without blocking the sampler, a subscriber with a full buffer is dropped. Called with mu held.
+func (s *statusStream) send(sub *statusSubscriber, event *model.StatusEvent) {
+	select {
+	case sub.events <- event:
+		// after an error the next event holds every peer again
+		sub.snapshot = event.Type == model.StatusEventError
+		sub.err = event.Error
+	default:
+		log.WithFields(log.Fields{
+			"interface": sub.iface,
+		}).Debug("dropping slow status stream subscriber")
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// sample read every interface with subscribers once and publish the changes
+func (s *statusStream) sample(now time.Time) {
+	s.mu.Lock()
+	ifaces := make(map[string]bool)
+	for sub := range s.subscribers {
+		ifaces[sub.iface] = true
+	}
+	// the rates of an interface nobody watched for a while would be averages over that time
+	for iface := range s.last {
+		if !ifaces[iface] {
+			delete(s.last, iface)
+		}
+	}
+	s.mu.Unlock()
+
+	for iface := range ifaces {
+		peers, err := ReadClientStatus(iface)
+		s.mu.Lock()
+		if err != nil {
+			s.publishError(iface, now, err)
+		} else {
+			s.publish(iface, now, peers)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// publishError send err to the subscribers of iface which were not told yet. Called with mu held.
+func (s *statusStream) publishError(iface string, now time.Time, err error) {
+	delete(s.last, iface)
+	event := &model.StatusEvent{
+		Type:      model.StatusEventError,
+		Interface: iface,
+		Time:      now,
+		Peers:     []*model.PeerDelta{},
+		Error:     err.Error(),
+	}
+	for sub := range s.subscribers {
+		if sub.iface == iface && sub.err != event.Error {
+			s.send(sub, event)
+		}
+	}
+}
+
+// publish send the peers that changed since the last sample of iface, or all of them to new subscribers and
+// subscribers recovering from an error. Called with mu held.
+func (s *statusStream) publish(iface string, now time.Time, peers []*model.ClientStatus) {
+	previous := s.last[iface]
+	current := &statusSample{
+		time:   now,
+		peers:  make([]*model.PeerDelta, 0, len(peers)),
+		byPeer: make(map[string]*model.PeerDelta, len(peers)),
+	}
+	changed := &model.StatusEvent{Type: model.StatusEventDelta, Interface: iface, Time: now, Peers: []*model.PeerDelta{}}
+
+	for _, peer := range peers {
+		delta := &model.PeerDelta{Status: peer}
+		var old *model.PeerDelta
+		if previous != nil {
+			old = previous.byPeer[peer.PublicKey]
+		}
+		if old != nil {
+			if seconds := now.Sub(previous.time).Seconds(); seconds > 0 {
+				delta.ReceiveRate = float64(counterDelta(int64(old.Status.ReceivedBytes), int64(peer.ReceivedBytes))) / seconds
+				delta.TransmitRate = float64(counterDelta(int64(old.Status.TransmittedBytes), int64(peer.TransmittedBytes))) / seconds
+			}
+		}
+		current.peers = append(current.peers, delta)
+		current.byPeer[peer.PublicKey] = delta
+		if old == nil || peerDeltaChanged(old, delta) {
+			changed.Peers = append(changed.Peers, delta)
+		}
+	}
+	if previous != nil {
+		for key := range previous.byPeer {
+			if _, ok := current.byPeer[key]; !ok {
+				changed.Removed = append(changed.Removed, key)
+			}
+		}
+		sort.Strings(changed.Removed)
+	}
+	s.last[iface] = current
+
+	var snapshot *model.StatusEvent
+	for sub := range s.subscribers {
+		if sub.iface != iface {
+			continue
+		}
+		if sub.snapshot || sub.err != "" {
+			if snapshot == nil {
+				snapshot = current.event(model.StatusEventSnapshot, iface)
+			}
+			s.send(sub, snapshot)
+		} else if len(changed.Peers) > 0 || len(changed.Removed) > 0 {
+			s.send(sub, changed)
+		}
+	}
+}
+
+// event every peer of the sample
+func (sample *statusSample) event(typ, iface string) *model.StatusEvent {
+	return &model.StatusEvent{Type: typ, Interface: iface, Time: sample.time, Peers: sample.peers}
+}
+
+// peerDeltaChanged whether a dashboard showing old must be updated to show current
+func peerDeltaChanged(old, current *model.PeerDelta) bool {
+	o, c := old.Status, current.Status
+	return !o.LastHandshake.Equal(c.LastHandshake) ||
+		o.Endpoint != c.Endpoint ||
+		o.Connected != c.Connected ||
+		o.ReceivedBytes != c.ReceivedBytes ||
+		o.TransmittedBytes != c.TransmittedBytes ||
+		o.Name != c.Name ||
+		o.Email != c.Email ||
+		strings.Join(o.AllowedIPs, ",") != strings.Join(c.AllowedIPs, ",") ||
+		old.ReceiveRate != current.ReceiveRate ||
+		old.TransmitRate != current.TransmitRate
+}
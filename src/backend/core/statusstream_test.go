@@ -0,0 +1,141 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+// setupStatusStream status stream which is sampled by the test, and the source of the peers laptop and an unknown one
+func setupStatusStream(t *testing.T) (*statusStream, *fakeStatusSource) {
+	t.Helper()
+	setupCore(t)
+	laptop, err := CreateClient(testActor, "wg0", newTestClient("laptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &fakeStatusSource{peers: []*model.ClientStatus{
+		{PublicKey: laptop.PublicKey, ReceivedBytes: 1000},
+		{PublicKey: testKey(7).PublicKey().String()},
+	}}
+	SetStatusSource(source)
+
+	previous := stream
+	t.Cleanup(func() { stream = previous })
+	stream = &statusStream{
+		subscribers: make(map[*statusSubscriber]struct{}),
+		last:        make(map[string]*statusSample),
+	}
+	return stream, source
+}
+
+// nextEvent event waiting on events as type, peers with their rates, removed peers and error, "none" without event
+func nextEvent(events <-chan *model.StatusEvent) string {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			return "closed"
+		}
+		peers := make([]string, 0, len(event.Peers))
+		for _, peer := range event.Peers {
+			peers = append(peers, fmt.Sprintf("%s %g", peer.Status.Name, peer.ReceiveRate))
+		}
+		parts := []string{event.Type, "[" + strings.Join(peers, ",") + "]"}
+		if len(event.Removed) > 0 {
+			parts = append(parts, strings.Join(event.Removed, ","))
+		}
+		if event.Error != "" {
+			parts = append(parts, event.Error)
+		}
+		return strings.Join(parts, " ")
+	default:
+		return "none"
+	}
+}
+
+func TestStatusStream(t *testing.T) {
+	s, source := setupStatusStream(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	unknown := source.peers[1].PublicKey
+
+	events, unsubscribe, err := SubscribeStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	steps := []struct {
+		name   string
+		change func()
+		want   string
+	}{
+		{"first sample", func() {}, "snapshot [laptop 0,UNKNOWN 0]"},
+		{"traffic", func() { source.peers[0].ReceivedBytes += 1000 }, "delta [laptop 100]"},
+		// the rate drops back to 0
+		{"idle", func() {}, "delta [laptop 0]"},
+		{"unchanged", func() {}, "none"},
+		{"peer removed", func() { source.peers = source.peers[:1] }, "delta [] " + unknown},
+		{"error", func() { source.err = errors.New("interface wg0 is not up") }, "error [] interface wg0 is not up"},
+		{"same error", func() {}, "none"},
+		{"recovered", func() { source.err = nil }, "snapshot [laptop 0]"},
+	}
+	for i, step := range steps {
+		step.change()
+		s.sample(now.Add(time.Duration(i) * 10 * time.Second))
+		if got := nextEvent(events); got != step.want {
+			t.Errorf("%s: event %s, want %s", step.name, got, step.want)
+		}
+	}
+
+	// a late subscriber gets the last sample right away
+	late, unsubscribeLate, err := SubscribeStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nextEvent(late); got != "snapshot [laptop 0]" {
+		t.Errorf("late subscriber event %s, want the last sample", got)
+	}
+	unsubscribeLate()
+	if got := nextEvent(late); got != "closed" {
+		t.Errorf("event %s after unsubscribing, want the events closed", got)
+	}
+}
+
+func TestStatusStreamSlowSubscriber(t *testing.T) {
+	s, source := setupStatusStream(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	events, unsubscribe, err := SubscribeStatus("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// every sample changes, the subscriber reads none of them
+	for i := 0; i <= statusSubscriberBuffer; i++ {
+		source.peers[0].ReceivedBytes += 1000
+		s.sample(now.Add(time.Duration(i) * time.Second))
+	}
+	for i := 0; i < statusSubscriberBuffer; i++ {
+		if got := nextEvent(events); got == "none" || got == "closed" {
+			t.Fatalf("event %d: %s, want the buffered events", i, got)
+		}
+	}
+	if got := nextEvent(events); got != "closed" {
+		t.Errorf("event %s past the buffer, want the slow subscriber dropped", got)
+	}
+}
+
+func TestSubscribeStatusUnavailable(t *testing.T) {
+	setupStatusStream(t)
+	SetStatusSource(nil)
+	if _, _, err := SubscribeStatus("wg0"); !errors.Is(err, ErrStatusUnavailable) {
+		t.Errorf("subscription without status source: %v, want ErrStatusUnavailable", err)
+	}
+	stream = nil
+	if _, _, err := SubscribeStatus("wg0"); !errors.Is(err, ErrStatusStreamDisabled) {
+		t.Errorf("subscription without status stream: %v, want ErrStatusStreamDisabled", err)
+	}
+}
@@ -0,0 +1,13 @@
+package core
+
+import (
+	"wg-gen-plus/storage"
+)
+
+// store persistence used by core, set once on startup
+var store storage.Store
+
+// SetStore sets the storage backend used by core
+func SetStore(s storage.Store) {
+	store = s
+}
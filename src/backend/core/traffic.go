@@ -0,0 +1,303 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/storage"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// downsampledPeriod period samples older than the raw retention are merged into, in seconds
+const downsampledPeriod = 3600
+
+// maxTrafficPoints longest client history one request returns
+const maxTrafficPoints = 10000
+
+// ErrInvalidHistory history range is empty, or has a step that is not positive or too many steps
+var ErrInvalidHistory = errors.New("history range or step is invalid")
+
+// trafficCounters raw interface counters of a client at its last sample
+type trafficCounters struct {
+	received    int64
+	transmitted int64
+}
+
+// trafficSampler periodic sampling of the traffic of the clients of every interface
+type trafficSampler struct {
+	interval     time.Duration
+	rawRetention time.Duration
+	retention    time.Duration
+	last         map[string]trafficCounters
+	lastPruned   time.Time
+}
+
+// StartTrafficSampler store the traffic of the clients of every interface each interval in the background.
+// Samples older than rawRetention are merged into hourly ones, samples older than retention are deleted.
+func StartTrafficSampler(interval, rawRetention, retention time.Duration) error {
+	if interval <= 0 {
+		return errors.New("traffic sample interval must be positive")
+	}
+	samples, err := store.LoadLastTrafficSamples()
+	if err != nil {
+		return err
+	}
+
+	// continue from the counters stored before a restart of wg-gen-plus
+	sampler := &trafficSampler{
+		interval:     interval,
+		rawRetention: rawRetention,
+		retention:    retention,
+		last:         make(map[string]trafficCounters),
+	}
+	for _, sample := range samples {
+		sampler.last[sample.ClientId] = trafficCounters{sample.ReceivedTotal, sample.TransmittedTotal}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			sampler.sample(now.UTC())
+			enforceQuotas(now.UTC())
+			sampler.prune(now.UTC())
+		}
+	}()
+	return nil
+}
+
+// sample store one sample per client with a peer on its interface
+func (t *trafficSampler) sample(now time.Time) {
+	for _, iface := range configuredInterfaces {
+		source := statusSourceOf(iface)
+		if source == nil {
+			continue
+		}
+		peers, err := source.Peers(iface)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Debug("failed to read peers for traffic sample")
+			continue
+		}
+		clients, err := store.LoadAllClients(iface)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"interface": iface,
+			}).Error("failed to read clients for traffic sample")
+			continue
+		}
+		byPublicKey := make(map[string]*model.Client, len(clients))
+		for _, client := range clients {
+			byPublicKey[client.PublicKey] = client
+		}
+
+		for _, peer := range peers {
+			client, ok := byPublicKey[peer.PublicKey]
+			if !ok {
+				continue
+			}
+			current := trafficCounters{int64(peer.ReceivedBytes), int64(peer.TransmittedBytes)}
+			sample := &model.TrafficSample{
+				ClientId:         client.Id,
+				Time:             now,
+				Period:           int(t.interval.Seconds()),
+				ReceivedTotal:    current.received,
+				TransmittedTotal: current.transmitted,
+				LastHandshake:    peer.LastHandshake,
+			}
+			// the first sample of a client is the baseline, traffic before it is unknown
+			if previous, ok := t.last[client.Id]; ok {
+				sample.ReceivedBytes = counterDelta(previous.received, current.received)
+				sample.TransmittedBytes = counterDelta(previous.transmitted, current.transmitted)
+			}
+			err = store.SaveTrafficSample(sample)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err":    err,
+					"client": client.Id,
+				}).Error("failed to save traffic sample")
+				continue
+			}
+			t.last[client.Id] = current
+		}
+	}
+}
+
+// counterDelta bytes counted since previous, a counter lower than before restarted from zero with the interface
+func counterDelta(previous, current int64) int64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// prune merge the samples older than the raw retention into hourly ones and delete the ones older than the retention,
+// at most once an hour
+func (t *trafficSampler) prune(now time.Time) {
+	if now.Sub(t.lastPruned) < time.Hour {
+		return
+	}
+	t.lastPruned = now
+
+	err := downsampleTraffic(now.Add(-t.rawRetention).Truncate(time.Hour))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to downsample traffic history")
+	}
+	if t.retention > 0 {
+		err = store.DeleteTrafficSamples(model.TrafficFilter{To: now.Add(-t.retention)})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to delete expired traffic history")
+		}
+	}
+}
+
+// downsampleTraffic replace the samples of shorter periods ending before cutoff by one sample per client and hour.
+// A sample is counted in the hour its period starts in, so a sample spanning the hour of an earlier downsampling
+// is added to the hourly sample of that hour.
+func downsampleTraffic(cutoff time.Time) error {
+	filter := model.TrafficFilter{To: cutoff, MaxPeriod: downsampledPeriod - 1}
+	samples, err := store.LoadTrafficSamples(filter)
+	if err != nil || len(samples) == 0 {
+		return err
+	}
+
+	type bucket struct {
+		clientId string
+		start    time.Time
+	}
+	merged := make(map[bucket]*model.TrafficSample)
+	order := make([]bucket, 0)
+	earliest := cutoff
+	for _, sample := range samples {
+		b := bucket{sample.ClientId, trafficSampleStart(sample).Truncate(time.Hour)}
+		m, ok := merged[b]
+		if !ok {
+			m = &model.TrafficSample{ClientId: b.clientId, Time: b.start.Add(time.Hour), Period: downsampledPeriod}
+			merged[b] = m
+			order = append(order, b)
+			if b.start.Before(earliest) {
+				earliest = b.start
+			}
+		}
+		m.ReceivedBytes += sample.ReceivedBytes
+		m.TransmittedBytes += sample.TransmittedBytes
+		// samples are loaded oldest first, the last one holds the latest counters
+		m.ReceivedTotal = sample.ReceivedTotal
+		m.TransmittedTotal = sample.TransmittedTotal
+		if sample.LastHandshake.After(m.LastHandshake) {
+			m.LastHandshake = sample.LastHandshake
+		}
+	}
+
+	return store.WithTx(func(tx storage.Store) error {
+		// hourly samples of the same hours, from earlier downsamplings
+		existing, err := tx.LoadTrafficSamples(model.TrafficFilter{
+			From:      earliest.Add(time.Hour),
+			To:        cutoff.Add(time.Hour),
+			MinPeriod: downsampledPeriod,
+		})
+		if err != nil {
+			return err
+		}
+		for _, sample := range existing {
+			m, ok := merged[bucket{sample.ClientId, trafficSampleStart(sample)}]
+			if !ok || sample.Period != downsampledPeriod {
+				continue
+			}
+			m.ReceivedBytes += sample.ReceivedBytes
+			m.TransmittedBytes += sample.TransmittedBytes
+			if sample.LastHandshake.After(m.LastHandshake) {
+				m.LastHandshake = sample.LastHandshake
+			}
+			err = tx.DeleteTrafficSamples(model.TrafficFilter{
+				ClientId:  sample.ClientId,
+				From:      sample.Time,
+				To:        sample.Time.Add(time.Second),
+				MinPeriod: downsampledPeriod,
+				MaxPeriod: downsampledPeriod,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.DeleteTrafficSamples(filter)
+		if err != nil {
+			return err
+		}
+		for _, b := range order {
+			err = tx.SaveTrafficSample(merged[b])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// trafficSampleStart beginning of the period of sample
+func trafficSampleStart(sample *model.TrafficSample) time.Time {
+	return sample.Time.Add(-time.Duration(sample.Period) * time.Second)
+}
+
+// clientTraffic bytes received and transmitted by client id in the samples ending from from on
+func clientTraffic(id string, from time.Time) (received, transmitted int64, err error) {
+	samples, err := store.LoadTrafficSamples(model.TrafficFilter{ClientId: id, From: from})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, sample := range samples {
+		received += sample.ReceivedBytes
+		transmitted += sample.TransmittedBytes
+	}
+	return received, transmitted, nil
+}
+
+// ReadClientHistory traffic of client id from from to to, summed per step.
+// Samples are counted in the step their period starts in, hourly samples of old history are not split.
+func ReadClientHistory(id string, from, to time.Time, step time.Duration) ([]*model.TrafficPoint, error) {
+	if step <= 0 || !to.After(from) {
+		return nil, ErrInvalidHistory
+	}
+	count := int((to.Sub(from) + step - 1) / step)
+	if count > maxTrafficPoints {
+		return nil, fmt.Errorf("%w: more than %d steps", ErrInvalidHistory, maxTrafficPoints)
+	}
+
+	// samples are stored at the end of their period
+	samples, err := store.LoadTrafficSamples(model.TrafficFilter{
+		ClientId: id,
+		From:     from,
+		To:       to.Add(downsampledPeriod * time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]*model.TrafficPoint, count)
+	for i := range points {
+		points[i] = &model.TrafficPoint{Time: from.Add(time.Duration(i) * step)}
+	}
+	for _, sample := range samples {
+		start := trafficSampleStart(sample)
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+		point := points[int(start.Sub(from)/step)]
+		point.ReceivedBytes += sample.ReceivedBytes
+		point.TransmittedBytes += sample.TransmittedBytes
+		if sample.LastHandshake.After(point.LastHandshake) {
+			point.LastHandshake = sample.LastHandshake
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous int64
+		current  int64
+		want     int64
+	}{
+		{"counted", 1000, 1500, 500},
+		{"idle", 1000, 1000, 0},
+		{"from zero", 0, 700, 700},
+		// the interface restarted, its counters start from zero again
+		{"reset", 1000, 300, 300},
+		{"reset to zero", 1000, 0, 0},
+	}
+	for _, test := range tests {
+		if got := counterDelta(test.previous, test.current); got != test.want {
+			t.Errorf("%s: delta from %d to %d is %d, want %d", test.name, test.previous, test.current, got, test.want)
+		}
+	}
+}
+
+func TestTrafficSamplerCounters(t *testing.T) {
+	setupCore(t)
+	client := testPeerClient("laptop", 2, "10.0.0.2/32")
+	if err := store.SaveClient(client); err != nil {
+		t.Fatal(err)
+	}
+	peer := &model.ClientStatus{PublicKey: client.PublicKey}
+	SetStatusSource(&fakeStatusSource{peers: []*model.ClientStatus{peer}})
+	sampler := &trafficSampler{interval: time.Minute, last: make(map[string]trafficCounters)}
+
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	counters := []struct {
+		name                  string
+		received, transmitted int
+		// want bytes of the sample
+		wantReceived, wantTransmitted int64
+	}{
+		// traffic before the first sample is unknown
+		{"first", 5000, 8000, 0, 0},
+		{"counted", 5600, 8100, 600, 100},
+		{"interface restarted", 200, 50, 200, 50},
+		{"counted after the restart", 700, 60, 500, 10},
+	}
+	for i, c := range counters {
+		peer.ReceivedBytes, peer.TransmittedBytes = c.received, c.transmitted
+		sampler.sample(start.Add(time.Duration(i) * time.Minute))
+	}
+
+	samples, err := store.LoadTrafficSamples(model.TrafficFilter{ClientId: client.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != len(counters) {
+		t.Fatalf("%d samples, want %d", len(samples), len(counters))
+	}
+	for i, c := range counters {
+		s := samples[i]
+		if s.ReceivedBytes != c.wantReceived || s.TransmittedBytes != c.wantTransmitted {
+			t.Errorf("%s sample: %d received %d transmitted, want %d %d", c.name, s.ReceivedBytes, s.TransmittedBytes, c.wantReceived, c.wantTransmitted)
+		}
+		if s.ReceivedTotal != int64(c.received) || s.TransmittedTotal != int64(c.transmitted) || s.Period != 60 {
+			t.Errorf("%s sample: totals %d %d of %ds, want the raw counters of 60s", c.name, s.ReceivedTotal, s.TransmittedTotal, s.Period)
+		}
+	}
+}
+
+// saveTrafficSample raw sample of client id ending at end after period, with bytes received and twice as many transmitted
+func saveTrafficSample(t *testing.T, id string, end time.Time, period time.Duration, bytes int64) {
+	t.Helper()
+	err := store.SaveTrafficSample(&model.TrafficSample{
+		ClientId:         id,
+		Time:             end,
+		Period:           int(period.Seconds()),
+		ReceivedBytes:    bytes,
+		TransmittedBytes: 2 * bytes,
+		ReceivedTotal:    end.Unix(),
+		TransmittedTotal: 2 * end.Unix(),
+		LastHandshake:    end.Add(-10 * time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownsampleTraffic(t *testing.T) {
+	setupCore(t)
+	hour := func(h int) time.Time {
+		return time.Date(2024, 1, 1, h, 0, 0, 0, time.UTC)
+	}
+	// one sample a minute from 09:00 to 11:00 for two clients, the last sample of each client spanning
+	// 10:59:30 to 11:00:30, and samples of 09:30 to 10:30 every 10 minutes for a third one
+	want := map[string]map[time.Time]int64{}
+	add := func(id string, end time.Time, period time.Duration, bytes int64) {
+		saveTrafficSample(t, id, end, period, bytes)
+		if want[id] == nil {
+			want[id] = map[time.Time]int64{}
+		}
+		want[id][end.Add(-period).Truncate(time.Hour)] += bytes
+	}
+	for _, id := range []string{"a", "b"} {
+		for m := 1; m < 120; m++ {
+			add(id, hour(9).Add(time.Duration(m)*time.Minute), time.Minute, int64(m))
+		}
+		add(id, hour(11).Add(30*time.Second), time.Minute, 1000)
+	}
+	for m := 40; m <= 90; m += 10 {
+		add("c", hour(9).Add(time.Duration(m)*time.Minute), 10*time.Minute, 10)
+	}
+
+	// raw retention reaching 10:00, then 11:00 then 12:00, the hours of 09:00 and 10:00 get more samples each time
+	for _, cutoff := range []time.Time{hour(10), hour(11), hour(12), hour(12)} {
+		if err := downsampleTraffic(cutoff); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := store.LoadTrafficSamples(model.TrafficFilter{To: cutoff, MaxPeriod: downsampledPeriod - 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != 0 {
+			t.Errorf("%d raw samples ending before %s left", len(raw), cutoff.Format("15:04"))
+		}
+	}
+
+	samples, err := store.LoadTrafficSamples(model.TrafficFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]map[time.Time]int64{}
+	seen := map[string]bool{}
+	for _, s := range samples {
+		if s.Period != downsampledPeriod {
+			t.Errorf("sample of client %s ending %s of %ds, want hourly ones only", s.ClientId, s.Time.Format("15:04:05"), s.Period)
+			continue
+		}
+		start := trafficSampleStart(s)
+		key := fmt.Sprintf("%s %s", s.ClientId, start.Format("15:04"))
+		if seen[key] {
+			t.Errorf("hour %s of client %s downsampled twice", start.Format("15:04"), s.ClientId)
+		}
+		seen[key] = true
+		if s.TransmittedBytes != 2*s.ReceivedBytes {
+			t.Errorf("hour %s of client %s transmitted %d for %d received, want the sums of its samples", start.Format("15:04"), s.ClientId, s.TransmittedBytes, s.ReceivedBytes)
+		}
+		if got[s.ClientId] == nil {
+			got[s.ClientId] = map[time.Time]int64{}
+		}
+		got[s.ClientId][start] += s.ReceivedBytes
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("hourly bytes received %v, want %v", got, want)
+	}
+
+	// the last counters of an hour are kept, including those of the sample spanning the next hour
+	last, err := store.LoadTrafficSamples(model.TrafficFilter{ClientId: "a", From: hour(11), To: hour(11).Add(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 1 || last[0].ReceivedTotal != hour(11).Add(30*time.Second).Unix() {
+		t.Errorf("hour 10:00 of client a: %+v, want the totals of its last sample", last)
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"errors"
 	"strings"
 	"wg-gen-plus/model"
-	"wg-gen-plus/storage"
 
 	"github.com/gofrs/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrUserNameTaken another user has the name, ignoring case
+var ErrUserNameTaken = errors.New("a user with this name already exists")
+
 // CreateUser creates a new user
-func CreateUser(user *model.User) (*model.User, error) {
+func CreateUser(actor model.Actor, user *model.User) (*model.User, error) {
 	// Generate a unique ID if not provided
 	if user.Sub == "" {
 		u, err := uuid.NewV4()
@@ -30,7 +32,7 @@ func CreateUser(user *model.User) (*model.User, error) {
 	}
 
 	// Check if a user with this name already exists
-	existingUsers, err := storage.LoadAllUsers()
+	existingUsers, err := store.LoadAllUsers()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -40,12 +42,12 @@ func CreateUser(user *model.User) (*model.User, error) {
 
 	for _, existingUser := range existingUsers {
 		if strings.EqualFold(existingUser.Name, user.Name) && existingUser.Sub != user.Sub {
-			return nil, errors.New("a user with this name already exists")
+			return nil, ErrUserNameTaken
 		}
 	}
 
 	// Save to database
-	err = storage.SaveUser(user)
+	err = store.SaveUser(user)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -54,23 +56,28 @@ func CreateUser(user *model.User) (*model.User, error) {
 	}
 
 	// Reload from DB to ensure all fields are set
-	return storage.LoadUser(user.Sub)
+	created, err := store.LoadUser(user.Sub)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionCreate, "user", created.Sub, created.Name, nil, created)
+	return created, nil
 }
 
 // ReadUser retrieves a user by their ID
 func ReadUser(id string) (*model.User, error) {
-	return storage.LoadUser(id)
+	return store.LoadUser(id)
 }
 
 // ReadUsers retrieves all users
 func ReadUsers() ([]*model.User, error) {
-	return storage.LoadAllUsers()
+	return store.LoadAllUsers()
 }
 
 // UpdateUser updates an existing user
-func UpdateUser(id string, user *model.User) (*model.User, error) {
+func UpdateUser(actor model.Actor, id string, user *model.User) (*model.User, error) {
 	// Make sure the user exists
-	_, err := storage.LoadUser(id)
+	current, err := store.LoadUser(id)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +93,7 @@ func UpdateUser(id string, user *model.User) (*model.User, error) {
 	}
 
 	// Check if another user with this name already exists
-	existingUsers, err := storage.LoadAllUsers()
+	existingUsers, err := store.LoadAllUsers()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -100,8 +107,13 @@ func UpdateUser(id string, user *model.User) (*model.User, error) {
 		}
 	}
 
+	// Never demote the last admin, nobody could manage users anymore
+	if current.IsAdmin && !user.IsAdmin && countAdmins(existingUsers) <= 1 {
+		return nil, errors.New("cannot remove admin rights from the last admin user")
+	}
+
 	// Save changes
-	err = storage.SaveUser(user)
+	err = store.SaveUser(user)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -110,17 +122,50 @@ func UpdateUser(id string, user *model.User) (*model.User, error) {
 	}
 
 	// Reload from DB to ensure all fields are set
-	return storage.LoadUser(id)
+	updated, err := store.LoadUser(id)
+	if err != nil {
+		return nil, err
+	}
+	audit(store, actor, model.AuditActionUpdate, "user", updated.Sub, updated.Name, current, updated)
+	return updated, nil
 }
 
 // DeleteUser removes a user
-func DeleteUser(id string) error {
-	err := storage.DeleteUser(id)
+func DeleteUser(actor model.Actor, id string) error {
+	user, err := store.LoadUser(id)
+	if err != nil {
+		return err
+	}
+
+	// Never delete the last admin, nobody could manage users anymore
+	if user.IsAdmin {
+		users, err := store.LoadAllUsers()
+		if err != nil {
+			return err
+		}
+		if countAdmins(users) <= 1 {
+			return errors.New("cannot delete the last admin user")
+		}
+	}
+
+	err = store.DeleteUser(id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
 		}).Error("failed to delete user")
 		return err
 	}
+	audit(store, actor, model.AuditActionDelete, "user", user.Sub, user.Name, user, nil)
 	return nil
 }
+
+// countAdmins number of admin users in the list
+func countAdmins(users []*model.User) int {
+	count := 0
+	for _, user := range users {
+		if user.IsAdmin {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"wg-gen-plus/model"
+	"wg-gen-plus/util"
+)
+
+// apiError implements a top-level JSON-RPC error.
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+
+	Data interface{} `json:"data,omitempty"`
+}
+
+type apiRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type apiResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *apiError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// apiDevice result of GetDeviceInfo
+type apiDevice struct {
+	Device *apiDeviceInfo `json:"device"`
+}
+
+type apiDeviceInfo struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PublicKey  string `json:"public_key"`
+	ListenPort int    `json:"listen_port"`
+	NumPeers   int    `json:"num_peers"`
+}
+
+// apiPeers result of ListPeers
+type apiPeers struct {
+	Peers []apiPeer `json:"peers"`
+}
+
+type apiPeer struct {
+	PublicKey       string    `json:"public_key"`
+	HasPresharedKey bool      `json:"has_preshared_key"`
+	ProtocolVersion int       `json:"protocol_version"`
+	Endpoint        string    `json:"endpoint"`
+	LastHandshake   time.Time `json:"last_handshake"`
+	ReceiveBytes    int       `json:"receive_bytes"`
+	TransmitBytes   int       `json:"transmit_bytes"`
+	AllowedIPs      []string  `json:"allowed_ips"`
+}
+
+// wgAPIStatus status source reading the https://github.com/jamescun/wg-api instance set with WG_STATS_API
+type wgAPIStatus struct{}
+
+// Interface status of interface iface from GetDeviceInfo
+func (wgAPIStatus) Interface(iface string) (*model.InterfaceStatus, error) {
+	result := apiDevice{}
+	err := callWireGuardAPI(iface, "GetDeviceInfo", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Device == nil {
+		return nil, errors.New("wg-api GetDeviceInfo result has no device")
+	}
+
+	return &model.InterfaceStatus{
+		Name:          result.Device.Name,
+		DeviceType:    result.Device.Type,
+		ListenPort:    result.Device.ListenPort,
+		NumberOfPeers: result.Device.NumPeers,
+		PublicKey:     result.Device.PublicKey,
+	}, nil
+}
+
+// Peers peers of interface iface from ListPeers
+func (wgAPIStatus) Peers(iface string) ([]*model.ClientStatus, error) {
+	result := apiPeers{}
+	err := callWireGuardAPI(iface, "ListPeers", []byte("{}"), &result)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]*model.ClientStatus, 0, len(result.Peers))
+	for _, peer := range result.Peers {
+		peers = append(peers, &model.ClientStatus{
+			PublicKey:        peer.PublicKey,
+			HasPresharedKey:  peer.HasPresharedKey,
+			ProtocolVersion:  peer.ProtocolVersion,
+			AllowedIPs:       peer.AllowedIPs,
+			Endpoint:         peer.Endpoint,
+			LastHandshake:    peer.LastHandshake,
+			ReceivedBytes:    peer.ReceiveBytes,
+			TransmittedBytes: peer.TransmitBytes,
+		})
+	}
+	return peers, nil
+}
+
+// callWireGuardAPI call method on the wg-api instance of interface iface and decode its result into result
+func callWireGuardAPI(iface, method string, params json.RawMessage, result interface{}) error {
+	data, err := fetchWireGuardAPI(iface, apiRequest{
+		Version: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	if data.Error != nil {
+		return fmt.Errorf("wg-api %s failed: %d %s", method, data.Error.Code, data.Error.Message)
+	}
+	if len(data.Result) == 0 {
+		return fmt.Errorf("wg-api %s returned no result", method)
+	}
+	err = json.Unmarshal(data.Result, result)
+	if err != nil {
+		return fmt.Errorf("wg-api %s returned an unexpected result: %w", method, err)
+	}
+	return nil
+}
+
+// fetchWireGuardAPI call the wg-api instance of interface iface, WG_STATS_API_<INTERFACE> overrides WG_STATS_API
+func fetchWireGuardAPI(iface string, reqData apiRequest) (*apiResponse, error) {
+	apiUrl := util.InterfaceEnv("WG_STATS_API", iface)
+	if apiUrl == "" {
+		return nil, errors.New("Status API integration not configured")
+	}
+
+	apiClient := http.Client{
+		Timeout: time.Second * 2, // Timeout after 2 seconds
+	}
+	jsonData, _ := json.Marshal(reqData)
+	req, err := http.NewRequest(http.MethodPost, apiUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "wg-gen-plus")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	if token := util.InterfaceEnv("WG_STATS_API_TOKEN", iface); token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
+	} else if user := util.InterfaceEnv("WG_STATS_API_USER", iface); user != "" {
+		req.SetBasicAuth(user, util.InterfaceEnv("WG_STATS_API_PASS", iface))
+	}
+
+	res, getErr := apiClient.Do(req)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, readErr := io.ReadAll(res.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	response := apiResponse{}
+	jsonErr := json.Unmarshal(body, &response)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,383 @@
+// Package ipam allocates host addresses from pools of addresses, keeping the taken addresses as sorted
+// ranges so allocation stays fast on large networks with many clients.
+package ipam
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// allocation strategies
+const (
+	// StrategySequential lowest free address of the pool
+	StrategySequential = "sequential"
+	// StrategyRandom free address at a random position of the pool, harder to guess on large networks
+	StrategyRandom = "random"
+)
+
+// ErrPoolExhausted the pool has no free address left
+var ErrPoolExhausted = errors.New("no more available address in pool")
+
+// Range addresses from First to Last, both included and of the same family
+type Range struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// ParseRange range written as first-last, a CIDR or a single address
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if first, last, ok := strings.Cut(s, "-"); ok {
+		r := Range{}
+		var err error
+		if r.First, err = netip.ParseAddr(strings.TrimSpace(first)); err != nil {
+			return Range{}, err
+		}
+		if r.Last, err = netip.ParseAddr(strings.TrimSpace(last)); err != nil {
+			return Range{}, err
+		}
+		r.First, r.Last = r.First.Unmap(), r.Last.Unmap()
+		if r.First.Is4() != r.Last.Is4() || r.Last.Less(r.First) {
+			return Range{}, fmt.Errorf("range %s is invalid", s)
+		}
+		return r, nil
+	}
+	if strings.Contains(s, "/") {
+		prefix, err := ParsePrefix(s)
+		if err != nil {
+			return Range{}, err
+		}
+		return PrefixRange(prefix), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return Range{}, err
+	}
+	addr = addr.Unmap()
+	return Range{addr, addr}, nil
+}
+
+// ParsePrefix network of a CIDR, host bits are dropped so 10.6.6.1/24 is 10.6.6.0/24
+func ParsePrefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// PrefixRange every address of prefix
+func PrefixRange(prefix netip.Prefix) Range {
+	prefix = prefix.Masked()
+	first := prefix.Addr()
+	last := first.As16()
+	hostBits := first.BitLen() - prefix.Bits()
+	for i := 15; hostBits > 0; i-- {
+		n := hostBits
+		if n > 8 {
+			n = 8
+		}
+		last[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+	lastAddr := netip.AddrFrom16(last)
+	if first.Is4() {
+		lastAddr = lastAddr.Unmap()
+	}
+	return Range{first, lastAddr}
+}
+
+// Contains check if addr is in the range
+func (r Range) Contains(addr netip.Addr) bool {
+	return !addr.Less(r.First) && !r.Last.Less(addr)
+}
+
+// Size number of addresses of the range
+func (r Range) Size() *big.Int {
+	size := new(big.Int).Sub(addrInt(r.Last), addrInt(r.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+// String range as first-last
+func (r Range) String() string {
+	if r.First == r.Last {
+		return r.First.String()
+	}
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// intersect common addresses of r and other, false when there are none
+func (r Range) intersect(other Range) (Range, bool) {
+	first, last := r.First, r.Last
+	if first.Less(other.First) {
+		first = other.First
+	}
+	if other.Last.Less(last) {
+		last = other.Last
+	}
+	if last.Less(first) {
+		return Range{}, false
+	}
+	return Range{first, last}, true
+}
+
+func addrInt(addr netip.Addr) *big.Int {
+	b := addr.As16()
+	return new(big.Int).SetBytes(b[:])
+}
+
+// Set addresses kept as sorted, disjoint and non adjacent ranges
+type Set struct {
+	ranges []Range
+}
+
+// NewSet set of the addresses of ranges
+func NewSet(ranges []Range) *Set {
+	sorted := append([]Range{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].First.Less(sorted[j].First)
+	})
+	s := &Set{ranges: make([]Range, 0, len(sorted))}
+	for _, r := range sorted {
+		n := len(s.ranges)
+		if n > 0 && !before(s.ranges[n-1], r) {
+			if s.ranges[n-1].Last.Less(r.Last) {
+				s.ranges[n-1].Last = r.Last
+			}
+			continue
+		}
+		s.ranges = append(s.ranges, r)
+	}
+	return s
+}
+
+// before check if a ends before b starts with a gap in between, so they can not be merged
+func before(a, b Range) bool {
+	if a.First.Is4() != b.First.Is4() {
+		return a.First.Less(b.First)
+	}
+	next := a.Last.Next()
+	return next.IsValid() && next.Less(b.First)
+}
+
+// Add the addresses of r
+func (s *Set) Add(r Range) {
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return !before(s.ranges[i], r)
+	})
+	j := i
+	for ; j < len(s.ranges) && !before(r, s.ranges[j]); j++ {
+		if s.ranges[j].First.Less(r.First) {
+			r.First = s.ranges[j].First
+		}
+		if r.Last.Less(s.ranges[j].Last) {
+			r.Last = s.ranges[j].Last
+		}
+	}
+	if i == j {
+		// nothing to merge with, insert in place
+		s.ranges = append(s.ranges, Range{})
+		copy(s.ranges[i+1:], s.ranges[i:])
+		s.ranges[i] = r
+		return
+	}
+	s.ranges[i] = r
+	s.ranges = append(s.ranges[:i+1], s.ranges[j:]...)
+}
+
+// Contains check if addr is in the set
+func (s *Set) Contains(addr netip.Addr) bool {
+	i := s.search(addr)
+	return i < len(s.ranges) && s.ranges[i].Contains(addr)
+}
+
+// search index of the first range ending at or after addr
+func (s *Set) search(addr netip.Addr) int {
+	return sort.Search(len(s.ranges), func(i int) bool {
+		return !s.ranges[i].Last.Less(addr)
+	})
+}
+
+// nextFree lowest address of r from addr on which is not in the set, false when there is none
+func (s *Set) nextFree(addr netip.Addr, r Range) (netip.Addr, bool) {
+	for i := s.search(addr); i < len(s.ranges) && !addr.Less(s.ranges[i].First); i++ {
+		addr = s.ranges[i].Last.Next()
+		if !addr.IsValid() {
+			return netip.Addr{}, false
+		}
+	}
+	return addr, r.Contains(addr)
+}
+
+// Count number of addresses of the set within r
+func (s *Set) Count(r Range) *big.Int {
+	count := new(big.Int)
+	for i := s.search(r.First); i < len(s.ranges) && !r.Last.Less(s.ranges[i].First); i++ {
+		if common, ok := s.ranges[i].intersect(r); ok {
+			count.Add(count, common.Size())
+		}
+	}
+	return count
+}
+
+// Ranges the ranges of the set in order
+func (s *Set) Ranges() []Range {
+	return append([]Range{}, s.ranges...)
+}
+
+// Pool addresses to allocate from, the first and last address of a network are never allocated
+type Pool struct {
+	Name     string
+	Prefix   netip.Prefix
+	Strategy string
+	usable   Range
+}
+
+// NewPool pool of the usable addresses of prefix
+func NewPool(name string, prefix netip.Prefix, strategy string) (*Pool, error) {
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("pool %s network is invalid", name)
+	}
+	switch strategy {
+	case "":
+		strategy = StrategySequential
+	case StrategySequential, StrategyRandom:
+	default:
+		return nil, fmt.Errorf("pool %s strategy %s is invalid", name, strategy)
+	}
+	prefix = prefix.Masked()
+	return &Pool{Name: name, Prefix: prefix, Strategy: strategy, usable: UsableRange(prefix)}, nil
+}
+
+// UsableRange addresses of prefix which may be given to a host, all of them but the first and the last: the network
+// and broadcast addresses, or the subnet router anycast address for IPv6. Networks with less than 2 host bits,
+// like point to point ones, have no such addresses.
+func UsableRange(prefix netip.Prefix) Range {
+	prefix = prefix.Masked()
+	usable := PrefixRange(prefix)
+	if prefix.Addr().BitLen()-prefix.Bits() >= 2 {
+		usable = Range{usable.First.Next(), usable.Last.Prev()}
+	}
+	return usable
+}
+
+// Usable the addresses of the pool which may be allocated
+func (p *Pool) Usable() Range {
+	return p.usable
+}
+
+// Allocator allocates the free addresses of its pools, an address is free unless it is used or reserved
+type Allocator struct {
+	pools    []*Pool
+	used     *Set
+	reserved *Set
+	taken    *Set
+}
+
+// NewAllocator allocator of pools with the used addresses, like those of clients, and the reserved ones,
+// like reservations and excluded ranges
+func NewAllocator(pools []*Pool, used, reserved []Range) *Allocator {
+	return &Allocator{
+		pools:    pools,
+		used:     NewSet(used),
+		reserved: NewSet(reserved),
+		taken:    NewSet(append(append([]Range{}, used...), reserved...)),
+	}
+}
+
+// Pools the pools of the allocator
+func (a *Allocator) Pools() []*Pool {
+	return a.pools
+}
+
+// Pool pool by name, nil when there is none
+func (a *Allocator) Pool(name string) *Pool {
+	for _, pool := range a.pools {
+		if strings.EqualFold(pool.Name, name) {
+			return pool
+		}
+	}
+	return nil
+}
+
+// IsFree check if addr is neither used nor reserved
+func (a *Allocator) IsFree(addr netip.Addr) bool {
+	return !a.taken.Contains(addr)
+}
+
+// IsReserved check if addr is reserved
+func (a *Allocator) IsReserved(addr netip.Addr) bool {
+	return a.reserved.Contains(addr)
+}
+
+// Use mark addr as used, eg for a static address
+func (a *Allocator) Use(addr netip.Addr) {
+	a.used.Add(Range{addr, addr})
+	a.taken.Add(Range{addr, addr})
+}
+
+// Allocate a free address of pool with its strategy and mark it as used
+func (a *Allocator) Allocate(pool *Pool) (netip.Addr, error) {
+	start := pool.usable.First
+	if pool.Strategy == StrategyRandom {
+		var err error
+		start, err = randomAddr(pool.usable)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	addr, ok := a.taken.nextFree(start, pool.usable)
+	if !ok && start != pool.usable.First {
+		// wrap around to the start of the pool
+		addr, ok = a.taken.nextFree(pool.usable.First, pool.usable)
+	}
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("%w %s", ErrPoolExhausted, pool.Name)
+	}
+	a.Use(addr)
+	return addr, nil
+}
+
+// randomAddr address of r at a random position
+func randomAddr(r Range) (netip.Addr, error) {
+	size := r.Size()
+	offset, err := rand.Int(rand.Reader, size)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	b := offset.Add(offset, addrInt(r.First)).FillBytes(make([]byte, 16))
+	addr := netip.AddrFrom16([16]byte(b))
+	if r.First.Is4() {
+		addr = addr.Unmap()
+	}
+	return addr, nil
+}
+
+// PoolUsage number of addresses of pool which may be allocated, are used, and are reserved but not used
+type PoolUsage struct {
+	Size     *big.Int
+	Used     *big.Int
+	Reserved *big.Int
+	Free     *big.Int
+}
+
+// Usage of the addresses of pool
+func (a *Allocator) Usage(pool *Pool) PoolUsage {
+	size := pool.usable.Size()
+	used := a.used.Count(pool.usable)
+	taken := a.taken.Count(pool.usable)
+	return PoolUsage{
+		Size:     size,
+		Used:     used,
+		Reserved: new(big.Int).Sub(taken, used),
+		Free:     new(big.Int).Sub(size, taken),
+	}
+}
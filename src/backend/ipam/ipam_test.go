@@ -0,0 +1,248 @@
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func mustRange(t testing.TB, s string) Range {
+	t.Helper()
+	r, err := ParseRange(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func mustPool(t testing.TB, network string, strategy string) *Pool {
+	t.Helper()
+	prefix, err := ParsePrefix(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := NewPool(network, prefix, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func rangesString(ranges []Range) string {
+	return fmt.Sprint(ranges)
+}
+
+func TestSetAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		set  []string
+		add  string
+		want string
+	}{
+		{"empty", nil, "10.0.0.5", "[10.0.0.5]"},
+		{"before", []string{"10.0.0.10"}, "10.0.0.5", "[10.0.0.5 10.0.0.10]"},
+		{"after", []string{"10.0.0.5"}, "10.0.0.10", "[10.0.0.5 10.0.0.10]"},
+		{"adjacent below", []string{"10.0.0.5-10.0.0.9"}, "10.0.0.4", "[10.0.0.4-10.0.0.9]"},
+		{"adjacent above", []string{"10.0.0.5-10.0.0.9"}, "10.0.0.10", "[10.0.0.5-10.0.0.10]"},
+		{"filling a gap", []string{"10.0.0.1-10.0.0.4", "10.0.0.6-10.0.0.9"}, "10.0.0.5", "[10.0.0.1-10.0.0.9]"},
+		{"within", []string{"10.0.0.1-10.0.0.9"}, "10.0.0.5", "[10.0.0.1-10.0.0.9]"},
+		{"spanning several", []string{"10.0.0.2", "10.0.0.4", "10.0.0.8", "10.0.0.20"}, "10.0.0.3-10.0.0.9", "[10.0.0.2-10.0.0.9 10.0.0.20]"},
+		{"overlapping", []string{"10.0.0.5-10.0.0.9"}, "10.0.0.1-10.0.0.6", "[10.0.0.1-10.0.0.9]"},
+		{"end of the address space", []string{"255.255.255.254"}, "255.255.255.255", "[255.255.255.254-255.255.255.255]"},
+		{"families apart", []string{"255.255.255.255"}, "::", "[255.255.255.255 ::]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges := make([]Range, 0, len(test.set))
+			for _, s := range test.set {
+				ranges = append(ranges, mustRange(t, s))
+			}
+			set := NewSet(ranges)
+			set.Add(mustRange(t, test.add))
+			if got := rangesString(set.Ranges()); got != test.want {
+				t.Errorf("ranges %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewSetMerges(t *testing.T) {
+	set := NewSet([]Range{
+		mustRange(t, "10.0.0.8-10.0.0.9"),
+		mustRange(t, "10.0.0.1"),
+		mustRange(t, "10.0.0.2-10.0.0.3"),
+		mustRange(t, "10.0.0.9-10.0.0.12"),
+	})
+	if got, want := rangesString(set.Ranges()), "[10.0.0.1-10.0.0.3 10.0.0.8-10.0.0.12]"; got != want {
+		t.Errorf("ranges %s, want %s", got, want)
+	}
+}
+
+func TestSetNextFree(t *testing.T) {
+	set := NewSet([]Range{mustRange(t, "10.0.0.1-10.0.0.3"), mustRange(t, "10.0.0.5")})
+	within := mustRange(t, "10.0.0.1-10.0.0.6")
+	tests := []struct {
+		from string
+		want string
+		ok   bool
+	}{
+		{"10.0.0.1", "10.0.0.4", true},
+		{"10.0.0.2", "10.0.0.4", true},
+		{"10.0.0.4", "10.0.0.4", true},
+		{"10.0.0.5", "10.0.0.6", true},
+		{"10.0.0.6", "10.0.0.6", true},
+		{"10.0.0.7", "10.0.0.7", false},
+	}
+	for _, test := range tests {
+		addr, ok := set.nextFree(netip.MustParseAddr(test.from), within)
+		if ok != test.ok || (ok && addr.String() != test.want) {
+			t.Errorf("next free from %s: %s %v, want %s %v", test.from, addr, ok, test.want, test.ok)
+		}
+	}
+
+	full := NewSet([]Range{mustRange(t, "10.0.0.1-10.0.0.6")})
+	if addr, ok := full.nextFree(netip.MustParseAddr("10.0.0.1"), within); ok {
+		t.Errorf("next free %s of a range fully taken", addr)
+	}
+	end := NewSet([]Range{mustRange(t, "255.255.255.250-255.255.255.255")})
+	if addr, ok := end.nextFree(netip.MustParseAddr("255.255.255.250"), mustRange(t, "255.255.255.0/24")); ok {
+		t.Errorf("next free %s past the end of the address space", addr)
+	}
+}
+
+func TestSetCount(t *testing.T) {
+	set := NewSet([]Range{
+		mustRange(t, "10.0.0.1-10.0.0.10"),
+		mustRange(t, "10.0.0.20-10.0.0.29"),
+		mustRange(t, "10.0.1.0/24"),
+	})
+	tests := []struct {
+		within string
+		want   int64
+	}{
+		{"10.0.0.0/16", 276},
+		{"10.0.0.5-10.0.0.25", 12},
+		{"10.0.0.11-10.0.0.19", 0},
+		{"10.0.1.128/25", 128},
+		{"10.0.2.0/24", 0},
+	}
+	for _, test := range tests {
+		if got := set.Count(mustRange(t, test.within)); got.Int64() != test.want {
+			t.Errorf("count within %s: %s, want %d", test.within, got, test.want)
+		}
+	}
+	huge := NewSet([]Range{mustRange(t, "fd00::/64")})
+	if got, want := huge.Count(mustRange(t, "fd00::/48")).String(), "18446744073709551616"; got != want {
+		t.Errorf("count of a /64: %s, want %s", got, want)
+	}
+}
+
+func TestNewPoolUsable(t *testing.T) {
+	tests := []struct {
+		network string
+		want    string
+	}{
+		{"10.6.6.0/24", "10.6.6.1-10.6.6.254"},
+		{"10.6.6.77/24", "10.6.6.1-10.6.6.254"},
+		{"10.6.6.0/30", "10.6.6.1-10.6.6.2"},
+		// point to point networks have no network nor broadcast address
+		{"10.6.6.0/31", "10.6.6.0-10.6.6.1"},
+		{"10.6.6.1/32", "10.6.6.1"},
+		{"fd00::/64", "fd00::1-fd00::ffff:ffff:ffff:fffe"},
+		{"fd00::/127", "fd00::-fd00::1"},
+	}
+	for _, test := range tests {
+		pool := mustPool(t, test.network, "")
+		if got := pool.Usable().String(); got != test.want {
+			t.Errorf("usable addresses of %s: %s, want %s", test.network, got, test.want)
+		}
+		if pool.Strategy != StrategySequential {
+			t.Errorf("default strategy %s, want %s", pool.Strategy, StrategySequential)
+		}
+	}
+	if _, err := NewPool("bad", netip.MustParsePrefix("10.6.6.0/24"), "lowest"); err == nil {
+		t.Error("pool with an unknown strategy created")
+	}
+}
+
+func TestAllocateSequential(t *testing.T) {
+	pool := mustPool(t, "10.6.6.0/29", StrategySequential)
+	a := NewAllocator([]*Pool{pool}, []Range{mustRange(t, "10.6.6.1"), mustRange(t, "10.6.6.3")}, []Range{mustRange(t, "10.6.6.4")})
+	for _, want := range []string{"10.6.6.2", "10.6.6.5", "10.6.6.6"} {
+		addr, err := a.Allocate(pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != want {
+			t.Errorf("allocated %s, want %s", addr, want)
+		}
+	}
+	if addr, err := a.Allocate(pool); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("allocated %s %v from an exhausted pool, want ErrPoolExhausted", addr, err)
+	}
+	usage := a.Usage(pool)
+	if usage.Size.Int64() != 6 || usage.Used.Int64() != 5 || usage.Reserved.Int64() != 1 || usage.Free.Int64() != 0 {
+		t.Errorf("usage %+v, want size 6, used 5, reserved 1 and none free", usage)
+	}
+}
+
+func TestAllocateRandomWrapsAround(t *testing.T) {
+	pool := mustPool(t, "10.6.6.0/24", StrategyRandom)
+	// only the first usable address is free, a random start past it wraps around to the start of the pool
+	for i := 0; i < 50; i++ {
+		a := NewAllocator([]*Pool{pool}, []Range{mustRange(t, "10.6.6.2-10.6.6.254")}, nil)
+		addr, err := a.Allocate(pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != "10.6.6.1" {
+			t.Fatalf("allocated %s, want 10.6.6.1", addr)
+		}
+		if a.IsFree(addr) {
+			t.Fatalf("allocated %s still free", addr)
+		}
+		if _, err = a.Allocate(pool); !errors.Is(err, ErrPoolExhausted) {
+			t.Fatalf("allocation from an exhausted pool: %v, want ErrPoolExhausted", err)
+		}
+	}
+}
+
+func TestAllocateRandomWithinPool(t *testing.T) {
+	pool := mustPool(t, "fd00::/120", StrategyRandom)
+	a := NewAllocator([]*Pool{pool}, nil, nil)
+	seen := make(map[netip.Addr]bool)
+	for i := 0; i < 254; i++ {
+		addr, err := a.Allocate(pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pool.Usable().Contains(addr) || seen[addr] {
+			t.Fatalf("allocated %s, outside the pool or twice", addr)
+		}
+		seen[addr] = true
+	}
+	if _, err := a.Allocate(pool); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("allocation from an exhausted pool: %v, want ErrPoolExhausted", err)
+	}
+}
+
+// BenchmarkAllocate allocating the addresses of 10k clients
+func BenchmarkAllocate(b *testing.B) {
+	const clients = 10000
+	for _, network := range []string{"10.0.0.0/16", "fd00::/64"} {
+		for _, strategy := range []string{StrategySequential, StrategyRandom} {
+			b.Run(network+"/"+strategy, func(b *testing.B) {
+				pool := mustPool(b, network, strategy)
+				for i := 0; i < b.N; i++ {
+					a := NewAllocator([]*Pool{pool}, nil, nil)
+					for c := 0; c < clients; c++ {
+						if _, err := a.Allocate(pool); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Label name and value of a metric label
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels labels from name and value pairs
+func Labels(pairs ...string) []Label {
+	labels := make([]Label, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, Label{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return labels
+}
+
+// Sample one value of a metric
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// counter values of a counter per label values
+type counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+var (
+	configGenerations = newCounter("wg_gen_plus_config_generations_total",
+		"WireGuard config file generations", "interface")
+	configGenerationSeconds = newCounter("wg_gen_plus_config_generation_seconds_total",
+		"Time spent generating, writing and applying WireGuard config files", "interface")
+	reloadFailures = newCounter("wg_gen_plus_reload_failures_total",
+		"Failed reloads of the WireGuard config, with the reload command or live apply", "interface")
+	logins = newCounter("wg_gen_plus_logins_total",
+		"Login attempts", "method", "result")
+
+	counters = []*counter{configGenerations, configGenerationSeconds, reloadFailures, logins}
+)
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// add v to the value of the label values, given in the order of the counter labels
+func (c *counter) add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\x00")] += v
+}
+
+func (c *counter) samples() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	samples := make([]Sample, 0, len(c.values))
+	for key, value := range c.values {
+		labels := make([]Label, len(c.labels))
+		for i, labelValue := range strings.Split(key, "\x00") {
+			labels[i] = Label{Name: c.labels[i], Value: labelValue}
+		}
+		samples = append(samples, Sample{Labels: labels, Value: value})
+	}
+	return samples
+}
+
+// ConfigGenerated count a generation of the config of interface iface which took d
+func ConfigGenerated(iface string, d time.Duration) {
+	configGenerations.add(1, iface)
+	configGenerationSeconds.add(d.Seconds(), iface)
+}
+
+// ReloadFailed count a failed reload of interface iface
+func ReloadFailed(iface string) {
+	reloadFailures.add(1, iface)
+}
+
+// Login count a login attempt with method, local or oauth2
+func Login(method string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	logins.add(1, method, result)
+}
+
+// WriteCounters write the application counters in the Prometheus text format
+func WriteCounters(w io.Writer) {
+	for _, c := range counters {
+		Write(w, c.name, c.help, "counter", c.samples())
+	}
+}
+
+// Write metric name of type typ, gauge or counter, in the Prometheus text format, samples are sorted by labels
+func Write(w io.Writer, name, help, typ string, samples []Sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	lines := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		lines = append(lines, name+formatLabels(sample.Labels)+" "+strconv.FormatFloat(sample.Value, 'g', -1, 64))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, label.Name+`="`+escapeLabelValue(label.Value)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
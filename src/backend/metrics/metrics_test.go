@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	var out bytes.Buffer
+	Write(&out, "test_bytes", "Bytes of the test", "counter", []Sample{
+		{Labels: Labels("name", "b"), Value: 1.5},
+		{Labels: Labels("name", `quoted "a" \ with`+"\nnewline"), Value: 2e9},
+	})
+	want := "# HELP test_bytes Bytes of the test\n" +
+		"# TYPE test_bytes counter\n" +
+		`test_bytes{name="b"} 1.5` + "\n" +
+		`test_bytes{name="quoted \"a\" \\ with\nnewline"} 2e+09` + "\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	Write(&out, "test_up", "Up", "gauge", []Sample{{Value: 1}})
+	if !strings.HasSuffix(out.String(), "\ntest_up 1\n") {
+		t.Errorf("metric without labels:\n%s", out.String())
+	}
+}
+
+func TestWriteCounters(t *testing.T) {
+	for _, c := range counters {
+		c.mu.Lock()
+		c.values = make(map[string]float64)
+		c.mu.Unlock()
+	}
+	ConfigGenerated("wgtest", 1500*time.Millisecond)
+	ConfigGenerated("wgtest", 500*time.Millisecond)
+	ReloadFailed("wgtest")
+	Login("local", false)
+
+	var out bytes.Buffer
+	WriteCounters(&out)
+	for _, line := range []string{
+		`wg_gen_plus_config_generations_total{interface="wgtest"} 2`,
+		`wg_gen_plus_config_generation_seconds_total{interface="wgtest"} 2`,
+		`wg_gen_plus_reload_failures_total{interface="wgtest"} 1`,
+		`wg_gen_plus_logins_total{method="local",result="failure"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("counters do not hold %s:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+	"wg-gen-plus/util"
+)
+
+// alert rule types
+const (
+	// AlertRuleStaleHandshake client without handshake for Minutes
+	AlertRuleStaleHandshake = "staleHandshake"
+	// AlertRuleTrafficExceeded client received and transmitted more than Bytes since midnight UTC
+	AlertRuleTrafficExceeded = "trafficExceeded"
+	// AlertRuleUnknownPeer peer on the interface without client
+	AlertRuleUnknownPeer = "unknownPeer"
+	// AlertRuleQuotaExceeded client transferred more than its quota in the current quota period
+	AlertRuleQuotaExceeded = "quotaExceeded"
+	// AlertRuleKeyOverdue client keys older than the max key age of its interface
+	AlertRuleKeyOverdue = "keyOverdue"
+)
+
+// alert states
+const (
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+// AlertRule condition checked periodically, an alert fires for every client or peer meeting it
+type AlertRule struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Interface string `json:"interface"`
+	Enable    bool   `json:"enable"`
+	// Site2SiteOnly only site to site clients
+	Site2SiteOnly bool `json:"site2siteOnly"`
+	// Tags only clients with one of the tags, every client when empty
+	Tags      []string  `json:"tags"`
+	Minutes   int       `json:"minutes"`
+	Bytes     int64     `json:"bytes"`
+	Emails    []string  `json:"emails"`
+	Webhooks  []string  `json:"webhooks"`
+	CreatedBy string    `json:"createdBy"`
+	UpdatedBy string    `json:"updatedBy"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// IsValid check if model is valid
+func (a AlertRule) IsValid() []error {
+	errs := make([]error, 0)
+
+	// check the name field is between 2 to 40 chars
+	if len(a.Name) < 2 || len(a.Name) > 40 {
+		errs = append(errs, fmt.Errorf("name field must be between 2-40 chars"))
+	}
+	switch a.Type {
+	case AlertRuleStaleHandshake:
+		if a.Minutes <= 0 {
+			errs = append(errs, fmt.Errorf("minutes must be positive"))
+		}
+	case AlertRuleTrafficExceeded:
+		if a.Bytes <= 0 {
+			errs = append(errs, fmt.Errorf("bytes must be positive"))
+		}
+	case AlertRuleUnknownPeer, AlertRuleQuotaExceeded, AlertRuleKeyOverdue:
+	default:
+		errs = append(errs, fmt.Errorf("type %s is invalid", a.Type))
+	}
+	for _, email := range a.Emails {
+		if !util.RegexpEmail.MatchString(email) {
+			errs = append(errs, fmt.Errorf("email %s is invalid", email))
+		}
+	}
+	for _, webhook := range a.Webhooks {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhook %s is invalid", webhook))
+		}
+	}
+
+	return errs
+}
+
+// Alert firing or resolved alert of a rule for one client, or one peer for unknown peers
+type Alert struct {
+	Id        string `json:"id"`
+	RuleId    string `json:"ruleId"`
+	RuleName  string `json:"ruleName"`
+	Interface string `json:"interface"`
+	// Subject client id, or public key of an unknown peer
+	Subject        string    `json:"subject"`
+	SubjectName    string    `json:"subjectName"`
+	Message        string    `json:"message"`
+	State          string    `json:"state"`
+	Fired          time.Time `json:"fired"`
+	Resolved       time.Time `json:"resolved"`
+	Acknowledged   bool      `json:"acknowledged"`
+	AcknowledgedBy string    `json:"acknowledgedBy"`
+	AcknowledgedAt time.Time `json:"acknowledgedAt"`
+}
+
+// AlertFilter selection of alerts, zero values match everything
+type AlertFilter struct {
+	RuleId    string
+	State     string
+	Interface string
+	Offset    int
+	Limit     int
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	AuditActionRotate = "rotate"
+)
+
+// Actor who performs a change and from where, recorded in the audit log
+type Actor struct {
+	Sub      string `json:"sub"`
+	Name     string `json:"name"`
+	SourceIP string `json:"sourceIP"`
+}
+
+// SystemActor changes made by wg-gen-plus itself, eg scheduled jobs
+var SystemActor = Actor{Name: "system"}
+
+// AuditEntry append only record of a configuration change
+type AuditEntry struct {
+	Id         string          `json:"id"`
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	SourceIP   string          `json:"sourceIP"`
+	Action     string          `json:"action"`
+	ObjectType string          `json:"objectType"`
+	ObjectId   string          `json:"objectId"`
+	ObjectName string          `json:"objectName"`
+	Diff       json.RawMessage `json:"diff"` // changed fields as {"field": {"old": ..., "new": ...}}, secrets redacted
+}
+
+// AuditFilter selects audit entries, empty fields match everything
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	ObjectType string
+	ObjectId   string
+	From       time.Time
+	To         time.Time
+	Offset     int
+	Limit      int // 0 for no limit
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BackupVersion version of the backup format written, restore reads this version and older ones
+const BackupVersion = 1
+
+// Restore modes
+const (
+	// RestoreModeMerge objects of the backup are created or overwrite those with the same id, the others are kept
+	RestoreModeMerge = "merge"
+	// RestoreModeReplace objects which are not in the backup are deleted
+	RestoreModeReplace = "replace"
+)
+
+// Backup servers, clients, users and the configuration of wg-gen-plus, keys are in plaintext and passwords hashed.
+// The audit log, traffic history and alerts are not part of a backup.
+type Backup struct {
+	Version        int              `json:"version"`
+	AppVersion     string           `json:"appVersion"`
+	Created        time.Time        `json:"created"`
+	Servers        []*Server        `json:"servers"`
+	Clients        []*Client        `json:"clients"`
+	Users          []*User          `json:"users"`
+	Profiles       []*Profile       `json:"profiles"`
+	IPPools        []*IPPool        `json:"ipPools"`
+	IPReservations []*IPReservation `json:"ipReservations"`
+	AlertRules     []*AlertRule     `json:"alertRules"`
+}
+
+// EncryptedBackup backup sealed with AES-256-GCM under a key derived from a passphrase with scrypt
+type EncryptedBackup struct {
+	Version    int    `json:"version"`
+	Encryption string `json:"encryption"`
+	ScryptN    int    `json:"scryptN"`
+	ScryptR    int    `json:"scryptR"`
+	ScryptP    int    `json:"scryptP"`
+	Salt       []byte `json:"salt"`
+	// Data nonce followed by the sealed backup JSON
+	Data []byte `json:"data"`
+}
+
+// BackupRequest passphrase to encrypt a backup with, no encryption when empty
+type BackupRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// RestoreRequest backup to restore, as written by a backup, encrypted or not
+type RestoreRequest struct {
+	Mode       string          `json:"mode"`
+	Passphrase string          `json:"passphrase"`
+	Backup     json.RawMessage `json:"backup" binding:"required"`
+}
+
+// RestoreResult number of objects restored and deleted, or why the backup is invalid
+type RestoreResult struct {
+	Mode           string   `json:"mode"`
+	Servers        int      `json:"servers"`
+	Clients        int      `json:"clients"`
+	Users          int      `json:"users"`
+	Profiles       int      `json:"profiles"`
+	IPPools        int      `json:"ipPools"`
+	IPReservations int      `json:"ipReservations"`
+	AlertRules     int      `json:"alertRules"`
+	Deleted        int      `json:"deleted"`
+	Errors         []string `json:"errors"`
+}
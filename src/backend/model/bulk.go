@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// bulk actions
+const (
+	BulkActionEnable    = "enable"
+	BulkActionDisable   = "disable"
+	BulkActionDelete    = "delete"
+	BulkActionAddTag    = "addTag"
+	BulkActionRemoveTag = "removeTag"
+	BulkActionEmail     = "email"
+	BulkActionRotate    = "rotate"
+)
+
+// ClientSelector clients matching every criterion set: one of Ids, one of Tags, the Name glob pattern
+// like laptop-* and CreatedBy, all but Ids ignore case
+type ClientSelector struct {
+	Ids       []string `json:"ids"`
+	Tags      []string `json:"tags"`
+	Name      string   `json:"name"`
+	CreatedBy string   `json:"createdBy"`
+}
+
+// IsEmpty check if the selector has no criterion, it would select every client
+func (s ClientSelector) IsEmpty() bool {
+	return len(s.Ids) == 0 && len(s.Tags) == 0 && s.Name == "" && s.CreatedBy == ""
+}
+
+// Matches check if client meets every criterion of the selector
+func (s ClientSelector) Matches(client *Client) bool {
+	if len(s.Ids) > 0 && !matchesAny(s.Ids, []string{client.Id}, false) {
+		return false
+	}
+	if len(s.Tags) > 0 && !matchesAny(s.Tags, client.Tags, true) {
+		return false
+	}
+	if s.Name != "" {
+		matched, err := path.Match(strings.ToLower(s.Name), strings.ToLower(client.Name))
+		if err != nil || !matched {
+			return false
+		}
+	}
+	if s.CreatedBy != "" && !strings.EqualFold(s.CreatedBy, client.CreatedBy) {
+		return false
+	}
+	return true
+}
+
+// matchesAny check if one of wanted is in values, ignoring case with fold
+func matchesAny(wanted, values []string, fold bool) bool {
+	for _, w := range wanted {
+		for _, v := range values {
+			if w == v || (fold && strings.EqualFold(w, v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BulkOperation action on the selected clients, Tag is the tag to add or remove, Email sends rotated
+// clients their new config
+type BulkOperation struct {
+	Selector ClientSelector `json:"selector"`
+	Action   string         `json:"action"`
+	Tag      string         `json:"tag"`
+	Email    bool           `json:"email"`
+}
+
+// IsValid check if model is valid
+func (a BulkOperation) IsValid() []error {
+	errs := make([]error, 0)
+
+	// an empty selector would select every client by mistake
+	if a.Selector.IsEmpty() {
+		errs = append(errs, fmt.Errorf("selector must have ids, tags, name or createdBy"))
+	}
+	if _, err := path.Match(a.Selector.Name, ""); err != nil {
+		errs = append(errs, fmt.Errorf("name pattern %s is invalid", a.Selector.Name))
+	}
+	switch a.Action {
+	case BulkActionEnable, BulkActionDisable, BulkActionDelete, BulkActionEmail, BulkActionRotate:
+	case BulkActionAddTag, BulkActionRemoveTag:
+		if strings.TrimSpace(a.Tag) == "" {
+			errs = append(errs, fmt.Errorf("tag is required for %s", a.Action))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("action %s is invalid", a.Action))
+	}
+
+	return errs
+}
+
+// BulkClientResult outcome for one selected client, Changed is false when there was nothing to do
+type BulkClientResult struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkResult outcome of a bulk operation for every selected client
+type BulkResult struct {
+	Action   string              `json:"action"`
+	Selected int                 `json:"selected"`
+	Changed  int                 `json:"changed"`
+	Failed   int                 `json:"failed"`
+	Clients  []*BulkClientResult `json:"clients"`
+}
@@ -2,13 +2,27 @@ package model
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 	"wg-gen-plus/util"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Expiry actions once a client expired
+const (
+	// ExpiryActionDisable disable the client, the default
+	ExpiryActionDisable = "disable"
+	// ExpiryActionDelete delete the client
+	ExpiryActionDelete = "delete"
 )
 
 // Client structure
 type Client struct {
 	Id                              string    `json:"id"`
+	Interface                       string    `json:"interface"`
 	Name                            string    `json:"name"`
 	Email                           string    `json:"email"`
 	Enable                          bool      `json:"enable"`
@@ -29,6 +43,18 @@ type Client struct {
 	Tags                            []string  `json:"tags"`
 	PrivateKey                      string    `json:"privateKey"`
 	PublicKey                       string    `json:"publicKey"`
+	QuotaBytes                      int64     `json:"quotaBytes"`
+	QuotaPeriod                     string    `json:"quotaPeriod"`
+	QuotaAction                     string    `json:"quotaAction"`
+	QuotaDisabled                   bool      `json:"quotaDisabled"`
+	QuotaResetAt                    time.Time `json:"quotaResetAt"`
+	NotBefore                       time.Time `json:"notBefore"`
+	ExpiresAt                       time.Time `json:"expiresAt"`
+	ExpiryAction                    string    `json:"expiryAction"`
+	ScheduleDisabled                bool      `json:"scheduleDisabled"`
+	ExpiryReminded                  bool      `json:"expiryReminded"`
+	KeysRotated                     time.Time `json:"keysRotated"`
+	Owner                           string    `json:"owner"` // Sub of the user the client belongs to
 	CreatedBy                       string    `json:"createdBy"`
 	UpdatedBy                       string    `json:"updatedBy"`
 	Created                         time.Time `json:"created"`
@@ -94,6 +120,16 @@ func (a Client) IsValid() []error {
 		}
 	}
 
+	// check if the keepaliveInterval is valid
+	if a.KeepaliveInterval < 0 || a.KeepaliveInterval > 65535 {
+		errs = append(errs, fmt.Errorf("KeepaliveInterval %d is invalid", a.KeepaliveInterval))
+	}
+
+	// check if the routing table is valid
+	if !util.IsValidTable(a.Table) {
+		errs = append(errs, fmt.Errorf("table %s is invalid", a.Table))
+	}
+
 	// check if the lanIPs are valid (required if Site2Site is true, optional otherwise)
 	for _, lanIP := range a.LANIPs {
 		if !util.IsValidCidr(lanIP) {
@@ -115,12 +151,88 @@ func (a Client) IsValid() []error {
 	if len(a.Address) == 0 {
 		errs = append(errs, fmt.Errorf("address field is required"))
 	}
-	// check if the address are valid
+	// an address is a network to allocate from, a static host address or the name of an address pool
 	for _, address := range a.Address {
-		if !util.IsValidCidr(address) {
+		if !util.IsValidCidr(address) && !util.IsValidIp(address) && (len(address) < 2 || len(address) > 40) {
 			errs = append(errs, fmt.Errorf("address %s is invalid", address))
 		}
 	}
+	// a client bringing its own key only submits its public key
+	if a.PublicKey != "" {
+		if _, err := wgtypes.ParseKey(a.PublicKey); err != nil {
+			errs = append(errs, fmt.Errorf("publicKey %s is invalid", a.PublicKey))
+		}
+	}
+
+	// a quota of 0 bytes means no quota
+	if a.QuotaBytes < 0 {
+		errs = append(errs, fmt.Errorf("quotaBytes %d is invalid", a.QuotaBytes))
+	}
+	if a.QuotaBytes > 0 {
+		if a.QuotaPeriod != QuotaPeriodDay && a.QuotaPeriod != QuotaPeriodWeek && a.QuotaPeriod != QuotaPeriodMonth {
+			errs = append(errs, fmt.Errorf("quotaPeriod %s is invalid, must be day, week or month", a.QuotaPeriod))
+		}
+		if a.QuotaAction != QuotaActionAlert && a.QuotaAction != QuotaActionDisable {
+			errs = append(errs, fmt.Errorf("quotaAction %s is invalid, must be alert or disable", a.QuotaAction))
+		}
+	}
+
+	// zero times mean no validity window
+	if !a.NotBefore.IsZero() && !a.ExpiresAt.IsZero() && !a.ExpiresAt.After(a.NotBefore) {
+		errs = append(errs, fmt.Errorf("expiresAt must be after notBefore"))
+	}
+	if a.ExpiryAction != "" && a.ExpiryAction != ExpiryActionDisable && a.ExpiryAction != ExpiryActionDelete {
+		errs = append(errs, fmt.Errorf("expiryAction %s is invalid, must be disable or delete", a.ExpiryAction))
+	}
 
 	return errs
 }
+
+// IsOwnedBy check if client belongs to user, either owned by it or issued to its email
+func (a Client) IsOwnedBy(user *User) bool {
+	if a.Owner != "" && a.Owner == user.Sub {
+		return true
+	}
+	return a.Email != "" && user.Email != "" && strings.EqualFold(a.Email, user.Email)
+}
+
+// HasOwnKey check if the client brought its own keypair, the server only knows its public key
+func (a Client) HasOwnKey() bool {
+	return a.PrivateKey == ""
+}
+
+// IsPending check if the client is not valid yet at t
+func (a Client) IsPending(t time.Time) bool {
+	return !a.NotBefore.IsZero() && t.Before(a.NotBefore)
+}
+
+// IsExpired check if the client expired at t
+func (a Client) IsExpired(t time.Time) bool {
+	return !a.ExpiresAt.IsZero() && !t.Before(a.ExpiresAt)
+}
+
+// HasSite2SiteEndpoint check if the server can initiate the tunnel toward this site
+func (a Client) HasSite2SiteEndpoint() bool {
+	return a.Site2Site && a.Site2SiteEndpointOptionsEnabled && a.Site2SiteEndpoint != "" && a.Site2SiteEndpointListenPort > 0
+}
+
+// Site2SiteEndpointAddress host:port the server connects to, the public port defaults to the listen port
+func (a Client) Site2SiteEndpointAddress() string {
+	port := a.Site2SiteEndpointPort
+	if port == 0 {
+		port = a.Site2SiteEndpointListenPort
+	}
+	return net.JoinHostPort(a.Site2SiteEndpoint, strconv.Itoa(port))
+}
+
+// PersistentKeepalive keepalive interval for this client, 0 when disabled.
+// The server wide value applies unless the client ignores it for its own interval.
+func (a Client) PersistentKeepalive(serverKeepalive int) int {
+	if a.KeepaliveDisabled {
+		return 0
+	}
+	if a.IgnorePersistentKeepalive {
+		return a.KeepaliveInterval
+	}
+	return serverKeepalive
+}
@@ -0,0 +1,12 @@
+package model
+
+// Guest client issued by an admin for a limited time
+type Guest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// Duration validity from now on, like 24h or 7d
+	Duration string `json:"duration"`
+	// Profile optional client profile for the allowed IPs and DNS, the server allowed IPs otherwise
+	Profile   string `json:"profile"`
+	SendEmail bool   `json:"sendEmail"`
+}
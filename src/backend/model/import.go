@@ -0,0 +1,28 @@
+package model
+
+// ImportRow client to import, AllowedIPs and Address default to those of the server.
+// Address holds networks to allocate from, or static host addresses like 10.6.6.20/32.
+type ImportRow struct {
+	Name       string   `json:"name"`
+	Email      string   `json:"email"`
+	Tags       []string `json:"tags"`
+	AllowedIPs []string `json:"allowedIPs"`
+	Address    []string `json:"address"`
+}
+
+// ImportRowResult outcome of one row, Row counts the rows from 1, Client is the client created or to create
+type ImportRowResult struct {
+	Row    int      `json:"row"`
+	Name   string   `json:"name"`
+	Errors []string `json:"errors"`
+	Client *Client  `json:"client,omitempty"`
+}
+
+// ImportResult outcome of an import, nothing is imported unless every row is valid
+type ImportResult struct {
+	DryRun   bool               `json:"dryRun"`
+	Total    int                `json:"total"`
+	Valid    int                `json:"valid"`
+	Imported int                `json:"imported"`
+	Rows     []*ImportRowResult `json:"rows"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"fmt"
+	"time"
+	"wg-gen-plus/util"
+)
+
+// Interface WireGuard interface managed by wg-gen-plus, each one has its own server config and clients
+type Interface struct {
+	Name    string    `json:"name"`
+	Default bool      `json:"default"`
+	Created time.Time `json:"created"`
+}
+
+// IsValid check if model is valid
+func (a Interface) IsValid() []error {
+	errs := make([]error, 0)
+
+	// check the name is a valid network interface name
+	if !util.IsValidInterfaceName(a.Name) {
+		errs = append(errs, fmt.Errorf("interface name %s is invalid", a.Name))
+	}
+
+	return errs
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+	"wg-gen-plus/ipam"
+)
+
+// IPPool named part of a server network clients get their addresses from, Excluded ranges are never allocated
+type IPPool struct {
+	Id        string    `json:"id"`
+	Interface string    `json:"interface"`
+	Name      string    `json:"name"`
+	Network   string    `json:"network"`
+	Strategy  string    `json:"strategy"`
+	Excluded  []string  `json:"excluded"`
+	CreatedBy string    `json:"createdBy"`
+	UpdatedBy string    `json:"updatedBy"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// IsValid check if model is valid
+func (a IPPool) IsValid() []error {
+	errs := make([]error, 0)
+
+	// check the name field is between 2 to 40 chars
+	if len(a.Name) < 2 || len(a.Name) > 40 {
+		errs = append(errs, fmt.Errorf("name field must be between 2-40 chars"))
+	}
+	if _, err := ipam.ParsePrefix(a.Network); err != nil {
+		errs = append(errs, fmt.Errorf("network %s is invalid", a.Network))
+	}
+	if a.Strategy != "" && a.Strategy != ipam.StrategySequential && a.Strategy != ipam.StrategyRandom {
+		errs = append(errs, fmt.Errorf("strategy %s is invalid, must be sequential or random", a.Strategy))
+	}
+	for _, excluded := range a.Excluded {
+		if _, err := ipam.ParseRange(excluded); err != nil {
+			errs = append(errs, fmt.Errorf("excluded range %s is invalid", excluded))
+		}
+	}
+
+	return errs
+}
+
+// IPReservation address or range of an interface which is never allocated
+type IPReservation struct {
+	Id          string    `json:"id"`
+	Interface   string    `json:"interface"`
+	Address     string    `json:"address"`
+	Description string    `json:"description"`
+	CreatedBy   string    `json:"createdBy"`
+	Created     time.Time `json:"created"`
+}
+
+// IsValid check if model is valid
+func (a IPReservation) IsValid() []error {
+	errs := make([]error, 0)
+
+	if _, err := ipam.ParseRange(a.Address); err != nil {
+		errs = append(errs, fmt.Errorf("address %s is invalid, must be an address, a CIDR or a first-last range", a.Address))
+	}
+	if len(a.Description) > 200 {
+		errs = append(errs, fmt.Errorf("description must be at most 200 chars"))
+	}
+
+	return errs
+}
+
+// IPPoolUsage addresses of a pool, Default is true for a server network without pools. Size counts the
+// addresses which may be allocated, Reserved those reserved or excluded but not used.
+type IPPoolUsage struct {
+	Name        string   `json:"name"`
+	Network     string   `json:"network"`
+	Family      string   `json:"family"`
+	Strategy    string   `json:"strategy"`
+	Default     bool     `json:"default"`
+	Size        *big.Int `json:"size"`
+	Used        *big.Int `json:"used"`
+	Reserved    *big.Int `json:"reserved"`
+	Free        *big.Int `json:"free"`
+	Utilisation float64  `json:"utilisation"`
+}
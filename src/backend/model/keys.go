@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// KeyRotationHeader header of the responses of key rotations, KeyRotationImmediate as there is no overlap period:
+// WireGuard knows a single key per peer, the old keys stop working as soon as the config is reloaded
+const (
+	KeyRotationHeader    = "X-Wg-Gen-Plus-Key-Rotation"
+	KeyRotationImmediate = "immediate"
+)
+
+// KeyRotation request to rotate keys, Tag selects the clients of a tag rotation
+type KeyRotation struct {
+	Tag string `json:"tag"`
+	// Email send the new config to the clients with an email
+	Email bool `json:"email"`
+}
+
+// KeyAge age of the keys of a client or server against the max key age of its interface
+type KeyAge struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Tags        []string  `json:"tags"`
+	Enable      bool      `json:"enable"`
+	KeysRotated time.Time `json:"keysRotated"`
+	AgeDays     int       `json:"ageDays"`
+	// Due keys reach the max key age within the grace period
+	Due bool `json:"due"`
+	// Overdue keys are older than the max key age
+	Overdue bool `json:"overdue"`
+}
+
+// KeyReport key ages of the server and clients of an interface, MaxAgeDays 0 when no max key age is set
+type KeyReport struct {
+	Interface  string    `json:"interface"`
+	MaxAgeDays int       `json:"maxAgeDays"`
+	GraceDays  int       `json:"graceDays"`
+	Server     *KeyAge   `json:"server"`
+	Clients    []*KeyAge `json:"clients"`
+	Overdue    int       `json:"overdue"`
+	Due        int       `json:"due"`
+}
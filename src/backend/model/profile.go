@@ -0,0 +1,42 @@
+package model
+
+import (
+	"fmt"
+	"time"
+	"wg-gen-plus/util"
+)
+
+// Profile admin defined set of client settings self service users create their clients from
+type Profile struct {
+	Id           string    `json:"id"`
+	Interface    string    `json:"interface"`
+	Name         string    `json:"name"`
+	AllowedIPs   []string  `json:"allowedIPs"`
+	UseRemoteDNS bool      `json:"useRemoteDNS"`
+	CreatedBy    string    `json:"createdBy"`
+	UpdatedBy    string    `json:"updatedBy"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+}
+
+// IsValid check if model is valid
+func (a Profile) IsValid() []error {
+	errs := make([]error, 0)
+
+	// check the name field is between 2 to 40 chars
+	if len(a.Name) < 2 || len(a.Name) > 40 {
+		errs = append(errs, fmt.Errorf("name field must be between 2-40 chars"))
+	}
+	// check if the allowedIPs empty
+	if len(a.AllowedIPs) == 0 {
+		errs = append(errs, fmt.Errorf("allowedIPs field is required"))
+	}
+	// check if the allowedIPs are valid
+	for _, allowedIP := range a.AllowedIPs {
+		if !util.IsValidCidr(allowedIP) {
+			errs = append(errs, fmt.Errorf("allowedIP %s is invalid", allowedIP))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+// Quota periods, they start at midnight UTC, weeks on Monday
+const (
+	QuotaPeriodDay   = "day"
+	QuotaPeriodWeek  = "week"
+	QuotaPeriodMonth = "month"
+)
+
+// Quota actions once a client transferred more than its quota
+const (
+	// QuotaActionAlert keep the client enabled, quotaExceeded alert rules report it
+	QuotaActionAlert = "alert"
+	// QuotaActionDisable disable the client until the next period or a reset
+	QuotaActionDisable = "disable"
+)
+
+// QuotaUsage traffic of a client counted against its quota in the current period, since the later of
+// the period start and the last reset
+type QuotaUsage struct {
+	ClientId         string    `json:"clientId"`
+	QuotaBytes       int64     `json:"quotaBytes"`
+	Period           string    `json:"period"`
+	Action           string    `json:"action"`
+	PeriodStart      time.Time `json:"periodStart"`
+	PeriodEnd        time.Time `json:"periodEnd"`
+	Since            time.Time `json:"since"`
+	ReceivedBytes    int64     `json:"receivedBytes"`
+	TransmittedBytes int64     `json:"transmittedBytes"`
+	UsedBytes        int64     `json:"usedBytes"`
+	Exceeded         bool      `json:"exceeded"`
+	Disabled         bool      `json:"disabled"`
+}
+
+// QuotaPeriodBounds start and end of the quota period containing t
+func QuotaPeriodBounds(period string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case QuotaPeriodWeek:
+		// Monday is 1, Sunday 0
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	case QuotaPeriodMonth:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
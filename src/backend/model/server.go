@@ -8,19 +8,21 @@ import (
 
 // Server structure
 type Server struct {
-	Address             []string `json:"address"`
-	ListenPort          int      `json:"listenPort"`
-	Mtu                 int      `json:"mtu"`
-	PrivateKey          string   `json:"privateKey"`
-	PublicKey           string   `json:"publicKey"`
-	Endpoint            string   `json:"endpoint"`
-	PersistentKeepalive int      `json:"persistentKeepalive"`
-	Dns                 []string `json:"dns"`
-	AllowedIPs          []string `json:"allowedips"`
-	//Table               string    `json:"table"` // Placeholder for future routing table setting
-	UpdatedBy string    `json:"updatedBy"`
-	Created   time.Time `json:"created"`
-	Updated   time.Time `json:"updated"`
+	Interface           string    `json:"interface"`
+	Address             []string  `json:"address"`
+	ListenPort          int       `json:"listenPort"`
+	Mtu                 int       `json:"mtu"`
+	PrivateKey          string    `json:"privateKey"`
+	PublicKey           string    `json:"publicKey"`
+	Endpoint            string    `json:"endpoint"`
+	PersistentKeepalive int       `json:"persistentKeepalive"`
+	Dns                 []string  `json:"dns"`
+	AllowedIPs          []string  `json:"allowedips"`
+	Table               string    `json:"table"`
+	KeysRotated         time.Time `json:"keysRotated"`
+	UpdatedBy           string    `json:"updatedBy"`
+	Created             time.Time `json:"created"`
+	Updated             time.Time `json:"updated"`
 }
 
 // IsValid check if model is valid
@@ -65,6 +67,10 @@ func (a Server) IsValid() []error {
 			errs = append(errs, fmt.Errorf("allowedIP %s is invalid", allowedIP))
 		}
 	}
+	// check if the routing table is valid
+	if !util.IsValidTable(a.Table) {
+		errs = append(errs, fmt.Errorf("table %s is invalid", a.Table))
+	}
 
 	return errs
 }
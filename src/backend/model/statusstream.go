@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+const (
+	// StatusEventSnapshot every peer of the interface, sent first to a new subscriber
+	StatusEventSnapshot = "snapshot"
+	// StatusEventDelta peers that changed or were removed since the previous event
+	StatusEventDelta = "delta"
+	// StatusEventError status of the interface could not be read
+	StatusEventError = "error"
+)
+
+// PeerDelta status of a peer and its traffic rates in bytes per second since the previous sample
+type PeerDelta struct {
+	Status       *ClientStatus `json:"status"`
+	ReceiveRate  float64       `json:"receiveRate"`
+	TransmitRate float64       `json:"transmitRate"`
+}
+
+// StatusEvent streamed status of an interface, Removed holds the public keys of peers no longer on it
+type StatusEvent struct {
+	Type      string       `json:"type"`
+	Interface string       `json:"interface"`
+	Time      time.Time    `json:"time"`
+	Peers     []*PeerDelta `json:"peers"`
+	Removed   []string     `json:"removed,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// TrafficSample traffic of a client during one sampling period ending at Time.
+// The byte counts are corrected for counter resets when the interface restarts, the totals are the raw interface counters.
+type TrafficSample struct {
+	ClientId         string    `json:"clientId"`
+	Time             time.Time `json:"time"`
+	Period           int       `json:"period"`
+	ReceivedBytes    int64     `json:"receivedBytes"`
+	TransmittedBytes int64     `json:"transmittedBytes"`
+	ReceivedTotal    int64     `json:"receivedTotal"`
+	TransmittedTotal int64     `json:"transmittedTotal"`
+	LastHandshake    time.Time `json:"lastHandshake"`
+}
+
+// TrafficFilter selection of traffic samples, zero values match everything
+type TrafficFilter struct {
+	ClientId string
+	From     time.Time
+	To       time.Time
+	// MinPeriod only samples of longer or equal periods, in seconds
+	MinPeriod int
+	// MaxPeriod only samples of shorter or equal periods, in seconds
+	MaxPeriod int
+}
+
+// TrafficPoint traffic of a client during one step of its history, starting at Time
+type TrafficPoint struct {
+	Time             time.Time `json:"time"`
+	ReceivedBytes    int64     `json:"receivedBytes"`
+	TransmittedBytes int64     `json:"transmittedBytes"`
+	LastHandshake    time.Time `json:"lastHandshake"`
+}
@@ -1,15 +1,37 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // User structure
 type User struct {
-	Sub      string    `json:"sub"`
-	Name     string    `json:"name"`
-	Email    string    `json:"email"`
-	Password string    `json:"password,omitempty"` // omitempty prevents sending password in JSON responses
-	IsAdmin  bool      `json:"isAdmin"`
-	Profile  string    `json:"profile,omitempty"` // Keep but mark as omitempty
-	Issuer   string    `json:"-"`                 // Hide completely from JSON
-	IssuedAt time.Time `json:"-"`                 // Hide completely from JSON
+	Sub         string    `json:"sub"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Password    string    `json:"password,omitempty"` // omitempty prevents sending password in JSON responses
+	IsAdmin     bool      `json:"isAdmin"`
+	SelfService bool      `json:"selfService"`       // Self service users can only view and download their own clients
+	DeviceQuota int       `json:"deviceQuota"`       // Clients a self service user may create, 0 disables self service creation
+	Profile     string    `json:"profile,omitempty"` // Keep but mark as omitempty
+	Issuer      string    `json:"-"`                 // Hide completely from JSON
+	IssuedAt    time.Time `json:"-"`                 // Hide completely from JSON
+}
+
+// IsValid check if model is valid
+func (a User) IsValid() []error {
+	errs := make([]error, 0)
+
+	if a.Sub == "" {
+		errs = append(errs, fmt.Errorf("sub is required"))
+	}
+	if a.Name == "" {
+		errs = append(errs, fmt.Errorf("name is required"))
+	}
+	if a.DeviceQuota < 0 {
+		errs = append(errs, fmt.Errorf("deviceQuota %d is invalid", a.DeviceQuota))
+	}
+
+	return errs
 }
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+)
+
+const alertRuleColumns = `id, name, type, interface, enable, site2site_only, tags, minutes, bytes, emails, webhooks,
+    created_by, updated_by, created, updated`
+
+const alertColumns = `id, rule_id, rule_name, interface, subject, subject_name, message, state, fired, resolved,
+    acknowledged, acknowledged_by, acknowledged_at`
+
+// SaveAlertRule creates or updates an alert rule in the database
+func (s *sqlStore) SaveAlertRule(r *model.AlertRule) error {
+	tagsJSON, _ := json.Marshal(r.Tags)
+	emailsJSON, _ := json.Marshal(r.Emails)
+	webhooksJSON, _ := json.Marshal(r.Webhooks)
+
+	_, err := s.exec(`
+    INSERT INTO alert_rules (`+alertRuleColumns+`)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+        name=excluded.name,
+        type=excluded.type,
+        interface=excluded.interface,
+        enable=excluded.enable,
+        site2site_only=excluded.site2site_only,
+        tags=excluded.tags,
+        minutes=excluded.minutes,
+        bytes=excluded.bytes,
+        emails=excluded.emails,
+        webhooks=excluded.webhooks,
+        created_by=excluded.created_by,
+        updated_by=excluded.updated_by,
+        created=excluded.created,
+        updated=excluded.updated
+    `, r.Id, r.Name, r.Type, r.Interface, boolToInt(r.Enable), boolToInt(r.Site2SiteOnly), string(tagsJSON),
+		r.Minutes, r.Bytes, string(emailsJSON), string(webhooksJSON), r.CreatedBy, r.UpdatedBy,
+		r.Created.Format(time.RFC3339), r.Updated.Format(time.RFC3339))
+	return err
+}
+
+// LoadAlertRule loads an alert rule by id
+func (s *sqlStore) LoadAlertRule(id string) (*model.AlertRule, error) {
+	row := s.queryRow(`SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = ?`, id)
+	return scanAlertRule(row)
+}
+
+// LoadAllAlertRules loads all alert rules ordered by name
+func (s *sqlStore) LoadAllAlertRules() ([]*model.AlertRule, error) {
+	rows, err := s.query(`SELECT ` + alertRuleColumns + ` FROM alert_rules ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*model.AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteAlertRule deletes an alert rule by id, its alerts are kept
+func (s *sqlStore) DeleteAlertRule(id string) error {
+	_, err := s.exec("DELETE FROM alert_rules WHERE id = ?", id)
+	return err
+}
+
+// SaveAlert creates or updates an alert
+func (s *sqlStore) SaveAlert(a *model.Alert) error {
+	_, err := s.exec(`
+    INSERT INTO alerts (`+alertColumns+`)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+        rule_id=excluded.rule_id,
+        rule_name=excluded.rule_name,
+        interface=excluded.interface,
+        subject=excluded.subject,
+        subject_name=excluded.subject_name,
+        message=excluded.message,
+        state=excluded.state,
+        fired=excluded.fired,
+        resolved=excluded.resolved,
+        acknowledged=excluded.acknowledged,
+        acknowledged_by=excluded.acknowledged_by,
+        acknowledged_at=excluded.acknowledged_at
+    `, a.Id, a.RuleId, a.RuleName, a.Interface, a.Subject, a.SubjectName, a.Message, a.State,
+		a.Fired.UTC().Format(sortableTimeLayout), a.Resolved.UTC().Format(sortableTimeLayout),
+		boolToInt(a.Acknowledged), a.AcknowledgedBy, a.AcknowledgedAt.UTC().Format(sortableTimeLayout))
+	return err
+}
+
+// LoadAlert loads an alert by id
+func (s *sqlStore) LoadAlert(id string) (*model.Alert, error) {
+	row := s.queryRow(`SELECT `+alertColumns+` FROM alerts WHERE id = ?`, id)
+	return scanAlert(row)
+}
+
+// LoadAlerts alerts matching filter, most recently fired first, and the number of matching alerts
+func (s *sqlStore) LoadAlerts(filter model.AlertFilter) ([]*model.Alert, int, error) {
+	where := []string{}
+	args := []interface{}{}
+	for column, value := range map[string]string{
+		"rule_id":   filter.RuleId,
+		"state":     filter.State,
+		"interface": filter.Interface,
+	} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := s.queryRow(`SELECT COUNT(*) FROM alerts`+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + alertColumns + ` FROM alerts` + clause + ` ORDER BY fired DESC, id`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	alerts := []*model.Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, total, rows.Err()
+}
+
+func scanAlertRule(row rowScanner) (*model.AlertRule, error) {
+	var r model.AlertRule
+	var tagsJSON, emailsJSON, webhooksJSON, createdStr, updatedStr string
+	var enableInt, site2siteOnlyInt int
+
+	err := row.Scan(&r.Id, &r.Name, &r.Type, &r.Interface, &enableInt, &site2siteOnlyInt, &tagsJSON, &r.Minutes,
+		&r.Bytes, &emailsJSON, &webhooksJSON, &r.CreatedBy, &r.UpdatedBy, &createdStr, &updatedStr)
+	if err != nil {
+		return nil, err
+	}
+	r.Enable = enableInt != 0
+	r.Site2SiteOnly = site2siteOnlyInt != 0
+	_ = json.Unmarshal([]byte(tagsJSON), &r.Tags)
+	_ = json.Unmarshal([]byte(emailsJSON), &r.Emails)
+	_ = json.Unmarshal([]byte(webhooksJSON), &r.Webhooks)
+	r.Created, _ = time.Parse(time.RFC3339, createdStr)
+	r.Updated, _ = time.Parse(time.RFC3339, updatedStr)
+	return &r, nil
+}
+
+func scanAlert(row rowScanner) (*model.Alert, error) {
+	var a model.Alert
+	var firedStr, resolvedStr, acknowledgedAtStr string
+	var acknowledgedInt int
+
+	err := row.Scan(&a.Id, &a.RuleId, &a.RuleName, &a.Interface, &a.Subject, &a.SubjectName, &a.Message, &a.State,
+		&firedStr, &resolvedStr, &acknowledgedInt, &a.AcknowledgedBy, &acknowledgedAtStr)
+	if err != nil {
+		return nil, err
+	}
+	a.Acknowledged = acknowledgedInt != 0
+	a.Fired, _ = time.Parse(sortableTimeLayout, firedStr)
+	a.Resolved, _ = time.Parse(sortableTimeLayout, resolvedStr)
+	a.AcknowledgedAt, _ = time.Parse(sortableTimeLayout, acknowledgedAtStr)
+	return &a, nil
+}
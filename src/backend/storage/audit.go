@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+)
+
+// SaveAuditEntry appends an entry to the audit log, entries are never updated nor deleted
+func (s *sqlStore) SaveAuditEntry(e *model.AuditEntry) error {
+	_, err := s.exec(`
+    INSERT INTO audit (id, created, actor, source_ip, action, object_type, object_id, object_name, diff)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, e.Id, e.Time.UTC().Format(sortableTimeLayout), e.Actor, e.SourceIP, e.Action,
+		e.ObjectType, e.ObjectId, e.ObjectName, string(e.Diff))
+	return err
+}
+
+// LoadAuditEntries audit entries matching filter, most recent first, and the number of matching entries
+func (s *sqlStore) LoadAuditEntries(filter model.AuditFilter) ([]*model.AuditEntry, int, error) {
+	where := []string{}
+	args := []interface{}{}
+	for column, value := range map[string]string{
+		"actor":       filter.Actor,
+		"action":      filter.Action,
+		"object_type": filter.ObjectType,
+		"object_id":   filter.ObjectId,
+	} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, filter.From.UTC().Format(sortableTimeLayout))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, filter.To.UTC().Format(sortableTimeLayout))
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := s.queryRow(`SELECT COUNT(*) FROM audit`+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, created, actor, source_ip, action, object_type, object_id, object_name, diff
+    FROM audit` + clause + ` ORDER BY created DESC, id`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*model.AuditEntry{}
+	for rows.Next() {
+		var e model.AuditEntry
+		var createdStr, diff string
+		err := rows.Scan(&e.Id, &createdStr, &e.Actor, &e.SourceIP, &e.Action, &e.ObjectType, &e.ObjectId, &e.ObjectName, &diff)
+		if err != nil {
+			return nil, 0, err
+		}
+		e.Time, _ = time.Parse(sortableTimeLayout, createdStr)
+		e.Diff = []byte(diff)
+		entries = append(entries, &e)
+	}
+	return entries, total, rows.Err()
+}
@@ -0,0 +1,576 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+// The conformance tests run every test against each supported database: SQLite always, PostgreSQL when
+// DB_DSN holds its connection string, eg DB_DSN=postgres://wg:wg@localhost/wg?sslmode=disable go test ./storage
+// Each run gets its own schema, dropped afterwards, so the database of DB_DSN is left as it was.
+
+// forEachStore run test on a new, migrated store of each supported database
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		store := openSQLite(t, filepath.Join(t.TempDir(), "wg-gen-plus.db"))
+		migrateStore(t, store)
+		test(t, store)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("DB_DSN")
+		if dsn == "" || (os.Getenv("DB_TYPE") != "" && os.Getenv("DB_TYPE") != "postgres") {
+			t.Skip("DB_DSN is not set to a PostgreSQL database")
+		}
+		store := openPostgres(t, dsn)
+		migrateStore(t, store)
+		test(t, store)
+	})
+}
+
+func migrateStore(t *testing.T, store Store) {
+	t.Helper()
+	if _, err := store.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openPostgres open a store on a schema of its own in the database of dsn, dropped with the test
+func openPostgres(t *testing.T, dsn string) Store {
+	t.Helper()
+	db, err := sql.Open(postgresDialect.driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema := fmt.Sprintf("wg_gen_plus_test_%d", time.Now().UnixNano())
+	if _, err = db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	store, err := Open("postgres", withSearchPath(dsn, schema), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// withSearchPath dsn, as an URL or as key=value pairs, with its search_path set to schema
+func withSearchPath(dsn, schema string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func TestStoreUpserts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := &model.Server{Interface: "wg0", Address: []string{"10.0.0.1/24"}, ListenPort: 51820, Endpoint: "a:51820"}
+		if err := store.SaveServer(server); err != nil {
+			t.Fatal(err)
+		}
+		server.ListenPort = 51821
+		server.Address = []string{"10.0.0.1/24", "fd00::1/64"}
+		if err := store.SaveServer(server); err != nil {
+			t.Fatal(err)
+		}
+		loadedServer, err := store.LoadServer("wg0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loadedServer.ListenPort != 51821 || len(loadedServer.Address) != 2 {
+			t.Errorf("server not updated: %+v", loadedServer)
+		}
+
+		client := &model.Client{Id: "c1", Interface: "wg0", Name: "laptop", Enable: true, Address: []string{"10.0.0.2/32"}}
+		if err := store.SaveClient(client); err != nil {
+			t.Fatal(err)
+		}
+		client.Name = "desktop"
+		client.Enable = false
+		client.Tags = []string{"office"}
+		client.Owner = "u1"
+		if err := store.SaveClient(client); err != nil {
+			t.Fatal(err)
+		}
+		clients, err := store.LoadAllClients("wg0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clients) != 1 {
+			t.Fatalf("%d clients after saving the same client twice, want 1", len(clients))
+		}
+		if c := clients[0]; c.Name != "desktop" || c.Enable || !reflect.DeepEqual(c.Tags, []string{"office"}) || c.Owner != "u1" {
+			t.Errorf("client not updated: %+v", c)
+		}
+
+		user := &model.User{Sub: "u1", Name: "alice", Email: "alice@example.com"}
+		if err := store.SaveUser(user); err != nil {
+			t.Fatal(err)
+		}
+		user.Email = "alice@example.org"
+		user.DeviceQuota = 3
+		if err := store.SaveUser(user); err != nil {
+			t.Fatal(err)
+		}
+		users, err := store.LoadAllUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].Email != "alice@example.org" || users[0].DeviceQuota != 3 {
+			t.Errorf("user not updated: %+v", users)
+		}
+
+		profile := &model.Profile{Id: "p1", Interface: "wg0", Name: "split", AllowedIPs: []string{"10.0.0.0/24"}}
+		if err := store.SaveProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+		profile.Name = "split tunnel"
+		if err := store.SaveProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+		profiles, err := store.LoadAllProfiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(profiles) != 1 || profiles[0].Name != "split tunnel" {
+			t.Errorf("profile not updated: %+v", profiles)
+		}
+
+		pool := &model.IPPool{Id: "pool1", Interface: "wg0", Name: "laptops", Network: "10.0.0.0/25", Excluded: []string{}}
+		if err := store.SaveIPPool(pool); err != nil {
+			t.Fatal(err)
+		}
+		pool.Network = "10.0.0.128/25"
+		if err := store.SaveIPPool(pool); err != nil {
+			t.Fatal(err)
+		}
+		pools, err := store.LoadIPPools("wg0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pools) != 1 || pools[0].Network != "10.0.0.128/25" {
+			t.Errorf("address pool not updated: %+v", pools)
+		}
+
+		rule := &model.AlertRule{Id: "r1", Name: "offline", Type: "offline", Interface: "wg0", Minutes: 10}
+		if err := store.SaveAlertRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		rule.Minutes = 30
+		if err := store.SaveAlertRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		rules, err := store.LoadAllAlertRules()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 || rules[0].Minutes != 30 {
+			t.Errorf("alert rule not updated: %+v", rules)
+		}
+
+		// known interfaces are left untouched
+		first := &model.Interface{Name: "wg0", Created: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		if err := store.SaveInterface(first); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveInterface(&model.Interface{Name: "wg0", Created: first.Created.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		ifaces, err := store.LoadAllInterfaces()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ifaces) != 1 || !ifaces[0].Created.Equal(first.Created) {
+			t.Errorf("interface overwritten: %+v", ifaces)
+		}
+	})
+}
+
+func TestStoreBooleans(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		for _, value := range []bool{true, false} {
+			client := &model.Client{
+				Id:                              fmt.Sprintf("c-%v", value),
+				Interface:                       "wg0",
+				Enable:                          value,
+				Site2Site:                       value,
+				IgnorePersistentKeepalive:       value,
+				KeepaliveDisabled:               value,
+				UseRemoteDNS:                    value,
+				Site2SiteEndpointOptionsEnabled: value,
+				QuotaDisabled:                   value,
+				ScheduleDisabled:                value,
+				ExpiryReminded:                  value,
+			}
+			if err := store.SaveClient(client); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := store.LoadClient(client.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Enable != value || loaded.Site2Site != value || loaded.IgnorePersistentKeepalive != value ||
+				loaded.KeepaliveDisabled != value || loaded.UseRemoteDNS != value ||
+				loaded.Site2SiteEndpointOptionsEnabled != value || loaded.QuotaDisabled != value ||
+				loaded.ScheduleDisabled != value || loaded.ExpiryReminded != value {
+				t.Errorf("client booleans saved as %v loaded as %+v", value, loaded)
+			}
+
+			user := &model.User{Sub: fmt.Sprintf("u-%v", value), Name: fmt.Sprintf("user-%v", value), IsAdmin: value, SelfService: value}
+			if err := store.SaveUser(user); err != nil {
+				t.Fatal(err)
+			}
+			loadedUser, err := store.LoadUser(user.Sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loadedUser.IsAdmin != value || loadedUser.SelfService != value {
+				t.Errorf("user booleans saved as %v loaded as %+v", value, loadedUser)
+			}
+
+			profile := &model.Profile{Id: fmt.Sprintf("p-%v", value), Interface: "wg0", Name: fmt.Sprintf("profile-%v", value), UseRemoteDNS: value}
+			if err := store.SaveProfile(profile); err != nil {
+				t.Fatal(err)
+			}
+			loadedProfile, err := store.LoadProfile(profile.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loadedProfile.UseRemoteDNS != value {
+				t.Errorf("profile useRemoteDNS saved as %v loaded as %v", value, loadedProfile.UseRemoteDNS)
+			}
+
+			rule := &model.AlertRule{Id: fmt.Sprintf("r-%v", value), Name: "rule", Type: "offline", Interface: "wg0", Enable: value, Site2SiteOnly: value}
+			if err := store.SaveAlertRule(rule); err != nil {
+				t.Fatal(err)
+			}
+			loadedRule, err := store.LoadAlertRule(rule.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loadedRule.Enable != value || loadedRule.Site2SiteOnly != value {
+				t.Errorf("alert rule booleans saved as %v loaded as %+v", value, loadedRule)
+			}
+
+			alert := &model.Alert{Id: fmt.Sprintf("a-%v", value), RuleId: rule.Id, Interface: "wg0", State: "firing", Acknowledged: value}
+			if err := store.SaveAlert(alert); err != nil {
+				t.Fatal(err)
+			}
+			loadedAlert, err := store.LoadAlert(alert.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loadedAlert.Acknowledged != value {
+				t.Errorf("alert acknowledged saved as %v loaded as %v", value, loadedAlert.Acknowledged)
+			}
+		}
+	})
+}
+
+func TestStoreTimestamps(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		// times are saved in their zone and loaded back as the same instant
+		zone := time.FixedZone("UTC+2", 2*60*60)
+		created := time.Date(2024, 3, 1, 12, 30, 45, 0, zone)
+		client := &model.Client{
+			Id:           "c1",
+			Interface:    "wg0",
+			QuotaResetAt: created.Add(24 * time.Hour),
+			NotBefore:    created.Add(time.Hour),
+			ExpiresAt:    created.Add(48 * time.Hour),
+			KeysRotated:  created,
+			Created:      created,
+			Updated:      created.Add(time.Minute),
+		}
+		if err := store.SaveClient(client); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := store.LoadClient("c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, times := range map[string][2]time.Time{
+			"quotaResetAt": {client.QuotaResetAt, loaded.QuotaResetAt},
+			"notBefore":    {client.NotBefore, loaded.NotBefore},
+			"expiresAt":    {client.ExpiresAt, loaded.ExpiresAt},
+			"keysRotated":  {client.KeysRotated, loaded.KeysRotated},
+			"created":      {client.Created, loaded.Created},
+			"updated":      {client.Updated, loaded.Updated},
+		} {
+			if !times[0].Equal(times[1]) {
+				t.Errorf("client %s saved as %v loaded as %v", name, times[0], times[1])
+			}
+		}
+
+		// unset times stay unset
+		empty := &model.Client{Id: "c2", Interface: "wg0"}
+		if err := store.SaveClient(empty); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err = store.LoadClient("c2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loaded.NotBefore.IsZero() || !loaded.ExpiresAt.IsZero() || !loaded.QuotaResetAt.IsZero() || !loaded.Created.IsZero() {
+			t.Errorf("unset client times loaded as %+v", loaded)
+		}
+
+		// the audit log, traffic history and alerts keep sub-second times and filter on them
+		base := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+		for i := 0; i < 3; i++ {
+			entry := &model.AuditEntry{Id: fmt.Sprintf("e%d", i), Time: base.Add(time.Duration(i) * time.Second).In(zone), Actor: "admin",
+				Action: model.AuditActionUpdate, ObjectType: "client", ObjectId: "c1", Diff: []byte(`{}`)}
+			if err := store.SaveAuditEntry(entry); err != nil {
+				t.Fatal(err)
+			}
+			sample := &model.TrafficSample{ClientId: "c1", Time: base.Add(time.Duration(i) * time.Minute), Period: 60,
+				ReceivedBytes: int64(i), LastHandshake: base}
+			if err := store.SaveTrafficSample(sample); err != nil {
+				t.Fatal(err)
+			}
+		}
+		entries, total, err := store.LoadAuditEntries(model.AuditFilter{From: base.Add(time.Second), To: base.Add(2 * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 1 || len(entries) != 1 || entries[0].Id != "e1" {
+			t.Fatalf("audit entries from %v to %v: %d %+v, want e1", base.Add(time.Second), base.Add(2*time.Second), total, entries)
+		}
+		if !entries[0].Time.Equal(base.Add(time.Second)) {
+			t.Errorf("audit entry time saved as %v loaded as %v", base.Add(time.Second), entries[0].Time)
+		}
+
+		samples, err := store.LoadTrafficSamples(model.TrafficFilter{ClientId: "c1", From: base.Add(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 2 || !samples[0].Time.Equal(base.Add(time.Minute)) || !samples[1].Time.Equal(base.Add(2*time.Minute)) {
+			t.Errorf("traffic samples from %v, oldest first: %+v", base.Add(time.Minute), samples)
+		}
+		if !samples[0].LastHandshake.Equal(base) {
+			t.Errorf("traffic last handshake saved as %v loaded as %v", base, samples[0].LastHandshake)
+		}
+		last, err := store.LoadLastTrafficSamples()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(last) != 1 || !last[0].Time.Equal(base.Add(2*time.Minute)) {
+			t.Errorf("last traffic samples: %+v", last)
+		}
+
+		alert := &model.Alert{Id: "a1", RuleId: "r1", Interface: "wg0", State: "firing", Fired: base}
+		if err := store.SaveAlert(alert); err != nil {
+			t.Fatal(err)
+		}
+		loadedAlert, err := store.LoadAlert("a1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loadedAlert.Fired.Equal(base) || !loadedAlert.Resolved.IsZero() || !loadedAlert.AcknowledgedAt.IsZero() {
+			t.Errorf("alert times saved as fired %v loaded as %+v", base, loadedAlert)
+		}
+	})
+}
+
+func TestStoreLimitOffset(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i := 0; i < 5; i++ {
+			entry := &model.AuditEntry{Id: fmt.Sprintf("e%d", i), Time: base.Add(time.Duration(i) * time.Minute), Actor: "admin",
+				Action: model.AuditActionCreate, ObjectType: "client", ObjectId: fmt.Sprintf("c%d", i), Diff: []byte(`{}`)}
+			if err := store.SaveAuditEntry(entry); err != nil {
+				t.Fatal(err)
+			}
+			alert := &model.Alert{Id: fmt.Sprintf("a%d", i), RuleId: "r1", Interface: "wg0", State: "firing",
+				Fired: base.Add(time.Duration(i) * time.Minute)}
+			if err := store.SaveAlert(alert); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			name   string
+			offset int
+			limit  int
+			// want ids of the page, most recent first
+			want []string
+		}{
+			{"no limit", 0, 0, []string{"4", "3", "2", "1", "0"}},
+			{"first page", 0, 2, []string{"4", "3"}},
+			{"second page", 2, 2, []string{"2", "1"}},
+			{"last page", 4, 2, []string{"0"}},
+			{"past the end", 5, 2, []string{}},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				entries, total, err := store.LoadAuditEntries(model.AuditFilter{Offset: test.offset, Limit: test.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []string{}
+				for _, e := range entries {
+					got = append(got, strings.TrimPrefix(e.Id, "e"))
+				}
+				if total != 5 || !reflect.DeepEqual(got, test.want) {
+					t.Errorf("audit entries %v of %d, want %v of 5", got, total, test.want)
+				}
+
+				alerts, total, err := store.LoadAlerts(model.AlertFilter{Offset: test.offset, Limit: test.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = []string{}
+				for _, a := range alerts {
+					got = append(got, strings.TrimPrefix(a.Id, "a"))
+				}
+				if total != 5 || !reflect.DeepEqual(got, test.want) {
+					t.Errorf("alerts %v of %d, want %v of 5", got, total, test.want)
+				}
+			})
+		}
+	})
+}
+
+func TestStoreLockUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if err := store.SaveUser(&model.User{Sub: "u1", Name: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+		locked := make(chan struct{})
+		release := make(chan struct{})
+		first := make(chan error)
+		go func() {
+			first <- store.WithTx(func(tx Store) error {
+				if err := tx.LockUser("u1"); err != nil {
+					return err
+				}
+				// an unknown user locks nothing
+				if err := tx.LockUser("unknown"); err != nil {
+					return err
+				}
+				close(locked)
+				<-release
+				return nil
+			})
+		}()
+		<-locked
+
+		second := make(chan error)
+		go func() {
+			second <- store.WithTx(func(tx Store) error {
+				return tx.LockUser("u1")
+			})
+		}()
+		var errSecond error
+		done := false
+		if store.(*sqlStore).dialect.rowLocks {
+			select {
+			case errSecond = <-second:
+				done = true
+				t.Error("user locked by two transactions at once")
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		close(release)
+		if err := <-first; err != nil {
+			t.Fatal(err)
+		}
+		if !done {
+			errSecond = <-second
+		}
+		if errSecond != nil {
+			t.Fatal(errSecond)
+		}
+	})
+}
+
+func TestStoreOnRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		var ran []string
+		register := func(s Store, names ...string) {
+			for _, name := range names {
+				s.OnRollback(func() { ran = append(ran, name) })
+			}
+		}
+
+		register(store, "outside")
+		err := store.WithTx(func(tx Store) error {
+			register(tx, "committed")
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ran) != 0 {
+			t.Errorf("%v ran without a rollback", ran)
+		}
+
+		failure := errors.New("failure")
+		err = store.WithTx(func(tx Store) error {
+			register(tx, "first")
+			// a nested transaction joins the outer one
+			return tx.WithTx(func(tx Store) error {
+				register(tx, "nested")
+				return failure
+			})
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("transaction error %v, want the failure", err)
+		}
+		if fmt.Sprint(ran) != "[nested first]" {
+			t.Errorf("%v ran on rollback, want [nested first]", ran)
+		}
+
+		// a commit failing on a deferred constraint
+		ran = nil
+		s := store.(*sqlStore)
+		if !s.dialect.numberedParams {
+			// foreign keys are enabled per SQLite connection, outside of transactions
+			s.db.SetMaxOpenConns(1)
+			if _, err = s.exec(`PRAGMA foreign_keys = ON`); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, query := range []string{
+			`CREATE TABLE rollback_parents (id TEXT PRIMARY KEY)`,
+			`CREATE TABLE rollback_children (parent TEXT REFERENCES rollback_parents (id) DEFERRABLE INITIALLY DEFERRED)`,
+		} {
+			if _, err = s.exec(query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = store.WithTx(func(tx Store) error {
+			register(tx, "commit")
+			_, err := tx.(*sqlStore).exec(`INSERT INTO rollback_children (parent) VALUES (?)`, "missing")
+			return err
+		})
+		if err == nil {
+			t.Fatal("transaction breaking a deferred constraint committed")
+		}
+		if fmt.Sprint(ran) != "[commit]" {
+			t.Errorf("%v ran on a failed commit, want [commit]", ran)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+	"wg-gen-plus/model"
+)
+
+const ipPoolColumns = `id, interface, name, network, strategy, excluded, created_by, updated_by, created, updated`
+
+const ipReservationColumns = `id, interface, address, description, created_by, created`
+
+// SaveIPPool creates or updates an address pool in the database
+func (s *sqlStore) SaveIPPool(p *model.IPPool) error {
+	excludedJSON, _ := json.Marshal(p.Excluded)
+
+	_, err := s.exec(`
+    INSERT INTO ip_pools (`+ipPoolColumns+`)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+        interface=excluded.interface,
+        name=excluded.name,
+        network=excluded.network,
+        strategy=excluded.strategy,
+        excluded=excluded.excluded,
+        created_by=excluded.created_by,
+        updated_by=excluded.updated_by,
+        created=excluded.created,
+        updated=excluded.updated
+    `, p.Id, p.Interface, p.Name, p.Network, p.Strategy, string(excludedJSON), p.CreatedBy, p.UpdatedBy,
+		p.Created.Format(time.RFC3339), p.Updated.Format(time.RFC3339))
+	return err
+}
+
+// LoadIPPool loads an address pool by id
+func (s *sqlStore) LoadIPPool(id string) (*model.IPPool, error) {
+	row := s.queryRow(`SELECT `+ipPoolColumns+` FROM ip_pools WHERE id = ?`, id)
+	return scanIPPool(row)
+}
+
+// LoadIPPools loads the address pools of interface iface ordered by name
+func (s *sqlStore) LoadIPPools(iface string) ([]*model.IPPool, error) {
+	rows, err := s.query(`SELECT `+ipPoolColumns+` FROM ip_pools WHERE interface = ? ORDER BY name`, iface)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pools := []*model.IPPool{}
+	for rows.Next() {
+		p, err := scanIPPool(rows)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, p)
+	}
+	return pools, rows.Err()
+}
+
+// DeleteIPPool deletes an address pool by id, the addresses of its clients are kept
+func (s *sqlStore) DeleteIPPool(id string) error {
+	_, err := s.exec("DELETE FROM ip_pools WHERE id = ?", id)
+	return err
+}
+
+// SaveIPReservation creates or updates an address reservation in the database
+func (s *sqlStore) SaveIPReservation(r *model.IPReservation) error {
+	_, err := s.exec(`
+    INSERT INTO ip_reservations (`+ipReservationColumns+`)
+    VALUES (?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+        interface=excluded.interface,
+        address=excluded.address,
+        description=excluded.description,
+        created_by=excluded.created_by,
+        created=excluded.created
+    `, r.Id, r.Interface, r.Address, r.Description, r.CreatedBy, r.Created.Format(time.RFC3339))
+	return err
+}
+
+// LoadIPReservation loads an address reservation by id
+func (s *sqlStore) LoadIPReservation(id string) (*model.IPReservation, error) {
+	row := s.queryRow(`SELECT `+ipReservationColumns+` FROM ip_reservations WHERE id = ?`, id)
+	return scanIPReservation(row)
+}
+
+// LoadIPReservations loads the address reservations of interface iface ordered by address
+func (s *sqlStore) LoadIPReservations(iface string) ([]*model.IPReservation, error) {
+	rows, err := s.query(`SELECT `+ipReservationColumns+` FROM ip_reservations WHERE interface = ? ORDER BY address`, iface)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reservations := []*model.IPReservation{}
+	for rows.Next() {
+		r, err := scanIPReservation(rows)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, r)
+	}
+	return reservations, rows.Err()
+}
+
+// DeleteIPReservation deletes an address reservation by id
+func (s *sqlStore) DeleteIPReservation(id string) error {
+	_, err := s.exec("DELETE FROM ip_reservations WHERE id = ?", id)
+	return err
+}
+
+func scanIPPool(row rowScanner) (*model.IPPool, error) {
+	var p model.IPPool
+	var excludedJSON, createdStr, updatedStr string
+
+	err := row.Scan(&p.Id, &p.Interface, &p.Name, &p.Network, &p.Strategy, &excludedJSON, &p.CreatedBy, &p.UpdatedBy,
+		&createdStr, &updatedStr)
+	if err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(excludedJSON), &p.Excluded)
+	p.Created, _ = time.Parse(time.RFC3339, createdStr)
+	p.Updated, _ = time.Parse(time.RFC3339, updatedStr)
+	return &p, nil
+}
+
+func scanIPReservation(row rowScanner) (*model.IPReservation, error) {
+	var r model.IPReservation
+	var createdStr string
+
+	err := row.Scan(&r.Id, &r.Interface, &r.Address, &r.Description, &r.CreatedBy, &createdStr)
+	if err != nil {
+		return nil, err
+	}
+	r.Created, _ = time.Parse(time.RFC3339, createdStr)
+	return &r, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"wg-gen-plus/model"
+)
+
+// ImportLegacyDatabase imports the server, clients and users of a single interface SQLite database
+// (wg-gen-plus-<iface>.db of previous releases) into store as interface iface.
+// Users already present, by id or name, are kept. Importing the same file again overwrites the imported server and clients.
+func ImportLegacyDatabase(store Store, legacyDbFile string, iface string) error {
+	db, err := sql.Open(sqliteDialect.driver, "file:"+legacyDbFile+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	legacy := &sqlStore{db: db, q: db, dialect: sqliteDialect}
+
+	// the legacy server table holds a single row, table_name was never written
+	server, err := scanServer(legacy.queryRow(`SELECT
+        ?, address, listen_port, mtu, private_key, public_key, endpoint,
+        persistent_keepalive, dns, allowed_ips, COALESCE(table_name, ''), created, updated_by, created, updated
+        FROM server WHERE id = 1`, iface))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if server != nil {
+		err = store.SaveServer(server)
+		if err != nil {
+			return err
+		}
+	}
+
+	// legacy clients have no interface and predate quotas, validity windows and owners, their keys date from their creation
+	columns := strings.Replace(clientColumns, "interface", "?", 1)
+	columns = strings.Replace(columns, "quota_bytes, quota_period, quota_action, quota_disabled, quota_reset_at",
+		"0, '', '', 0, ''", 1)
+	columns = strings.Replace(columns, "not_before, expires_at, expiry_action, schedule_disabled, expiry_reminded, keys_rotated",
+		"'', '', '', 0, 0, created", 1)
+	columns = strings.Replace(columns, "owner, created_by", "'', created_by", 1)
+	rows, err := legacy.query(`SELECT `+columns+`
+    FROM clients`, iface)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	clients := make([]*model.Client, 0)
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return err
+		}
+		clients = append(clients, c)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// users are shared by all interfaces, the first imported database wins on name clashes
+	existing, err := store.LoadAllUsers()
+	if err != nil {
+		return err
+	}
+	userRows, err := legacy.query(`SELECT id, name, email, password, is_admin, 0, 0 FROM users`)
+	if err != nil {
+		return err
+	}
+	defer userRows.Close()
+	for userRows.Next() {
+		user, err := scanUser(userRows)
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, e := range existing {
+			if e.Sub == user.Sub || strings.EqualFold(e.Name, user.Name) {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		err = store.SaveUser(user)
+		if err != nil {
+			return err
+		}
+		existing = append(existing, user)
+	}
+	if err = userRows.Err(); err != nil {
+		return err
+	}
+
+	// clients belong to the user who created them, by name in legacy databases
+	for _, c := range clients {
+		for _, user := range existing {
+			if c.CreatedBy != "" && strings.EqualFold(user.Name, c.CreatedBy) {
+				c.Owner = user.Sub
+				break
+			}
+		}
+		err = store.SaveClient(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
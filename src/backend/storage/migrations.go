@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration single schema upgrade step, applied in its own transaction
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// migrations ordered schema history, never edit a released step, append a new one instead.
+// Steps run on SQLite and PostgreSQL, keep to the SQL both understand.
+// Statements of the first step are idempotent, databases created before schema versioning are only stamped.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "interfaces, servers, clients, users and profiles",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS interfaces (
+				name TEXT PRIMARY KEY,
+				created TEXT
+			);
+			CREATE TABLE IF NOT EXISTS clients (
+				id TEXT PRIMARY KEY,
+				interface TEXT NOT NULL DEFAULT '',
+				name TEXT,
+				email TEXT,
+				enable INTEGER,
+				site2site INTEGER,
+				ignore_persistent_keepalive INTEGER,
+				keepalive_disabled INTEGER,
+				keepalive_interval INTEGER,
+				use_remote_dns INTEGER,
+				site2site_endpoint_options_enabled INTEGER,
+				site2site_endpoint TEXT,
+				site2site_endpoint_port INTEGER,
+				site2site_endpoint_listen_port INTEGER,
+				lan_ips TEXT,
+				table_name TEXT,
+				preshared_key TEXT,
+				allowed_ips TEXT,
+				address TEXT,
+				tags TEXT,
+				private_key TEXT,
+				public_key TEXT,
+				created_by TEXT,
+				updated_by TEXT,
+				created TEXT,
+				updated TEXT
+			);
+			CREATE INDEX IF NOT EXISTS clients_interface ON clients (interface);
+			CREATE TABLE IF NOT EXISTS servers (
+				interface TEXT PRIMARY KEY,
+				address TEXT,
+				listen_port INTEGER,
+				mtu INTEGER,
+				private_key TEXT,
+				public_key TEXT,
+				endpoint TEXT,
+				persistent_keepalive INTEGER,
+				dns TEXT,
+				allowed_ips TEXT,
+				table_name TEXT,
+				updated_by TEXT,
+				created TEXT,
+				updated TEXT
+			);
+			CREATE TABLE IF NOT EXISTS users (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				email TEXT,
+				password TEXT,
+				is_admin INTEGER,
+				self_service INTEGER NOT NULL DEFAULT 0,
+				device_quota INTEGER NOT NULL DEFAULT 0
+			);
+			CREATE TABLE IF NOT EXISTS profiles (
+				id TEXT PRIMARY KEY,
+				interface TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL UNIQUE,
+				allowed_ips TEXT,
+				use_remote_dns INTEGER,
+				created_by TEXT,
+				updated_by TEXT,
+				created TEXT,
+				updated TEXT
+			);
+			`)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "audit log",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS audit (
+				id TEXT PRIMARY KEY,
+				created TEXT NOT NULL,
+				actor TEXT NOT NULL,
+				source_ip TEXT NOT NULL,
+				action TEXT NOT NULL,
+				object_type TEXT NOT NULL,
+				object_id TEXT NOT NULL,
+				object_name TEXT NOT NULL,
+				diff TEXT NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS audit_created ON audit (created);
+			`)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "traffic history",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE traffic (
+				client_id TEXT NOT NULL,
+				created TEXT NOT NULL,
+				period INTEGER NOT NULL,
+				received_bytes BIGINT NOT NULL,
+				transmitted_bytes BIGINT NOT NULL,
+				received_total BIGINT NOT NULL,
+				transmitted_total BIGINT NOT NULL,
+				last_handshake TEXT NOT NULL
+			);
+			CREATE INDEX traffic_client_created ON traffic (client_id, created);
+			CREATE INDEX traffic_created ON traffic (created);
+			`)
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "alert rules and alerts",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE alert_rules (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				type TEXT NOT NULL,
+				interface TEXT NOT NULL,
+				enable INTEGER NOT NULL,
+				site2site_only INTEGER NOT NULL,
+				tags TEXT NOT NULL,
+				minutes INTEGER NOT NULL,
+				bytes BIGINT NOT NULL,
+				emails TEXT NOT NULL,
+				webhooks TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				updated_by TEXT NOT NULL,
+				created TEXT NOT NULL,
+				updated TEXT NOT NULL
+			);
+			CREATE TABLE alerts (
+				id TEXT PRIMARY KEY,
+				rule_id TEXT NOT NULL,
+				rule_name TEXT NOT NULL,
+				interface TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				subject_name TEXT NOT NULL,
+				message TEXT NOT NULL,
+				state TEXT NOT NULL,
+				fired TEXT NOT NULL,
+				resolved TEXT NOT NULL,
+				acknowledged INTEGER NOT NULL,
+				acknowledged_by TEXT NOT NULL,
+				acknowledged_at TEXT NOT NULL
+			);
+			CREATE INDEX alerts_state ON alerts (state);
+			CREATE INDEX alerts_fired ON alerts (fired);
+			`)
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "client data quotas",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			ALTER TABLE clients ADD COLUMN quota_bytes BIGINT NOT NULL DEFAULT 0;
+			ALTER TABLE clients ADD COLUMN quota_period TEXT NOT NULL DEFAULT '';
+			ALTER TABLE clients ADD COLUMN quota_action TEXT NOT NULL DEFAULT '';
+			ALTER TABLE clients ADD COLUMN quota_disabled INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE clients ADD COLUMN quota_reset_at TEXT NOT NULL DEFAULT '';
+			`)
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "client validity window",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			ALTER TABLE clients ADD COLUMN not_before TEXT NOT NULL DEFAULT '';
+			ALTER TABLE clients ADD COLUMN expires_at TEXT NOT NULL DEFAULT '';
+			ALTER TABLE clients ADD COLUMN expiry_action TEXT NOT NULL DEFAULT '';
+			ALTER TABLE clients ADD COLUMN schedule_disabled INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE clients ADD COLUMN expiry_reminded INTEGER NOT NULL DEFAULT 0;
+			`)
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "key rotation timestamps",
+		Up: func(tx *sql.Tx) error {
+			// keys were generated when the client or server was created
+			_, err := tx.Exec(`
+			ALTER TABLE clients ADD COLUMN keys_rotated TEXT NOT NULL DEFAULT '';
+			ALTER TABLE servers ADD COLUMN keys_rotated TEXT NOT NULL DEFAULT '';
+			UPDATE clients SET keys_rotated = COALESCE(created, '');
+			UPDATE servers SET keys_rotated = COALESCE(created, '');
+			`)
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "address pools and reservations",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE ip_pools (
+				id TEXT PRIMARY KEY,
+				interface TEXT NOT NULL,
+				name TEXT NOT NULL,
+				network TEXT NOT NULL,
+				strategy TEXT NOT NULL,
+				excluded TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				updated_by TEXT NOT NULL,
+				created TEXT NOT NULL,
+				updated TEXT NOT NULL
+			);
+			CREATE TABLE ip_reservations (
+				id TEXT PRIMARY KEY,
+				interface TEXT NOT NULL,
+				address TEXT NOT NULL,
+				description TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				created TEXT NOT NULL
+			);
+			CREATE INDEX ip_pools_interface ON ip_pools (interface);
+			CREATE INDEX ip_reservations_interface ON ip_reservations (interface);
+			`)
+			return err
+		},
+	},
+	{
+		Version:     9,
+		Description: "client owners",
+		Up: func(tx *sql.Tx) error {
+			// clients were owned by the name of the user who created them, names can change but ids do not
+			_, err := tx.Exec(`
+			ALTER TABLE clients ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+			UPDATE clients SET owner = COALESCE((SELECT id FROM users WHERE LOWER(users.name) = LOWER(clients.created_by) LIMIT 1), '');
+			`)
+			return err
+		},
+	},
+}
+
+// SchemaVersion version of the last applied migration, 0 for an empty database
+func (s *sqlStore) SchemaVersion() (int, error) {
+	var tables int
+	err := s.queryRow(s.dialect.schemaVersionTableQuery).Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+	var version int
+	err = s.queryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// pendingMigrations migrations not applied yet, in order
+func (s *sqlStore) pendingMigrations() ([]Migration, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]Migration, 0)
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order, each one in its own transaction, and returns them.
+// A dry run applies them all in a single transaction which is rolled back, leaving the database untouched.
+func (s *sqlStore) Migrate(dryRun bool) ([]Migration, error) {
+	pending, err := s.pendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return pending, nil
+	}
+
+	if dryRun {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		for _, m := range pending {
+			err = s.applyMigration(tx, m)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return pending, nil
+	}
+
+	for _, m := range pending {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		err = s.applyMigration(tx, m)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+	}
+	return pending, nil
+}
+
+func (s *sqlStore) applyMigration(tx *sql.Tx, m Migration) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT,
+		applied TEXT
+	);
+	`)
+	if err != nil {
+		return err
+	}
+	err = m.Up(tx)
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+	}
+	_, err = tx.Exec(s.rebind(`INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?)`),
+		m.Version, m.Description, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
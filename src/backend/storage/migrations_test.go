@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+)
+
+// Fixtures of testdata are built from the .sql file of the same name:
+// sqlite3 testdata/schema-v1.db < testdata/schema-v1.sql
+
+// openFixture open a copy of the SQLite database testdata/name, the fixture itself is never written
+func openFixture(t *testing.T, name string) Store {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), name)
+	if err = os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return openSQLite(t, file)
+}
+
+// openSQLite open the SQLite database file, closed with the test
+func openSQLite(t *testing.T, file string) Store {
+	t.Helper()
+	store, err := Open("sqlite", file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func day(d int) time.Time {
+	return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func latestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func loadClients(t *testing.T, store Store, ifaces ...string) map[string]*model.Client {
+	t.Helper()
+	clients := make(map[string]*model.Client)
+	for _, iface := range ifaces {
+		loaded, err := store.LoadAllClients(iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range loaded {
+			clients[c.Id] = c
+		}
+	}
+	return clients
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	store := openFixture(t, "schema-v1.db")
+
+	pending, err := store.Migrate(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != latestVersion()-1 || pending[0].Version != 2 {
+		t.Fatalf("dry run tested %d migrations from version %d, want versions 2 to %d", len(pending), pending[0].Version, latestVersion())
+	}
+
+	version, err := store.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("dry run left schema version %d, want 1", version)
+	}
+	// the columns of the later migrations were rolled back with them
+	if _, err = store.LoadAllClients("wg0"); err == nil {
+		t.Error("clients loaded after a dry run, the migrations were not rolled back")
+	}
+
+	// the migrations still apply once the dry run rolled back
+	applied, err := store.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(pending) {
+		t.Errorf("applied %d migrations after the dry run, want %d", len(applied), len(pending))
+	}
+}
+
+func TestMigrateFromVersion1(t *testing.T) {
+	store := openFixture(t, "schema-v1.db")
+
+	applied, err := store.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != latestVersion()-1 {
+		t.Errorf("applied %d migrations, want %d", len(applied), latestVersion()-1)
+	}
+	version, err := store.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestVersion() {
+		t.Errorf("schema version %d, want %d", version, latestVersion())
+	}
+	again, err := store.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Errorf("applied %d migrations on an up to date database, want none", len(again))
+	}
+
+	ifaces, err := store.LoadAllInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifaces) != 2 {
+		t.Errorf("%d interfaces, want 2", len(ifaces))
+	}
+
+	server, err := store.LoadServer("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ListenPort != 51820 || server.Mtu != 1420 || server.Table != "off" || server.Endpoint != "vpn.example.com:51820" {
+		t.Errorf("server wg0 not kept: %+v", server)
+	}
+	if !server.KeysRotated.Equal(day(1)) {
+		t.Errorf("server keysRotated %v, want its creation %v", server.KeysRotated, day(1))
+	}
+
+	clients := loadClients(t, store, "wg0", "wg1")
+	if len(clients) != 3 {
+		t.Fatalf("%d clients, want 3", len(clients))
+	}
+	tests := []struct {
+		id     string
+		name   string
+		enable bool
+		// owner id of the user the client was created by, matched by name whatever the case
+		owner string
+	}{
+		{"c1", "laptop", true, "22222222-2222-2222-2222-222222222222"},
+		{"c2", "office", true, "11111111-1111-1111-1111-111111111111"},
+		{"c3", "phone", false, ""},
+	}
+	for _, test := range tests {
+		c := clients[test.id]
+		if c == nil {
+			t.Errorf("client %s missing", test.id)
+			continue
+		}
+		if c.Name != test.name || c.Enable != test.enable {
+			t.Errorf("client %s: name %q enable %v, want %q %v", test.id, c.Name, c.Enable, test.name, test.enable)
+		}
+		if c.Owner != test.owner {
+			t.Errorf("client %s: owner %q, want %q", test.id, c.Owner, test.owner)
+		}
+		if !c.KeysRotated.Equal(c.Created) {
+			t.Errorf("client %s: keysRotated %v, want its creation %v", test.id, c.KeysRotated, c.Created)
+		}
+		if c.QuotaBytes != 0 || !c.ExpiresAt.IsZero() || c.ExpiryReminded {
+			t.Errorf("client %s: quota and validity window not empty: %+v", test.id, c)
+		}
+	}
+	office := clients["c2"]
+	if !office.Site2Site || office.Site2SiteEndpointPort != 443 || office.KeepaliveInterval != 15 ||
+		len(office.LANIPs) != 1 || office.LANIPs[0] != "192.168.1.0/24" || office.Table != "off" {
+		t.Errorf("site to site settings of client c2 not kept: %+v", office)
+	}
+
+	carol, err := store.LoadUser("33333333-3333-3333-3333-333333333333")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !carol.SelfService || carol.DeviceQuota != 2 || carol.IsAdmin {
+		t.Errorf("self service user not kept: %+v", carol)
+	}
+	profiles, err := store.LoadAllProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].Name != "split tunnel" {
+		t.Errorf("profiles not kept: %+v", profiles)
+	}
+}
+
+func TestImportLegacyDatabase(t *testing.T) {
+	store := openSQLite(t, filepath.Join(t.TempDir(), "wg-gen-plus.db"))
+	if _, err := store.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	// a user of another interface, by the name of a legacy user, is kept
+	admin := &model.User{Sub: "99999999-9999-9999-9999-999999999999", Name: "Admin", Email: "root@example.com", IsAdmin: true}
+	if err := store.SaveUser(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := filepath.Join("testdata", "legacy-wg0.db")
+	if err := ImportLegacyDatabase(store, legacy, "wg0"); err != nil {
+		t.Fatal(err)
+	}
+	// importing the same file again overwrites what it imported
+	if err := ImportLegacyDatabase(store, legacy, "wg0"); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := store.LoadServer("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Interface != "wg0" || server.ListenPort != 51820 || server.Table != "" || len(server.Dns) != 1 {
+		t.Errorf("legacy server not imported: %+v", server)
+	}
+	created := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	if !server.KeysRotated.Equal(created) {
+		t.Errorf("server keysRotated %v, want its creation %v", server.KeysRotated, created)
+	}
+
+	users, err := store.LoadAllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Errorf("%d users, want the existing admin and Alice", len(users))
+	}
+	kept, err := store.LoadUser(admin.Sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Email != admin.Email {
+		t.Errorf("existing user overwritten by the legacy one: %+v", kept)
+	}
+
+	clients := loadClients(t, store, "wg0")
+	if len(clients) != 3 {
+		t.Fatalf("%d clients, want 3", len(clients))
+	}
+	owners := map[string]string{
+		"c1": "22222222-2222-2222-2222-222222222222",
+		"c2": admin.Sub,
+		"c3": "",
+	}
+	for id, owner := range owners {
+		c := clients[id]
+		if c == nil {
+			t.Errorf("client %s missing", id)
+			continue
+		}
+		if c.Interface != "wg0" {
+			t.Errorf("client %s: interface %q, want wg0", id, c.Interface)
+		}
+		if c.Owner != owner {
+			t.Errorf("client %s: owner %q, want %q", id, c.Owner, owner)
+		}
+		if !c.KeysRotated.Equal(c.Created) {
+			t.Errorf("client %s: keysRotated %v, want its creation %v", id, c.KeysRotated, c.Created)
+		}
+	}
+	if office := clients["c2"]; !office.HasSite2SiteEndpoint() || office.Site2SiteEndpointAddress() != "office.example.com:443" {
+		t.Errorf("site to site endpoint of client c2 not imported: %+v", office)
+	}
+	if printer := clients["c3"]; printer.Enable || !printer.KeepaliveDisabled || len(printer.Tags) != 0 {
+		t.Errorf("client c3 not imported as is: %+v", printer)
+	}
+}
@@ -0,0 +1,13 @@
+package storage
+
+import (
+	_ "github.com/lib/pq"
+)
+
+// postgresDialect shared database, for several wg-gen-plus replicas behind a load balancer
+var postgresDialect = dialect{
+	driver:                  "postgres",
+	numberedParams:          true,
+	rowLocks:                true,
+	schemaVersionTableQuery: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version'`,
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"wg-gen-plus/model"
+)
+
+// secretPrefix marks a value encrypted by a Cipher, it is followed by the id of the master key, the wrapped
+// data key and the sealed value
+const secretPrefix = "enc:v1:"
+
+// ErrNoEncryptionKey the database holds encrypted keys but no master key is configured
+var ErrNoEncryptionKey = errors.New("the database holds encrypted keys but no encryption key is configured")
+
+// Cipher envelope encryption of the private and preshared keys: every value is sealed with AES-GCM under its
+// own random data key, which is sealed under the master key and stored along
+type Cipher struct {
+	master cipher.AEAD
+	id     string
+}
+
+// NewCipher cipher of a 32 bytes master key
+func NewCipher(masterKey []byte) (*Cipher, error) {
+	if len(masterKey) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(masterKey)
+	return &Cipher{master: master, id: hex.EncodeToString(sum[:4])}, nil
+}
+
+// ParseMasterKey master key written in base64, like the output of openssl rand -base64 32
+func ParseMasterKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes encoded in base64")
+	}
+	return key, nil
+}
+
+// KeyID short id of the master key, stored with every value it encrypted
+func (c *Cipher) KeyID() string {
+	return c.id
+}
+
+// encrypt plain under a new data key
+func (c *Cipher) encrypt(plain string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(c.master, dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(data, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + c.id + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt value encrypted with the master key of c
+func (c *Cipher) decrypt(value string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("encrypted value is malformed")
+	}
+	if parts[0] != c.id {
+		return "", fmt.Errorf("value is encrypted with master key %s, the encryption key is %s", parts[0], c.id)
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(c.master, wrapped)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(data, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal plain with a random nonce, which is prepended
+func seal(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open sealed with its prepended nonce
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt value, wrong encryption key or corrupted value")
+	}
+	return plain, nil
+}
+
+// isEncryptedWith check if value is encrypted with the master key of c, or in plaintext for a nil c
+func isEncryptedWith(value string, c *Cipher) bool {
+	if c == nil {
+		return !strings.HasPrefix(value, secretPrefix)
+	}
+	return strings.HasPrefix(value, secretPrefix+c.id+":")
+}
+
+// encryptSecret value as stored, in plaintext without a cipher. Empty values, like the private key of a
+// client with its own key, stay empty.
+func (s *sqlStore) encryptSecret(value string) (string, error) {
+	if s.cipher == nil || value == "" {
+		return value, nil
+	}
+	return s.cipher.encrypt(value)
+}
+
+// decryptSecret stored value in plaintext, values stored before encryption was enabled are returned as is
+func (s *sqlStore) decryptSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, nil
+	}
+	if s.cipher == nil {
+		return "", ErrNoEncryptionKey
+	}
+	return s.cipher.decrypt(value)
+}
+
+// decryptClient keys of client c as loaded
+func (s *sqlStore) decryptClient(c *model.Client) error {
+	var err error
+	if c.PrivateKey, err = s.decryptSecret(c.PrivateKey); err != nil {
+		return fmt.Errorf("client %s private key: %w", c.Id, err)
+	}
+	if c.PresharedKey, err = s.decryptSecret(c.PresharedKey); err != nil {
+		return fmt.Errorf("client %s preshared key: %w", c.Id, err)
+	}
+	return nil
+}
+
+// ReencryptSecrets see Store
+func (s *sqlStore) ReencryptSecrets(next *Cipher) (int, error) {
+	// secretRow one stored secret, keyed by its table, column and row
+	type secretRow struct {
+		table, column, keyColumn, key, value string
+	}
+	count := 0
+	err := s.WithTx(func(tx Store) error {
+		t := tx.(*sqlStore)
+		secrets := make([]secretRow, 0)
+		for _, source := range []struct{ table, keyColumn, column string }{
+			{"servers", "interface", "private_key"},
+			{"clients", "id", "private_key"},
+			{"clients", "id", "preshared_key"},
+		} {
+			// collected first, rows are updated once the query is closed
+			rows, err := t.query(`SELECT ` + source.keyColumn + `, ` + source.column + ` FROM ` + source.table)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				secret := secretRow{table: source.table, column: source.column, keyColumn: source.keyColumn}
+				if err := rows.Scan(&secret.key, &secret.value); err != nil {
+					rows.Close()
+					return err
+				}
+				if secret.value != "" && !isEncryptedWith(secret.value, next) {
+					secrets = append(secrets, secret)
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+
+		for _, secret := range secrets {
+			plain, err := t.decryptSecret(secret.value)
+			if err != nil {
+				return fmt.Errorf("%s %s %s: %w", secret.table, secret.key, secret.column, err)
+			}
+			value := plain
+			if next != nil {
+				if value, err = next.encrypt(plain); err != nil {
+					return err
+				}
+			}
+			_, err = t.exec(`UPDATE `+secret.table+` SET `+secret.column+` = ? WHERE `+secret.keyColumn+` = ?`, value, secret.key)
+			if err != nil {
+				return err
+			}
+		}
+		count = len(secrets)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.cipher = next
+	return count, nil
+}
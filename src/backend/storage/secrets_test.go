@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"wg-gen-plus/model"
+)
+
+// testCipher cipher of a fixed master key, seed tells keys apart
+func testCipher(t *testing.T, seed byte) *Cipher {
+	t.Helper()
+	c, err := NewCipher(bytes.Repeat([]byte{seed}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// withCipher store over the same database as store, reading and writing the keys with c
+func withCipher(store Store, c *Cipher) Store {
+	s := store.(*sqlStore)
+	return &sqlStore{db: s.db, q: s.db, dialect: s.dialect, cipher: c}
+}
+
+// storedSecrets keys as stored in the database, by table, row and column
+func storedSecrets(t *testing.T, store Store) map[string]string {
+	t.Helper()
+	s := store.(*sqlStore)
+	secrets := make(map[string]string)
+	for _, source := range []struct{ table, keyColumn, column string }{
+		{"servers", "interface", "private_key"},
+		{"clients", "id", "private_key"},
+		{"clients", "id", "preshared_key"},
+	} {
+		rows, err := s.query(`SELECT ` + source.keyColumn + `, ` + source.column + ` FROM ` + source.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var key, value string
+			if err = rows.Scan(&key, &value); err != nil {
+				t.Fatal(err)
+			}
+			secrets[source.table+" "+key+" "+source.column] = value
+		}
+		rows.Close()
+	}
+	return secrets
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c := testCipher(t, 1)
+	plain := "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	first, err := c.encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, secretPrefix+c.KeyID()+":") || strings.Contains(first, plain) {
+		t.Errorf("encrypted value %s, want the prefix %s%s: and no plaintext", first, secretPrefix, c.KeyID())
+	}
+	if first == second {
+		t.Error("the same value encrypted twice alike, want a new data key and nonce every time")
+	}
+	for _, value := range []string{first, second} {
+		decrypted, err := c.decrypt(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != plain {
+			t.Errorf("decrypted %q, want %q", decrypted, plain)
+		}
+	}
+}
+
+func TestCipherWrongKey(t *testing.T) {
+	c := testCipher(t, 1)
+	value, err := c.encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testCipher(t, 2).decrypt(value); err == nil || !strings.Contains(err.Error(), c.KeyID()) {
+		t.Errorf("decryption with another master key: %v, want an error naming master key %s", err, c.KeyID())
+	}
+	// a master key of the same id, the wrapped data key does not open
+	other := testCipher(t, 2)
+	other.id = c.id
+	if plain, err := other.decrypt(value); err == nil {
+		t.Errorf("decrypted %q with a wrong master key", plain)
+	}
+
+	parts := strings.Split(value, ":")
+	sealed, _ := base64.RawStdEncoding.DecodeString(parts[len(parts)-1])
+	sealed[len(sealed)-1] ^= 1
+	parts[len(parts)-1] = base64.RawStdEncoding.EncodeToString(sealed)
+	for name, corrupted := range map[string]string{
+		"tampered":   strings.Join(parts, ":"),
+		"malformed":  secretPrefix + c.KeyID() + ":abc",
+		"too short":  secretPrefix + c.KeyID() + ":AAAA:AAAA",
+		"not base64": secretPrefix + c.KeyID() + ":!!:!!",
+	} {
+		if plain, err := c.decrypt(corrupted); err == nil {
+			t.Errorf("%s value decrypted to %q", name, plain)
+		}
+	}
+}
+
+func TestNewCipherKeyLength(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 16)); err == nil {
+		t.Error("cipher of a 16 bytes master key created")
+	}
+	if _, err := ParseMasterKey(base64.StdEncoding.EncodeToString(make([]byte, 31))); err == nil {
+		t.Error("31 bytes master key parsed")
+	}
+	key, err := ParseMasterKey(" " + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)) + "\n")
+	if err != nil || !bytes.Equal(key, bytes.Repeat([]byte{7}, 32)) {
+		t.Errorf("master key parsed as %v %v", key, err)
+	}
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := &model.Server{Interface: "wg0", Address: []string{"10.0.0.1/24"}, PrivateKey: "server-private"}
+		clients := []*model.Client{
+			{Id: "c1", Interface: "wg0", Name: "laptop", PrivateKey: "c1-private", PresharedKey: "c1-preshared"},
+			// a client with its own key has no private key stored
+			{Id: "c2", Interface: "wg0", Name: "phone", PresharedKey: "c2-preshared"},
+		}
+		if err := store.SaveServer(server); err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range clients {
+			if err := store.SaveClient(client); err != nil {
+				t.Fatal(err)
+			}
+		}
+		plaintext := storedSecrets(t, store)
+
+		// loadsWith check the keys load in plaintext from a store with cipher c
+		loadsWith := func(c *Cipher) error {
+			s := withCipher(store, c)
+			loaded, err := s.LoadServer("wg0")
+			if err != nil {
+				return err
+			}
+			if loaded.PrivateKey != server.PrivateKey {
+				t.Errorf("server private key loaded as %q", loaded.PrivateKey)
+			}
+			for _, client := range clients {
+				loaded, err := s.LoadClient(client.Id)
+				if err != nil {
+					return err
+				}
+				if loaded.PrivateKey != client.PrivateKey || loaded.PresharedKey != client.PresharedKey {
+					t.Errorf("client %s keys loaded as %q %q", client.Id, loaded.PrivateKey, loaded.PresharedKey)
+				}
+			}
+			return nil
+		}
+		// encryptedWith check every key is stored encrypted with c, and empty keys stay empty
+		encryptedWith := func(c *Cipher) {
+			t.Helper()
+			for name, value := range storedSecrets(t, store) {
+				if plaintext[name] == "" {
+					if value != "" {
+						t.Errorf("%s stored as %q, want it empty", name, value)
+					}
+					continue
+				}
+				if !strings.HasPrefix(value, secretPrefix+c.KeyID()+":") {
+					t.Errorf("%s stored as %q, want it encrypted with master key %s", name, value, c.KeyID())
+				}
+			}
+		}
+
+		// plaintext keys of a previous release are encrypted
+		old := testCipher(t, 1)
+		count, err := store.ReencryptSecrets(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 4 {
+			t.Errorf("%d keys encrypted, want 4", count)
+		}
+		encryptedWith(old)
+		if err = loadsWith(old); err != nil {
+			t.Fatal(err)
+		}
+		// the store encrypts with the key from then on
+		clients[1].PresharedKey = "c2-preshared-new"
+		if err = store.SaveClient(clients[1]); err != nil {
+			t.Fatal(err)
+		}
+		encryptedWith(old)
+		if err = loadsWith(old); err != nil {
+			t.Fatal(err)
+		}
+
+		// rotation of the master key
+		next := testCipher(t, 2)
+		if count, err = withCipher(store, old).ReencryptSecrets(next); err != nil {
+			t.Fatal(err)
+		}
+		if count != 4 {
+			t.Errorf("%d keys re-encrypted, want 4", count)
+		}
+		encryptedWith(next)
+		if err = loadsWith(next); err != nil {
+			t.Fatal(err)
+		}
+		if err = loadsWith(old); err == nil {
+			t.Error("keys load with the previous master key after the rotation")
+		}
+		if err = loadsWith(nil); !errors.Is(err, ErrNoEncryptionKey) {
+			t.Errorf("keys loaded without a master key: %v, want ErrNoEncryptionKey", err)
+		}
+		if count, err = withCipher(store, next).ReencryptSecrets(next); err != nil || count != 0 {
+			t.Errorf("%d keys re-encrypted with the master key they are encrypted with, %v", count, err)
+		}
+
+		// a store with the wrong master key fails and changes nothing
+		before := storedSecrets(t, store)
+		if _, err = withCipher(store, old).ReencryptSecrets(testCipher(t, 3)); err == nil {
+			t.Error("keys re-encrypted by a store with the wrong master key")
+		}
+		if _, err = withCipher(store, nil).ReencryptSecrets(nil); !errors.Is(err, ErrNoEncryptionKey) {
+			t.Errorf("keys decrypted without a master key: %v, want ErrNoEncryptionKey", err)
+		}
+		for name, value := range storedSecrets(t, store) {
+			if value != before[name] {
+				t.Errorf("%s changed by a failed re-encryption", name)
+			}
+		}
+
+		// --decrypt-keys stores them in plaintext again
+		decrypting := withCipher(store, next)
+		if count, err = decrypting.ReencryptSecrets(nil); err != nil {
+			t.Fatal(err)
+		}
+		if count != 4 {
+			t.Errorf("%d keys decrypted, want 4", count)
+		}
+		for name, value := range storedSecrets(t, store) {
+			if strings.HasPrefix(value, secretPrefix) {
+				t.Errorf("%s stored encrypted after the decryption", name)
+			}
+		}
+		if err = loadsWith(nil); err != nil {
+			t.Fatal(err)
+		}
+		// the decrypting store keeps the keys in plaintext from then on
+		if err = decrypting.SaveClient(clients[0]); err != nil {
+			t.Fatal(err)
+		}
+		if value := storedSecrets(t, store)["clients c1 private_key"]; value != clients[0].PrivateKey {
+			t.Errorf("private key saved as %q after the decryption, want it in plaintext", value)
+		}
+	})
+}
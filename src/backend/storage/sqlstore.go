@@ -0,0 +1,548 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+	"wg-gen-plus/model"
+
+	uuid "github.com/gofrs/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SaveInterface registers a WireGuard interface, known interfaces are left untouched
+func (s *sqlStore) SaveInterface(i *model.Interface) error {
+	_, err := s.exec(`INSERT INTO interfaces (name, created) VALUES (?, ?) ON CONFLICT(name) DO NOTHING`,
+		i.Name, i.Created.Format(time.RFC3339))
+	return err
+}
+
+// LoadAllInterfaces loads all registered WireGuard interfaces
+func (s *sqlStore) LoadAllInterfaces() ([]*model.Interface, error) {
+	rows, err := s.query(`SELECT name, created FROM interfaces ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	interfaces := []*model.Interface{}
+	for rows.Next() {
+		var i model.Interface
+		var createdStr string
+		if err := rows.Scan(&i.Name, &createdStr); err != nil {
+			return nil, err
+		}
+		i.Created, _ = time.Parse(time.RFC3339, createdStr)
+		interfaces = append(interfaces, &i)
+	}
+	return interfaces, rows.Err()
+}
+
+// SaveClient saves a client to the database
+func (s *sqlStore) SaveClient(c *model.Client) error {
+	// Encode slices as JSON
+	allowedIPsJSON, _ := json.Marshal(c.AllowedIPs)
+	addressJSON, _ := json.Marshal(c.Address)
+	tagsJSON, _ := json.Marshal(c.Tags)
+	lanIPsJSON, _ := json.Marshal(c.LANIPs)
+	privateKey, err := s.encryptSecret(c.PrivateKey)
+	if err != nil {
+		return err
+	}
+	presharedKey, err := s.encryptSecret(c.PresharedKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(`
+    INSERT INTO clients (
+        id, interface, name, email, enable, site2site, ignore_persistent_keepalive, 
+        keepalive_disabled, keepalive_interval, use_remote_dns,
+        site2site_endpoint_options_enabled,
+        site2site_endpoint, site2site_endpoint_port, site2site_endpoint_listen_port,
+        lan_ips, table_name, preshared_key, allowed_ips, address, tags,
+        private_key, public_key, quota_bytes, quota_period, quota_action, quota_disabled, quota_reset_at,
+        not_before, expires_at, expiry_action, schedule_disabled, expiry_reminded, keys_rotated,
+        owner, created_by, updated_by, created, updated
+    )
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+        interface=excluded.interface,
+        name=excluded.name,
+        email=excluded.email,
+        enable=excluded.enable,
+        site2site=excluded.site2site,
+        ignore_persistent_keepalive=excluded.ignore_persistent_keepalive,
+        keepalive_disabled=excluded.keepalive_disabled,
+        keepalive_interval=excluded.keepalive_interval,
+        use_remote_dns=excluded.use_remote_dns,
+        site2site_endpoint_options_enabled=excluded.site2site_endpoint_options_enabled,
+        site2site_endpoint=excluded.site2site_endpoint,
+        site2site_endpoint_port=excluded.site2site_endpoint_port,
+        site2site_endpoint_listen_port=excluded.site2site_endpoint_listen_port,
+        lan_ips=excluded.lan_ips,
+        table_name=excluded.table_name,
+        preshared_key=excluded.preshared_key,
+        allowed_ips=excluded.allowed_ips,
+        address=excluded.address,
+        tags=excluded.tags,
+        private_key=excluded.private_key,
+        public_key=excluded.public_key,
+        quota_bytes=excluded.quota_bytes,
+        quota_period=excluded.quota_period,
+        quota_action=excluded.quota_action,
+        quota_disabled=excluded.quota_disabled,
+        quota_reset_at=excluded.quota_reset_at,
+        not_before=excluded.not_before,
+        expires_at=excluded.expires_at,
+        expiry_action=excluded.expiry_action,
+        schedule_disabled=excluded.schedule_disabled,
+        expiry_reminded=excluded.expiry_reminded,
+        keys_rotated=excluded.keys_rotated,
+        owner=excluded.owner,
+        created_by=excluded.created_by,
+        updated_by=excluded.updated_by,
+        created=excluded.created,
+        updated=excluded.updated
+`, c.Id, c.Interface, c.Name, c.Email, boolToInt(c.Enable), boolToInt(c.Site2Site),
+		boolToInt(c.IgnorePersistentKeepalive), boolToInt(c.KeepaliveDisabled), c.KeepaliveInterval,
+		boolToInt(c.UseRemoteDNS), boolToInt(c.Site2SiteEndpointOptionsEnabled),
+		c.Site2SiteEndpoint, c.Site2SiteEndpointPort, c.Site2SiteEndpointListenPort,
+		string(lanIPsJSON),
+		c.Table, presharedKey, string(allowedIPsJSON),
+		string(addressJSON), string(tagsJSON),
+		privateKey, c.PublicKey, c.QuotaBytes, c.QuotaPeriod, c.QuotaAction, boolToInt(c.QuotaDisabled),
+		c.QuotaResetAt.Format(time.RFC3339), c.NotBefore.Format(time.RFC3339), c.ExpiresAt.Format(time.RFC3339),
+		c.ExpiryAction, boolToInt(c.ScheduleDisabled), boolToInt(c.ExpiryReminded), c.KeysRotated.Format(time.RFC3339),
+		c.Owner, c.CreatedBy, c.UpdatedBy,
+		c.Created.Format(time.RFC3339), c.Updated.Format(time.RFC3339))
+
+	return err
+}
+
+const clientColumns = `
+        id, interface, name, email, enable, site2site, ignore_persistent_keepalive, 
+        keepalive_disabled, keepalive_interval, use_remote_dns,
+        site2site_endpoint_options_enabled,
+        site2site_endpoint, site2site_endpoint_port, site2site_endpoint_listen_port,
+        lan_ips, table_name, preshared_key, allowed_ips, address, tags,
+        private_key, public_key, quota_bytes, quota_period, quota_action, quota_disabled, quota_reset_at,
+        not_before, expires_at, expiry_action, schedule_disabled, expiry_reminded, keys_rotated,
+        owner, created_by, updated_by, created, updated`
+
+// LoadClient loads a client by id
+func (s *sqlStore) LoadClient(id string) (*model.Client, error) {
+	row := s.queryRow(`SELECT `+clientColumns+`
+    FROM clients WHERE id = ?`, id)
+
+	c, err := scanClient(row)
+	if err != nil {
+		return nil, err
+	}
+	return c, s.decryptClient(c)
+}
+
+// DeleteClient deletes a client by id, with its traffic history
+func (s *sqlStore) DeleteClient(id string) error {
+	_, err := s.exec("DELETE FROM clients WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec("DELETE FROM traffic WHERE client_id = ?", id)
+	return err
+}
+
+// LoadAllClients loads all clients of interface iface from the database, or the clients of every interface when iface is empty
+func (s *sqlStore) LoadAllClients(iface string) ([]*model.Client, error) {
+	query := `SELECT ` + clientColumns + `
+    FROM clients`
+	args := []interface{}{}
+	if iface != "" {
+		query += ` WHERE interface = ?`
+		args = append(args, iface)
+	}
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*model.Client{}
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err = s.decryptClient(c); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
+
+func scanClient(row rowScanner) (*model.Client, error) {
+	var c model.Client
+	var allowedIPsJSON, addressJSON, tagsJSON, lanIPsJSON string
+	var quotaResetAtStr, notBeforeStr, expiresAtStr, keysRotatedStr, createdStr, updatedStr string
+	var enableInt, site2siteInt, ignorePKInt, keepaliveDisabledInt, useRemoteDNSInt, endpointOptionsEnabledInt int
+	var quotaDisabledInt, scheduleDisabledInt, expiryRemindedInt int
+
+	err := row.Scan(
+		&c.Id, &c.Interface, &c.Name, &c.Email, &enableInt, &site2siteInt, &ignorePKInt,
+		&keepaliveDisabledInt, &c.KeepaliveInterval, &useRemoteDNSInt,
+		&endpointOptionsEnabledInt,
+		&c.Site2SiteEndpoint, &c.Site2SiteEndpointPort, &c.Site2SiteEndpointListenPort,
+		&lanIPsJSON, &c.Table, &c.PresharedKey, &allowedIPsJSON, &addressJSON, &tagsJSON,
+		&c.PrivateKey, &c.PublicKey, &c.QuotaBytes, &c.QuotaPeriod, &c.QuotaAction, &quotaDisabledInt,
+		&quotaResetAtStr, &notBeforeStr, &expiresAtStr, &c.ExpiryAction, &scheduleDisabledInt, &expiryRemindedInt,
+		&keysRotatedStr, &c.Owner, &c.CreatedBy, &c.UpdatedBy, &createdStr, &updatedStr,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert integers to booleans
+	c.Enable = enableInt != 0
+	c.Site2Site = site2siteInt != 0
+	c.IgnorePersistentKeepalive = ignorePKInt != 0
+	c.KeepaliveDisabled = keepaliveDisabledInt != 0
+	c.UseRemoteDNS = useRemoteDNSInt != 0
+	c.Site2SiteEndpointOptionsEnabled = endpointOptionsEnabledInt != 0
+	c.QuotaDisabled = quotaDisabledInt != 0
+	c.ScheduleDisabled = scheduleDisabledInt != 0
+	c.ExpiryReminded = expiryRemindedInt != 0
+
+	// Unmarshal JSON strings to slices
+	_ = json.Unmarshal([]byte(allowedIPsJSON), &c.AllowedIPs)
+	_ = json.Unmarshal([]byte(addressJSON), &c.Address)
+	_ = json.Unmarshal([]byte(tagsJSON), &c.Tags)
+	_ = json.Unmarshal([]byte(lanIPsJSON), &c.LANIPs)
+
+	// Parse timestamps
+	c.QuotaResetAt, _ = time.Parse(time.RFC3339, quotaResetAtStr)
+	c.NotBefore, _ = time.Parse(time.RFC3339, notBeforeStr)
+	c.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAtStr)
+	c.KeysRotated, _ = time.Parse(time.RFC3339, keysRotatedStr)
+	c.Created, _ = time.Parse(time.RFC3339, createdStr)
+	c.Updated, _ = time.Parse(time.RFC3339, updatedStr)
+
+	return &c, nil
+}
+
+// SaveServer saves the server config of its interface to the database
+func (s *sqlStore) SaveServer(server *model.Server) error {
+	addressJSON, _ := json.Marshal(server.Address)
+	dnsJSON, _ := json.Marshal(server.Dns)
+	allowedIPsJSON, _ := json.Marshal(server.AllowedIPs)
+	privateKey, err := s.encryptSecret(server.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(`
+    INSERT INTO servers (
+        interface, address, listen_port, mtu, private_key, public_key, endpoint,
+        persistent_keepalive, dns, allowed_ips, table_name, keys_rotated, updated_by, created, updated
+    ) VALUES (
+        ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+    )
+    ON CONFLICT(interface) DO UPDATE SET
+        address=excluded.address,
+        listen_port=excluded.listen_port,
+        mtu=excluded.mtu,
+        private_key=excluded.private_key,
+        public_key=excluded.public_key,
+        endpoint=excluded.endpoint,
+        persistent_keepalive=excluded.persistent_keepalive,
+        dns=excluded.dns,
+        allowed_ips=excluded.allowed_ips,
+        table_name=excluded.table_name,
+        keys_rotated=excluded.keys_rotated,
+        updated_by=excluded.updated_by,
+        created=excluded.created,
+        updated=excluded.updated
+    `, server.Interface, string(addressJSON), server.ListenPort, server.Mtu, privateKey, server.PublicKey, server.Endpoint,
+		server.PersistentKeepalive, string(dnsJSON), string(allowedIPsJSON),
+		server.Table, server.KeysRotated.Format(time.RFC3339), server.UpdatedBy,
+		server.Created.Format(time.RFC3339), server.Updated.Format(time.RFC3339))
+	return err
+}
+
+// LoadServer loads the server config of interface iface from the database
+func (s *sqlStore) LoadServer(iface string) (*model.Server, error) {
+	row := s.queryRow(`SELECT
+        interface, address, listen_port, mtu, private_key, public_key, endpoint,
+        persistent_keepalive, dns, allowed_ips, COALESCE(table_name, ''), keys_rotated, updated_by, created, updated
+        FROM servers WHERE interface = ?`, iface)
+	server, err := scanServer(row)
+	if err != nil {
+		return nil, err
+	}
+	if server.PrivateKey, err = s.decryptSecret(server.PrivateKey); err != nil {
+		return nil, fmt.Errorf("server %s private key: %w", iface, err)
+	}
+	return server, nil
+}
+
+func scanServer(row rowScanner) (*model.Server, error) {
+	var server model.Server
+	var addressJSON, dnsJSON, allowedIPsJSON string
+	var keysRotatedStr, createdStr, updatedStr string
+
+	err := row.Scan(
+		&server.Interface, &addressJSON, &server.ListenPort, &server.Mtu, &server.PrivateKey, &server.PublicKey, &server.Endpoint,
+		&server.PersistentKeepalive, &dnsJSON, &allowedIPsJSON, &server.Table, &keysRotatedStr, &server.UpdatedBy,
+		&createdStr, &updatedStr,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(addressJSON), &server.Address)
+	_ = json.Unmarshal([]byte(dnsJSON), &server.Dns)
+	_ = json.Unmarshal([]byte(allowedIPsJSON), &server.AllowedIPs)
+	server.KeysRotated, _ = time.Parse(time.RFC3339, keysRotatedStr)
+	server.Created, _ = time.Parse(time.RFC3339, createdStr)
+	server.Updated, _ = time.Parse(time.RFC3339, updatedStr)
+	return &server, nil
+}
+
+// SaveUser creates or updates a user in the database
+func (s *sqlStore) SaveUser(user *model.User) error {
+	_, err := s.exec(`
+        INSERT INTO users (id, name, email, password, is_admin, self_service, device_quota)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(id) DO UPDATE SET
+            name=excluded.name,
+            email=excluded.email,
+            password=excluded.password,
+            is_admin=excluded.is_admin,
+            self_service=excluded.self_service,
+            device_quota=excluded.device_quota
+    `, user.Sub, user.Name, user.Email, user.Password, boolToInt(user.IsAdmin),
+		boolToInt(user.SelfService), user.DeviceQuota)
+
+	return err
+}
+
+// LoadUser retrieves a user by their ID
+func (s *sqlStore) LoadUser(id string) (*model.User, error) {
+	row := s.queryRow(`
+        SELECT id, name, email, password, is_admin, self_service, device_quota
+        FROM users
+        WHERE id = ?
+    `, id)
+
+	return scanUser(row)
+}
+
+// LockUser see Store
+func (s *sqlStore) LockUser(id string) error {
+	if !s.dialect.rowLocks {
+		return nil
+	}
+	rows, err := s.query(`SELECT id FROM users WHERE id = ? FOR UPDATE`, id)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// LoadAllUsers retrieves all users from the database
+func (s *sqlStore) LoadAllUsers() ([]*model.User, error) {
+	rows, err := s.query(`
+        SELECT id, name, email, password, is_admin, self_service, device_quota
+        FROM users
+        ORDER BY name
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*model.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func scanUser(row rowScanner) (*model.User, error) {
+	var user model.User
+	var isAdminInt, selfServiceInt int
+	err := row.Scan(&user.Sub, &user.Name, &user.Email, &user.Password, &isAdminInt, &selfServiceInt, &user.DeviceQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	user.IsAdmin = isAdminInt != 0
+	user.SelfService = selfServiceInt != 0
+
+	return &user, nil
+}
+
+// DeleteUser removes a user from the database
+func (s *sqlStore) DeleteUser(id string) error {
+	_, err := s.exec("DELETE FROM users WHERE id = ?", id)
+	return err
+}
+
+// SaveProfile creates or updates a client profile in the database
+func (s *sqlStore) SaveProfile(p *model.Profile) error {
+	allowedIPsJSON, _ := json.Marshal(p.AllowedIPs)
+
+	_, err := s.exec(`
+    INSERT INTO profiles (
+        id, interface, name, allowed_ips, use_remote_dns, created_by, updated_by, created, updated
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+        interface=excluded.interface,
+        name=excluded.name,
+        allowed_ips=excluded.allowed_ips,
+        use_remote_dns=excluded.use_remote_dns,
+        created_by=excluded.created_by,
+        updated_by=excluded.updated_by,
+        created=excluded.created,
+        updated=excluded.updated
+    `, p.Id, p.Interface, p.Name, string(allowedIPsJSON), boolToInt(p.UseRemoteDNS), p.CreatedBy, p.UpdatedBy,
+		p.Created.Format(time.RFC3339), p.Updated.Format(time.RFC3339))
+	return err
+}
+
+// LoadProfile loads a client profile by id
+func (s *sqlStore) LoadProfile(id string) (*model.Profile, error) {
+	row := s.queryRow(`SELECT
+        id, interface, name, allowed_ips, use_remote_dns, created_by, updated_by, created, updated
+        FROM profiles WHERE id = ?`, id)
+	return scanProfile(row)
+}
+
+// LoadAllProfiles loads all client profiles ordered by name
+func (s *sqlStore) LoadAllProfiles() ([]*model.Profile, error) {
+	rows, err := s.query(`SELECT
+        id, interface, name, allowed_ips, use_remote_dns, created_by, updated_by, created, updated
+        FROM profiles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []*model.Profile{}
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// DeleteProfile deletes a client profile by id
+func (s *sqlStore) DeleteProfile(id string) error {
+	_, err := s.exec("DELETE FROM profiles WHERE id = ?", id)
+	return err
+}
+
+// rowScanner common interface of *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProfile(row rowScanner) (*model.Profile, error) {
+	var p model.Profile
+	var allowedIPsJSON, createdStr, updatedStr string
+	var useRemoteDNSInt int
+
+	err := row.Scan(&p.Id, &p.Interface, &p.Name, &allowedIPsJSON, &useRemoteDNSInt, &p.CreatedBy, &p.UpdatedBy, &createdStr, &updatedStr)
+	if err != nil {
+		return nil, err
+	}
+	p.UseRemoteDNS = useRemoteDNSInt != 0
+	_ = json.Unmarshal([]byte(allowedIPsJSON), &p.AllowedIPs)
+	p.Created, _ = time.Parse(time.RFC3339, createdStr)
+	p.Updated, _ = time.Parse(time.RFC3339, updatedStr)
+	return &p, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// CreateDefaultAdminIfNeeded creates a default admin user if no users exist
+func CreateDefaultAdminIfNeeded(store Store) error {
+	// Check if any users exist
+	users, err := store.LoadAllUsers()
+	if err != nil {
+		return err
+	}
+
+	// If no users exist, create a default admin
+	if len(users) == 0 {
+		fmt.Println("No users found. Creating default admin user...")
+
+		// Generate a random password
+		password := generateRandomPassword(12)
+		fmt.Println("Generated admin password:", password)
+
+		// Hash password using bcrypt
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %v", err)
+		}
+
+		// Generate UUID for admin user
+		u, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("failed to generate UUID: %v", err)
+		}
+
+		// Create admin user
+		adminUser := &model.User{
+			Sub:      u.String(),
+			Name:     "admin",
+			Email:    "admin@example.com",
+			Password: string(hashedPassword),
+			IsAdmin:  true,
+		}
+
+		err = store.SaveUser(adminUser)
+		if err != nil {
+			return fmt.Errorf("failed to save admin user: %v", err)
+		}
+
+		fmt.Println("Default admin user created:")
+		fmt.Println("  Username: admin")
+		fmt.Println("  Password:", password)
+		fmt.Println("  Please change this password after logging in!")
+	}
+
+	return nil
+}
+
+// Helper function to generate a random password
+func generateRandomPassword(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
+	rand.Seed(time.Now().UnixNano()) // Seed the random number generator once
+
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[rand.Intn(len(charset))]
+	}
+	return string(b)
+}
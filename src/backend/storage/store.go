@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"wg-gen-plus/model"
+)
+
+// Store persistence of interfaces, servers, clients, users, profiles, address pools, the audit log, traffic history
+// and alerts
+type Store interface {
+	SaveInterface(i *model.Interface) error
+	LoadAllInterfaces() ([]*model.Interface, error)
+
+	SaveServer(server *model.Server) error
+	LoadServer(iface string) (*model.Server, error)
+
+	SaveClient(c *model.Client) error
+	LoadClient(id string) (*model.Client, error)
+	DeleteClient(id string) error
+	LoadAllClients(iface string) ([]*model.Client, error)
+
+	SaveUser(user *model.User) error
+	LoadUser(id string) (*model.User, error)
+	LoadAllUsers() ([]*model.User, error)
+	DeleteUser(id string) error
+	// LockUser holds user id until the end of the transaction, so concurrent transactions changing what the user
+	// owns run one after the other. SQLite has a single writing transaction at a time and needs no lock.
+	LockUser(id string) error
+
+	SaveProfile(p *model.Profile) error
+	LoadProfile(id string) (*model.Profile, error)
+	LoadAllProfiles() ([]*model.Profile, error)
+	DeleteProfile(id string) error
+
+	SaveIPPool(p *model.IPPool) error
+	LoadIPPool(id string) (*model.IPPool, error)
+	// LoadIPPools pools of interface iface ordered by name
+	LoadIPPools(iface string) ([]*model.IPPool, error)
+	DeleteIPPool(id string) error
+	SaveIPReservation(r *model.IPReservation) error
+	LoadIPReservation(id string) (*model.IPReservation, error)
+	// LoadIPReservations reservations of interface iface ordered by address
+	LoadIPReservations(iface string) ([]*model.IPReservation, error)
+	DeleteIPReservation(id string) error
+
+	// SaveAuditEntry appends to the audit log, which has no update nor delete
+	SaveAuditEntry(e *model.AuditEntry) error
+	// LoadAuditEntries entries matching filter, most recent first, and the number of matching entries
+	LoadAuditEntries(filter model.AuditFilter) ([]*model.AuditEntry, int, error)
+
+	SaveTrafficSample(sample *model.TrafficSample) error
+	// LoadTrafficSamples samples matching filter, oldest first
+	LoadTrafficSamples(filter model.TrafficFilter) ([]*model.TrafficSample, error)
+	// LoadLastTrafficSamples most recent sample of every client
+	LoadLastTrafficSamples() ([]*model.TrafficSample, error)
+	DeleteTrafficSamples(filter model.TrafficFilter) error
+
+	SaveAlertRule(r *model.AlertRule) error
+	LoadAlertRule(id string) (*model.AlertRule, error)
+	LoadAllAlertRules() ([]*model.AlertRule, error)
+	DeleteAlertRule(id string) error
+	SaveAlert(a *model.Alert) error
+	LoadAlert(id string) (*model.Alert, error)
+	// LoadAlerts alerts matching filter, most recently fired first, and the number of matching alerts
+	LoadAlerts(filter model.AlertFilter) ([]*model.Alert, int, error)
+
+	// ReencryptSecrets encrypts the private and preshared keys of every server and client with next, or stores them
+	// in plaintext for a nil next, and returns how many keys changed. Keys already encrypted with next are left
+	// as they are, so it also encrypts the keys stored before encryption was enabled. The store uses next from then on.
+	ReencryptSecrets(next *Cipher) (int, error)
+
+	// WithTx runs fn with a Store bound to a transaction, committed when fn returns nil and rolled back otherwise.
+	// Nested calls join the outer transaction.
+	WithTx(fn func(tx Store) error) error
+	// OnRollback registers fn to run once the transaction of the store is rolled back or fails to commit, to undo
+	// what was changed outside of the database along with it. Functions run in reverse order of registration.
+	// Outside of a transaction fn never runs.
+	OnRollback(fn func())
+
+	// SchemaVersion version of the last applied migration, 0 for an empty database
+	SchemaVersion() (int, error)
+	// Migrate applies the pending migrations in order and returns them, see Migration
+	Migrate(dryRun bool) ([]Migration, error)
+
+	Close() error
+}
+
+// sortableTimeLayout fixed width UTC timestamps, for text columns that are sorted and filtered on
+const sortableTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// dialect SQL differences between the supported databases
+type dialect struct {
+	driver string
+	// numberedParams placeholders are $1, $2... instead of ?
+	numberedParams bool
+	// schemaVersionTableQuery counts the schema_version tables, to tell an empty database
+	schemaVersionTableQuery string
+	// rowLocks rows are locked with SELECT ... FOR UPDATE
+	rowLocks bool
+}
+
+// Open the database of type dbType (sqlite or postgres), dsn is the SQLite file or the PostgreSQL connection string.
+// The keys of servers and clients are encrypted with secrets, nil stores them in plaintext.
+func Open(dbType string, dsn string, secrets *Cipher) (Store, error) {
+	var d dialect
+	switch dbType {
+	case "", "sqlite":
+		d = sqliteDialect
+	case "postgres":
+		d = postgresDialect
+	default:
+		return nil, fmt.Errorf("unsupported database type %s", dbType)
+	}
+
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, q: db, dialect: d, cipher: secrets}, nil
+}
+
+// querier common interface of *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlStore Store over database/sql, queries are written with ? placeholders and rebound per dialect
+type sqlStore struct {
+	db      *sql.DB
+	q       querier // db, or the transaction of a store handed out by WithTx
+	inTx    bool
+	dialect dialect
+	// cipher of the keys, nil when they are stored in plaintext
+	cipher *Cipher
+	// rollbacks functions to run when the transaction is rolled back, see OnRollback
+	rollbacks *[]func()
+}
+
+// WithTx runs fn in a transaction, see Store
+func (s *sqlStore) WithTx(fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	rollbacks := make([]func(), 0)
+	err = fn(&sqlStore{db: s.db, q: tx, inTx: true, dialect: s.dialect, cipher: s.cipher, rollbacks: &rollbacks})
+	if err != nil {
+		tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+	if err != nil {
+		for i := len(rollbacks) - 1; i >= 0; i-- {
+			rollbacks[i]()
+		}
+	}
+	return err
+}
+
+// OnRollback see Store
+func (s *sqlStore) OnRollback(fn func()) {
+	if s.inTx {
+		*s.rollbacks = append(*s.rollbacks, fn)
+	}
+}
+
+// Close the database
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.q.Exec(s.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.q.Query(s.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.q.QueryRow(s.rebind(query), args...)
+}
+
+// rebind replace ? placeholders outside of string literals for dialects with numbered parameters
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numberedParams {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
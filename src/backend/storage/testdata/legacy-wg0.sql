@@ -0,0 +1,72 @@
+-- single interface database of releases before schema versioning, imported with ImportLegacyDatabase
+CREATE TABLE clients (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	email TEXT,
+	enable INTEGER,
+	site2site INTEGER,
+	ignore_persistent_keepalive INTEGER,
+	keepalive_disabled INTEGER,
+	keepalive_interval INTEGER,
+	use_remote_dns INTEGER,
+	site2site_endpoint_options_enabled INTEGER,
+	site2site_endpoint TEXT,
+	site2site_endpoint_port INTEGER,
+	site2site_endpoint_listen_port INTEGER,
+	lan_ips TEXT,
+	table_name TEXT,
+	preshared_key TEXT,
+	allowed_ips TEXT,
+	address TEXT,
+	tags TEXT,
+	private_key TEXT,
+	public_key TEXT,
+	created_by TEXT,
+	updated_by TEXT,
+	created TEXT,
+	updated TEXT
+);
+CREATE TABLE server (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	address TEXT,
+	listen_port INTEGER,
+	mtu INTEGER,
+	private_key TEXT,
+	public_key TEXT,
+	endpoint TEXT,
+	persistent_keepalive INTEGER,
+	dns TEXT,
+	allowed_ips TEXT,
+	table_name TEXT,
+	updated_by TEXT,
+	created TEXT,
+	updated TEXT
+);
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	email TEXT,
+	password TEXT,
+	is_admin INTEGER
+);
+
+INSERT INTO server VALUES (1, '["10.0.0.1/24"]', 51820, 1420,
+	'AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=', 'pOCSkrZRwni5dyxWn1+puxPZBrRqtoyd+dwrRAn4ogk=',
+	'vpn.example.com:51820', 25, '["10.0.0.1"]', '["0.0.0.0/0"]', NULL, 'admin',
+	'2023-05-01T10:00:00Z', '2023-06-01T10:00:00Z');
+
+INSERT INTO users VALUES ('11111111-1111-1111-1111-111111111111', 'admin', 'admin@example.com', '$2a$10$legacyhashlegacyhashlegacyhashlegacyhashlegacyhashlega', 1);
+INSERT INTO users VALUES ('22222222-2222-2222-2222-222222222222', 'Alice', 'alice@example.com', '$2a$10$legacyhashlegacyhashlegacyhashlegacyhashlegacyhashlegb', 0);
+
+INSERT INTO clients VALUES ('c1', 'laptop', 'alice@example.com', 1, 0, 0, 0, 0, 1, 0, '', 0, 0, 'null', '',
+	'ZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmY=', '["0.0.0.0/0"]', '["10.0.0.2/32"]', '["laptops"]',
+	'AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=', 'zo060cy2M+x7cMF4FKXHbs0CloUFDTRHRboFhw5YfVk=',
+	'alice', 'alice', '2023-05-02T10:00:00Z', '2023-05-03T10:00:00Z');
+INSERT INTO clients VALUES ('c2', 'office', '', 1, 1, 1, 0, 15, 0, 1, 'office.example.com', 443, 51821,
+	'["192.168.1.0/24"]', 'off', 'aGhoaGhoaGhoaGhoaGhoaGhoaGhoaGhoaGhoaGhoaGg=', '["10.0.0.0/24"]', '["10.0.0.3/32"]', '[]',
+	'BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ=', 'rAGyIJ6GNU+4UyN7XeD0+rE8f8v0M6YcAZNpYX/s8Qs=',
+	'admin', 'admin', '2023-05-04T10:00:00Z', '2023-05-04T10:00:00Z');
+INSERT INTO clients VALUES ('c3', 'printer', '', 0, 0, 0, 1, 0, 0, 0, '', 0, 0, 'null', '',
+	'aWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWk=', '["10.0.0.4/32"]', '["10.0.0.4/32"]', 'null',
+	'BQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQU=', 'UKYUCbHd0DJemxa3AOcZ6XcsBwALG9d4bpB8ZT0gSV0=',
+	'bob', 'bob', '2023-05-05T10:00:00Z', '2023-05-05T10:00:00Z');
@@ -0,0 +1,107 @@
+-- database at schema version 1, the first versioned release, upgraded with Migrate
+CREATE TABLE IF NOT EXISTS interfaces (
+	name TEXT PRIMARY KEY,
+	created TEXT
+);
+CREATE TABLE IF NOT EXISTS clients (
+	id TEXT PRIMARY KEY,
+	interface TEXT NOT NULL DEFAULT '',
+	name TEXT,
+	email TEXT,
+	enable INTEGER,
+	site2site INTEGER,
+	ignore_persistent_keepalive INTEGER,
+	keepalive_disabled INTEGER,
+	keepalive_interval INTEGER,
+	use_remote_dns INTEGER,
+	site2site_endpoint_options_enabled INTEGER,
+	site2site_endpoint TEXT,
+	site2site_endpoint_port INTEGER,
+	site2site_endpoint_listen_port INTEGER,
+	lan_ips TEXT,
+	table_name TEXT,
+	preshared_key TEXT,
+	allowed_ips TEXT,
+	address TEXT,
+	tags TEXT,
+	private_key TEXT,
+	public_key TEXT,
+	created_by TEXT,
+	updated_by TEXT,
+	created TEXT,
+	updated TEXT
+);
+CREATE INDEX IF NOT EXISTS clients_interface ON clients (interface);
+CREATE TABLE IF NOT EXISTS servers (
+	interface TEXT PRIMARY KEY,
+	address TEXT,
+	listen_port INTEGER,
+	mtu INTEGER,
+	private_key TEXT,
+	public_key TEXT,
+	endpoint TEXT,
+	persistent_keepalive INTEGER,
+	dns TEXT,
+	allowed_ips TEXT,
+	table_name TEXT,
+	updated_by TEXT,
+	created TEXT,
+	updated TEXT
+);
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	email TEXT,
+	password TEXT,
+	is_admin INTEGER,
+	self_service INTEGER NOT NULL DEFAULT 0,
+	device_quota INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS profiles (
+	id TEXT PRIMARY KEY,
+	interface TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL UNIQUE,
+	allowed_ips TEXT,
+	use_remote_dns INTEGER,
+	created_by TEXT,
+	updated_by TEXT,
+	created TEXT,
+	updated TEXT
+);
+CREATE TABLE schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT,
+	applied TEXT
+);
+INSERT INTO schema_version VALUES (1, 'interfaces, servers, clients, users and profiles', '2024-01-01T00:00:00Z');
+
+INSERT INTO interfaces VALUES ('wg0', '2024-01-01T00:00:00Z');
+INSERT INTO interfaces VALUES ('wg1', '2024-01-01T00:00:00Z');
+
+INSERT INTO servers VALUES ('wg0', '["10.0.0.1/24"]', 51820, 1420,
+	'AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=', 'pOCSkrZRwni5dyxWn1+puxPZBrRqtoyd+dwrRAn4ogk=',
+	'vpn.example.com:51820', 25, '["10.0.0.1"]', '["0.0.0.0/0"]', 'off', 'admin',
+	'2024-01-01T00:00:00Z', '2024-01-02T00:00:00Z');
+INSERT INTO servers VALUES ('wg1', '["10.1.0.1/24"]', 51821, 0,
+	'AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=', 'zo060cy2M+x7cMF4FKXHbs0CloUFDTRHRboFhw5YfVk=',
+	'vpn.example.com:51821', 0, '[]', '["10.1.0.0/24"]', '', 'admin',
+	'2024-01-03T00:00:00Z', '2024-01-03T00:00:00Z');
+
+INSERT INTO users VALUES ('11111111-1111-1111-1111-111111111111', 'admin', 'admin@example.com', '$2a$10$fixturehashfixturehashfixturehashfixturehashfixturehas', 1, 0, 0);
+INSERT INTO users VALUES ('22222222-2222-2222-2222-222222222222', 'Alice', 'alice@example.com', '$2a$10$fixturehashfixturehashfixturehashfixturehashfixturehat', 0, 0, 0);
+INSERT INTO users VALUES ('33333333-3333-3333-3333-333333333333', 'carol', 'carol@example.com', '$2a$10$fixturehashfixturehashfixturehashfixturehashfixturehau', 0, 1, 2);
+
+INSERT INTO profiles VALUES ('p1', 'wg0', 'split tunnel', '["10.0.0.0/24"]', 1, 'admin', 'admin', '2024-01-04T00:00:00Z', '2024-01-04T00:00:00Z');
+
+INSERT INTO clients VALUES ('c1', 'wg0', 'laptop', 'alice@example.com', 1, 0, 0, 0, 0, 1, 0, '', 0, 0, 'null', '',
+	'ZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmZmY=', '["0.0.0.0/0"]', '["10.0.0.2/32"]', '["laptops"]',
+	'AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=', 'zo060cy2M+x7cMF4FKXHbs0CloUFDTRHRboFhw5YfVk=',
+	'ALICE', 'admin', '2024-01-05T00:00:00Z', '2024-01-06T00:00:00Z');
+INSERT INTO clients VALUES ('c2', 'wg0', 'office', '', 1, 1, 1, 0, 15, 0, 1, 'office.example.com', 443, 51821,
+	'["192.168.1.0/24"]', 'off', 'aGhoaGhoaGhoaGhoaGhoaGhoaGhoaGhoaGhoaGhoaGg=', '["10.0.0.0/24"]', '["10.0.0.3/32"]', '[]',
+	'BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ=', 'rAGyIJ6GNU+4UyN7XeD0+rE8f8v0M6YcAZNpYX/s8Qs=',
+	'admin', 'admin', '2024-01-07T00:00:00Z', '2024-01-07T00:00:00Z');
+INSERT INTO clients VALUES ('c3', 'wg1', 'phone', 'carol@example.com', 0, 0, 0, 1, 0, 1, 0, '', 0, 0, 'null', '',
+	'aWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWk=', '["10.1.0.0/24"]', '["10.1.0.2/32"]', 'null',
+	'BQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQU=', 'UKYUCbHd0DJemxa3AOcZ6XcsBwALG9d4bpB8ZT0gSV0=',
+	'ghost', 'ghost', '2024-01-08T00:00:00Z', '2024-01-08T00:00:00Z');
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"wg-gen-plus/model"
+)
+
+const trafficColumns = `client_id, created, period, received_bytes, transmitted_bytes, received_total, transmitted_total, last_handshake`
+
+// SaveTrafficSample appends a traffic sample
+func (s *sqlStore) SaveTrafficSample(t *model.TrafficSample) error {
+	_, err := s.exec(`
+    INSERT INTO traffic (`+trafficColumns+`)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, t.ClientId, t.Time.UTC().Format(sortableTimeLayout), t.Period, t.ReceivedBytes, t.TransmittedBytes,
+		t.ReceivedTotal, t.TransmittedTotal, t.LastHandshake.UTC().Format(sortableTimeLayout))
+	return err
+}
+
+// LoadTrafficSamples traffic samples matching filter, oldest first
+func (s *sqlStore) LoadTrafficSamples(filter model.TrafficFilter) ([]*model.TrafficSample, error) {
+	clause, args := trafficWhere(filter)
+	rows, err := s.query(`SELECT `+trafficColumns+` FROM traffic`+clause+` ORDER BY created, client_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrafficSamples(rows)
+}
+
+// LoadLastTrafficSamples most recent traffic sample of every client
+func (s *sqlStore) LoadLastTrafficSamples() ([]*model.TrafficSample, error) {
+	rows, err := s.query(`SELECT ` + trafficColumns + ` FROM traffic t
+    WHERE created = (SELECT MAX(created) FROM traffic WHERE client_id = t.client_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrafficSamples(rows)
+}
+
+// DeleteTrafficSamples deletes the traffic samples matching filter
+func (s *sqlStore) DeleteTrafficSamples(filter model.TrafficFilter) error {
+	clause, args := trafficWhere(filter)
+	_, err := s.exec(`DELETE FROM traffic`+clause, args...)
+	return err
+}
+
+func trafficWhere(filter model.TrafficFilter) (string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+	if filter.ClientId != "" {
+		where = append(where, "client_id = ?")
+		args = append(args, filter.ClientId)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, filter.From.UTC().Format(sortableTimeLayout))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, filter.To.UTC().Format(sortableTimeLayout))
+	}
+	if filter.MinPeriod > 0 {
+		where = append(where, "period >= ?")
+		args = append(args, filter.MinPeriod)
+	}
+	if filter.MaxPeriod > 0 {
+		where = append(where, "period <= ?")
+		args = append(args, filter.MaxPeriod)
+	}
+	if len(where) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func scanTrafficSamples(rows *sql.Rows) ([]*model.TrafficSample, error) {
+	samples := []*model.TrafficSample{}
+	for rows.Next() {
+		var t model.TrafficSample
+		var createdStr, handshakeStr string
+		err := rows.Scan(&t.ClientId, &createdStr, &t.Period, &t.ReceivedBytes, &t.TransmittedBytes,
+			&t.ReceivedTotal, &t.TransmittedTotal, &handshakeStr)
+		if err != nil {
+			return nil, err
+		}
+		t.Time, _ = time.Parse(sortableTimeLayout, createdStr)
+		t.LastHandshake, _ = time.Parse(sortableTimeLayout, handshakeStr)
+		samples = append(samples, &t)
+	}
+	return samples, rows.Err()
+}
@@ -6,7 +6,6 @@ import (
 	"strings"
 	"text/template"
 	"wg-gen-plus/model"
-	"wg-gen-plus/util"
 )
 
 var (
@@ -104,9 +103,11 @@ var (
                                                     <tr>
                                                         <th class="column-top" width="280" style="font-size:0pt; line-height:0pt; padding:0; margin:0; font-weight:normal; vertical-align:top;">
                                                             <table width="100%" border="0" cellspacing="0" cellpadding="0">
+                                                                {{ if .QrcodePngName -}}
                                                                 <tr>
                                                                     <td class="fluid-img" style="font-size:0pt; line-height:0pt; text-align:left;"><img src="cid:{{.QrcodePngName}}" width="280" height="210" border="0" alt="" /></td>
                                                                 </tr>
+                                                                {{ end -}}
                                                             </table>
                                                         </th>
                                                         <th class="column-empty2" width="30" style="font-size:0pt; line-height:0pt; padding:0; margin:0; font-weight:normal; vertical-align:top;"></th>
@@ -116,7 +117,7 @@ var (
                                                                     <td class="h4 pb20" style="color:#ffffff; font-family:'Muli', Arial,sans-serif; font-size:20px; line-height:28px; text-align:left; padding-bottom:20px;">Hello</td>
                                                                 </tr>
                                                                 <tr>
-                                                                    <td class="text pb20" style="color:#ffffff; font-family:Arial,sans-serif; font-size:14px; line-height:26px; text-align:left; padding-bottom:20px;">You probably requested VPN configuration. Here is <strong>{{.Client.Name}}</strong> configuration created <strong>{{.Client.Created.Format "Monday, 02 January 06 15:04:05 MST"}}</strong>. Scan the Qrcode or open attached configuration file in VPN client.</td>
+                                                                    <td class="text pb20" style="color:#ffffff; font-family:Arial,sans-serif; font-size:14px; line-height:26px; text-align:left; padding-bottom:20px;">You probably requested VPN configuration. Here is <strong>{{.Client.Name}}</strong> configuration created <strong>{{.Client.Created.Format "Monday, 02 January 06 15:04:05 MST"}}</strong>. {{ if .Client.HasOwnKey }}Replace &lt;insert&gt; in the attached configuration file with the private key of your device, then open it in VPN client.{{ else }}Scan the Qrcode or open attached configuration file in VPN client.{{ end }}</td>
                                                                 </tr>
                                                             </table>
                                                         </th>
@@ -198,7 +199,13 @@ var (
 
 	clientTpl = `[Interface]
 Address = {{ StringsJoin .Client.Address ", " }}
-PrivateKey = {{ .Client.PrivateKey }}
+{{ if .Client.HasSite2SiteEndpoint -}}
+ListenPort = {{ .Client.Site2SiteEndpointListenPort }}
+{{ end -}}
+PrivateKey = {{ if .Client.HasOwnKey }}<insert>{{ else }}{{ .Client.PrivateKey }}{{ end }}
+{{ if ne .Client.Table "" -}}
+Table = {{ .Client.Table }}
+{{ end -}}
 {{ if and (ne (len .Server.Dns) 0) .Client.UseRemoteDNS -}}
 DNS = {{ StringsJoin .Server.Dns ", " }}
 {{- end }}
@@ -208,10 +215,10 @@ MTU = {{.Server.Mtu}}
 [Peer]
 PublicKey = {{ .Server.PublicKey }}
 PresharedKey = {{ .Client.PresharedKey }}
-AllowedIPs = {{ StringsJoin .Client.AllowedIPs ", " }}
+AllowedIPs = {{ StringsJoin .AllowedIPs ", " }}
 Endpoint = {{ .Server.Endpoint }}
-{{ if and (ne .Server.PersistentKeepalive 0) (not .Client.IgnorePersistentKeepalive) -}}
-PersistentKeepalive = {{.Server.PersistentKeepalive}}
+{{ with .Client.PersistentKeepalive .Server.PersistentKeepalive -}}
+PersistentKeepalive = {{ . }}
 {{- end}}
 `
 
@@ -225,6 +232,9 @@ PrivateKey = {{ .Server.PrivateKey }}
 {{ if ne .Server.Mtu 0 -}}
 MTU = {{.Server.Mtu}}
 {{ end -}}
+{{ if ne .Server.Table "" -}}
+Table = {{ .Server.Table }}
+{{ end -}}
 {{ if ne .PreUpHook "" -}}
 PreUp = {{ .PreUpHook }}
 {{- end }}
@@ -245,28 +255,67 @@ PostDown = {{ .PostDownHook }}
 PublicKey = {{ .PublicKey }}
 PresharedKey = {{ .PresharedKey }}
 AllowedIPs = {{ StringsJoin (AppendStrings .Address .LANIPs) ", " }}
+{{- if .HasSite2SiteEndpoint }}
+Endpoint = {{ .Site2SiteEndpointAddress }}
+{{- with .PersistentKeepalive $.Server.PersistentKeepalive }}
+PersistentKeepalive = {{ . }}
+{{- end }}
+{{- end }}
 {{- end }}
 {{ end }}`
 )
 
-// DumpClientWg dump client wg config with go template
-func DumpClientWg(client *model.Client, server *model.Server) ([]byte, error) {
+// DumpClientWg dump client wg config with go template, clients are used to route the LANs of the other sites
+func DumpClientWg(client *model.Client, server *model.Server, clients []*model.Client) ([]byte, error) {
 	t, err := template.New("client").Funcs(template.FuncMap{"StringsJoin": strings.Join}).Parse(clientTpl)
 	if err != nil {
 		return nil, err
 	}
 
 	return dump(t, struct {
-		Client *model.Client
-		Server *model.Server
+		Client     *model.Client
+		Server     *model.Server
+		AllowedIPs []string
 	}{
-		Client: client,
-		Server: server,
+		Client:     client,
+		Server:     server,
+		AllowedIPs: clientAllowedIPs(client, clients),
 	})
 }
 
-// DumpServerWg dump server wg config with go template, write it to file and return bytes
-func DumpServerWg(clients []*model.Client, server *model.Server, preUpHook, postUpHook, preDownHook, postDownHook, WgConfigFile string) ([]byte, error) {
+// clientAllowedIPs client allowed IPs, site to site clients also get the LANs of the other enabled sites
+func clientAllowedIPs(client *model.Client, clients []*model.Client) []string {
+	allowedIPs := append([]string{}, client.AllowedIPs...)
+	if !client.Site2Site {
+		return allowedIPs
+	}
+
+	seen := make(map[string]bool)
+	for _, allowedIP := range allowedIPs {
+		seen[allowedIP] = true
+	}
+	// a site never routes its own LAN through the tunnel
+	for _, lanIP := range client.LANIPs {
+		seen[lanIP] = true
+	}
+
+	for _, site := range clients {
+		if site.Id == client.Id || !site.Site2Site || !site.Enable {
+			continue
+		}
+		for _, lanIP := range site.LANIPs {
+			if !seen[lanIP] {
+				seen[lanIP] = true
+				allowedIPs = append(allowedIPs, lanIP)
+			}
+		}
+	}
+
+	return allowedIPs
+}
+
+// DumpServerWg dump server wg config with go template
+func DumpServerWg(clients []*model.Client, server *model.Server, preUpHook, postUpHook, preDownHook, postDownHook string) ([]byte, error) {
 	// Create a copy of clients to avoid modifying the original slice
 	sortedClients := make([]*model.Client, len(clients))
 	copy(sortedClients, clients)
@@ -305,17 +354,6 @@ func DumpServerWg(clients []*model.Client, server *model.Server, preUpHook, post
 		return nil, err
 	}
 
-	err = util.WriteFile(WgConfigFile, configDataWg)
-	if err != nil {
-		return nil, err
-	}
-
-	// Reload the WireGuard configuration after writing the file
-	err = util.ReloadServerConfig()
-	if err != nil {
-		return nil, err
-	}
-
 	return configDataWg, nil
 }
 
@@ -0,0 +1,172 @@
+package template
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wg-gen-plus/model"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// update rewrite the golden files with the rendered configs: go test ./template -update
+var update = flag.Bool("update", false, "update the golden files")
+
+var created = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testKey fixed private key, so the golden files do not change between runs
+func testKey(seed byte) wgtypes.Key {
+	var key wgtypes.Key
+	for i := range key {
+		key[i] = seed
+	}
+	return key
+}
+
+func testServer() *model.Server {
+	return &model.Server{
+		Interface:           "wg0",
+		Address:             []string{"10.0.0.1/24", "fd00::1/64"},
+		ListenPort:          51820,
+		Mtu:                 1420,
+		PrivateKey:          testKey(1).String(),
+		PublicKey:           testKey(1).PublicKey().String(),
+		Endpoint:            "vpn.example.com:51820",
+		PersistentKeepalive: 25,
+		Dns:                 []string{"10.0.0.1"},
+		AllowedIPs:          []string{"0.0.0.0/0"},
+		Created:             created,
+		Updated:             created,
+	}
+}
+
+func testClient(name string, seed byte, address string) *model.Client {
+	return &model.Client{
+		Id:           name,
+		Interface:    "wg0",
+		Name:         name,
+		Email:        name + "@example.com",
+		Enable:       true,
+		UseRemoteDNS: true,
+		PrivateKey:   testKey(seed).String(),
+		PublicKey:    testKey(seed).PublicKey().String(),
+		PresharedKey: testKey(seed + 100).String(),
+		AllowedIPs:   []string{"0.0.0.0/0"},
+		Address:      []string{address},
+		Created:      created,
+		Updated:      created,
+	}
+}
+
+// testSite site to site client with LAN, the server connects to it when endpoint is set
+func testSite(name string, seed byte, address, lan, endpoint string) *model.Client {
+	client := testClient(name, seed, address)
+	client.Site2Site = true
+	client.UseRemoteDNS = false
+	client.AllowedIPs = []string{"10.0.0.0/24"}
+	client.LANIPs = []string{lan}
+	if endpoint != "" {
+		client.Site2SiteEndpointOptionsEnabled = true
+		client.Site2SiteEndpoint = endpoint
+		client.Site2SiteEndpointListenPort = 51821
+	}
+	return client
+}
+
+// checkGolden compare got with testdata/name, or write it with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestDumpServerWg(t *testing.T) {
+	server := testServer()
+	server.Table = "off"
+
+	disabled := testClient("disabled", 3, "10.0.0.3/32")
+	disabled.Enable = false
+	ownInterval := testSite("site-b", 5, "10.0.0.5/32", "192.168.2.0/24", "site-b.example.com")
+	ownInterval.Site2SiteEndpointPort = 443
+	ownInterval.IgnorePersistentKeepalive = true
+	ownInterval.KeepaliveInterval = 15
+	clients := []*model.Client{
+		testSite("site-a", 4, "10.0.0.4/32", "192.168.1.0/24", "site-a.example.com"),
+		testClient("alice", 2, "10.0.0.2/32"),
+		disabled,
+		ownInterval,
+		testSite("site-c", 6, "10.0.0.6/32", "192.168.3.0/24", ""),
+	}
+
+	got, err := DumpServerWg(clients, server, "", "iptables -A FORWARD -i %i -j ACCEPT", "", "iptables -D FORWARD -i %i -j ACCEPT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateWg(got); err != nil {
+		t.Errorf("rendered server config is invalid: %v", err)
+	}
+	checkGolden(t, "server.conf.golden", got)
+}
+
+func TestDumpClientWg(t *testing.T) {
+	siteA := testSite("site-a", 4, "10.0.0.4/32", "192.168.1.0/24", "site-a.example.com")
+	siteB := testSite("site-b", 5, "10.0.0.5/32", "192.168.2.0/24", "")
+	disabledSite := testSite("site-c", 6, "10.0.0.6/32", "192.168.3.0/24", "")
+	disabledSite.Enable = false
+	sites := []*model.Client{siteA, siteB, disabledSite}
+
+	table := testClient("table", 7, "10.0.0.7/32")
+	table.Table = "1234"
+	table.UseRemoteDNS = false
+	ownInterval := testClient("interval", 8, "10.0.0.8/32")
+	ownInterval.IgnorePersistentKeepalive = true
+	ownInterval.KeepaliveInterval = 10
+	noKeepalive := testClient("no-keepalive", 9, "10.0.0.9/32")
+	noKeepalive.KeepaliveDisabled = true
+	ownKey := testClient("own-key", 10, "10.0.0.10/32")
+	ownKey.PrivateKey = ""
+
+	tests := []struct {
+		golden string
+		client *model.Client
+		// valid the config is complete, a client with its own key gets a template
+		valid bool
+	}{
+		{"client.conf.golden", testClient("alice", 2, "10.0.0.2/32"), true},
+		{"client-table.conf.golden", table, true},
+		{"client-keepalive-interval.conf.golden", ownInterval, true},
+		{"client-keepalive-disabled.conf.golden", noKeepalive, true},
+		{"client-own-key.conf.golden", ownKey, false},
+		{"client-site-listen-port.conf.golden", siteA, true},
+		{"client-site-routes.conf.golden", siteB, true},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			got, err := DumpClientWg(test.client, testServer(), sites)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.valid {
+				if err := ValidateWg(got); err != nil {
+					t.Errorf("rendered client config is invalid: %v", err)
+				}
+			}
+			checkGolden(t, test.golden, got)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"wg-gen-plus/util"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// wgKeys keys accepted in each section of a wg-quick config, and whether they are required
+var wgKeys = map[string]map[string]bool{
+	"Interface": {
+		"Address": false, "ListenPort": false, "PrivateKey": true, "MTU": false, "Table": false, "DNS": false,
+		"PreUp": false, "PostUp": false, "PreDown": false, "PostDown": false, "SaveConfig": false, "FwMark": false,
+	},
+	"Peer": {
+		"PublicKey": true, "PresharedKey": false, "AllowedIPs": false, "Endpoint": false, "PersistentKeepalive": false,
+	},
+}
+
+// ValidateWg parse a rendered wg-quick config and check it before it replaces the one in use:
+// a single leading [Interface], known keys only, valid keys, addresses and ports, no duplicate peers
+func ValidateWg(data []byte) error {
+	section := ""
+	sections := 0
+	var seen map[string]bool
+	peers := map[string]bool{}
+
+	// checkRequired report keys of the finished section that never showed up
+	checkRequired := func() error {
+		for key, required := range wgKeys[section] {
+			if required && !seen[key] {
+				return fmt.Errorf("section %d [%s]: %s is missing", sections, section, key)
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if err := checkRequired(); err != nil {
+				return err
+			}
+			section = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+			if _, ok := wgKeys[section]; !ok {
+				return fmt.Errorf("line %d: unknown section [%s]", lineNumber, section)
+			}
+			if (section == "Interface") != (sections == 0) {
+				return fmt.Errorf("line %d: exactly one [Interface] section is allowed, before the peers", lineNumber)
+			}
+			sections++
+			seen = map[string]bool{}
+			continue
+		}
+
+		if section == "" {
+			return fmt.Errorf("line %d: setting outside of a section", lineNumber)
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if _, ok := wgKeys[section][key]; !ok {
+			return fmt.Errorf("line %d: unknown key %s in [%s]", lineNumber, key, section)
+		}
+		if seen[key] && key != "Address" && key != "AllowedIPs" && key != "DNS" {
+			return fmt.Errorf("line %d: duplicate key %s", lineNumber, key)
+		}
+		seen[key] = true
+
+		if err := validateWgValue(key, value); err != nil {
+			return fmt.Errorf("line %d: %s: %w", lineNumber, key, err)
+		}
+		if section == "Peer" && key == "PublicKey" {
+			if peers[value] {
+				return fmt.Errorf("line %d: duplicate peer %s", lineNumber, value)
+			}
+			peers[value] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if sections == 0 {
+		return fmt.Errorf("[Interface] section is missing")
+	}
+	return checkRequired()
+}
+
+func validateWgValue(key, value string) error {
+	switch key {
+	case "PrivateKey", "PublicKey", "PresharedKey":
+		_, err := wgtypes.ParseKey(value)
+		return err
+	case "Address", "AllowedIPs":
+		for _, cidr := range strings.Split(value, ",") {
+			if cidr = strings.TrimSpace(cidr); cidr == "" {
+				continue
+			}
+			if !util.IsValidCidr(cidr) && !util.IsValidIp(cidr) {
+				return fmt.Errorf("%s is not a valid address", cidr)
+			}
+		}
+	case "ListenPort", "PersistentKeepalive":
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return fmt.Errorf("%s is not a port or interval", value)
+		}
+	case "MTU":
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return fmt.Errorf("%s is not a valid MTU", value)
+		}
+	case "Table":
+		if !util.IsValidTable(value) {
+			return fmt.Errorf("%s is not a valid table", value)
+		}
+	case "Endpoint":
+		host, port, err := net.SplitHostPort(value)
+		if err != nil || host == "" {
+			return fmt.Errorf("%s is not a host:port endpoint", value)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("%s is not a valid port", port)
+		}
+	}
+	return nil
+}
@@ -3,12 +3,14 @@ package util
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -16,6 +18,14 @@ import (
 var (
 	// AuthTokenHeaderName http header for token transport
 	AuthTokenHeaderName = "x-wg-gen-plus-auth"
+	// StreamTokenQueryName query parameter carrying a one time stream token, for clients that can not set headers
+	StreamTokenQueryName = "token"
+	// StreamTokenCachePrefix cache key prefix of stream tokens, they map to the auth token they were created with
+	StreamTokenCachePrefix = "stream:"
+	// RegexpTableName check valid routing table name, as listed in /etc/iproute2/rt_tables
+	RegexpTableName = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_.-]*$")
+	// RegexpInterfaceName check valid network interface name, as accepted by the kernel and wg-quick
+	RegexpInterfaceName = regexp.MustCompile("^[a-zA-Z0-9_=+.-]{1,15}$")
 	// RegexpEmail check valid email
 	RegexpEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
@@ -30,14 +40,43 @@ func ReadFile(path string) (bytes []byte, err error) {
 	return bytes, nil
 }
 
-// WriteFile content to file
-func WriteFile(path string, bytes []byte) (err error) {
-	err = os.WriteFile(path, bytes, 0644)
+// WriteFile content to file atomically: readers see either the old or the new content, never a partial file.
+// The content goes to a temporary file in the same directory, synced to disk, then renamed over path.
+func WriteFile(path string, bytes []byte, perm os.FileMode) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
 
-	return nil
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err = tmp.Write(bytes); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// persist the rename itself
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
 }
 
 // FileExists check if file exists
@@ -58,32 +97,35 @@ func DirectoryExists(name string) bool {
 	return info.IsDir()
 }
 
-// GetAvailableIp search for an available ip in cidr against a list of reserved ips
-func GetAvailableIp(cidr string, reserved []string) (string, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return "", err
+// IsValidTable check if value is a valid wg-quick Table setting: off, auto, a table number or a table name
+func IsValidTable(table string) bool {
+	if table == "" || table == "off" || table == "auto" {
+		return true
 	}
+	if number, err := strconv.ParseUint(table, 10, 32); err == nil {
+		return number > 0
+	}
+	return RegexpTableName.MatchString(table)
+}
 
-	// this two addresses are not usable
-	broadcastAddr := BroadcastAddr(ipnet).String()
-	networkAddr := ipnet.IP.String()
+// IsValidInterfaceName check if name is a valid WireGuard interface name
+func IsValidInterfaceName(name string) bool {
+	return RegexpInterfaceName.MatchString(name)
+}
 
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); Inc(ip) {
-		ok := true
-		address := ip.String()
-		for _, r := range reserved {
-			if address == r {
-				ok = false
-				break
-			}
-		}
-		if ok && address != networkAddr && address != broadcastAddr {
-			return address, nil
+// InterfaceEnv value of environment variable key for WireGuard interface iface,
+// KEY_<IFACE> (upper cased, non alphanumeric chars as _) overrides KEY
+func InterfaceEnv(key, iface string) string {
+	suffix := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
 		}
+		return '_'
+	}, strings.ToUpper(iface))
+	if value, ok := os.LookupEnv(key + "_" + suffix); ok {
+		return value
 	}
-
-	return "", errors.New("no more available address from cidr")
+	return os.Getenv(key)
 }
 
 // IsIPv6 check if given ip is IPv6
@@ -125,21 +167,6 @@ func Inc(ip net.IP) {
 	}
 }
 
-// BroadcastAddr returns the last address in the given network, or the broadcast address.
-func BroadcastAddr(n *net.IPNet) net.IP {
-	// The golang net package doesn't make it easy to calculate the broadcast address. :(
-	var broadcast net.IP
-	if len(n.IP) == 4 {
-		broadcast = net.ParseIP("0.0.0.0").To4()
-	} else {
-		broadcast = net.ParseIP("::")
-	}
-	for i := 0; i < len(n.IP); i++ {
-		broadcast[i] = n.IP[i] | ^n.Mask[i]
-	}
-	return broadcast
-}
-
 // GenerateRandomBytes returns securely generated random bytes.
 // It will return an error if the system's secure random
 // number generator fails to function correctly, in which
@@ -223,12 +250,14 @@ func GenerateRandomIPv4Subnet() (string, error) {
 }
 
 // ReloadServerConfig executes the command specified in SERVER_RELOAD_CMD environment variable
-func ReloadServerConfig() error {
-	cmdString := os.Getenv("SERVER_RELOAD_CMD")
+// for WireGuard interface iface, %i in the command is replaced by the interface name
+func ReloadServerConfig(iface string) error {
+	cmdString := InterfaceEnv("SERVER_RELOAD_CMD", iface)
 	if cmdString == "" {
 		// No reload command specified, nothing to do so lets just return without error
 		return nil
 	}
+	cmdString = strings.ReplaceAll(cmdString, "%i", iface)
 
 	log.Infof("Executing server reload command: %s", cmdString)
 